@@ -11,6 +11,7 @@ import (
 //UserListQuery query params for list user
 type UserListQuery struct {
 	Query           string
+	State           string
 	Offset          int
 	Count           int
 	CreateTimeStart time.Time
@@ -27,6 +28,20 @@ type UserListResult struct {
 	Users []*models.User
 }
 
+//InvitationListQuery query params for list invitations, empty fields are not filtered
+type InvitationListQuery struct {
+	Email  string
+	Status string
+	Offset int
+	Count  int
+}
+
+//InvitationListResult query result for list invitations
+type InvitationListResult struct {
+	Count       int64
+	Invitations []*models.Invitation
+}
+
 //AccountDatabase account database
 type AccountDatabase interface {
 	database.Database
@@ -41,7 +56,62 @@ type AccountDatabase interface {
 	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
 	ListUser(ctx context.Context, q UserListQuery) (*UserListResult, error)
 
+	//UserIsExists return true if the username or email is used, the soft-deleted users are included
 	UserIsExists(ctx context.Context, u *models.User) (bool, error)
+	//ListDeletedUsers list the soft-deleted users, the newest deleted first
+	ListDeletedUsers(ctx context.Context, q UserListQuery) (*UserListResult, error)
+	//RestoreUser restore the soft-deleted user, ErrUsernameAlreadyExists or ErrEmailAlreadyExists is returned
+	//if its identifiers are taken
+	RestoreUser(ctx context.Context, id string) (*models.User, error)
+	//PurgeUser permanently deletes the soft-deleted user with its tokens, sessions and credentials, the purged
+	//user is returned with its profile to remove the files of user
+	PurgeUser(ctx context.Context, id string) (*models.User, error)
+	//ListPurgeableUsers get the ids of the users soft-deleted before the time, the oldest first
+	ListPurgeableUsers(ctx context.Context, deletedBefore time.Time, count int) ([]string, error)
+	//UpdateUserState saves the state of user if the state is still from, ErrNotFound is returned if not
+	UpdateUserState(ctx context.Context, u *models.User, from string) error
+	//ChangeEmail replace the email of user if it is still from, the new email is verified at the time.
+	//ErrNotFound is returned if the email is not from, ErrEmailAlreadyExists if another user has email to
+	ChangeEmail(ctx context.Context, userID string, from string, to string, verifiedAt time.Time) error
+	//VerifyEmail set the email of user verified if it is still email, ErrNotFound is returned if not
+	VerifyEmail(ctx context.Context, userID string, email string, verifiedAt time.Time) error
 
 	CountUser(ctx context.Context) (int64, error)
+
+	//ChangePassword saves the user and keeps the previous password in history, only the newest keep
+	//passwords of history are kept
+	ChangePassword(ctx context.Context, u *models.User, previous string, keep int) error
+	//ListPasswordHistory get the hashed previous passwords of user, the newest first
+	ListPasswordHistory(ctx context.Context, userID string, count int) ([]string, error)
+
+	GetTOTP(ctx context.Context, userID string) (*models.TOTP, error)
+	SaveTOTP(ctx context.Context, t *models.TOTP) error
+	//DeleteTOTP deletes the totp and the recovery codes of user
+	DeleteTOTP(ctx context.Context, userID string) error
+	//UseTOTPStep records the time step of an accepted code, return false if the step was used
+	UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID string, hashes []string) error
+	//UseRecoveryCode marks the code used, return false if it does not exist or was used
+	UseRecoveryCode(ctx context.Context, userID string, hash string, at time.Time) (bool, error)
+
+	GetFederatedIdentity(ctx context.Context, provider string, subject string) (*models.FederatedIdentity, error)
+	CreateFederatedIdentity(ctx context.Context, i *models.FederatedIdentity) error
+	//CreateFederatedUser creates the user with its federated identity
+	CreateFederatedUser(ctx context.Context, u *models.User, i *models.FederatedIdentity) (*models.User, error)
+
+	CreateInvitation(ctx context.Context, i *models.Invitation) (*models.Invitation, error)
+	GetInvitationByHash(ctx context.Context, hash string) (*models.Invitation, error)
+	//ListInvitations list the invitations, the newest first
+	ListInvitations(ctx context.Context, q InvitationListQuery) (*InvitationListResult, error)
+	//RevokeInvitation revokes the pending invitation, ErrNotFound is returned if it does not exist or is not pending
+	RevokeInvitation(ctx context.Context, id string, at time.Time) error
+	//CreateInvitedUser creates the user with the federated identity if it is not nil and accepts the invitation,
+	//ErrNotFound is returned if the invitation is not pending at the time. The accepted is called at last, nothing
+	//is saved if it fails
+	CreateInvitedUser(ctx context.Context,
+		u *models.User,
+		i *models.FederatedIdentity,
+		invitationID string,
+		at time.Time,
+		accepted func(u *models.User) error) (*models.User, error)
 }
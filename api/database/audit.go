@@ -0,0 +1,35 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils/database/database"
+)
+
+//AuditListQuery query params for list audit events, empty fields are not filtered
+type AuditListQuery struct {
+	Action    string
+	ActorID   string
+	UserID    string
+	TimeStart time.Time
+	TimeEnd   time.Time
+	Offset    int
+	Count     int
+}
+
+//AuditListResult query result for list audit events
+type AuditListResult struct {
+	Count  int64
+	Events []*models.AuditEvent
+}
+
+//AuditDatabase audit log database
+type AuditDatabase interface {
+	database.Database
+
+	CreateAuditEvent(ctx context.Context, e *models.AuditEvent) error
+	//ListAuditEvents list the events of query, the newest first
+	ListAuditEvents(ctx context.Context, q AuditListQuery) (*AuditListResult, error)
+}
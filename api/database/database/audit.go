@@ -0,0 +1,20 @@
+package database
+
+import (
+	dao "github.com/ngs24313/gopu/api/database"
+	gormdao "github.com/ngs24313/gopu/api/database/gorm"
+	"github.com/ngs24313/gopu/utils/database/database"
+	gormdb "github.com/ngs24313/gopu/utils/database/gorm"
+)
+
+//NewAuditDatabase create audit database
+func NewAuditDatabase(db database.Database) dao.AuditDatabase {
+	switch d := db.(type) {
+	case gormdb.Database:
+		return &gormdao.AuditDatabase{
+			Database: d,
+		}
+	default:
+		panic("Audit: database type is not supported")
+	}
+}
@@ -0,0 +1,20 @@
+package database
+
+import (
+	dao "github.com/ngs24313/gopu/api/database"
+	gormdao "github.com/ngs24313/gopu/api/database/gorm"
+	"github.com/ngs24313/gopu/utils/database/database"
+	gormdb "github.com/ngs24313/gopu/utils/database/gorm"
+)
+
+//NewOAuthDatabase create oauth database
+func NewOAuthDatabase(db database.Database) dao.OAuthDatabase {
+	switch d := db.(type) {
+	case gormdb.Database:
+		return &gormdao.OAuthDatabase{
+			Database: d,
+		}
+	default:
+		panic("OAuth: database type is not supported")
+	}
+}
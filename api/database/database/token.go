@@ -0,0 +1,20 @@
+package database
+
+import (
+	dao "github.com/ngs24313/gopu/api/database"
+	gormdao "github.com/ngs24313/gopu/api/database/gorm"
+	"github.com/ngs24313/gopu/utils/database/database"
+	gormdb "github.com/ngs24313/gopu/utils/database/gorm"
+)
+
+//NewTokenDatabase create token database
+func NewTokenDatabase(db database.Database) dao.TokenDatabase {
+	switch d := db.(type) {
+	case gormdb.Database:
+		return &gormdao.TokenDatabase{
+			Database: d,
+		}
+	default:
+		panic("Token: database type is not supported")
+	}
+}
@@ -91,7 +91,8 @@ func (d *AccountDatabase) GetUserByEmail(ctx context.Context, email string) (*mo
 }
 
 func (d *AccountDatabase) UserIsExists(ctx context.Context, u *models.User) (bool, error) {
-	db := d.Instance()
+	//the identifiers of soft-deleted users are kept until they are purged, so that they can be restored
+	db := d.Instance().Unscoped()
 
 	var user models.User
 	if err := db.Where("username = ? OR email = ?", u.Username, u.Email).First(&user).Error; err != nil {
@@ -127,12 +128,16 @@ func (d *AccountDatabase) ListUser(ctx context.Context, q dao.UserListQuery) (*d
 		db = db.Where("(username LIKE ?) OR (email LIKE ?)", likeString, likeString)
 	}
 
+	if q.State != "" {
+		db = db.Where("state = ?", q.State)
+	}
+
 	if !q.CreateTimeStart.IsZero() {
-		db = db.Where("createdAt >= ?", q.CreateTimeStart)
+		db = db.Where("created_at >= ?", q.CreateTimeStart)
 	}
 
 	if !q.CreateTimeEnd.IsZero() {
-		db = db.Where("createdAt < ?", q.CreateTimeEnd)
+		db = db.Where("created_at < ?", q.CreateTimeEnd)
 	}
 
 	if q.WithCount {
@@ -0,0 +1,61 @@
+package gorm
+
+import (
+	"context"
+
+	dao "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/models"
+	gormdb "github.com/ngs24313/gopu/utils/database/gorm"
+)
+
+//AuditDatabase audit database
+type AuditDatabase struct {
+	gormdb.Database
+}
+
+func (d *AuditDatabase) CreateAuditEvent(ctx context.Context, e *models.AuditEvent) error {
+	db := d.Instance()
+	return db.Create(e).Error
+}
+
+func (d *AuditDatabase) ListAuditEvents(ctx context.Context, q dao.AuditListQuery) (*dao.AuditListResult, error) {
+	var result dao.AuditListResult
+
+	db := d.Instance()
+	db = db.Model(&models.AuditEvent{})
+	if q.Action != "" {
+		db = db.Where("action = ?", q.Action)
+	}
+	if q.ActorID != "" {
+		db = db.Where("actor_id = ?", q.ActorID)
+	}
+	if q.UserID != "" {
+		db = db.Where("user_id = ?", q.UserID)
+	}
+	if !q.TimeStart.IsZero() {
+		db = db.Where("created_at >= ?", q.TimeStart)
+	}
+	if !q.TimeEnd.IsZero() {
+		db = db.Where("created_at < ?", q.TimeEnd)
+	}
+
+	if err := db.Count(&result.Count).Error; err != nil {
+		return nil, err
+	}
+
+	if q.Offset > 0 {
+		db = db.Offset(q.Offset)
+	}
+
+	var limit int = 20
+	if q.Count > 0 {
+		limit = q.Count
+	}
+
+	if err := db.Limit(limit).
+		Order("created_at desc").
+		Find(&result.Events).Error; err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
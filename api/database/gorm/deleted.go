@@ -0,0 +1,124 @@
+package gorm
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	dao "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/models"
+)
+
+func (d *AccountDatabase) ListDeletedUsers(ctx context.Context, q dao.UserListQuery) (*dao.UserListResult, error) {
+	var result dao.UserListResult
+
+	db := d.Instance().Unscoped().Model(&models.User{}).Where("deleted_at IS NOT NULL")
+	if q.Query != "" {
+		likeString := "%" + q.Query + "%"
+		db = db.Where("(username LIKE ?) OR (email LIKE ?)", likeString, likeString)
+	}
+
+	if q.WithCount {
+		if err := db.Count(&result.Count).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	limit := 20
+	if q.Count > 0 {
+		limit = q.Count
+	}
+
+	if err := db.Preload("Profile").
+		Order("deleted_at DESC").
+		Offset(q.Offset).
+		Limit(limit).
+		Find(&result.Users).Error; err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (d *AccountDatabase) RestoreUser(ctx context.Context, id string) (*models.User, error) {
+	var user models.User
+	err := d.Instance().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).First(&user).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return dao.ErrNotFound
+			}
+			return err
+		}
+
+		//the identifiers may be taken by the users created by login of other systems
+		var other models.User
+		err := tx.Where("id <> ? AND (username = ? OR email = ?)", id, user.Username, user.Email).First(&other).Error
+		if err == nil {
+			if other.Username == user.Username {
+				return dao.ErrUsernameAlreadyExists
+			}
+			return dao.ErrEmailAlreadyExists
+		}
+		if err != gorm.ErrRecordNotFound {
+			return err
+		}
+
+		return tx.Unscoped().Model(&models.User{}).Where("id = ?", id).Update("deleted_at", nil).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d.GetUserByID(ctx, id)
+}
+
+func (d *AccountDatabase) PurgeUser(ctx context.Context, id string) (*models.User, error) {
+	var user models.User
+	err := d.Instance().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).First(&user).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return dao.ErrNotFound
+			}
+			return err
+		}
+		if err := tx.Unscoped().Where("id = ?", user.ProfileID).First(&user.Profile).Error; err != nil &&
+			err != gorm.ErrRecordNotFound {
+			return err
+		}
+
+		//audit events are kept, they are the records of what happened to the user
+		for _, model := range []interface{}{
+			&models.RefreshToken{},
+			&models.Session{},
+			&models.PersonalAccessToken{},
+			&models.TOTP{},
+			&models.RecoveryCode{},
+			&models.FederatedIdentity{},
+			&models.OAuthConsent{},
+			&models.PasswordHistory{},
+		} {
+			if err := tx.Unscoped().Where("user_id = ?", id).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Unscoped().Where("id = ?", user.ProfileID).Delete(&models.Profile{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("id = ?", id).Delete(&models.User{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (d *AccountDatabase) ListPurgeableUsers(ctx context.Context, deletedBefore time.Time, count int) ([]string, error) {
+	var ids []string
+	if err := d.Instance().Unscoped().Model(&models.User{}).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", deletedBefore).
+		Order("deleted_at ASC").
+		Limit(count).
+		Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,135 @@
+package gorm
+
+import (
+	"context"
+	"testing"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	dao "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/models"
+	gormdb "github.com/ngs24313/gopu/utils/database/gorm"
+)
+
+func newTestAccountDatabase(t *testing.T) *AccountDatabase {
+	h, err := gormdb.NewHandler("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//every connection of sqlite memory database is a new database
+	h.(gormdb.Database).Instance().DB().SetMaxOpenConns(1)
+
+	if err := h.Migrate(
+		&models.User{},
+		&models.Profile{},
+		&models.RefreshToken{},
+		&models.Session{},
+		&models.PersonalAccessToken{},
+		&models.TOTP{},
+		&models.RecoveryCode{},
+		&models.FederatedIdentity{},
+		&models.OAuthConsent{},
+		&models.PasswordHistory{},
+	); err != nil {
+		t.Fatal(err)
+	}
+	return &AccountDatabase{Database: h.(gormdb.Database)}
+}
+
+func TestRestoreUser(t *testing.T) {
+	d := newTestAccountDatabase(t)
+	defer d.Close()
+	ctx := context.Background()
+
+	alice, err := d.CreateUser(ctx, &models.User{Username: "alice", Email: "alice@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteUser(ctx, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	//the identifiers are kept for restoring
+	if ok, err := d.UserIsExists(ctx, &models.User{Username: "alice"}); !ok || err != dao.ErrUsernameAlreadyExists {
+		t.Errorf("UserIsExists() of deleted = %v %v, want %v", ok, err, dao.ErrUsernameAlreadyExists)
+	}
+
+	//a user of other systems takes the email meanwhile
+	other, err := d.CreateUser(ctx, &models.User{Username: "alice2", Email: "alice@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.RestoreUser(ctx, alice.ID); err != dao.ErrEmailAlreadyExists {
+		t.Errorf("RestoreUser() of taken email = %v, want %v", err, dao.ErrEmailAlreadyExists)
+	}
+	if _, err := d.PurgeUser(ctx, other.ID); err != dao.ErrNotFound {
+		t.Errorf("PurgeUser() of active user = %v, want %v", err, dao.ErrNotFound)
+	}
+	if err := d.DeleteUser(ctx, other.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.PurgeUser(ctx, other.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := d.RestoreUser(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.ID != alice.ID || restored.DeletedAt != nil {
+		t.Errorf("RestoreUser() = %v, want the active alice", restored)
+	}
+	if _, err := d.RestoreUser(ctx, alice.ID); err != dao.ErrNotFound {
+		t.Errorf("RestoreUser() of active user = %v, want %v", err, dao.ErrNotFound)
+	}
+}
+
+func TestPurgeUser(t *testing.T) {
+	d := newTestAccountDatabase(t)
+	defer d.Close()
+	ctx := context.Background()
+
+	alice, err := d.CreateUser(ctx, &models.User{
+		Username: "alice",
+		Email:    "alice@example.com",
+		Profile:  models.Profile{Avatar: "alice.png"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := d.CreateUser(ctx, &models.User{Username: "bob", Email: "bob@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, hash := range map[string]string{alice.ID: "alice", bob.ID: "bob"} {
+		if err := d.Instance().Create(&models.RefreshToken{UserID: id, TokenHash: hash}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := d.DeleteUser(ctx, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	purged, err := d.PurgeUser(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged.Profile.Avatar != "alice.png" {
+		t.Errorf("avatar of purged = %q, want alice.png", purged.Profile.Avatar)
+	}
+
+	//the identifiers are free for new users
+	if ok, err := d.UserIsExists(ctx, &models.User{Username: "alice", Email: "alice@example.com"}); ok || err != nil {
+		t.Errorf("UserIsExists() of purged = %v %v, want false", ok, err)
+	}
+	if _, err := d.RestoreUser(ctx, alice.ID); err != dao.ErrNotFound {
+		t.Errorf("RestoreUser() of purged = %v, want %v", err, dao.ErrNotFound)
+	}
+
+	var tokens []models.RefreshToken
+	if err := d.Instance().Find(&tokens).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].UserID != bob.ID {
+		t.Errorf("refresh tokens after purging = %v, want the token of bob", tokens)
+	}
+}
@@ -0,0 +1,53 @@
+package gorm
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	dao "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/models"
+)
+
+func (d *AccountDatabase) ChangeEmail(ctx context.Context,
+	userID string,
+	from string,
+	to string,
+	verifiedAt time.Time) error {
+	return d.Instance().Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Unscoped().Model(&models.User{}).Where("email = ?", to).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return dao.ErrEmailAlreadyExists
+		}
+
+		db := tx.Model(&models.User{}).
+			Where("id = ? AND email = ?", userID, from).
+			Updates(map[string]interface{}{
+				"email":             to,
+				"email_verified_at": verifiedAt,
+			})
+		if db.Error != nil {
+			return db.Error
+		}
+		if db.RowsAffected == 0 {
+			return dao.ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (d *AccountDatabase) VerifyEmail(ctx context.Context, userID string, email string, verifiedAt time.Time) error {
+	db := d.Instance().Model(&models.User{}).
+		Where("id = ? AND email = ?", userID, email).
+		Update("email_verified_at", verifiedAt)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return dao.ErrNotFound
+	}
+	return nil
+}
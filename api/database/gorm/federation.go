@@ -0,0 +1,46 @@
+package gorm
+
+import (
+	"context"
+
+	"github.com/jinzhu/gorm"
+	dao "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/models"
+)
+
+func (d *AccountDatabase) GetFederatedIdentity(ctx context.Context,
+	provider string,
+	subject string) (*models.FederatedIdentity, error) {
+	db := d.Instance()
+
+	var identity models.FederatedIdentity
+	if err := db.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, dao.ErrNotFound
+		}
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (d *AccountDatabase) CreateFederatedIdentity(ctx context.Context, i *models.FederatedIdentity) error {
+	db := d.Instance()
+	return db.Create(i).Error
+}
+
+func (d *AccountDatabase) CreateFederatedUser(ctx context.Context,
+	u *models.User,
+	i *models.FederatedIdentity) (*models.User, error) {
+	err := d.Instance().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(u).Error; err != nil {
+			return err
+		}
+
+		i.UserID = u.ID
+		return tx.Create(i).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
@@ -0,0 +1,126 @@
+package gorm
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	dao "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/models"
+)
+
+func (d *AccountDatabase) CreateInvitation(ctx context.Context, i *models.Invitation) (*models.Invitation, error) {
+	db := d.Instance()
+	if err := db.Create(i).Error; err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+func (d *AccountDatabase) GetInvitationByHash(ctx context.Context, hash string) (*models.Invitation, error) {
+	db := d.Instance()
+
+	var invitation models.Invitation
+	if err := db.Where("token_hash = ?", hash).First(&invitation).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, dao.ErrNotFound
+		}
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+func (d *AccountDatabase) ListInvitations(ctx context.Context, q dao.InvitationListQuery) (*dao.InvitationListResult, error) {
+	var result dao.InvitationListResult
+
+	db := d.Instance()
+	db = db.Model(&models.Invitation{})
+	if q.Email != "" {
+		db = db.Where("email = ?", q.Email)
+	}
+
+	now := time.Now()
+	switch q.Status {
+	case models.InvitationPending:
+		db = db.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", now)
+	case models.InvitationAccepted:
+		db = db.Where("accepted_at IS NOT NULL")
+	case models.InvitationRevoked:
+		db = db.Where("accepted_at IS NULL AND revoked_at IS NOT NULL")
+	case models.InvitationExpired:
+		db = db.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at <= ?", now)
+	}
+
+	if err := db.Count(&result.Count).Error; err != nil {
+		return nil, err
+	}
+
+	if q.Offset > 0 {
+		db = db.Offset(q.Offset)
+	}
+
+	var limit int = 20
+	if q.Count > 0 {
+		limit = q.Count
+	}
+
+	if err := db.Limit(limit).
+		Order("created_at desc").
+		Find(&result.Invitations).Error; err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (d *AccountDatabase) RevokeInvitation(ctx context.Context, id string, at time.Time) error {
+	db := d.Instance()
+	db = db.Model(&models.Invitation{}).
+		Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", id, at).
+		Update("revoked_at", at)
+	if err := db.Error; err != nil {
+		return err
+	}
+	if db.RowsAffected == 0 {
+		return dao.ErrNotFound
+	}
+	return nil
+}
+
+func (d *AccountDatabase) CreateInvitedUser(ctx context.Context,
+	u *models.User,
+	i *models.FederatedIdentity,
+	invitationID string,
+	at time.Time,
+	accepted func(u *models.User) error) (*models.User, error) {
+	err := d.Instance().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(u).Error; err != nil {
+			return err
+		}
+
+		if i != nil {
+			i.UserID = u.ID
+			if err := tx.Create(i).Error; err != nil {
+				return err
+			}
+		}
+
+		//only the pending invitation can be accepted once
+		result := tx.Model(&models.Invitation{}).
+			Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", invitationID, at).
+			Updates(map[string]interface{}{
+				"accepted_at": at,
+				"user_id":     u.ID,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return dao.ErrNotFound
+		}
+		return accepted(u)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
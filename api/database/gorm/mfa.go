@@ -0,0 +1,77 @@
+package gorm
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	dao "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/models"
+)
+
+func (d *AccountDatabase) GetTOTP(ctx context.Context, userID string) (*models.TOTP, error) {
+	db := d.Instance()
+
+	var totp models.TOTP
+	if err := db.Where("user_id = ?", userID).First(&totp).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, dao.ErrNotFound
+		}
+		return nil, err
+	}
+	return &totp, nil
+}
+
+func (d *AccountDatabase) SaveTOTP(ctx context.Context, t *models.TOTP) error {
+	db := d.Instance()
+	return db.Save(t).Error
+}
+
+func (d *AccountDatabase) DeleteTOTP(ctx context.Context, userID string) error {
+	return d.Instance().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.TOTP{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+	})
+}
+
+func (d *AccountDatabase) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	db := d.Instance()
+	db = db.Model(&models.TOTP{}).
+		Where("user_id = ? AND last_step < ?", userID, step).
+		Update("last_step", step)
+	if err := db.Error; err != nil {
+		return false, err
+	}
+	return db.RowsAffected == 1, nil
+}
+
+func (d *AccountDatabase) ReplaceRecoveryCodes(ctx context.Context, userID string, hashes []string) error {
+	return d.Instance().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		for _, hash := range hashes {
+			if err := tx.Create(&models.RecoveryCode{
+				UserID:   userID,
+				CodeHash: hash,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (d *AccountDatabase) UseRecoveryCode(ctx context.Context, userID string, hash string, at time.Time) (bool, error) {
+	db := d.Instance()
+	db = db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", at)
+	if err := db.Error; err != nil {
+		return false, err
+	}
+	return db.RowsAffected == 1, nil
+}
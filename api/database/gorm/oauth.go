@@ -0,0 +1,77 @@
+package gorm
+
+import (
+	"context"
+
+	"github.com/jinzhu/gorm"
+	dao "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/models"
+	gormdb "github.com/ngs24313/gopu/utils/database/gorm"
+)
+
+//OAuthDatabase oauth client database
+type OAuthDatabase struct {
+	gormdb.Database
+}
+
+func (d *OAuthDatabase) CreateClient(ctx context.Context, c *models.OAuthClient) (*models.OAuthClient, error) {
+	db := d.Instance()
+	if err := db.Create(c).Error; err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (d *OAuthDatabase) GetClient(ctx context.Context, id string) (*models.OAuthClient, error) {
+	db := d.Instance()
+
+	var client models.OAuthClient
+	if err := db.Where("id = ?", id).First(&client).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, dao.ErrNotFound
+		}
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (d *OAuthDatabase) ListClients(ctx context.Context) ([]*models.OAuthClient, error) {
+	db := d.Instance()
+
+	clients := make([]*models.OAuthClient, 0)
+	if err := db.Order("created_at").Find(&clients).Error; err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+func (d *OAuthDatabase) DeleteClient(ctx context.Context, id string) error {
+	return d.Instance().Transaction(func(tx *gorm.DB) error {
+		db := tx.Where("id = ?", id).Delete(&models.OAuthClient{})
+		if err := db.Error; err != nil {
+			return err
+		}
+		if db.RowsAffected == 0 {
+			return dao.ErrNotFound
+		}
+		return tx.Where("client_id = ?", id).Delete(&models.OAuthConsent{}).Error
+	})
+}
+
+func (d *OAuthDatabase) GetConsent(ctx context.Context, userID string, clientID string) (*models.OAuthConsent, error) {
+	db := d.Instance()
+
+	var consent models.OAuthConsent
+	if err := db.Where("user_id = ? AND client_id = ?", userID, clientID).First(&consent).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, dao.ErrNotFound
+		}
+		return nil, err
+	}
+	return &consent, nil
+}
+
+func (d *OAuthDatabase) SaveConsent(ctx context.Context, c *models.OAuthConsent) error {
+	db := d.Instance()
+	return db.Save(c).Error
+}
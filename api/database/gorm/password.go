@@ -0,0 +1,53 @@
+package gorm
+
+import (
+	"context"
+
+	"github.com/jinzhu/gorm"
+	"github.com/ngs24313/gopu/models"
+)
+
+func (d *AccountDatabase) ChangePassword(ctx context.Context, u *models.User, previous string, keep int) error {
+	return d.Instance().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(u).Error; err != nil {
+			return err
+		}
+
+		if keep <= 0 || previous == "" {
+			return tx.Where("user_id = ?", u.ID).Delete(&models.PasswordHistory{}).Error
+		}
+
+		if err := tx.Create(&models.PasswordHistory{
+			UserID:   u.ID,
+			Password: previous,
+		}).Error; err != nil {
+			return err
+		}
+
+		var ids []uint
+		if err := tx.Model(&models.PasswordHistory{}).
+			Where("user_id = ?", u.ID).
+			Order("id desc").
+			Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) <= keep {
+			return nil
+		}
+		return tx.Where("id IN (?)", ids[keep:]).Delete(&models.PasswordHistory{}).Error
+	})
+}
+
+func (d *AccountDatabase) ListPasswordHistory(ctx context.Context, userID string, count int) ([]string, error) {
+	db := d.Instance()
+
+	var passwords []string
+	if err := db.Model(&models.PasswordHistory{}).
+		Where("user_id = ?", userID).
+		Order("id desc").
+		Limit(count).
+		Pluck("password", &passwords).Error; err != nil {
+		return nil, err
+	}
+	return passwords, nil
+}
@@ -0,0 +1,26 @@
+package gorm
+
+import (
+	"context"
+
+	dao "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/models"
+)
+
+func (d *AccountDatabase) UpdateUserState(ctx context.Context, u *models.User, from string) error {
+	db := d.Instance().Model(&models.User{}).
+		Where("id = ? AND state = ?", u.ID, from).
+		Updates(map[string]interface{}{
+			"state":            u.State,
+			"state_reason":     u.StateReason,
+			"state_actor_id":   u.StateActorID,
+			"state_changed_at": u.StateChangedAt,
+		})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return dao.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package gorm
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	dao "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/models"
+	gormdb "github.com/ngs24313/gopu/utils/database/gorm"
+)
+
+//TokenDatabase token database
+type TokenDatabase struct {
+	gormdb.Database
+}
+
+func (d *TokenDatabase) CreateRefreshToken(ctx context.Context, t *models.RefreshToken) (*models.RefreshToken, error) {
+	db := d.Instance()
+	if err := db.Create(t).Error; err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (d *TokenDatabase) GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	db := d.Instance()
+
+	var token models.RefreshToken
+	if err := db.Where("token_hash = ?", hash).First(&token).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, dao.ErrNotFound
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (d *TokenDatabase) UseRefreshToken(ctx context.Context, id string, at time.Time) (bool, error) {
+	db := d.Instance()
+	db = db.Model(&models.RefreshToken{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", id).
+		Update("used_at", at)
+	if err := db.Error; err != nil {
+		return false, err
+	}
+	return db.RowsAffected == 1, nil
+}
+
+func (d *TokenDatabase) RevokeRefreshTokenFamily(ctx context.Context, familyID string, at time.Time) error {
+	db := d.Instance()
+	return db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", at).Error
+}
+
+func (d *TokenDatabase) RevokeUserRefreshTokens(ctx context.Context, userID string, at time.Time) error {
+	db := d.Instance()
+	return db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", at).Error
+}
+
+func (d *TokenDatabase) RevokeClientRefreshTokens(ctx context.Context, clientID string, at time.Time) error {
+	db := d.Instance()
+	return db.Model(&models.RefreshToken{}).
+		Where("client_id = ? AND revoked_at IS NULL", clientID).
+		Update("revoked_at", at).Error
+}
+
+func (d *TokenDatabase) CreatePersonalAccessToken(ctx context.Context,
+	t *models.PersonalAccessToken) (*models.PersonalAccessToken, error) {
+	db := d.Instance()
+	if err := db.Create(t).Error; err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (d *TokenDatabase) GetPersonalAccessTokenByHash(ctx context.Context, hash string) (*models.PersonalAccessToken, error) {
+	db := d.Instance()
+
+	var token models.PersonalAccessToken
+	if err := db.Where("token_hash = ?", hash).First(&token).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, dao.ErrNotFound
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (d *TokenDatabase) ListPersonalAccessTokens(ctx context.Context, userID string) ([]*models.PersonalAccessToken, error) {
+	db := d.Instance()
+
+	tokens := make([]*models.PersonalAccessToken, 0)
+	if err := db.Where("user_id = ?", userID).Order("created_at").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (d *TokenDatabase) RevokePersonalAccessToken(ctx context.Context, userID string, id string, at time.Time) error {
+	db := d.Instance()
+	db = db.Model(&models.PersonalAccessToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		UpdateColumn("revoked_at", at)
+	if err := db.Error; err != nil {
+		return err
+	}
+	if db.RowsAffected == 0 {
+		return dao.ErrNotFound
+	}
+	return nil
+}
+
+func (d *TokenDatabase) TouchPersonalAccessToken(ctx context.Context, id string, at time.Time) error {
+	db := d.Instance()
+	return db.Model(&models.PersonalAccessToken{}).
+		Where("id = ?", id).
+		UpdateColumn("last_used_at", at).Error
+}
+
+func (d *TokenDatabase) CreateSession(ctx context.Context, s *models.Session) (*models.Session, error) {
+	db := d.Instance()
+	if err := db.Create(s).Error; err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (d *TokenDatabase) GetSessionByFamily(ctx context.Context, familyID string) (*models.Session, error) {
+	db := d.Instance()
+	var s models.Session
+	if err := db.Where("family_id = ?", familyID).First(&s).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, dao.ErrNotFound
+		}
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (d *TokenDatabase) ListSessions(ctx context.Context, userID string, now time.Time) ([]*models.Session, error) {
+	db := d.Instance()
+	var sessions []*models.Session
+	if err := db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, now).
+		Order("last_seen_at desc").
+		Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (d *TokenDatabase) TouchSession(ctx context.Context, id string, clientIP string, at time.Time) error {
+	db := d.Instance()
+	return db.Model(&models.Session{}).
+		Where("id = ?", id).
+		UpdateColumns(map[string]interface{}{
+			"client_ip":    clientIP,
+			"last_seen_at": at,
+		}).Error
+}
+
+func (d *TokenDatabase) RefreshSession(ctx context.Context, id string, at time.Time, expiresAt time.Time) error {
+	db := d.Instance()
+	return db.Model(&models.Session{}).
+		Where("id = ?", id).
+		UpdateColumns(map[string]interface{}{
+			"last_seen_at": at,
+			"expires_at":   expiresAt,
+		}).Error
+}
+
+func (d *TokenDatabase) RevokeSession(ctx context.Context, userID string, id string, at time.Time) (*models.Session, error) {
+	db := d.Instance()
+	var s models.Session
+	if err := db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).First(&s).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, dao.ErrNotFound
+		}
+		return nil, err
+	}
+
+	db = db.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		UpdateColumn("revoked_at", at)
+	if err := db.Error; err != nil {
+		return nil, err
+	}
+	if db.RowsAffected == 0 {
+		return nil, dao.ErrNotFound
+	}
+	s.RevokedAt = &at
+	return &s, nil
+}
+
+func (d *TokenDatabase) RevokeUserSessions(ctx context.Context, userID string, at time.Time) error {
+	db := d.Instance()
+	return db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		UpdateColumn("revoked_at", at).Error
+}
@@ -0,0 +1,22 @@
+package database
+
+import (
+	"context"
+
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils/database/database"
+)
+
+//OAuthDatabase oauth client database
+type OAuthDatabase interface {
+	database.Database
+
+	CreateClient(ctx context.Context, c *models.OAuthClient) (*models.OAuthClient, error)
+	GetClient(ctx context.Context, id string) (*models.OAuthClient, error)
+	ListClients(ctx context.Context) ([]*models.OAuthClient, error)
+	//DeleteClient deletes the client and the consents granted to it
+	DeleteClient(ctx context.Context, id string) error
+
+	GetConsent(ctx context.Context, userID string, clientID string) (*models.OAuthConsent, error)
+	SaveConsent(ctx context.Context, c *models.OAuthConsent) error
+}
@@ -0,0 +1,40 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils/database/database"
+)
+
+//TokenDatabase token database
+type TokenDatabase interface {
+	database.Database
+
+	CreateRefreshToken(ctx context.Context, t *models.RefreshToken) (*models.RefreshToken, error)
+	GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error)
+	//UseRefreshToken marks the token used, return false if it was already used or revoked
+	UseRefreshToken(ctx context.Context, id string, at time.Time) (bool, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string, at time.Time) error
+	RevokeUserRefreshTokens(ctx context.Context, userID string, at time.Time) error
+	RevokeClientRefreshTokens(ctx context.Context, clientID string, at time.Time) error
+
+	CreateSession(ctx context.Context, s *models.Session) (*models.Session, error)
+	GetSessionByFamily(ctx context.Context, familyID string) (*models.Session, error)
+	//ListSessions get the sessions of user which are not revoked or expired at now
+	ListSessions(ctx context.Context, userID string, now time.Time) ([]*models.Session, error)
+	//TouchSession records the last request of session
+	TouchSession(ctx context.Context, id string, clientIP string, at time.Time) error
+	//RefreshSession records the refresh token issued at time for session
+	RefreshSession(ctx context.Context, id string, at time.Time, expiresAt time.Time) error
+	//RevokeSession revokes the session of user, ErrNotFound is returned if it does not exist or was revoked
+	RevokeSession(ctx context.Context, userID string, id string, at time.Time) (*models.Session, error)
+	RevokeUserSessions(ctx context.Context, userID string, at time.Time) error
+
+	CreatePersonalAccessToken(ctx context.Context, t *models.PersonalAccessToken) (*models.PersonalAccessToken, error)
+	GetPersonalAccessTokenByHash(ctx context.Context, hash string) (*models.PersonalAccessToken, error)
+	ListPersonalAccessTokens(ctx context.Context, userID string) ([]*models.PersonalAccessToken, error)
+	RevokePersonalAccessToken(ctx context.Context, userID string, id string, at time.Time) error
+	TouchPersonalAccessToken(ctx context.Context, id string, at time.Time) error
+}
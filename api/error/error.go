@@ -5,12 +5,19 @@ import (
 	"net/http"
 )
 
+//Cause is one of the causes of error, such as a rule which the request breaks
+type Cause struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
 //AppError is struct of application error
 type AppError struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
-	Detail  string `json:"detail"`
-	Err     error  `json:"-"`
+	Code    int     `json:"code"`
+	Message string  `json:"message"`
+	Detail  string  `json:"detail"`
+	Causes  []Cause `json:"causes,omitempty"`
+	Err     error   `json:"-"`
 }
 
 //Error is error interface impl
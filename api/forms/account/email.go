@@ -0,0 +1,11 @@
+package account
+
+//EmailChangeForm for send email change code to the new email
+type EmailChangeForm struct {
+	Email string `json:"email" form:"email" binding:"required,email"`
+}
+
+//EmailCodeForm for confirm the new email or verify the email by code
+type EmailCodeForm struct {
+	Code string `json:"code" form:"code" binding:"required,len=6,numeric"`
+}
@@ -7,6 +7,7 @@ import (
 //ListUserForm for list user query
 type ListUserForm struct {
 	Query           string `json:"query" form:"query"`
+	State           string `json:"state" form:"state"`
 	Page            int    `json:"page"  form:"page"`
 	PageSize        int    `json:"page_size" form:"page_size"`
 	CreateTimeStart int64  `json:"create_time_start" form:"create_time_start"`
@@ -0,0 +1,6 @@
+package account
+
+//MagicLinkForm for send magic link
+type MagicLinkForm struct {
+	Email string `json:"email" form:"email" binding:"required,email"`
+}
@@ -0,0 +1,6 @@
+package account
+
+//MFACodeForm for totp code or recovery code
+type MFACodeForm struct {
+	Code string `json:"code" form:"code" binding:"required"`
+}
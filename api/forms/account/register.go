@@ -6,7 +6,8 @@ type RegisterForm struct {
 	Password        string `json:"password" form:"password" binding:"required"`
 	ConfirmPassword string `json:"confirm_password" form:"confirm_password" binding:"required"`
 	Email           string `json:"email" form:"email" binding:"required,email"`
-	RegisterCode    string `json:"register_code" form:"register_code" binding:"required,len=6,numeric"`
+	RegisterCode    string `json:"register_code" form:"register_code" binding:"omitempty,len=6,numeric"`
+	InvitationToken string `json:"invitation_token" form:"invitation_token"` //used instead of register code by invited users
 }
 
 //RegisterCodeForm 注册码表单
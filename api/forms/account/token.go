@@ -0,0 +1,14 @@
+package account
+
+import (
+	"time"
+
+	"github.com/ngs24313/gopu/api/forms/rbac"
+)
+
+//TokenForm personal access token http form, the token has all permissions of user if permissions is empty
+type TokenForm struct {
+	Name        string                `json:"name" form:"name" binding:"required,lt=64"`
+	ExpiresAt   *time.Time            `json:"expires_at" form:"expires_at"`
+	Permissions []rbac.PermissionForm `json:"permissions" form:"permissions"`
+}
@@ -0,0 +1,24 @@
+package admin
+
+import (
+	"github.com/ngs24313/gopu/models"
+)
+
+//AuditListForm for list audit events query
+type AuditListForm struct {
+	Action    string `json:"action" form:"action"`
+	ActorID   string `json:"actor_id" form:"actor_id"`
+	UserID    string `json:"user_id" form:"user_id"`
+	TimeStart int64  `json:"time_start" form:"time_start"`
+	TimeEnd   int64  `json:"time_end" form:"time_end"`
+	Page      int    `json:"page" form:"page"`
+	PageSize  int    `json:"page_size" form:"page_size"`
+}
+
+//AuditListResultForm for list audit events query result
+type AuditListResultForm struct {
+	Page      int                  `json:"page"`
+	PageSize  int                  `json:"page_size"`
+	PageCount int                  `json:"page_count"`
+	Events    []*models.AuditEvent `json:"events"`
+}
@@ -0,0 +1,20 @@
+package admin
+
+import (
+	"github.com/ngs24313/gopu/models"
+)
+
+//DeletedUserListForm for list deleted users query
+type DeletedUserListForm struct {
+	Query    string `json:"query" form:"query"`
+	Page     int    `json:"page" form:"page"`
+	PageSize int    `json:"page_size" form:"page_size"`
+}
+
+//DeletedUserListResultForm for list deleted users query result
+type DeletedUserListResultForm struct {
+	Page      int            `json:"page"`
+	PageSize  int            `json:"page_size"`
+	PageCount int            `json:"page_count"`
+	Users     []*models.User `json:"users"`
+}
@@ -0,0 +1,27 @@
+package admin
+
+import (
+	"github.com/ngs24313/gopu/models"
+)
+
+//InvitationForm for invite user to register
+type InvitationForm struct {
+	Email string   `json:"email" form:"email" binding:"required,email"`
+	Roles []string `json:"roles" form:"roles" binding:"omitempty,dive,required"`
+}
+
+//InvitationListForm for list invitations query
+type InvitationListForm struct {
+	Email    string `json:"email" form:"email"`
+	Status   string `json:"status" form:"status" binding:"omitempty,oneof=pending accepted revoked expired"`
+	Page     int    `json:"page" form:"page"`
+	PageSize int    `json:"page_size" form:"page_size"`
+}
+
+//InvitationListResultForm for list invitations query result
+type InvitationListResultForm struct {
+	Page        int                  `json:"page"`
+	PageSize    int                  `json:"page_size"`
+	PageCount   int                  `json:"page_count"`
+	Invitations []*models.Invitation `json:"invitations"`
+}
@@ -0,0 +1,7 @@
+package admin
+
+//UserStateForm for move user to state
+type UserStateForm struct {
+	State  string `json:"state" form:"state" binding:"required"`
+	Reason string `json:"reason" form:"reason" binding:"omitempty,lt=256"`
+}
@@ -0,0 +1,9 @@
+package oauth
+
+//ClientForm oauth client registration http form
+type ClientForm struct {
+	Name         string   `json:"name" form:"name" binding:"required,lt=64"`
+	Public       bool     `json:"public" form:"public"`
+	RedirectURIs []string `json:"redirect_uris" form:"redirect_uris" binding:"required,gt=0"`
+	Scopes       []string `json:"scopes" form:"scopes" binding:"required,gt=0"`
+}
@@ -23,3 +23,14 @@ type RoleUpdateForm struct {
 	API    string `json:"api" form:"api" binding:"required"`
 	Method string `json:"method" form:"method" binding:"required"`
 }
+
+//RoleReplaceForm role permissions replace http form
+type RoleReplaceForm struct {
+	Permissions []RoleUpdateForm `json:"permissions" binding:"required,gt=0,dive"`
+}
+
+//RolePatchForm role permissions add and remove http form
+type RolePatchForm struct {
+	Add    []RoleUpdateForm `json:"add" binding:"omitempty,dive"`
+	Remove []RoleUpdateForm `json:"remove" binding:"omitempty,dive"`
+}
@@ -2,8 +2,10 @@ package v1
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -11,9 +13,9 @@ import (
 
 	"github.com/ngs24313/gopu/config"
 	"github.com/ngs24313/gopu/middleware"
-	"github.com/ngs24313/gopu/utils"
 	"github.com/ngs24313/gopu/utils/log"
 	"github.com/ngs24313/gopu/utils/mailer"
+	"github.com/ngs24313/gopu/utils/oidc"
 	"github.com/ngs24313/gopu/utils/rolemanager"
 	"github.com/rs/xid"
 
@@ -24,16 +26,28 @@ import (
 	"github.com/ngs24313/gopu/models"
 	"github.com/ngs24313/gopu/utils/cache"
 	"github.com/ngs24313/gopu/utils/password"
+	"github.com/ngs24313/gopu/utils/password/policy"
+	"github.com/ngs24313/gopu/utils/verifycode"
 	"go.uber.org/zap"
 )
 
+//errEmailLinkNotConfigured the emailed link has neither its url nor the issuer configured
+var errEmailLinkNotConfigured = errors.New("url of emailed link is not configured")
+
 //Account is account api
 type Account struct {
 	ADB            db.AccountDatabase
+	AuditDB        db.AuditDatabase
 	RoleMgr        rolemanager.RoleManager
 	AuthMiddleware *middleware.Auth
 	Config         config.Config
 	Cache          cache.Cache
+	PasswordPolicy *policy.Policy //new passwords are not checked if nil
+	VerifyCodes    *verifycode.Service
+
+	IdentityProviders map[string]*oidc.Provider //upstream providers of federated login by name
+
+	jwtMiddleware *middleware.JWTMiddleware //built once by Register
 }
 
 //Register register handles
@@ -42,6 +56,7 @@ func (a *Account) Register(router *gin.RouterGroup) {
 	if err != nil {
 		panic(err)
 	}
+	a.jwtMiddleware = authMiddleware
 
 	v1 := router.Group("/v1")
 
@@ -49,7 +64,15 @@ func (a *Account) Register(router *gin.RouterGroup) {
 	{
 		session.POST("/", authMiddleware.LoginHandler)
 		session.DELETE("/", authMiddleware.LogoutHandler)
-		session.GET("/refresh_token", authMiddleware.RefreshHandler)
+		session.POST("/refresh_token", authMiddleware.RefreshHandler)
+		session.POST("/mfa", authMiddleware.MFAHandler)
+		session.GET("/federated/:provider", a.FederatedLogin)
+		session.GET("/federated/:provider/callback", a.FederatedCallback)
+
+		if a.Config.Services.Account.MagicLink.Enabled {
+			session.POST("/magic_link", a.CreateMagicLink)
+			session.GET("/magic_link/:token", a.LoginByMagicLink)
+		}
 	}
 
 	{
@@ -59,9 +82,15 @@ func (a *Account) Register(router *gin.RouterGroup) {
 
 		v1.POST("/user", a.RegisterUser)
 
+		v1.GET("/invitation/:token", a.GetInvitation)
+
 		v1.GET("/user/:id", a.GetUserByID)
 
-		v1.PUT("/user/:id/password", a.ResetUserPassword)
+		v1.PUT("/user/:id/password", authMiddleware.RejectImpersonation(), a.ResetUserPassword)
+
+		v1.GET("/user/:id/email/undo/:token", a.UndoEmailChange)
+
+		v1.GET("/user/:id/export/:token", a.DownloadExport)
 
 		user := v1.Group("/")
 		user.Use(authMiddleware.MiddlewareFunc())
@@ -70,9 +99,29 @@ func (a *Account) Register(router *gin.RouterGroup) {
 
 			user.PUT("/user/:id/profile", a.UpdateUserProfile)
 
+			user.PUT("/user/:id/email", a.ChangeEmail)
+			user.PUT("/user/:id/email/confirm", a.ConfirmEmail)
+			user.PUT("/user/:id/email/verify_code", a.CreateEmailVerifyCode)
+			user.PUT("/user/:id/email/verify", a.VerifyEmail)
+
 			user.DELETE("/user/:id", a.DeleteUser)
 
 			user.GET("/user", a.ListUser)
+
+			user.PUT("/user/:id/mfa/totp", a.EnrollTOTP)
+			user.PUT("/user/:id/mfa/totp/confirm", a.ConfirmTOTP)
+			user.DELETE("/user/:id/mfa/totp", a.DisableTOTP)
+			user.PUT("/user/:id/mfa/recovery_codes", a.RegenerateRecoveryCodes)
+
+			user.PUT("/user/:id/tokens", a.CreateToken)
+			user.GET("/user/:id/tokens", a.ListTokens)
+			user.DELETE("/user/:id/tokens/:token_id", a.RevokeToken)
+
+			user.GET("/user/:id/sessions", a.ListSessions)
+			user.DELETE("/user/:id/sessions", a.RevokeSessions)
+			user.DELETE("/user/:id/sessions/:sid", a.RevokeSession)
+
+			user.GET("/user/:id/export", a.ExportUser)
 		}
 	}
 }
@@ -85,6 +134,10 @@ func (a *Account) CreateUserRegisterCode(c *gin.Context) {
 		return
 	}
 
+	if !a.registrationAllowed(c, form.Email) {
+		return
+	}
+
 	if _, err := a.ADB.GetUserByEmail(c.Request.Context(), form.Email); err != nil {
 		if err != db.ErrNotFound {
 			replyInternalError(c, err)
@@ -95,15 +148,8 @@ func (a *Account) CreateUserRegisterCode(c *gin.Context) {
 		return
 	}
 
-	resetCode := utils.RandomDigit(6)
-	codeKey := a.Config.Services.Account.RegisterCodePrefix + form.Email + "." + resetCode
-
-	if err := a.Cache.Set(&cache.Entity{
-		Key:        codeKey,
-		Value:      []byte(""),
-		Expiration: a.Config.Services.Account.RegisterCodeExpiration,
-	}); err != nil {
-		replyInternalError(c, err)
+	registerCode, ok := a.createVerifyCode(c, a.registerCodePurpose(), form.Email)
+	if !ok {
 		return
 	}
 
@@ -113,7 +159,7 @@ func (a *Account) CreateUserRegisterCode(c *gin.Context) {
 		Address: form.Email,
 	}, a.Config.Mailer.EmailTemplates.RegisterCodeName,
 		map[string]string{
-			"code": resetCode,
+			"code": registerCode,
 		},
 		nil,
 	)
@@ -132,63 +178,123 @@ func (a *Account) RegisterUser(c *gin.Context) {
 		return
 	}
 
-	codeKey := a.Config.Services.Account.RegisterCodePrefix + form.Email + "." + form.RegisterCode
-	if _, err := a.Cache.Get(codeKey); err != nil {
-		if err == cache.ErrNotFound {
+	//the invited users register by the invitation instead of register code
+	var invitation *models.Invitation
+	if form.InvitationToken != "" {
+		if a.Config.Services.Account.Registration.Mode == registrationClosed {
+			replyError(c, apierr.NewAppError(http.StatusForbidden, "Registration is closed"))
+			return
+		}
+
+		var ok bool
+		if invitation, ok = a.pendingInvitation(c, form.InvitationToken); !ok {
+			return
+		}
+		if !strings.EqualFold(invitation.Email, form.Email) {
+			replyBadRequest(c, "The email is not invited", nil)
+			return
+		}
+		form.Email = invitation.Email
+	} else {
+		if !a.registrationAllowed(c, form.Email) {
+			return
+		}
+
+		if form.RegisterCode == "" {
 			replyBadRequest(c, "Register code is invalid", nil)
 			return
 		}
-		replyInternalError(c, err)
-		return
+
+		if err := a.VerifyCodes.Verify(a.registerCodePurpose(), form.Email, form.RegisterCode); err != nil {
+			if err == verifycode.ErrInvalidCode {
+				replyBadRequest(c, "Register code is invalid", nil)
+				return
+			}
+			replyInternalError(c, err)
+			return
+		}
 	}
 
 	user := &models.User{
 		Username: form.Username,
-		Password: password.GenHashPassword(form.Password),
 		Email:    form.Email,
+		State:    models.UserStateActive,
 		Profile: models.Profile{
 			Nickname: form.Username,
 		},
 	}
 
-	if ok, err := a.ADB.UserIsExists(c.Request.Context(), user); ok || err != nil {
-		if !ok && err != nil {
+	if !a.validatePassword(c, user, form.Password) {
+		return
+	}
+
+	if invitation == nil {
+		state, err := a.registrationState(c.Request.Context())
+		if err != nil {
 			replyInternalError(c, err)
 			return
-		} else if ok {
-			replyBadRequest(c, err.Error(), nil)
-			return
 		}
+		user.State = state
 	}
 
-	createdUser, err := a.ADB.CreateUser(c.Request.Context(), user)
+	hashedPwd, err := password.GenHashPassword(form.Password)
 	if err != nil {
 		replyInternalError(c, err)
 		return
 	}
 
-	count, err := a.ADB.CountUser(c.Request.Context())
-	if err != nil {
-		replyInternalError(c, err)
-		return
+	//the register code or invitation proves the email
+	now := time.Now()
+	user.Password = hashedPwd
+	user.PasswordChangedAt = &now
+	user.EmailVerifiedAt = &now
+
+	if ok, err := a.ADB.UserIsExists(c.Request.Context(), user); ok || err != nil {
+		if !ok && err != nil {
+			replyInternalError(c, err)
+			return
+		} else if ok {
+			replyBadRequest(c, err.Error(), nil)
+			return
+		}
 	}
 
-	if count == 1 {
-		if _, err := a.RoleMgr.AddRoleForUser(createdUser.ID, a.Config.RBAC.AdminName); err != nil {
-			if err != rolemanager.ErrUserHasRole {
-				log.Logger(c.Request.Context()).Warn("Failed to set user role", zap.Error(err))
+	//the code is burnt right before creating, so the concurrent requests cannot share it
+	if invitation == nil {
+		if err := a.VerifyCodes.Consume(a.registerCodePurpose(), form.Email, form.RegisterCode); err != nil {
+			if err == verifycode.ErrInvalidCode {
+				replyBadRequest(c, "Register code is invalid", nil)
+				return
 			}
+			replyInternalError(c, err)
+			return
 		}
+	}
+
+	var createdUser *models.User
+	if invitation != nil {
+		createdUser, err = a.ADB.CreateInvitedUser(c.Request.Context(), user, nil, invitation.ID, now,
+			func(u *models.User) error {
+				return a.addInvitedRoles(c.Request.Context(), u, invitation)
+			})
 	} else {
-		if _, err := a.RoleMgr.AddRoleForUser(createdUser.ID, a.Config.RBAC.UserName); err != nil {
-			if err != rolemanager.ErrUserHasRole {
-				log.Logger(c.Request.Context()).Warn("Failed to set user role", zap.Error(err))
-			}
+		createdUser, err = a.ADB.CreateUser(c.Request.Context(), user)
+	}
+	if err != nil {
+		switch err {
+		case db.ErrNotFound:
+			replyNotFound(c, "Invitation is invalid or expired", nil)
+		case rolemanager.ErrRoleNotExists:
+			replyBadRequest(c, errInvitedRoleNotExists.Error(), nil)
+		default:
+			replyInternalError(c, err)
 		}
+		return
+	}
 
-		if err := rolemanager.AddUserIDPrimaryAPI(createdUser.ID, a.Config.RBAC.UserName, a.RoleMgr, a.Config.RBAC); err != nil {
-			log.Logger(c.Request.Context()).Warn("Failed to set user primary api", zap.Error(err))
-		}
+	if err := a.addUserRoles(c.Request.Context(), createdUser); err != nil {
+		replyInternalError(c, err)
+		return
 	}
 
 	if err := a.userWithRoles(createdUser); err != nil {
@@ -196,13 +302,6 @@ func (a *Account) RegisterUser(c *gin.Context) {
 		return
 	}
 
-	if err := a.Cache.Del(codeKey); err != nil {
-		log.Logger(c.Request.Context()).Warn(
-			"Failed to delete register code",
-			zap.String("key", codeKey),
-			zap.Error(err),
-		)
-	}
 	replyOK(c, createdUser)
 }
 
@@ -230,6 +329,11 @@ func (a *Account) DeleteUser(c *gin.Context) {
 		return
 	}
 
+	if err := a.AuthMiddleware.RevokeUserTokens(c.Request.Context(), id); err != nil {
+		replyInternalError(c, err)
+		return
+	}
+
 	replyOK(c, gin.H{
 		"id": id,
 	})
@@ -256,6 +360,11 @@ func (a *Account) ListUser(c *gin.Context) {
 		return
 	}
 
+	if form.State != "" && !models.IsValidUserState(form.State) {
+		replyBadRequest(c, fmt.Sprintf("State [%s] is not valid", form.State), nil)
+		return
+	}
+
 	page := form.Page
 	if page <= 0 {
 		page = 1
@@ -266,14 +375,19 @@ func (a *Account) ListUser(c *gin.Context) {
 	}
 
 	query := db.UserListQuery{
-		Query:           form.Query,
-		Offset:          (page - 1) * pageSize,
-		Count:           pageSize,
-		CreateTimeStart: time.Unix(form.CreateTimeStart, 0),
-		CreateTimeEnd:   time.Unix(form.CreateTimeEnd, 0),
-		WithCount:       true,
-		OrderBy:         strings.Split(form.OrderBy, ","),
-		SortType:        strings.Split(form.SortType, ","),
+		Query:     form.Query,
+		State:     form.State,
+		Offset:    (page - 1) * pageSize,
+		Count:     pageSize,
+		WithCount: true,
+		OrderBy:   strings.Split(form.OrderBy, ","),
+		SortType:  strings.Split(form.SortType, ","),
+	}
+	if form.CreateTimeStart > 0 {
+		query.CreateTimeStart = time.Unix(form.CreateTimeStart, 0)
+	}
+	if form.CreateTimeEnd > 0 {
+		query.CreateTimeEnd = time.Unix(form.CreateTimeEnd, 0)
 	}
 
 	result, err := a.ADB.ListUser(c.Request.Context(), query)
@@ -363,41 +477,61 @@ func (a *Account) ResetUserPassword(c *gin.Context) {
 	}
 
 	a.withUserByID(c, func(user *models.User) {
-		var resetCodeKey string
+		var resetByCode bool
 		if form.OldPassword != "" {
-			if !password.CompareHashPassword(user.Password, form.OldPassword) {
-				replyBadRequest(c, "Old password incorrect", nil)
+			if !a.checkOldPassword(c, user, form.OldPassword) {
 				return
 			}
 		} else if form.ResetCode != "" {
-			resetCodeKey = a.Config.Services.Account.PasswordResetCodePrefix + user.Email + "." + form.ResetCode
-			_, err := a.Cache.Get(resetCodeKey)
-			if err != nil {
-				if err == cache.ErrNotFound {
+			if err := a.VerifyCodes.Verify(a.passwordResetCodePurpose(), user.Email, form.ResetCode); err != nil {
+				if err == verifycode.ErrInvalidCode {
 					replyNotFound(c, "Reset code is invalid", nil)
 					return
 				}
 				replyInternalError(c, err)
 				return
 			}
+			resetByCode = true
 		} else {
 			replyBadRequest(c, "Old password or reset code is missing", nil)
 			return
 		}
 
-		user.Password = password.GenHashPassword(form.NewPassword)
-		if err := a.ADB.UpdateUser(c.Request.Context(), user); err != nil {
+		if !a.validatePassword(c, user, form.NewPassword) {
+			return
+		}
+
+		hashedPwd, err := password.GenHashPassword(form.NewPassword)
+		if err != nil {
 			replyInternalError(c, err)
 			return
 		}
 
-		if resetCodeKey != "" {
-			if err := a.Cache.Del(resetCodeKey); err != nil {
-				log.Logger(c.Request.Context()).Warn("Failed to delete password reset code",
-					zap.String("key", resetCodeKey),
-					zap.Error(err))
+		if resetByCode {
+			if err := a.VerifyCodes.Consume(a.passwordResetCodePurpose(), user.Email, form.ResetCode); err != nil {
+				if err == verifycode.ErrInvalidCode {
+					replyNotFound(c, "Reset code is invalid", nil)
+					return
+				}
+				replyInternalError(c, err)
+				return
 			}
 		}
+
+		now := time.Now()
+		previous := user.Password
+		user.Password = hashedPwd
+		user.PasswordChangedAt = &now
+		if err := a.ADB.ChangePassword(c.Request.Context(), user, previous, a.passwordHistoryKeep()); err != nil {
+			replyInternalError(c, err)
+			return
+		}
+
+		if err := a.AuthMiddleware.RevokeUserTokens(c.Request.Context(), user.ID); err != nil {
+			replyInternalError(c, err)
+			return
+		}
+
 		replyOK(c, nil)
 	})
 }
@@ -425,13 +559,8 @@ func (a *Account) CreatePasswordResetCode(c *gin.Context) {
 		return
 	}
 
-	resetCode := utils.RandomDigit(6)
-	if err := a.Cache.Set(&cache.Entity{
-		Key:        a.Config.Services.Account.PasswordResetCodePrefix + form.Email + "." + resetCode,
-		Value:      []byte(""),
-		Expiration: a.Config.Services.Account.PasswordResetCodeExpiration,
-	}); err != nil {
-		replyInternalError(c, err)
+	resetCode, ok := a.createVerifyCode(c, a.passwordResetCodePurpose(), user.Email)
+	if !ok {
 		return
 	}
 
@@ -513,6 +642,34 @@ func (a *Account) withUserByID(c *gin.Context, f func(user *models.User)) {
 	f(user)
 }
 
+//addUserRoles adds the admin role to the first user, and the user role to others
+func (a *Account) addUserRoles(ctx context.Context, user *models.User) error {
+	count, err := a.ADB.CountUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	if count == 1 {
+		if _, err := a.RoleMgr.AddRoleForUser(user.ID, a.Config.RBAC.AdminName); err != nil {
+			if err != rolemanager.ErrUserHasRole {
+				log.Logger(ctx).Warn("Failed to set user role", zap.Error(err))
+			}
+		}
+		return nil
+	}
+
+	if _, err := a.RoleMgr.AddRoleForUser(user.ID, a.Config.RBAC.UserName); err != nil {
+		if err != rolemanager.ErrUserHasRole {
+			log.Logger(ctx).Warn("Failed to set user role", zap.Error(err))
+		}
+	}
+
+	if err := rolemanager.AddUserIDPrimaryAPI(user.ID, a.Config.RBAC.UserName, a.RoleMgr, a.Config.RBAC); err != nil {
+		log.Logger(ctx).Warn("Failed to set user primary api", zap.Error(err))
+	}
+	return nil
+}
+
 func (a *Account) userWithRoles(user *models.User) error {
 	roles, err := a.RoleMgr.GetRoleForUser(user.ID)
 	if err != nil {
@@ -521,3 +678,45 @@ func (a *Account) userWithRoles(user *models.User) error {
 	user.Roles = strings.Join(roles, ",")
 	return nil
 }
+
+//emailLink get the configured url of an emailed link, or the api path under the configured issuer.
+//The links are never derived from the request, its host headers are set by the client
+func emailLink(conf config.Config, configured string, path string) (string, error) {
+	if configured != "" {
+		return configured, nil
+	}
+
+	issuer := strings.TrimSuffix(conf.Services.Account.Auth.OAuth.Issuer, "/")
+	if issuer == "" {
+		return "", errEmailLinkNotConfigured
+	}
+	return issuer + path, nil
+}
+
+//CheckEmailLinks return error unless every enabled emailed link has an absolute url or the issuer configured
+func CheckEmailLinks(conf config.Config) error {
+	account := conf.Services.Account
+	links := map[string]string{
+		"registration.invitation_url": account.Registration.InvitationURL,
+		"email_change.undo_url":       account.EmailChange.UndoURL,
+		"data_export.url":             account.DataExport.URL,
+	}
+	if account.MagicLink.Enabled {
+		links["magic_link.url"] = account.MagicLink.URL
+	}
+
+	for name, link := range links {
+		if link == "" {
+			if account.Auth.OAuth.Issuer == "" {
+				return fmt.Errorf("%s or oauth.issuer must be configured", name)
+			}
+			name, link = "oauth.issuer", account.Auth.OAuth.Issuer
+		}
+
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s must be an absolute url", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/gin-gonic/gin"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	dao "github.com/ngs24313/gopu/api/database/database"
+	"github.com/ngs24313/gopu/config"
+	"github.com/ngs24313/gopu/middleware"
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils/cache/bigcache"
+	gormdb "github.com/ngs24313/gopu/utils/database/gorm"
+	"github.com/ngs24313/gopu/utils/mailer"
+	"github.com/ngs24313/gopu/utils/mailer/template"
+	"github.com/ngs24313/gopu/utils/password"
+	casbinmgr "github.com/ngs24313/gopu/utils/rolemanager/casbin"
+	"github.com/ngs24313/gopu/utils/verifycode"
+)
+
+const (
+	testUsername = "alice"
+	testEmail    = "alice@example.com"
+	testPassword = "alice-password"
+)
+
+//testMailer keeps the sent messages instead of sending them
+type testMailer struct {
+	mu       sync.Mutex
+	messages []*mailer.Message
+}
+
+func (m *testMailer) Send(msg *mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+//last get the body of the last message sent to address
+func (m *testMailer) last(address string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To[0].Address == address {
+			return m.messages[i].Body
+		}
+	}
+	return ""
+}
+
+type testServer struct {
+	*httptest.Server
+	t       *testing.T
+	account *Account
+	mailer  *testMailer
+	user    *models.User
+}
+
+//newTestServer serve the account apis for user alice, setup changes the config before the apis are registered.
+//The emails only contain the code or link
+func newTestServer(t *testing.T, setup func(conf *config.Config)) *testServer {
+	h, err := gormdb.NewHandler("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//every connection of sqlite memory database is a new database
+	h.(gormdb.Database).Instance().DB().SetMaxOpenConns(1)
+
+	if err := h.Migrate(
+		&models.User{},
+		&models.Profile{},
+		&models.RefreshToken{},
+		&models.PersonalAccessToken{},
+		&models.TOTP{},
+		&models.RecoveryCode{},
+		&models.OAuthClient{},
+		&models.OAuthConsent{},
+		&models.FederatedIdentity{},
+		&models.Session{},
+		&models.AuditEvent{},
+		&models.PasswordHistory{},
+		&models.Invitation{},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := config.Config{}
+	conf.Services.Account.Auth.OAuth.Issuer = "https://id.example.com"
+	conf.Services.Account.RegisterCodePrefix = "register_code."
+	conf.Services.Account.RegisterCodeExpiration = time.Hour
+	conf.RBAC.AdminName = "admin"
+	conf.RBAC.UserName = "user"
+	conf.Mailer.Username = "noreply@example.com"
+	conf.Mailer.EmailTemplates = config.EmailTemplates{
+		Templates: map[string]config.EmailTemplate{
+			"code": {Subject: "code", Content: "{{.code}}"},
+			"link": {Subject: "link", Content: "{{.link}}"},
+		},
+		RegisterCodeName:    "code",
+		EmailChangeCodeName: "code",
+		EmailChangedName:    "link",
+	}
+	if setup != nil {
+		setup(&conf)
+	}
+	if err := template.Init(&conf); err != nil {
+		t.Fatal(err)
+	}
+	m := &testMailer{}
+	mailer.SetMailer(m)
+
+	e, err := casbin.NewSyncedEnforcer("../../policy/rbac_model.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roleMgr := casbinmgr.NewCasbinRoleManager(e)
+	for _, role := range []string{"admin", "user", "editor"} {
+		if _, err := roleMgr.CreateRole(&models.Role{
+			Name:        role,
+			Permissions: []models.Permission{{API: "/v1/current_user", Method: "GET"}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := bigcache.NewCache()
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	hashedPwd, err := password.GenHashPassword(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adb := dao.NewAccountDatabase(h)
+	user, err := adb.CreateUser(context.Background(), &models.User{
+		Username: testUsername,
+		Email:    testEmail,
+		Password: hashedPwd,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//alice manages herself only
+	if _, err := roleMgr.CreateRole(&models.Role{
+		Name:        user.ID,
+		Permissions: []models.Permission{{API: "/v1/user/" + user.ID + "/*", Method: "*"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	auth := middleware.NewAuth(adb, dao.NewTokenDatabase(h), dao.NewOAuthDatabase(h), dao.NewAuditDatabase(h), roleMgr,
+		middleware.WithKey([]byte("secret")),
+		middleware.WithCache(c),
+	)
+	a := &Account{
+		ADB:            adb,
+		AuditDB:        dao.NewAuditDatabase(h),
+		RoleMgr:        roleMgr,
+		AuthMiddleware: auth,
+		Config:         conf,
+		Cache:          c,
+		VerifyCodes:    verifycode.New(c, config.VerifyCode{}, nil),
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	a.Register(engine.Group(""))
+
+	return &testServer{
+		Server:  httptest.NewServer(engine),
+		t:       t,
+		account: a,
+		mailer:  m,
+		user:    user,
+	}
+}
+
+//do send the form by method, the form is in query for GET
+func (s *testServer) do(method string, path string, token string, form url.Values) (*http.Response, map[string]interface{}) {
+	var body *strings.Reader
+	if method == "GET" {
+		if len(form) > 0 {
+			path += "?" + form.Encode()
+		}
+		body = strings.NewReader("")
+	} else {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequest(method, s.URL+path, body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if method != "GET" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	reply := map[string]interface{}{}
+	json.NewDecoder(resp.Body).Decode(&reply)
+	return resp, reply
+}
+
+//login get the access token of alice
+func (s *testServer) login() string {
+	resp, reply := s.do("POST", "/v1/session/", "", url.Values{"username": {testUsername}, "password": {testPassword}})
+	token, _ := reply["token"].(string)
+	if resp.StatusCode != http.StatusOK || token == "" {
+		s.t.Fatalf("login = %d %v", resp.StatusCode, reply)
+	}
+	return token
+}
+
+func TestCheckEmailLinks(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(account *config.Account)
+		ok    bool
+	}{
+		{
+			name: "nothing configured",
+		},
+		{
+			name: "issuer",
+			setup: func(account *config.Account) {
+				account.Auth.OAuth.Issuer = "https://id.example.com"
+				account.MagicLink.Enabled = true
+			},
+			ok: true,
+		},
+		{
+			name: "relative issuer",
+			setup: func(account *config.Account) {
+				account.Auth.OAuth.Issuer = "id.example.com"
+			},
+		},
+		{
+			name: "every url",
+			setup: func(account *config.Account) {
+				account.Registration.InvitationURL = "https://example.com/register?invitation_token="
+				account.EmailChange.UndoURL = "https://example.com/undo/"
+				account.DataExport.URL = "https://example.com/export/"
+			},
+			ok: true,
+		},
+		{
+			name: "magic link without url",
+			setup: func(account *config.Account) {
+				account.Registration.InvitationURL = "https://example.com/register?invitation_token="
+				account.EmailChange.UndoURL = "https://example.com/undo/"
+				account.DataExport.URL = "https://example.com/export/"
+				account.MagicLink.Enabled = true
+			},
+		},
+		{
+			name: "relative url",
+			setup: func(account *config.Account) {
+				account.Auth.OAuth.Issuer = "https://id.example.com"
+				account.DataExport.URL = "/export/"
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := config.Config{}
+			if tt.setup != nil {
+				tt.setup(&conf.Services.Account)
+			}
+			if err := CheckEmailLinks(conf); (err == nil) != tt.ok {
+				t.Errorf("CheckEmailLinks() = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
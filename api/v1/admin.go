@@ -0,0 +1,261 @@
+package v1
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	db "github.com/ngs24313/gopu/api/database"
+	apierr "github.com/ngs24313/gopu/api/error"
+	forms "github.com/ngs24313/gopu/api/forms/admin"
+	"github.com/ngs24313/gopu/config"
+	"github.com/ngs24313/gopu/middleware"
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils/cache"
+	"github.com/ngs24313/gopu/utils/rolemanager"
+)
+
+//Admin is admin api
+type Admin struct {
+	ADB            db.AccountDatabase
+	AuditDB        db.AuditDatabase
+	RoleMgr        rolemanager.RoleManager
+	AuthMiddleware *middleware.Auth
+	Config         config.Config
+
+	jwtMiddleware *middleware.JWTMiddleware //built once by Register
+}
+
+type impersonationReply struct {
+	UserID string    `json:"user_id"`
+	Token  string    `json:"token"`
+	Expire time.Time `json:"expire"`
+}
+
+//Register register handles
+func (a *Admin) Register(router *gin.RouterGroup) {
+	jwtMiddleware, err := a.AuthMiddleware.Middleware()
+	if err != nil {
+		panic(err)
+	}
+	a.jwtMiddleware = jwtMiddleware
+
+	admin := router.Group("/v1/admin")
+	admin.Use(jwtMiddleware.MiddlewareFunc())
+	{
+		admin.GET("/lockout", a.ListLockout)
+		admin.GET("/lockout/:kind/:key", a.GetLockout)
+		admin.DELETE("/lockout/:kind/:key", a.ClearLockout)
+
+		admin.PUT("/user/:id/state", a.SetUserState)
+
+		admin.GET("/deleted_users", a.ListDeletedUsers)
+		admin.POST("/deleted_users/:id/restore", a.RestoreDeletedUser)
+		admin.DELETE("/deleted_users/:id", a.PurgeDeletedUser)
+
+		admin.POST("/invitations", a.CreateInvitation)
+		admin.GET("/invitations", a.ListInvitations)
+		admin.DELETE("/invitations/:id", a.RevokeInvitation)
+
+		admin.POST("/impersonate/:id", a.Impersonate)
+		admin.GET("/audit", a.ListAuditEvents)
+	}
+}
+
+//ListLockout handles GET /v1/admin/lockout
+func (a *Admin) ListLockout(c *gin.Context) {
+	lockedOnly := c.Query("locked") == "true"
+
+	states, err := a.AuthMiddleware.LoginLimiter().List(lockedOnly)
+	if err != nil {
+		replyInternalError(c, err)
+		return
+	}
+	replyOK(c, states)
+}
+
+//GetLockout handles GET /v1/admin/lockout/:kind/:key
+func (a *Admin) GetLockout(c *gin.Context) {
+	state, err := a.AuthMiddleware.LoginLimiter().State(c.Param("kind"), c.Param("key"))
+	if err != nil {
+		switch err {
+		case cache.ErrNotFound:
+			replyNotFound(c, "The lockout state does not exist", nil)
+		case middleware.ErrInvalidLockoutKind:
+			replyBadRequest(c, err.Error(), nil)
+		default:
+			replyInternalError(c, err)
+		}
+		return
+	}
+	replyOK(c, state)
+}
+
+//ClearLockout handles DELETE /v1/admin/lockout/:kind/:key
+func (a *Admin) ClearLockout(c *gin.Context) {
+	if err := a.AuthMiddleware.LoginLimiter().Clear(c.Param("kind"), c.Param("key")); err != nil {
+		if err == middleware.ErrInvalidLockoutKind {
+			replyBadRequest(c, err.Error(), nil)
+			return
+		}
+		replyInternalError(c, err)
+		return
+	}
+	replyOK(c, nil)
+}
+
+//Impersonate handles POST /v1/admin/impersonate/:id
+func (a *Admin) Impersonate(c *gin.Context) {
+	actor, ok := c.Get(a.AuthMiddleware.Options().IdentityKey)
+	if !ok {
+		replyUnauthorized(c, "You don't have permission to access", nil)
+		return
+	}
+
+	//the permissions of admin api can be granted to other roles, impersonation cannot
+	isAdmin, err := a.RoleMgr.HasRoleForUser(actor.(*models.User).ID, a.Config.RBAC.AdminName)
+	if err != nil {
+		replyInternalError(c, err)
+		return
+	}
+	if !isAdmin {
+		replyError(c, apierr.NewAppError(http.StatusForbidden, "Only admins can impersonate users"))
+		return
+	}
+
+	target, err := a.ADB.GetUserByID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if err == db.ErrNotFound {
+			replyNotFound(c, "The user does not exist", nil)
+			return
+		}
+		replyInternalError(c, err)
+		return
+	}
+
+	targetIsAdmin, err := a.RoleMgr.HasRoleForUser(target.ID, a.Config.RBAC.AdminName)
+	if err != nil {
+		replyInternalError(c, err)
+		return
+	}
+	if targetIsAdmin {
+		replyError(c, apierr.NewAppError(http.StatusForbidden, "Admins cannot be impersonated"))
+		return
+	}
+
+	token, expire, err := a.jwtMiddleware.ImpersonationToken(c, target)
+	if err != nil {
+		if err == middleware.ErrImpersonationNotAllowed {
+			replyError(c, apierr.NewAppError(http.StatusForbidden, err.Error()))
+			return
+		}
+		replyInternalError(c, err)
+		return
+	}
+
+	replyOK(c, &impersonationReply{
+		UserID: target.ID,
+		Token:  token,
+		Expire: expire,
+	})
+}
+
+//SetUserState handles PUT /v1/admin/user/:id/state
+func (a *Admin) SetUserState(c *gin.Context) {
+	form := forms.UserStateForm{}
+	if err := c.ShouldBind(&form); err != nil {
+		replyBadRequest(c, "Some fields is not valid", err)
+		return
+	}
+
+	if !models.IsValidUserState(form.State) {
+		replyBadRequest(c, fmt.Sprintf("State [%s] is not valid", form.State), nil)
+		return
+	}
+
+	actor, ok := c.Get(a.AuthMiddleware.Options().IdentityKey)
+	if !ok {
+		replyUnauthorized(c, "You don't have permission to access", nil)
+		return
+	}
+
+	user, err := a.ADB.GetUserByID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if err == db.ErrNotFound {
+			replyNotFound(c, "The user does not exist", nil)
+			return
+		}
+		replyInternalError(c, err)
+		return
+	}
+
+	if user.ID == actor.(*models.User).ID {
+		replyBadRequest(c, "Admins cannot change their own state", nil)
+		return
+	}
+
+	from := user.State
+	if err := a.AuthMiddleware.SetUserState(c.Request.Context(), user, form.State, form.Reason,
+		actor.(*models.User).ID); err != nil {
+		switch err {
+		case middleware.ErrInvalidStateTransition:
+			replyBadRequest(c, fmt.Sprintf("User cannot be moved from [%s] to [%s]", from, form.State), nil)
+		case db.ErrNotFound:
+			replyError(c, apierr.NewAppError(http.StatusConflict, "The state of user has been changed"))
+		default:
+			replyInternalError(c, err)
+		}
+		return
+	}
+	replyOK(c, user)
+}
+
+//ListAuditEvents handles GET /v1/admin/audit
+func (a *Admin) ListAuditEvents(c *gin.Context) {
+	form := forms.AuditListForm{}
+	if err := c.ShouldBind(&form); err != nil {
+		replyBadRequest(c, "Some fields is not valid", err)
+		return
+	}
+
+	page := form.Page
+	if page <= 0 {
+		page = 1
+	}
+	pageSize := form.PageSize
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	query := db.AuditListQuery{
+		Action:  form.Action,
+		ActorID: form.ActorID,
+		UserID:  form.UserID,
+		Offset:  (page - 1) * pageSize,
+		Count:   pageSize,
+	}
+	if form.TimeStart > 0 {
+		query.TimeStart = time.Unix(form.TimeStart, 0)
+	}
+	if form.TimeEnd > 0 {
+		query.TimeEnd = time.Unix(form.TimeEnd, 0)
+	}
+
+	result, err := a.AuditDB.ListAuditEvents(c.Request.Context(), query)
+	if err != nil {
+		replyInternalError(c, err)
+		return
+	}
+
+	resultForm := forms.AuditListResultForm{
+		Page:      page,
+		PageSize:  pageSize,
+		PageCount: int(result.Count) / pageSize,
+		Events:    result.Events,
+	}
+	if int(result.Count)%pageSize != 0 {
+		resultForm.PageCount++
+	}
+	replyOK(c, &resultForm)
+}
@@ -0,0 +1,100 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	db "github.com/ngs24313/gopu/api/database"
+	forms "github.com/ngs24313/gopu/api/forms/admin"
+	"github.com/ngs24313/gopu/utils/log"
+	"github.com/ngs24313/gopu/utils/rolemanager"
+	"go.uber.org/zap"
+)
+
+//ListDeletedUsers handles GET /v1/admin/deleted_users
+func (a *Admin) ListDeletedUsers(c *gin.Context) {
+	form := forms.DeletedUserListForm{}
+	if err := c.ShouldBind(&form); err != nil {
+		replyBadRequest(c, "Some fields is not valid", err)
+		return
+	}
+
+	page := form.Page
+	if page <= 0 {
+		page = 1
+	}
+	pageSize := form.PageSize
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	result, err := a.ADB.ListDeletedUsers(c.Request.Context(), db.UserListQuery{
+		Query:     form.Query,
+		Offset:    (page - 1) * pageSize,
+		Count:     pageSize,
+		WithCount: true,
+	})
+	if err != nil {
+		replyInternalError(c, err)
+		return
+	}
+
+	resultForm := forms.DeletedUserListResultForm{
+		Page:      page,
+		PageSize:  pageSize,
+		PageCount: int(result.Count) / pageSize,
+		Users:     result.Users,
+	}
+	if int(result.Count)%pageSize != 0 {
+		resultForm.PageCount++
+	}
+	replyOK(c, &resultForm)
+}
+
+//RestoreDeletedUser handles POST /v1/admin/deleted_users/:id/restore
+func (a *Admin) RestoreDeletedUser(c *gin.Context) {
+	user, err := a.ADB.RestoreUser(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		switch err {
+		case db.ErrNotFound:
+			replyNotFound(c, "The deleted user does not exist", nil)
+		case db.ErrUsernameAlreadyExists, db.ErrEmailAlreadyExists:
+			replyBadRequest(c, err.Error(), nil)
+		default:
+			replyInternalError(c, err)
+		}
+		return
+	}
+	replyOK(c, user)
+}
+
+//PurgeDeletedUser handles DELETE /v1/admin/deleted_users/:id, the user is deleted permanently
+//and its identifiers are freed
+func (a *Admin) PurgeDeletedUser(c *gin.Context) {
+	id := c.Param("id")
+	user, err := a.ADB.PurgeUser(c.Request.Context(), id)
+	if err != nil {
+		if err == db.ErrNotFound {
+			replyNotFound(c, "The deleted user does not exist", nil)
+			return
+		}
+		replyInternalError(c, err)
+		return
+	}
+
+	if err := rolemanager.DeleteUserRoles(id, a.RoleMgr); err != nil {
+		log.Logger(c.Request.Context()).Warn("Failed to delete roles of purged user",
+			zap.String("user", id),
+			zap.Error(err))
+	}
+
+	if avatar := user.Profile.Avatar; avatar != "" {
+		if err := a.Config.RemoveAvatar(avatar); err != nil {
+			log.Logger(c.Request.Context()).Warn("Failed to remove avatar of purged user",
+				zap.String("user", id),
+				zap.Error(err))
+		}
+	}
+
+	replyOK(c, gin.H{
+		"id": id,
+	})
+}
@@ -0,0 +1,341 @@
+package v1
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	db "github.com/ngs24313/gopu/api/database"
+	forms "github.com/ngs24313/gopu/api/forms/account"
+	"github.com/ngs24313/gopu/config"
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils"
+	"github.com/ngs24313/gopu/utils/cache"
+	"github.com/ngs24313/gopu/utils/log"
+	"github.com/ngs24313/gopu/utils/mailer"
+	"github.com/ngs24313/gopu/utils/verifycode"
+	"go.uber.org/zap"
+)
+
+const (
+	emailChangeUndoTokenSize         = 32
+	emailChangeDefaultCodeExpiration = time.Hour
+	emailChangeDefaultCodePrefix     = "email_change_code."
+	emailChangeDefaultUndoExpiration = 72 * time.Hour
+	emailChangeDefaultUndoPrefix     = "email_change_undo."
+	emailVerifyDefaultCodeExpiration = 24 * time.Hour
+	emailVerifyDefaultCodePrefix     = "email_verify_code."
+)
+
+//emailChange is cached by the user until the code sent to the new email is confirmed, and by the hash of the
+//undo token sent to the old email
+type emailChange struct {
+	UserID   string `json:"user_id"`
+	OldEmail string `json:"old_email"`
+	NewEmail string `json:"new_email"`
+}
+
+//ChangeEmail handles PUT /v1/user/:id/email
+func (a *Account) ChangeEmail(c *gin.Context) {
+	form := &forms.EmailChangeForm{}
+	if err := c.ShouldBind(form); err != nil {
+		replyBadRequest(c, "Some fields is not valid", err)
+		return
+	}
+
+	a.withUserByID(c, func(user *models.User) {
+		if form.Email == user.Email {
+			replyBadRequest(c, "New email is the same as the current email", nil)
+			return
+		}
+
+		if ok, err := a.ADB.UserIsExists(c.Request.Context(), &models.User{Email: form.Email}); ok || err != nil {
+			if !ok {
+				replyInternalError(c, err)
+				return
+			}
+			replyBadRequest(c, db.ErrEmailAlreadyExists.Error(), nil)
+			return
+		}
+
+		conf := a.emailChangeConfig()
+		code, ok := a.createVerifyCode(c, a.emailChangeCodePurpose(conf), user.ID)
+		if !ok {
+			return
+		}
+
+		if err := cache.SetJSON(a.Cache, emailChangePendingKey(conf, user.ID), &emailChange{
+			UserID:   user.ID,
+			OldEmail: user.Email,
+			NewEmail: form.Email,
+		}, conf.CodeExpiration); err != nil {
+			replyInternalError(c, err)
+			return
+		}
+
+		replyEmail(c, mailer.User{
+			Address: a.Config.Mailer.Username,
+		}, mailer.User{
+			Address: form.Email,
+		}, a.Config.Mailer.EmailTemplates.EmailChangeCodeName,
+			map[string]string{
+				"code": code,
+			},
+			nil,
+		)
+	})
+}
+
+//ConfirmEmail handles PUT /v1/user/:id/email/confirm, the old email gets a link to undo the change
+func (a *Account) ConfirmEmail(c *gin.Context) {
+	form := &forms.EmailCodeForm{}
+	if err := c.ShouldBind(form); err != nil {
+		replyBadRequest(c, "Some fields is not valid", err)
+		return
+	}
+
+	a.withUserByID(c, func(user *models.User) {
+		conf := a.emailChangeConfig()
+		link, err := emailLink(a.Config, conf.UndoURL, "/v1/user/"+user.ID+"/email/undo/")
+		if err != nil {
+			replyInternalError(c, err)
+			return
+		}
+
+		if err := a.VerifyCodes.Consume(a.emailChangeCodePurpose(conf), user.ID, form.Code); err != nil {
+			if err == verifycode.ErrInvalidCode {
+				replyBadRequest(c, "Email change code is invalid", nil)
+				return
+			}
+			replyInternalError(c, err)
+			return
+		}
+
+		change, ok := a.takeEmailChange(c, emailChangePendingKey(conf, user.ID), "Email change code is invalid")
+		if !ok {
+			return
+		}
+
+		if change.UserID != user.ID || change.OldEmail != user.Email {
+			replyBadRequest(c, "Email change code is invalid", nil)
+			return
+		}
+
+		if !a.swapEmail(c, change.UserID, change.OldEmail, change.NewEmail) {
+			return
+		}
+
+		token, err := utils.RandomToken(emailChangeUndoTokenSize)
+		if err != nil {
+			replyInternalError(c, err)
+			return
+		}
+
+		if err := cache.SetJSON(a.Cache, conf.UndoPrefix+utils.HashToken(token), change,
+			conf.UndoExpiration); err != nil {
+			replyInternalError(c, err)
+			return
+		}
+
+		replyEmail(c, mailer.User{
+			Address: a.Config.Mailer.Username,
+		}, mailer.User{
+			Address: change.OldEmail,
+		}, a.Config.Mailer.EmailTemplates.EmailChangedName,
+			map[string]string{
+				"old_email":  change.OldEmail,
+				"new_email":  change.NewEmail,
+				"link":       link + token,
+				"expiration": conf.UndoExpiration.String(),
+			},
+			gin.H{
+				"email": change.NewEmail,
+			},
+		)
+	})
+}
+
+//UndoEmailChange handles GET /v1/user/:id/email/undo/:token, the old email is restored and the tokens
+//of user are revoked
+func (a *Account) UndoEmailChange(c *gin.Context) {
+	key := a.emailChangeConfig().UndoPrefix + utils.HashToken(c.Param("token"))
+	change, ok := a.takeEmailChange(c, key, "Undo link is invalid or expired")
+	if !ok {
+		return
+	}
+
+	if change.UserID != c.Param("id") {
+		replyBadRequest(c, "Undo link is invalid or expired", nil)
+		return
+	}
+
+	if !a.swapEmail(c, change.UserID, change.NewEmail, change.OldEmail) {
+		return
+	}
+
+	if err := a.AuthMiddleware.RevokeUserTokens(c.Request.Context(), change.UserID); err != nil {
+		replyInternalError(c, err)
+		return
+	}
+
+	replyOK(c, gin.H{
+		"email": change.OldEmail,
+	})
+}
+
+//CreateEmailVerifyCode handles PUT /v1/user/:id/email/verify_code
+func (a *Account) CreateEmailVerifyCode(c *gin.Context) {
+	a.withUserByID(c, func(user *models.User) {
+		if user.EmailVerifiedAt != nil {
+			replyBadRequest(c, "Email is already verified", nil)
+			return
+		}
+
+		code, ok := a.createVerifyCode(c, a.emailVerifyCodePurpose(), user.Email)
+		if !ok {
+			return
+		}
+
+		replyEmail(c, mailer.User{
+			Address: a.Config.Mailer.Username,
+		}, mailer.User{
+			Address: user.Email,
+		}, a.Config.Mailer.EmailTemplates.EmailVerifyCodeName,
+			map[string]string{
+				"code": code,
+			},
+			nil,
+		)
+	})
+}
+
+//VerifyEmail handles PUT /v1/user/:id/email/verify, the code only verifies the email it is sent to
+func (a *Account) VerifyEmail(c *gin.Context) {
+	form := &forms.EmailCodeForm{}
+	if err := c.ShouldBind(form); err != nil {
+		replyBadRequest(c, "Some fields is not valid", err)
+		return
+	}
+
+	a.withUserByID(c, func(user *models.User) {
+		if err := a.VerifyCodes.Consume(a.emailVerifyCodePurpose(), user.Email, form.Code); err != nil {
+			if err == verifycode.ErrInvalidCode {
+				replyBadRequest(c, "Email verify code is invalid", nil)
+				return
+			}
+			replyInternalError(c, err)
+			return
+		}
+
+		if err := a.ADB.VerifyEmail(c.Request.Context(), user.ID, user.Email, time.Now()); err != nil {
+			if err == db.ErrNotFound {
+				replyBadRequest(c, "Email verify code is invalid", nil)
+				return
+			}
+			replyInternalError(c, err)
+			return
+		}
+
+		replyOK(c, gin.H{
+			"email":          user.Email,
+			"email_verified": true,
+		})
+	})
+}
+
+//takeEmailChange get and delete the cached change, only the one deleting it can use it
+func (a *Account) takeEmailChange(c *gin.Context, key string, invalidMsg string) (*emailChange, bool) {
+	var change emailChange
+	if err := cache.GetJSON(a.Cache, key, &change); err != nil {
+		if err != cache.ErrNotFound {
+			replyInternalError(c, err)
+			return nil, false
+		}
+		replyBadRequest(c, invalidMsg, nil)
+		return nil, false
+	}
+
+	if err := a.Cache.Del(key); err != nil {
+		if err != cache.ErrNotFound {
+			replyInternalError(c, err)
+			return nil, false
+		}
+		replyBadRequest(c, invalidMsg, nil)
+		return nil, false
+	}
+	return &change, true
+}
+
+//swapEmail replace the email of user if it is still from and to is not used by other users, to is verified
+//by the code or link sent to it
+func (a *Account) swapEmail(c *gin.Context, userID string, from string, to string) bool {
+	if ok, err := a.ADB.UserIsExists(c.Request.Context(), &models.User{Email: to}); ok || err != nil {
+		if !ok {
+			replyInternalError(c, err)
+			return false
+		}
+		replyBadRequest(c, db.ErrEmailAlreadyExists.Error(), nil)
+		return false
+	}
+
+	if err := a.ADB.ChangeEmail(c.Request.Context(), userID, from, to, time.Now()); err != nil {
+		switch err {
+		case db.ErrEmailAlreadyExists:
+			replyBadRequest(c, err.Error(), nil)
+		case db.ErrNotFound:
+			replyBadRequest(c, "Email has been changed", nil)
+		default:
+			replyInternalError(c, err)
+		}
+		return false
+	}
+
+	log.Logger(c.Request.Context()).Info("Email of user changed",
+		zap.String("user", userID),
+		zap.String("from", from),
+		zap.String("to", to))
+	return true
+}
+
+//emailChangeCodePurpose the email change codes are kept by the user, a new request replaces the pending change
+func (a *Account) emailChangeCodePurpose(conf config.EmailChange) verifycode.Purpose {
+	return verifycode.Purpose{
+		Prefix:     conf.CodePrefix,
+		Expiration: conf.CodeExpiration,
+	}
+}
+
+//emailVerifyCodePurpose the email verify codes are kept by the email, so a code only verifies the email it is sent to
+func (a *Account) emailVerifyCodePurpose() verifycode.Purpose {
+	conf := a.emailChangeConfig()
+	return verifycode.Purpose{
+		Prefix:     conf.VerifyCodePrefix,
+		Expiration: conf.VerifyCodeExpiration,
+	}
+}
+
+func emailChangePendingKey(conf config.EmailChange, userID string) string {
+	return conf.CodePrefix + "pending." + userID
+}
+
+func (a *Account) emailChangeConfig() config.EmailChange {
+	conf := a.Config.Services.Account.EmailChange
+	if conf.CodeExpiration == 0 {
+		conf.CodeExpiration = emailChangeDefaultCodeExpiration
+	}
+	if conf.CodePrefix == "" {
+		conf.CodePrefix = emailChangeDefaultCodePrefix
+	}
+	if conf.UndoExpiration == 0 {
+		conf.UndoExpiration = emailChangeDefaultUndoExpiration
+	}
+	if conf.UndoPrefix == "" {
+		conf.UndoPrefix = emailChangeDefaultUndoPrefix
+	}
+	if conf.VerifyCodeExpiration == 0 {
+		conf.VerifyCodeExpiration = emailVerifyDefaultCodeExpiration
+	}
+	if conf.VerifyCodePrefix == "" {
+		conf.VerifyCodePrefix = emailVerifyDefaultCodePrefix
+	}
+	return conf
+}
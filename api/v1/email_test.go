@@ -0,0 +1,95 @@
+package v1
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ngs24313/gopu/models"
+)
+
+func TestChangeEmail(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	ctx := context.Background()
+
+	if _, err := s.account.ADB.CreateUser(ctx, &models.User{Username: "bob", Email: "bob@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	token := s.login()
+	path := "/v1/user/" + s.user.ID + "/email"
+	emailOf := func() string {
+		user, err := s.account.ADB.GetUserByID(ctx, s.user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user.Email
+	}
+
+	tests := []struct {
+		name   string
+		email  string
+		status int
+	}{
+		{"same email", testEmail, http.StatusBadRequest},
+		{"email of other user", "bob@example.com", http.StatusBadRequest},
+		{"new email", "alice@new.example.com", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if resp, reply := s.do("PUT", path, token, url.Values{"email": {tt.email}}); resp.StatusCode != tt.status {
+			t.Errorf("%s: change = %d %v, want %d", tt.name, resp.StatusCode, reply, tt.status)
+		}
+	}
+
+	code := s.mailer.last("alice@new.example.com")
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	if resp, _ := s.do("PUT", path+"/confirm", token, url.Values{"code": {wrong}}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("confirm by wrong code = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+	if email := emailOf(); email != testEmail {
+		t.Errorf("email before confirming = %s, want %s", email, testEmail)
+	}
+
+	resp, reply := s.do("PUT", path+"/confirm", token, url.Values{"code": {code}})
+	if resp.StatusCode != http.StatusOK || reply["email"] != "alice@new.example.com" {
+		t.Fatalf("confirm = %d %v", resp.StatusCode, reply)
+	}
+	if email := emailOf(); email != "alice@new.example.com" {
+		t.Errorf("email after confirming = %s, want alice@new.example.com", email)
+	}
+	if resp, _ := s.do("PUT", path+"/confirm", token, url.Values{"code": {code}}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("confirm again = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	//the old email gets the undo link under the issuer
+	link := s.mailer.last(testEmail)
+	undo := strings.TrimPrefix(link, s.account.Config.Services.Account.Auth.OAuth.Issuer)
+	if !strings.HasPrefix(undo, path+"/undo/") {
+		t.Fatalf("undo link = %q", link)
+	}
+
+	if resp, _ := s.do("GET", "/v1/user/"+s.user.ID+"/sessions", token, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("access token before undoing = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	resp, reply = s.do("GET", undo, "", nil)
+	if resp.StatusCode != http.StatusOK || reply["email"] != testEmail {
+		t.Fatalf("undo = %d %v", resp.StatusCode, reply)
+	}
+	if email := emailOf(); email != testEmail {
+		t.Errorf("email after undoing = %s, want %s", email, testEmail)
+	}
+
+	//the one who changed the email is logged out
+	if resp, _ := s.do("GET", "/v1/user/"+s.user.ID+"/sessions", token, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("access token after undoing = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+	if resp, _ := s.do("GET", undo, "", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("undo again = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
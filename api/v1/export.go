@@ -0,0 +1,382 @@
+package v1
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	db "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/config"
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils"
+	"github.com/ngs24313/gopu/utils/cache"
+	"github.com/ngs24313/gopu/utils/log"
+	"github.com/ngs24313/gopu/utils/mailer"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+)
+
+const (
+	exportTokenSize              = 32
+	exportAuditPageSize          = 500
+	exportDefaultAsyncThreshold  = 1000
+	exportDefaultExpiration      = 24 * time.Hour
+	exportDefaultPrefix          = "user_export."
+	exportDataFilename           = "user.json"
+	exportAvatarDir              = "avatar/"
+	exportArchiveFilenamePattern = "export-%s.zip"
+	exportPendingKeyPrefix       = "pending."
+)
+
+//exportLock serializes the checks of the pending exports
+var exportLock sync.Mutex
+
+//userExport is the json document in the export archive
+type userExport struct {
+	ExportedAt  time.Time            `json:"exported_at"`
+	User        *models.User         `json:"user"`
+	Profile     models.Profile       `json:"profile"`
+	Roles       []string             `json:"roles"`
+	Permissions []models.Permission  `json:"permissions"` //the permissions granted to user directly
+	Sessions    []*models.Session    `json:"sessions"`
+	AuditEvents []*models.AuditEvent `json:"audit_events"`
+}
+
+//exportArchive is cached by the hash of the download token
+type exportArchive struct {
+	UserID string `json:"user_id"`
+	Path   string `json:"path"`
+}
+
+//ExportUser handles GET /v1/user/:id/export, the archive is replied directly, or generated in background and
+//a download link is emailed if the user has more records than the async threshold
+func (a *Account) ExportUser(c *gin.Context) {
+	a.withUserByID(c, func(user *models.User) {
+		ctx := c.Request.Context()
+		conf := a.dataExportConfig()
+
+		events, err := a.AuditDB.ListAuditEvents(ctx, db.AuditListQuery{
+			UserID: user.ID,
+			Count:  1,
+		})
+		if err != nil {
+			replyInternalError(c, err)
+			return
+		}
+
+		if events.Count <= int64(conf.AsyncThreshold) {
+			buf := &bytes.Buffer{}
+			if err := a.writeExport(ctx, buf, user); err != nil {
+				replyInternalError(c, err)
+				return
+			}
+			c.Header("Content-Disposition", `attachment; filename="`+exportArchiveFilename(user)+`"`)
+			c.Data(http.StatusOK, "application/zip", buf.Bytes())
+			return
+		}
+
+		//the repeated requests get the pending export, no new archive is generated
+		started, err := a.startExport(user.ID)
+		if err != nil {
+			replyInternalError(c, err)
+			return
+		}
+		if !started {
+			c.JSON(http.StatusAccepted, gin.H{
+				"email": user.Email,
+			})
+			return
+		}
+
+		link, err := emailLink(a.Config, conf.URL, "/v1/user/"+user.ID+"/export/")
+		if err != nil {
+			a.finishExport(user.ID)
+			replyInternalError(c, err)
+			return
+		}
+
+		token, err := utils.RandomToken(exportTokenSize)
+		if err != nil {
+			a.finishExport(user.ID)
+			replyInternalError(c, err)
+			return
+		}
+
+		go a.exportInBackground(user, token, link+token)
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"email": user.Email,
+		})
+	})
+}
+
+//DownloadExport handles GET /v1/user/:id/export/:token, the link can be used until it expires
+func (a *Account) DownloadExport(c *gin.Context) {
+	key := a.dataExportConfig().Prefix + utils.HashToken(c.Param("token"))
+
+	var archive exportArchive
+	if err := cache.GetJSON(a.Cache, key, &archive); err != nil {
+		if err != cache.ErrNotFound {
+			replyInternalError(c, err)
+			return
+		}
+		replyNotFound(c, "Download link is invalid or expired", nil)
+		return
+	}
+
+	if archive.UserID != c.Param("id") {
+		replyNotFound(c, "Download link is invalid or expired", nil)
+		return
+	}
+
+	if _, err := os.Stat(archive.Path); err != nil {
+		if os.IsNotExist(err) {
+			replyNotFound(c, "Download link is invalid or expired", nil)
+			return
+		}
+		replyInternalError(c, err)
+		return
+	}
+
+	c.FileAttachment(archive.Path, exportArchiveFilename(&models.User{ID: archive.UserID}))
+}
+
+//exportInBackground writes the archive to the export path and emails the download link to user,
+//the archive is removed when the link expires
+func (a *Account) exportInBackground(user *models.User, token string, link string) {
+	ctx := context.Background()
+	conf := a.dataExportConfig()
+
+	path := filepath.Join(a.Config.ExportBasePath(), xid.New().String()+".zip")
+	if err := a.writeExportFile(ctx, path, user); err != nil {
+		log.Logger(ctx).Warn("Failed to export user",
+			zap.String("user", user.ID),
+			zap.Error(err))
+		os.Remove(path)
+		a.finishExport(user.ID)
+		return
+	}
+
+	time.AfterFunc(conf.Expiration, func() {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Logger(ctx).Warn("Failed to remove export archive",
+				zap.String("path", path),
+				zap.Error(err))
+		}
+	})
+
+	if err := cache.SetJSON(a.Cache, conf.Prefix+utils.HashToken(token), &exportArchive{
+		UserID: user.ID,
+		Path:   path,
+	}, conf.Expiration); err != nil {
+		log.Logger(ctx).Warn("Failed to save export archive",
+			zap.String("user", user.ID),
+			zap.Error(err))
+		a.finishExport(user.ID)
+		return
+	}
+
+	sendEmail(ctx, mailer.User{
+		Address: a.Config.Mailer.Username,
+	}, mailer.User{
+		Address: user.Email,
+	}, a.Config.Mailer.EmailTemplates.DataExportName,
+		map[string]string{
+			"link":       link,
+			"expiration": conf.Expiration.String(),
+		},
+	)
+}
+
+//startExport mark the background export of user pending until its archive expires, false is returned if
+//an export of user is already pending
+func (a *Account) startExport(userID string) (bool, error) {
+	exportLock.Lock()
+	defer exportLock.Unlock()
+
+	conf := a.dataExportConfig()
+	key := conf.Prefix + exportPendingKeyPrefix + userID
+	if _, err := a.Cache.Get(key); err != cache.ErrNotFound {
+		return false, err
+	}
+
+	return true, a.Cache.Set(&cache.Entity{
+		Key:        key,
+		Value:      []byte(userID),
+		Expiration: conf.Expiration,
+	})
+}
+
+//finishExport clear the pending export of user if it fails, so that the user can export again
+func (a *Account) finishExport(userID string) {
+	key := a.dataExportConfig().Prefix + exportPendingKeyPrefix + userID
+	if err := a.Cache.Del(key); err != nil && err != cache.ErrNotFound {
+		log.Logger(context.Background()).Warn("Failed to clear pending export",
+			zap.String("user", userID),
+			zap.Error(err))
+	}
+}
+
+func (a *Account) writeExportFile(ctx context.Context, path string, user *models.User) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := a.writeExport(ctx, f, user); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//writeExport writes the zip archive of the json document and the avatar of user
+func (a *Account) writeExport(ctx context.Context, w io.Writer, user *models.User) error {
+	data, err := a.collectExport(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+
+	fw, err := zw.Create(exportDataFilename)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(fw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(data); err != nil {
+		return err
+	}
+
+	if avatar := user.Profile.Avatar; avatar != "" {
+		if err := addExportFile(zw, exportAvatarDir+filepath.Base(avatar),
+			filepath.Join(a.Config.AvatarBasePath(), filepath.Base(avatar))); err != nil {
+			if !os.IsNotExist(err) {
+				return err
+			}
+			log.Logger(ctx).Warn("Avatar of exported user does not exist",
+				zap.String("user", user.ID),
+				zap.String("avatar", avatar))
+		}
+	}
+
+	return zw.Close()
+}
+
+func (a *Account) collectExport(ctx context.Context, user *models.User) (*userExport, error) {
+	data := &userExport{
+		ExportedAt: time.Now(),
+		User:       user,
+		Profile:    user.Profile,
+	}
+
+	roles, err := a.RoleMgr.GetRoleForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	data.Roles = roles
+
+	role, err := a.RoleMgr.GetRoleByName(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	data.Permissions = role.Permissions
+
+	sessions, err := a.AuthMiddleware.ListSessions(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	data.Sessions = sessions
+
+	for offset := 0; ; offset += exportAuditPageSize {
+		events, err := a.AuditDB.ListAuditEvents(ctx, db.AuditListQuery{
+			UserID: user.ID,
+			Offset: offset,
+			Count:  exportAuditPageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		data.AuditEvents = append(data.AuditEvents, events.Events...)
+		if len(events.Events) < exportAuditPageSize {
+			break
+		}
+	}
+	return data, nil
+}
+
+func addExportFile(zw *zip.Writer, name string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fw, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, f)
+	return err
+}
+
+func exportArchiveFilename(user *models.User) string {
+	return fmt.Sprintf(exportArchiveFilenamePattern, user.ID)
+}
+
+//RemoveExpiredExports removes the export archives older than the expiration, the timers removing them are lost
+//if the instance stops before they expire
+func RemoveExpiredExports(cfg config.Config, now time.Time) error {
+	conf := exportConfig(cfg)
+
+	paths, err := filepath.Glob(filepath.Join(cfg.ExportBasePath(), "*.zip"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if info.IsDir() || now.Sub(info.ModTime()) < conf.Expiration {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Account) dataExportConfig() config.DataExport {
+	return exportConfig(a.Config)
+}
+
+//exportConfig get the config with the defaults of empty fields
+func exportConfig(cfg config.Config) config.DataExport {
+	conf := cfg.Services.Account.DataExport
+	if conf.AsyncThreshold <= 0 {
+		conf.AsyncThreshold = exportDefaultAsyncThreshold
+	}
+	if conf.Expiration <= 0 {
+		conf.Expiration = exportDefaultExpiration
+	}
+	if conf.Prefix == "" {
+		conf.Prefix = exportDefaultPrefix
+	}
+	return conf
+}
@@ -0,0 +1,62 @@
+package v1
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ngs24313/gopu/config"
+)
+
+func TestRemoveExpiredExports(t *testing.T) {
+	//the archives are kept under the working directory
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	conf := config.Config{}
+	conf.Services.Account.DataExport.Expiration = time.Hour
+	if err := os.MkdirAll(conf.ExportBasePath(), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	files := map[string]time.Time{
+		"expired.zip": now.Add(-2 * time.Hour),
+		"alive.zip":   now.Add(-time.Minute),
+		"other.txt":   now.Add(-2 * time.Hour),
+	}
+	for name, modTime := range files {
+		path := filepath.Join(conf.ExportBasePath(), name)
+		if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := RemoveExpiredExports(conf, now); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, removed := range map[string]bool{"expired.zip": true, "alive.zip": false, "other.txt": false} {
+		_, err := os.Stat(filepath.Join(conf.ExportBasePath(), name))
+		if os.IsNotExist(err) != removed {
+			t.Errorf("%s: stat = %v, want removed %v", name, err, removed)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	db "github.com/ngs24313/gopu/api/database"
+	apierr "github.com/ngs24313/gopu/api/error"
+	"github.com/ngs24313/gopu/middleware"
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils"
+	"github.com/ngs24313/gopu/utils/cache"
+	"github.com/ngs24313/gopu/utils/log"
+	"github.com/ngs24313/gopu/utils/oidc"
+	"github.com/ngs24313/gopu/utils/password"
+	"github.com/ngs24313/gopu/utils/rolemanager"
+	"go.uber.org/zap"
+)
+
+const (
+	federatedStatePrefix     = "federated_state."
+	federatedStateExpiration = 10 * time.Minute
+	federatedStateCookie     = "gopu_federated_state"
+	federatedTokenSize       = 32
+	federatedUsernameRetries = 5
+)
+
+var (
+	//errFederatedEmailUnverified the upstream identity cannot be linked or registered without verified email
+	errFederatedEmailUnverified = errors.New("email of the identity provider is not verified")
+	//errLocalEmailUnverified the upstream identity is not linked to the user whose email is not verified
+	errLocalEmailUnverified = errors.New("email of the local user is not verified, verify it before the federated login")
+)
+
+//federatedState is kept until the callback of provider
+type federatedState struct {
+	Provider     string `json:"provider"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	RedirectURI  string `json:"redirect_uri"`
+}
+
+//FederatedLogin handles GET /v1/session/federated/:provider
+func (a *Account) FederatedLogin(c *gin.Context) {
+	a.withIdentityProvider(c, func(provider *oidc.Provider) {
+		ctx := c.Request.Context()
+
+		var tokens [3]string
+		for i := range tokens {
+			token, err := utils.RandomToken(federatedTokenSize)
+			if err != nil {
+				replyInternalError(c, err)
+				return
+			}
+			tokens[i] = token
+		}
+
+		state := tokens[0]
+		fs := &federatedState{
+			Provider:     provider.Name(),
+			Nonce:        tokens[1],
+			CodeVerifier: tokens[2],
+			RedirectURI:  a.federatedRedirectURI(c, provider),
+		}
+
+		authURL, err := provider.AuthCodeURL(ctx, fs.RedirectURI, state, fs.Nonce, fs.CodeVerifier)
+		if err != nil {
+			log.Logger(ctx).Error("Failed to get authorization url of identity provider",
+				zap.String("provider", provider.Name()),
+				zap.Error(err))
+			replyInternalError(c, err)
+			return
+		}
+
+		if err := cache.SetJSON(a.Cache, federatedStatePrefix+utils.HashToken(state), fs,
+			federatedStateExpiration); err != nil {
+			replyInternalError(c, err)
+			return
+		}
+
+		//the state is bound to the browser which starts the login
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     federatedStateCookie,
+			Value:    state,
+			Path:     "/v1/session/federated/" + provider.Name(),
+			MaxAge:   int(federatedStateExpiration / time.Second),
+			Secure:   c.Request.TLS != nil,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		c.Redirect(http.StatusFound, authURL)
+	})
+}
+
+//FederatedCallback handles GET /v1/session/federated/:provider/callback
+func (a *Account) FederatedCallback(c *gin.Context) {
+	a.withIdentityProvider(c, func(provider *oidc.Provider) {
+		ctx := c.Request.Context()
+
+		if e := c.Query("error"); e != "" {
+			replyUnauthorized(c, "Identity provider rejects the login: "+e, nil)
+			return
+		}
+
+		state := c.Query("state")
+		cookie, _ := c.Cookie(federatedStateCookie)
+		if state == "" || cookie != state {
+			replyUnauthorized(c, "Federated login state is invalid", nil)
+			return
+		}
+
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     federatedStateCookie,
+			Path:     "/v1/session/federated/" + provider.Name(),
+			MaxAge:   -1,
+			HttpOnly: true,
+		})
+
+		key := federatedStatePrefix + utils.HashToken(state)
+		var fs federatedState
+		if err := cache.GetJSON(a.Cache, key, &fs); err != nil {
+			if err != cache.ErrNotFound {
+				replyInternalError(c, err)
+				return
+			}
+			replyUnauthorized(c, "Federated login state is invalid", nil)
+			return
+		}
+
+		//state is used only once
+		if err := a.Cache.Del(key); err != nil && err != cache.ErrNotFound {
+			log.Logger(ctx).Warn("Failed to delete federated login state", zap.Error(err))
+		}
+
+		if fs.Provider != provider.Name() {
+			replyUnauthorized(c, "Federated login state is invalid", nil)
+			return
+		}
+
+		claims, err := provider.Exchange(ctx, fs.RedirectURI, c.Query("code"), fs.CodeVerifier, fs.Nonce)
+		if err != nil {
+			log.Logger(ctx).Warn("Failed to login with identity provider",
+				zap.String("provider", provider.Name()),
+				zap.Error(err))
+			replyUnauthorized(c, "Failed to login with identity provider", nil)
+			return
+		}
+
+		user, err := a.federatedUser(ctx, provider, claims)
+		if err != nil {
+			switch err {
+			case errFederatedEmailUnverified, errLocalEmailUnverified:
+				replyUnauthorized(c, err.Error(), nil)
+			case errInvitedRoleNotExists:
+				replyBadRequest(c, err.Error(), nil)
+			case errRegistrationInvite, errRegistrationDomain, errRegistrationClosed:
+				replyError(c, apierr.NewAppError(http.StatusForbidden, err.Error()))
+			case db.ErrNotFound:
+				replyUnauthorized(c, "The linked user is not found", nil)
+			default:
+				replyInternalError(c, err)
+			}
+			return
+		}
+
+		a.jwtMiddleware.LoginUser(c, user, middleware.AMRFederated)
+	})
+}
+
+//federatedUser get the user linked to the upstream identity, the identity is linked to the user
+//of the same verified email, or a user is created for it
+func (a *Account) federatedUser(ctx context.Context, provider *oidc.Provider, claims *oidc.Claims) (*models.User, error) {
+	identity, err := a.ADB.GetFederatedIdentity(ctx, provider.Name(), claims.Subject)
+	if err == nil {
+		return a.ADB.GetUserByID(ctx, identity.UserID)
+	}
+	if err != db.ErrNotFound {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errFederatedEmailUnverified
+	}
+
+	identity = &models.FederatedIdentity{
+		Provider: provider.Name(),
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+
+	user, err := a.ADB.GetUserByEmail(ctx, claims.Email)
+	if err == nil {
+		//an unverified email may be registered by anyone, linking it would hand over the login
+		if user.EmailVerifiedAt == nil {
+			return nil, errLocalEmailUnverified
+		}
+
+		identity.UserID = user.ID
+		if err := a.ADB.CreateFederatedIdentity(ctx, identity); err != nil {
+			return nil, err
+		}
+		log.Logger(ctx).Info("Federated identity is linked to user",
+			zap.String("provider", provider.Name()),
+			zap.String("user", user.ID))
+		return user, nil
+	}
+	if err != db.ErrNotFound {
+		return nil, err
+	}
+
+	//the upstream users are registered like the others, the invitation of email is needed if the mode forbids them
+	var invitation *models.Invitation
+	forbidden := checkRegistration(a.Config.Services.Account.Registration, claims.Email)
+	if forbidden != nil {
+		if forbidden == errRegistrationClosed {
+			return nil, forbidden
+		}
+		if invitation, err = a.invitationByEmail(ctx, claims.Email); err != nil {
+			if err == db.ErrNotFound {
+				return nil, forbidden
+			}
+			return nil, err
+		}
+	}
+
+	username, err := a.federatedUsername(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	//federated users have no password until they reset it
+	secret, err := utils.RandomToken(federatedTokenSize)
+	if err != nil {
+		return nil, err
+	}
+
+	nickname := claims.Name
+	if nickname == "" {
+		nickname = username
+	}
+
+	hashedPwd, err := password.GenHashPassword(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	//only verified emails of upstream providers are accepted
+	now := time.Now()
+	user = &models.User{
+		Username:        username,
+		Password:        hashedPwd,
+		Email:           claims.Email,
+		EmailVerifiedAt: &now,
+		State:           models.UserStateActive,
+		Profile: models.Profile{
+			Nickname: nickname,
+		},
+	}
+
+	var createdUser *models.User
+	if invitation != nil {
+		createdUser, err = a.ADB.CreateInvitedUser(ctx, user, identity, invitation.ID, now,
+			func(u *models.User) error {
+				return a.addInvitedRoles(ctx, u, invitation)
+			})
+		switch err {
+		case db.ErrNotFound:
+			//the invitation is accepted or revoked meanwhile
+			return nil, forbidden
+		case rolemanager.ErrRoleNotExists:
+			return nil, errInvitedRoleNotExists
+		}
+	} else {
+		if user.State, err = a.registrationState(ctx); err != nil {
+			return nil, err
+		}
+		createdUser, err = a.ADB.CreateFederatedUser(ctx, user, identity)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.addUserRoles(ctx, createdUser); err != nil {
+		return nil, err
+	}
+	return createdUser, nil
+}
+
+//federatedUsername get an unused username from the preferred username or the email of upstream identity
+func (a *Account) federatedUsername(ctx context.Context, claims *oidc.Claims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base = claims.Email
+	}
+	if i := strings.Index(base, "@"); i >= 0 {
+		base = base[:i]
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	username := base
+	for i := 0; i < federatedUsernameRetries; i++ {
+		if _, err := a.ADB.GetUserByUsername(ctx, username); err != nil {
+			if err == db.ErrNotFound {
+				return username, nil
+			}
+			return "", err
+		}
+		suffix, err := utils.RandomDigit(4)
+		if err != nil {
+			return "", err
+		}
+		username = base + "-" + suffix
+	}
+	return "", db.ErrUsernameAlreadyExists
+}
+
+func (a *Account) federatedRedirectURI(c *gin.Context, provider *oidc.Provider) string {
+	if redirectURL := provider.Config().RedirectURL; redirectURL != "" {
+		return redirectURL
+	}
+	return a.jwtMiddleware.Issuer(c) + "/v1/session/federated/" + provider.Name() + "/callback"
+}
+
+func (a *Account) withIdentityProvider(c *gin.Context, f func(provider *oidc.Provider)) {
+	provider, ok := a.IdentityProviders[c.Param("provider")]
+	if !ok {
+		replyNotFound(c, "Identity provider is not found", nil)
+		return
+	}
+
+	f(provider)
+}
@@ -0,0 +1,293 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	db "github.com/ngs24313/gopu/api/database"
+	apierr "github.com/ngs24313/gopu/api/error"
+	forms "github.com/ngs24313/gopu/api/forms/admin"
+	"github.com/ngs24313/gopu/config"
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils"
+	"github.com/ngs24313/gopu/utils/log"
+	"github.com/ngs24313/gopu/utils/mailer"
+	"github.com/ngs24313/gopu/utils/rolemanager"
+	"go.uber.org/zap"
+)
+
+//registration modes
+const (
+	registrationOpen     = "open"
+	registrationApproval = "approval"
+	registrationInvite   = "invite"
+	registrationDomain   = "domain"
+	registrationClosed   = "closed"
+)
+
+//the registration modes forbid the email to register without invitation
+var (
+	errRegistrationInvite = errors.New("Registration requires an invitation")
+	errRegistrationDomain = errors.New("The email domain is not allowed to register")
+	errRegistrationClosed = errors.New("Registration is closed")
+)
+
+//errInvitedRoleNotExists a pre-assigned role of invitation is deleted after inviting
+var errInvitedRoleNotExists = errors.New("The role of invitation does not exist, ask for a new invitation")
+
+const (
+	invitationTokenSize         = 32
+	invitationDefaultExpiration = 7 * 24 * time.Hour
+)
+
+//CreateInvitation handles POST /v1/admin/invitations, the invitee gets a link to register
+func (a *Admin) CreateInvitation(c *gin.Context) {
+	form := forms.InvitationForm{}
+	if err := c.ShouldBind(&form); err != nil {
+		replyBadRequest(c, "Some fields is not valid", err)
+		return
+	}
+
+	actor, ok := c.Get(a.AuthMiddleware.Options().IdentityKey)
+	if !ok {
+		replyUnauthorized(c, "You don't have permission to access", nil)
+		return
+	}
+
+	conf := a.Config.Services.Account.Registration
+	if conf.Mode == registrationClosed {
+		replyBadRequest(c, "Registration is closed", nil)
+		return
+	}
+
+	if ok, err := a.ADB.UserIsExists(c.Request.Context(), &models.User{Email: form.Email}); ok || err != nil {
+		if !ok {
+			replyInternalError(c, err)
+			return
+		}
+		replyBadRequest(c, db.ErrEmailAlreadyExists.Error(), nil)
+		return
+	}
+
+	for _, name := range form.Roles {
+		role, err := a.RoleMgr.GetRoleByName(name)
+		if err != nil {
+			replyInternalError(c, err)
+			return
+		}
+		if len(role.Permissions) == 0 {
+			replyBadRequest(c, rolemanager.ErrRoleNotExists.Error()+": "+name, nil)
+			return
+		}
+	}
+
+	link, err := emailLink(a.Config, conf.InvitationURL, "/v1/invitation/")
+	if err != nil {
+		replyInternalError(c, err)
+		return
+	}
+
+	token, err := utils.RandomToken(invitationTokenSize)
+	if err != nil {
+		replyInternalError(c, err)
+		return
+	}
+
+	expiration := conf.InvitationExpiration
+	if expiration <= 0 {
+		expiration = invitationDefaultExpiration
+	}
+
+	invitation, err := a.ADB.CreateInvitation(c.Request.Context(), &models.Invitation{
+		Email:     form.Email,
+		Roles:     strings.Join(form.Roles, " "),
+		TokenHash: utils.HashToken(token),
+		InviterID: actor.(*models.User).ID,
+		ExpiresAt: time.Now().Add(expiration),
+	})
+	if err != nil {
+		replyInternalError(c, err)
+		return
+	}
+
+	replyEmail(c, mailer.User{
+		Address: a.Config.Mailer.Username,
+	}, mailer.User{
+		Address: form.Email,
+	}, a.Config.Mailer.EmailTemplates.InvitationName,
+		map[string]string{
+			"email":      form.Email,
+			"link":       link + token,
+			"expiration": expiration.String(),
+		},
+		invitation,
+	)
+}
+
+//ListInvitations handles GET /v1/admin/invitations
+func (a *Admin) ListInvitations(c *gin.Context) {
+	form := forms.InvitationListForm{}
+	if err := c.ShouldBind(&form); err != nil {
+		replyBadRequest(c, "Some fields is not valid", err)
+		return
+	}
+
+	page := form.Page
+	if page <= 0 {
+		page = 1
+	}
+	pageSize := form.PageSize
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	result, err := a.ADB.ListInvitations(c.Request.Context(), db.InvitationListQuery{
+		Email:  form.Email,
+		Status: form.Status,
+		Offset: (page - 1) * pageSize,
+		Count:  pageSize,
+	})
+	if err != nil {
+		replyInternalError(c, err)
+		return
+	}
+
+	resultForm := forms.InvitationListResultForm{
+		Page:        page,
+		PageSize:    pageSize,
+		PageCount:   int(result.Count) / pageSize,
+		Invitations: result.Invitations,
+	}
+	if int(result.Count)%pageSize != 0 {
+		resultForm.PageCount++
+	}
+	replyOK(c, &resultForm)
+}
+
+//RevokeInvitation handles DELETE /v1/admin/invitations/:id
+func (a *Admin) RevokeInvitation(c *gin.Context) {
+	if err := a.ADB.RevokeInvitation(c.Request.Context(), c.Param("id"), time.Now()); err != nil {
+		if err == db.ErrNotFound {
+			replyNotFound(c, "The pending invitation does not exist", nil)
+			return
+		}
+		replyInternalError(c, err)
+		return
+	}
+	replyOK(c, nil)
+}
+
+//GetInvitation handles GET /v1/invitation/:token, the client can fill the registration by it
+func (a *Account) GetInvitation(c *gin.Context) {
+	if invitation, ok := a.pendingInvitation(c, c.Param("token")); ok {
+		replyOK(c, invitation)
+	}
+}
+
+//pendingInvitation get the pending invitation of token, reply not found if it is not pending
+func (a *Account) pendingInvitation(c *gin.Context, token string) (*models.Invitation, bool) {
+	invitation, err := a.ADB.GetInvitationByHash(c.Request.Context(), utils.HashToken(token))
+	if err != nil {
+		if err == db.ErrNotFound {
+			replyNotFound(c, "Invitation is invalid or expired", nil)
+			return nil, false
+		}
+		replyInternalError(c, err)
+		return nil, false
+	}
+
+	if invitation.Status != models.InvitationPending {
+		replyNotFound(c, "Invitation is invalid or expired", nil)
+		return nil, false
+	}
+	return invitation, true
+}
+
+//registrationAllowed check if the email can register without invitation, reply forbidden if not
+func (a *Account) registrationAllowed(c *gin.Context, email string) bool {
+	if err := checkRegistration(a.Config.Services.Account.Registration, email); err != nil {
+		replyError(c, apierr.NewAppError(http.StatusForbidden, err.Error()))
+		return false
+	}
+	return true
+}
+
+//checkRegistration check if the email can register without invitation by the registration mode
+func checkRegistration(conf config.Registration, email string) error {
+	switch conf.Mode {
+	case "", registrationOpen, registrationApproval:
+		return nil
+	case registrationInvite:
+		return errRegistrationInvite
+	case registrationDomain:
+		domain := email[strings.LastIndex(email, "@")+1:]
+		for _, allowed := range conf.AllowedDomains {
+			if strings.EqualFold(domain, allowed) {
+				return nil
+			}
+		}
+		return errRegistrationDomain
+	}
+	//closed and unknown modes
+	return errRegistrationClosed
+}
+
+//registrationState get the state of the user registered without invitation, the users wait for the approval
+//of admin in approval mode except the first user
+func (a *Account) registrationState(ctx context.Context) (string, error) {
+	if a.Config.Services.Account.Registration.Mode != registrationApproval {
+		return models.UserStateActive, nil
+	}
+
+	count, err := a.ADB.CountUser(ctx)
+	if err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return models.UserStateActive, nil
+	}
+	return models.UserStatePendingApproval, nil
+}
+
+//invitationByEmail get the pending invitation of email, ErrNotFound is returned if there is none
+func (a *Account) invitationByEmail(ctx context.Context, email string) (*models.Invitation, error) {
+	result, err := a.ADB.ListInvitations(ctx, db.InvitationListQuery{
+		Email:  email,
+		Status: models.InvitationPending,
+		Count:  1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Invitations) == 0 {
+		return nil, db.ErrNotFound
+	}
+	return result.Invitations[0], nil
+}
+
+//addInvitedRoles adds the pre-assigned roles of invitation to user, the added roles are removed if one fails
+func (a *Account) addInvitedRoles(ctx context.Context, user *models.User, invitation *models.Invitation) error {
+	var added []string
+	for _, role := range invitation.RoleList() {
+		ok, err := a.RoleMgr.AddRoleForUser(user.ID, role)
+		if err != nil && err != rolemanager.ErrUserHasRole {
+			for _, r := range added {
+				if _, err := a.RoleMgr.DelRoleForUser(user.ID, r); err != nil {
+					log.Logger(ctx).Warn("Failed to remove invited role",
+						zap.String("user", user.ID),
+						zap.String("role", r),
+						zap.Error(err))
+				}
+			}
+			return err
+		}
+		if ok {
+			added = append(added, role)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package v1
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ngs24313/gopu/config"
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils"
+)
+
+//register the email by the register code sent to it, the status of the failed step is returned
+func (s *testServer) register(username string, email string) (int, map[string]interface{}) {
+	resp, reply := s.do("POST", "/v1/user/register_code", "", url.Values{"email": {email}})
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, reply
+	}
+
+	resp, reply = s.do("POST", "/v1/user", "", url.Values{
+		"username":         {username},
+		"email":            {email},
+		"password":         {testPassword},
+		"confirm_password": {testPassword},
+		"register_code":    {s.mailer.last(email)},
+	})
+	return resp.StatusCode, reply
+}
+
+//invite create the pending invitation of token
+func (s *testServer) invite(email string, roles string, token string) *models.Invitation {
+	invitation, err := s.account.ADB.CreateInvitation(context.Background(), &models.Invitation{
+		Email:     email,
+		Roles:     roles,
+		TokenHash: utils.HashToken(token),
+		InviterID: s.user.ID,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return invitation
+}
+
+func TestRegistrationMode(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		email  string
+		status int
+		state  string
+	}{
+		{"default", "", "bob@example.com", http.StatusOK, models.UserStateActive},
+		{"open", registrationOpen, "bob@example.com", http.StatusOK, models.UserStateActive},
+		{"approval", registrationApproval, "bob@example.com", http.StatusOK, models.UserStatePendingApproval},
+		{"invite", registrationInvite, "bob@example.com", http.StatusForbidden, ""},
+		{"allowed domain", registrationDomain, "bob@Example.com", http.StatusOK, models.UserStateActive},
+		{"other domain", registrationDomain, "bob@other.com", http.StatusForbidden, ""},
+		{"closed", registrationClosed, "bob@example.com", http.StatusForbidden, ""},
+		{"unknown", "unknown", "bob@example.com", http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, func(conf *config.Config) {
+				conf.Services.Account.Registration.Mode = tt.mode
+				conf.Services.Account.Registration.AllowedDomains = []string{"example.com"}
+			})
+			defer s.Close()
+
+			status, reply := s.register("bob", tt.email)
+			if status != tt.status {
+				t.Fatalf("register = %d %v, want %d", status, reply, tt.status)
+			}
+			if state, _ := reply["state"].(string); tt.status == http.StatusOK && state != tt.state {
+				t.Errorf("state = %q, want %q", state, tt.state)
+			}
+		})
+	}
+}
+
+func TestRegisterByInvitation(t *testing.T) {
+	s := newTestServer(t, func(conf *config.Config) {
+		conf.Services.Account.Registration.Mode = registrationInvite
+	})
+	defer s.Close()
+	ctx := context.Background()
+
+	form := func(email string, token string) url.Values {
+		return url.Values{
+			"username":         {"bob"},
+			"email":            {email},
+			"password":         {testPassword},
+			"confirm_password": {testPassword},
+			"invitation_token": {token},
+		}
+	}
+
+	s.invite("bob@example.com", "editor", "bob-token")
+	missing := s.invite("bob@example.com", "editor missing", "missing-token")
+
+	if resp, reply := s.do("POST", "/v1/user", "", form("carol@example.com", "bob-token")); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("register other email = %d %v, want %d", resp.StatusCode, reply, http.StatusBadRequest)
+	}
+
+	//the roles are granted all or none
+	if resp, reply := s.do("POST", "/v1/user", "", form("bob@example.com", "missing-token")); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("register with missing role = %d %v, want %d", resp.StatusCode, reply, http.StatusBadRequest)
+	}
+	if ok, err := s.account.ADB.UserIsExists(ctx, &models.User{Username: "bob"}); ok || err != nil {
+		t.Errorf("UserIsExists() after failed registration = %v %v, want false", ok, err)
+	}
+	if invitation, err := s.account.ADB.GetInvitationByHash(ctx, missing.TokenHash); err != nil || invitation.Status != models.InvitationPending {
+		t.Errorf("invitation of missing role = %v %v, want pending", invitation, err)
+	}
+
+	resp, reply := s.do("POST", "/v1/user", "", form("BOB@example.com", "bob-token"))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("register = %d %v", resp.StatusCode, reply)
+	}
+	id, _ := reply["id"].(string)
+	if state, _ := reply["state"].(string); state != models.UserStateActive {
+		t.Errorf("state = %q, want %q", state, models.UserStateActive)
+	}
+	roles, err := s.account.RoleMgr.GetRoleForUser(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"editor", "user"}; !reflect.DeepEqual(roles, want) {
+		t.Errorf("roles = %v, want %v", roles, want)
+	}
+
+	invitation, err := s.account.ADB.GetInvitationByHash(ctx, utils.HashToken("bob-token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invitation.Status != models.InvitationAccepted || invitation.UserID != id {
+		t.Errorf("invitation = %s of %s, want accepted by %s", invitation.Status, invitation.UserID, id)
+	}
+	if resp, _ := s.do("POST", "/v1/user", "", form("bob@example.com", "bob-token")); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("register by accepted invitation = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestRegisterByInvitationClosed(t *testing.T) {
+	s := newTestServer(t, func(conf *config.Config) {
+		conf.Services.Account.Registration.Mode = registrationClosed
+	})
+	defer s.Close()
+
+	s.invite("bob@example.com", "", "bob-token")
+	resp, reply := s.do("POST", "/v1/user", "", url.Values{
+		"username":         {"bob"},
+		"email":            {"bob@example.com"},
+		"password":         {testPassword},
+		"confirm_password": {testPassword},
+		"invitation_token": {"bob-token"},
+	})
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("register = %d %v, want %d", resp.StatusCode, reply, http.StatusForbidden)
+	}
+}
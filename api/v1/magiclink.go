@@ -0,0 +1,135 @@
+package v1
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	db "github.com/ngs24313/gopu/api/database"
+	forms "github.com/ngs24313/gopu/api/forms/account"
+	"github.com/ngs24313/gopu/config"
+	"github.com/ngs24313/gopu/middleware"
+	"github.com/ngs24313/gopu/utils"
+	"github.com/ngs24313/gopu/utils/cache"
+	"github.com/ngs24313/gopu/utils/mailer"
+)
+
+const (
+	magicLinkTokenSize         = 32
+	magicLinkDefaultExpiration = 15 * time.Minute
+	magicLinkDefaultPrefix     = "magic_link."
+)
+
+//magicLink is cached by the hash of its token, it can only be used by the client which requests it
+type magicLink struct {
+	UserID    string `json:"user_id"`
+	ClientIP  string `json:"client_ip"`
+	UserAgent string `json:"user_agent"`
+}
+
+//CreateMagicLink handles POST /v1/session/magic_link
+func (a *Account) CreateMagicLink(c *gin.Context) {
+	var form forms.MagicLinkForm
+	if err := c.ShouldBind(&form); err != nil {
+		replyBadRequest(c, "Some fields is not valid", err)
+		return
+	}
+
+	//the reply is the same whether the email exists or not
+	user, err := a.ADB.GetUserByEmail(c.Request.Context(), form.Email)
+	if err != nil {
+		if err == db.ErrNotFound {
+			replyOK(c, nil)
+			return
+		}
+		replyInternalError(c, err)
+		return
+	}
+
+	conf := a.magicLinkConfig()
+	link, err := emailLink(a.Config, conf.URL, "/v1/session/magic_link/")
+	if err != nil {
+		replyInternalError(c, err)
+		return
+	}
+
+	token, err := utils.RandomToken(magicLinkTokenSize)
+	if err != nil {
+		replyInternalError(c, err)
+		return
+	}
+
+	if err := cache.SetJSON(a.Cache, conf.Prefix+utils.HashToken(token), &magicLink{
+		UserID:    user.ID,
+		ClientIP:  c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}, conf.Expiration); err != nil {
+		replyInternalError(c, err)
+		return
+	}
+
+	replyEmail(c, mailer.User{
+		Address: a.Config.Mailer.Username,
+	}, mailer.User{
+		Address: user.Email,
+	}, a.Config.Mailer.EmailTemplates.MagicLinkName,
+		map[string]string{
+			"link":       link + token,
+			"expiration": conf.Expiration.String(),
+		},
+		nil,
+	)
+}
+
+//LoginByMagicLink handles GET /v1/session/magic_link/:token
+func (a *Account) LoginByMagicLink(c *gin.Context) {
+	key := a.magicLinkConfig().Prefix + utils.HashToken(c.Param("token"))
+
+	var link magicLink
+	if err := cache.GetJSON(a.Cache, key, &link); err != nil {
+		if err != cache.ErrNotFound {
+			replyInternalError(c, err)
+			return
+		}
+		replyUnauthorized(c, "Magic link is invalid or expired", nil)
+		return
+	}
+
+	//the link is kept for the requesting client if it is opened by others, such as the link scanners of mail
+	if link.ClientIP != c.ClientIP() || link.UserAgent != c.Request.UserAgent() {
+		replyUnauthorized(c, "Magic link must be opened by the client which requests it", nil)
+		return
+	}
+
+	//only the one deleting the link can login by it
+	if err := a.Cache.Del(key); err != nil {
+		if err != cache.ErrNotFound {
+			replyInternalError(c, err)
+			return
+		}
+		replyUnauthorized(c, "Magic link is invalid or expired", nil)
+		return
+	}
+
+	user, err := a.ADB.GetUserByID(c.Request.Context(), link.UserID)
+	if err != nil {
+		if err == db.ErrNotFound {
+			replyUnauthorized(c, "Magic link is invalid or expired", nil)
+			return
+		}
+		replyInternalError(c, err)
+		return
+	}
+
+	a.jwtMiddleware.LoginUser(c, user, middleware.AMRMagicLink)
+}
+
+func (a *Account) magicLinkConfig() config.MagicLink {
+	conf := a.Config.Services.Account.MagicLink
+	if conf.Expiration == 0 {
+		conf.Expiration = magicLinkDefaultExpiration
+	}
+	if conf.Prefix == "" {
+		conf.Prefix = magicLinkDefaultPrefix
+	}
+	return conf
+}
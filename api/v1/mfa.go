@@ -0,0 +1,169 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	db "github.com/ngs24313/gopu/api/database"
+	apierr "github.com/ngs24313/gopu/api/error"
+	forms "github.com/ngs24313/gopu/api/forms/account"
+	"github.com/ngs24313/gopu/middleware"
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils/totp"
+)
+
+type totpEnrollReply struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type recoveryCodesReply struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+//EnrollTOTP handles PUT /v1/user/:id/mfa/totp
+func (a *Account) EnrollTOTP(c *gin.Context) {
+	a.withUserByID(c, func(user *models.User) {
+		if enabled, err := a.AuthMiddleware.MFAEnabled(c.Request.Context(), user.ID); err != nil {
+			replyInternalError(c, err)
+			return
+		} else if enabled {
+			replyBadRequest(c, "TOTP is already enabled", nil)
+			return
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			replyInternalError(c, err)
+			return
+		}
+
+		if err := a.ADB.SaveTOTP(c.Request.Context(), &models.TOTP{
+			UserID: user.ID,
+			Secret: secret,
+		}); err != nil {
+			replyInternalError(c, err)
+			return
+		}
+
+		replyOK(c, &totpEnrollReply{
+			Secret: secret,
+			URI:    totp.URI(a.AuthMiddleware.Options().Realm, user.Username, secret),
+		})
+	})
+}
+
+//ConfirmTOTP handles PUT /v1/user/:id/mfa/totp/confirm
+func (a *Account) ConfirmTOTP(c *gin.Context) {
+	form := &forms.MFACodeForm{}
+	if err := c.ShouldBind(form); err != nil {
+		replyBadRequest(c, "Some fields is not valid", err)
+		return
+	}
+
+	a.withUserByID(c, func(user *models.User) {
+		t, err := a.ADB.GetTOTP(c.Request.Context(), user.ID)
+		if err != nil {
+			if err == db.ErrNotFound {
+				replyBadRequest(c, "TOTP is not enrolled", nil)
+				return
+			}
+			replyInternalError(c, err)
+			return
+		}
+
+		if t.Enabled {
+			replyBadRequest(c, "TOTP is already enabled", nil)
+			return
+		}
+
+		step, ok := totp.Validate(t.Secret, form.Code, a.AuthMiddleware.Options().TimeFunc(), 1)
+		if !ok {
+			replyBadRequest(c, "TOTP code is invalid", nil)
+			return
+		}
+
+		t.Enabled = true
+		t.LastStep = step
+		if err := a.ADB.SaveTOTP(c.Request.Context(), t); err != nil {
+			replyInternalError(c, err)
+			return
+		}
+
+		codes, err := a.AuthMiddleware.GenerateRecoveryCodes(c.Request.Context(), user.ID)
+		if err != nil {
+			replyInternalError(c, err)
+			return
+		}
+
+		replyOK(c, &recoveryCodesReply{
+			RecoveryCodes: codes,
+		})
+	})
+}
+
+//DisableTOTP handles DELETE /v1/user/:id/mfa/totp
+func (a *Account) DisableTOTP(c *gin.Context) {
+	form := &forms.MFACodeForm{}
+	if err := c.ShouldBind(form); err != nil {
+		replyBadRequest(c, "Some fields is not valid", err)
+		return
+	}
+
+	a.withUserByID(c, func(user *models.User) {
+		if !a.verifyMFA(c, user, form.Code) {
+			return
+		}
+
+		if err := a.ADB.DeleteTOTP(c.Request.Context(), user.ID); err != nil {
+			replyInternalError(c, err)
+			return
+		}
+		replyOK(c, nil)
+	})
+}
+
+//RegenerateRecoveryCodes handles PUT /v1/user/:id/mfa/recovery_codes
+func (a *Account) RegenerateRecoveryCodes(c *gin.Context) {
+	form := &forms.MFACodeForm{}
+	if err := c.ShouldBind(form); err != nil {
+		replyBadRequest(c, "Some fields is not valid", err)
+		return
+	}
+
+	a.withUserByID(c, func(user *models.User) {
+		if !a.verifyMFA(c, user, form.Code) {
+			return
+		}
+
+		codes, err := a.AuthMiddleware.GenerateRecoveryCodes(c.Request.Context(), user.ID)
+		if err != nil {
+			replyInternalError(c, err)
+			return
+		}
+
+		replyOK(c, &recoveryCodesReply{
+			RecoveryCodes: codes,
+		})
+	})
+}
+
+//verifyMFA replies an error and return false if the mfa code of user is invalid,
+//the invalid codes are limited with the ones of login
+func (a *Account) verifyMFA(c *gin.Context, user *models.User, code string) bool {
+	ok, err := a.AuthMiddleware.VerifyMFA(c.Request.Context(), user.ID, code)
+	if err == middleware.ErrMFALocked {
+		replyError(c, apierr.NewAppError(http.StatusTooManyRequests, err.Error()))
+		return false
+	}
+	if err != nil {
+		replyInternalError(c, err)
+		return false
+	}
+
+	if !ok {
+		replyBadRequest(c, "MFA code is invalid", nil)
+		return false
+	}
+	return true
+}
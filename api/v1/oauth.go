@@ -0,0 +1,173 @@
+package v1
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	db "github.com/ngs24313/gopu/api/database"
+	forms "github.com/ngs24313/gopu/api/forms/oauth"
+	"github.com/ngs24313/gopu/middleware"
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils"
+)
+
+//clientSecretSize is the random bytes of client secret
+const clientSecretSize = 32
+
+//OAuth is oauth2 authorization server api
+type OAuth struct {
+	ODB            db.OAuthDatabase
+	AuthMiddleware *middleware.Auth
+}
+
+type clientReply struct {
+	*models.OAuthClient
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+//Register register handles
+func (o *OAuth) Register(router *gin.RouterGroup) {
+	jwtMiddleware, err := o.AuthMiddleware.Middleware()
+	if err != nil {
+		panic(err)
+	}
+
+	router.POST("/oauth/token", jwtMiddleware.TokenHandler)
+
+	authorize := router.Group("/oauth/authorize")
+	authorize.Use(jwtMiddleware.MiddlewareFunc())
+	{
+		authorize.GET("", jwtMiddleware.AuthorizeHandler)
+		authorize.POST("", jwtMiddleware.ConsentHandler)
+	}
+
+	userInfo := router.Group("/oauth/userinfo")
+	userInfo.Use(jwtMiddleware.MiddlewareFunc())
+	{
+		userInfo.GET("", jwtMiddleware.UserInfoHandler)
+		userInfo.POST("", jwtMiddleware.UserInfoHandler)
+	}
+
+	client := router.Group("/v1/oauth/client")
+	client.Use(jwtMiddleware.MiddlewareFunc())
+	{
+		client.POST("", o.CreateClient)
+		client.GET("", o.ListClients)
+		client.GET("/:id", o.GetClient)
+		client.DELETE("/:id", o.DeleteClient)
+	}
+}
+
+//CreateClient handles POST /v1/oauth/client
+func (o *OAuth) CreateClient(c *gin.Context) {
+	form := &forms.ClientForm{}
+	if err := c.ShouldBind(form); err != nil {
+		replyBadRequest(c, "Some fields is not valid", err)
+		return
+	}
+
+	for _, uri := range form.RedirectURIs {
+		if !validRedirectURI(uri) {
+			replyBadRequest(c, "The redirect uri is not valid: "+uri, nil)
+			return
+		}
+	}
+
+	for _, scope := range form.Scopes {
+		if _, ok := o.AuthMiddleware.OAuthScope(scope); !ok {
+			replyBadRequest(c, "The scope does not exist: "+scope, nil)
+			return
+		}
+	}
+
+	client := &models.OAuthClient{
+		Name:         form.Name,
+		Public:       form.Public,
+		RedirectURIs: strings.Join(form.RedirectURIs, " "),
+		Scopes:       strings.Join(form.Scopes, " "),
+	}
+
+	if user, ok := c.Get(o.AuthMiddleware.Options().IdentityKey); ok {
+		client.OwnerID = user.(*models.User).ID
+	}
+
+	var secret string
+	if !client.Public {
+		var err error
+		if secret, err = utils.RandomToken(clientSecretSize); err != nil {
+			replyInternalError(c, err)
+			return
+		}
+		client.SecretHash = utils.HashToken(secret)
+	}
+
+	client, err := o.ODB.CreateClient(c.Request.Context(), client)
+	if err != nil {
+		replyInternalError(c, err)
+		return
+	}
+
+	//the secret is only returned here
+	replyOK(c, &clientReply{
+		OAuthClient:  client,
+		ClientSecret: secret,
+	})
+}
+
+//ListClients handles GET /v1/oauth/client
+func (o *OAuth) ListClients(c *gin.Context) {
+	clients, err := o.ODB.ListClients(c.Request.Context())
+	if err != nil {
+		replyInternalError(c, err)
+		return
+	}
+	replyOK(c, clients)
+}
+
+//GetClient handles GET /v1/oauth/client/:id
+func (o *OAuth) GetClient(c *gin.Context) {
+	client, err := o.ODB.GetClient(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if err == db.ErrNotFound {
+			replyNotFound(c, "The client does not exist", nil)
+			return
+		}
+		replyInternalError(c, err)
+		return
+	}
+	replyOK(c, client)
+}
+
+//DeleteClient handles DELETE /v1/oauth/client/:id, the tokens issued to the client are revoked
+func (o *OAuth) DeleteClient(c *gin.Context) {
+	id := c.Param("id")
+	if err := o.ODB.DeleteClient(c.Request.Context(), id); err != nil {
+		if err == db.ErrNotFound {
+			replyNotFound(c, "The client does not exist", nil)
+			return
+		}
+		replyInternalError(c, err)
+		return
+	}
+
+	if err := o.AuthMiddleware.RevokeClientTokens(c.Request.Context(), id); err != nil {
+		replyInternalError(c, err)
+		return
+	}
+	replyOK(c, nil)
+}
+
+//validRedirectURI return true if the uri is absolute without fragment,
+//custom schemes of native apps are allowed
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme == "" || u.Fragment != "" || strings.ContainsAny(uri, " \t\n") {
+		return false
+	}
+
+	if (u.Scheme == "http" || u.Scheme == "https") && u.Host == "" {
+		return false
+	}
+	return true
+}
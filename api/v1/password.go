@@ -0,0 +1,94 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	apierr "github.com/ngs24313/gopu/api/error"
+	"github.com/ngs24313/gopu/middleware"
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils/log"
+	"github.com/ngs24313/gopu/utils/password"
+	"go.uber.org/zap"
+)
+
+//checkOldPassword compares the old password of user under the login lockout, so it cannot be guessed
+//without limit. It replies and return false if the account or the client ip is locked or the password is wrong
+func (a *Account) checkOldPassword(c *gin.Context, user *models.User, old string) bool {
+	ctx := c.Request.Context()
+	limiter := a.AuthMiddleware.LoginLimiter()
+
+	if err := limiter.Attempt(user.ID, c.ClientIP()); err != nil {
+		if err != middleware.ErrLoginLocked {
+			replyInternalError(c, err)
+			return false
+		}
+		replyError(c, apierr.NewAppError(http.StatusTooManyRequests, err.Error()))
+		return false
+	}
+
+	if !password.CompareHashPassword(user.Password, old) {
+		replyBadRequest(c, "Old password incorrect", nil)
+		return false
+	}
+
+	if err := limiter.Release(middleware.LockoutIP, c.ClientIP()); err != nil {
+		log.Logger(ctx).Warn("Failed to release login attempt", zap.Error(err))
+	}
+	if err := limiter.Succeed(user.ID); err != nil {
+		log.Logger(ctx).Warn("Failed to clear login failures", zap.Error(err))
+	}
+	return true
+}
+
+//validatePassword replies the violations and return false if the new password of user breaks the password policy,
+//the current password of user counts in the password history
+func (a *Account) validatePassword(c *gin.Context, user *models.User, pwd string) bool {
+	if a.PasswordPolicy == nil {
+		return true
+	}
+
+	violations, err := a.PasswordPolicy.Validate(pwd, user.Username, user.Email)
+	if err != nil {
+		replyInternalError(c, err)
+		return false
+	}
+
+	if size := a.PasswordPolicy.HistorySize(); size > 0 && user.Password != "" {
+		history := []string{user.Password}
+		if size > 1 {
+			previous, err := a.ADB.ListPasswordHistory(c.Request.Context(), user.ID, size-1)
+			if err != nil {
+				replyInternalError(c, err)
+				return false
+			}
+			history = append(history, previous...)
+		}
+
+		if violation := a.PasswordPolicy.Reused(pwd, history); violation != nil {
+			violations = append(violations, *violation)
+		}
+	}
+
+	if len(violations) == 0 {
+		return true
+	}
+
+	appErr := apierr.NewAppError(http.StatusBadRequest, "Password does not meet the password policy")
+	for _, violation := range violations {
+		appErr.Causes = append(appErr.Causes, apierr.Cause{
+			Reason:  violation.Rule,
+			Message: violation.Message,
+		})
+	}
+	replyError(c, appErr)
+	return false
+}
+
+//passwordHistoryKeep get the number of previous passwords to keep, the current password is the newest of history
+func (a *Account) passwordHistoryKeep() int {
+	if a.PasswordPolicy == nil || a.PasswordPolicy.HistorySize() <= 1 {
+		return 0
+	}
+	return a.PasswordPolicy.HistorySize() - 1
+}
@@ -27,13 +27,21 @@ func (r *RBAC) Register(router *gin.RouterGroup) {
 	{
 		v1.POST("/role", r.CreateRole)
 		v1.DELETE("/role/:name", r.DeleteRole)
+		v1.PUT("/role/:name", r.ReplaceRole)
+		v1.PATCH("/role/:name", r.PatchRole)
 
 		v1.GET("/role/:name/user", r.GetUserForRoleByName)
+		v1.GET("/role/:name/parent", r.GetParentRoles)
+		v1.GET("/role/:name/child", r.GetChildRoles)
+		v1.GET("/role/:name/permissions", r.GetEffectivePermissions)
 		v1.GET("/role/:name", r.GetRoleByName)
 		v1.GET("/role", r.GetRoleList)
 
 		v1.POST("/role/:name/user/:id", r.AppendRoleForUser)
 		v1.DELETE("/role/:name/user/:id", r.DeleteRoleForUser)
+
+		v1.POST("/role/:name/parent/:parent", r.AppendParentRole)
+		v1.DELETE("/role/:name/parent/:parent", r.DeleteParentRole)
 	}
 }
 
@@ -91,6 +99,70 @@ func (r *RBAC) DeleteRole(c *gin.Context) {
 	replyOK(c, nil)
 }
 
+//ReplaceRole handles PUT /v1/role/:name, the permissions of role are replaced and the users are kept
+func (r *RBAC) ReplaceRole(c *gin.Context) {
+	form := &forms.RoleReplaceForm{}
+	if err := c.ShouldBindJSON(form); err != nil {
+		replyBadRequest(c, "Some fields is invalid", err)
+		return
+	}
+
+	r.updateRole(c, &rolemanager.RoleUpdate{
+		Permissions: permissionsOf(form.Permissions),
+	})
+}
+
+//PatchRole handles PATCH /v1/role/:name, the permissions are added to or removed from role
+func (r *RBAC) PatchRole(c *gin.Context) {
+	form := &forms.RolePatchForm{}
+	if err := c.ShouldBindJSON(form); err != nil {
+		replyBadRequest(c, "Some fields is invalid", err)
+		return
+	}
+
+	if len(form.Add) == 0 && len(form.Remove) == 0 {
+		replyBadRequest(c, "No permission to add or remove", nil)
+		return
+	}
+
+	r.updateRole(c, &rolemanager.RoleUpdate{
+		Add:    permissionsOf(form.Add),
+		Remove: permissionsOf(form.Remove),
+	})
+}
+
+//updateRole replies the permissions added and removed
+func (r *RBAC) updateRole(c *gin.Context, update *rolemanager.RoleUpdate) {
+	name := c.Param("name")
+	if name == "" {
+		replyBadRequest(c, "The role name cannot be empty", nil)
+		return
+	}
+
+	diff, err := r.RoleMgr.UpdateRole(name, update)
+	if err != nil {
+		switch err {
+		case rolemanager.ErrRoleNotExists:
+			replyNotFound(c, err.Error(), nil)
+		case rolemanager.ErrRoleNoPermission:
+			replyBadRequest(c, err.Error(), nil)
+		default:
+			replyInternalError(c, err)
+		}
+		return
+	}
+	replyOK(c, diff)
+}
+
+func permissionsOf(permissions []forms.RoleUpdateForm) []models.Permission {
+	result := make([]models.Permission, len(permissions))
+	for i, permission := range permissions {
+		result[i].API = permission.API
+		result[i].Method = permission.Method
+	}
+	return result
+}
+
 //GetRoleByName handles GET /v1/role/:name
 func (r *RBAC) GetRoleByName(c *gin.Context) {
 	name := c.Param("name")
@@ -203,3 +275,90 @@ func (r *RBAC) DeleteRoleForUser(c *gin.Context) {
 	}
 	replyOK(c, nil)
 }
+
+//AppendParentRole handles POST /role/:name/parent/:parent, the role inherits the permissions of parent
+func (r *RBAC) AppendParentRole(c *gin.Context) {
+	name := c.Param("name")
+	parent := c.Param("parent")
+	if name == "" || parent == "" {
+		replyBadRequest(c, "The role name cannot be empty", nil)
+		return
+	}
+
+	_, err := r.RoleMgr.AddParentRole(name, parent)
+	if err != nil && err != rolemanager.ErrRoleHasParent {
+		if err == rolemanager.ErrRoleNotExists || err == rolemanager.ErrRoleCycle {
+			replyBadRequest(c, err.Error(), nil)
+		} else {
+			replyInternalError(c, err)
+		}
+		return
+	}
+
+	replyOK(c, nil)
+}
+
+//DeleteParentRole handles DELETE /role/:name/parent/:parent
+func (r *RBAC) DeleteParentRole(c *gin.Context) {
+	name := c.Param("name")
+	parent := c.Param("parent")
+	if name == "" || parent == "" {
+		replyBadRequest(c, "The role name cannot be empty", nil)
+		return
+	}
+
+	_, err := r.RoleMgr.DelParentRole(name, parent)
+	if err != nil {
+		if err == rolemanager.ErrRoleNotExists || err == rolemanager.ErrRoleNotHaveParent {
+			replyBadRequest(c, err.Error(), nil)
+		} else {
+			replyInternalError(c, err)
+		}
+		return
+	}
+	replyOK(c, nil)
+}
+
+//GetParentRoles handles GET /role/:name/parent, only the direct parents are replied
+func (r *RBAC) GetParentRoles(c *gin.Context) {
+	r.withRole(c, r.RoleMgr.GetParentRoles)
+}
+
+//GetChildRoles handles GET /role/:name/child, only the direct children are replied
+func (r *RBAC) GetChildRoles(c *gin.Context) {
+	r.withRole(c, r.RoleMgr.GetChildRoles)
+}
+
+//GetEffectivePermissions handles GET /role/:name/permissions, the permissions inherited from the ancestors
+//are included with the role granting them
+func (r *RBAC) GetEffectivePermissions(c *gin.Context) {
+	name := c.Param("name")
+	permissions, err := r.RoleMgr.GetEffectivePermissions(name)
+	if err != nil {
+		if err == rolemanager.ErrRoleNotExists {
+			replyNotFound(c, err.Error(), nil)
+		} else {
+			replyInternalError(c, err)
+		}
+		return
+	}
+
+	replyOK(c, &models.Role{
+		Name:        name,
+		Permissions: permissions,
+	})
+}
+
+//withRole replies the roles related to the role of path
+func (r *RBAC) withRole(c *gin.Context, roles func(string) ([]string, error)) {
+	result, err := roles(c.Param("name"))
+	if err != nil {
+		if err == rolemanager.ErrRoleNotExists {
+			replyNotFound(c, err.Error(), nil)
+		} else {
+			replyInternalError(c, err)
+		}
+		return
+	}
+	replyOK(c, result)
+}
@@ -1,6 +1,7 @@
 package v1
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -61,6 +62,19 @@ func replyEmail(c *gin.Context,
 	tmplName string,
 	tmplData interface{},
 	okData interface{}) {
+	if err := sendEmail(c.Request.Context(), from, to, tmplName, tmplData); err != nil {
+		replyInternalError(c, err)
+		return
+	}
+	replyOK(c, okData)
+}
+
+//sendEmail sends the email generated by template, failures are logged
+func sendEmail(ctx context.Context,
+	from mailer.User,
+	to mailer.User,
+	tmplName string,
+	tmplData interface{}) error {
 	emailContent := template.GenEmailContent(tmplName, tmplData)
 
 	if err := mailer.Send(&mailer.Message{
@@ -72,12 +86,11 @@ func replyEmail(c *gin.Context,
 		ContentType: "text/html",
 		Body:        emailContent.Body,
 	}); err != nil {
-		log.Logger(c.Request.Context()).Warn("Failed to send email",
+		log.Logger(ctx).Warn("Failed to send email",
 			zap.String("from", from.Address),
 			zap.String("to", to.Address), zap.Error(err),
 		)
-		replyInternalError(c, err)
-		return
+		return err
 	}
-	replyOK(c, okData)
+	return nil
 }
@@ -0,0 +1,52 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	db "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/middleware"
+	"github.com/ngs24313/gopu/models"
+)
+
+//ListSessions handles GET /v1/user/:id/sessions
+func (a *Account) ListSessions(c *gin.Context) {
+	a.withUserByID(c, func(user *models.User) {
+		sessions, err := a.AuthMiddleware.ListSessions(c.Request.Context(), user.ID)
+		if err != nil {
+			replyInternalError(c, err)
+			return
+		}
+
+		current := middleware.SessionID(c)
+		for _, session := range sessions {
+			session.Current = session.ID == current
+		}
+		replyOK(c, sessions)
+	})
+}
+
+//RevokeSession handles DELETE /v1/user/:id/sessions/:sid
+func (a *Account) RevokeSession(c *gin.Context) {
+	a.withUserByID(c, func(user *models.User) {
+		if err := a.AuthMiddleware.RevokeSession(c.Request.Context(),
+			user.ID, c.Param("sid")); err != nil {
+			if err == db.ErrNotFound {
+				replyNotFound(c, "The session does not exist", nil)
+				return
+			}
+			replyInternalError(c, err)
+			return
+		}
+		replyOK(c, nil)
+	})
+}
+
+//RevokeSessions handles DELETE /v1/user/:id/sessions
+func (a *Account) RevokeSessions(c *gin.Context) {
+	a.withUserByID(c, func(user *models.User) {
+		if err := a.AuthMiddleware.RevokeUserTokens(c.Request.Context(), user.ID); err != nil {
+			replyInternalError(c, err)
+			return
+		}
+		replyOK(c, nil)
+	})
+}
@@ -0,0 +1,100 @@
+package v1
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	db "github.com/ngs24313/gopu/api/database"
+	apierr "github.com/ngs24313/gopu/api/error"
+	forms "github.com/ngs24313/gopu/api/forms/account"
+	"github.com/ngs24313/gopu/middleware"
+	"github.com/ngs24313/gopu/models"
+)
+
+type tokenReply struct {
+	*models.PersonalAccessToken
+	Token string `json:"token"`
+}
+
+//CreateToken handles PUT /v1/user/:id/tokens
+func (a *Account) CreateToken(c *gin.Context) {
+	if middleware.IsPersonalAccessToken(c) {
+		replyError(c, apierr.NewAppError(
+			http.StatusForbidden,
+			"Personal access tokens cannot create tokens",
+		))
+		return
+	}
+
+	form := &forms.TokenForm{}
+	if err := c.ShouldBind(form); err != nil {
+		replyBadRequest(c, "Some fields is not valid", err)
+		return
+	}
+
+	if form.ExpiresAt != nil && !form.ExpiresAt.After(time.Now()) {
+		replyBadRequest(c, "The expiration time must be in the future", nil)
+		return
+	}
+
+	a.withUserByID(c, func(user *models.User) {
+		permissions := make([]models.Permission, len(form.Permissions))
+		for i, p := range form.Permissions {
+			permissions[i] = models.Permission{
+				API:    p.API,
+				Method: p.Method,
+			}
+
+			//the token can only narrow the permissions of user
+			if ok, err := a.RoleMgr.Validate(user.ID, &permissions[i]); err != nil {
+				replyInternalError(c, err)
+				return
+			} else if !ok {
+				replyBadRequest(c, "The user does not have the permission: "+p.Method+" "+p.API, nil)
+				return
+			}
+		}
+
+		token, pat, err := a.AuthMiddleware.CreatePersonalAccessToken(c.Request.Context(),
+			user.ID, form.Name, form.ExpiresAt, permissions)
+		if err != nil {
+			replyInternalError(c, err)
+			return
+		}
+
+		//the token is only returned here
+		replyOK(c, &tokenReply{
+			PersonalAccessToken: pat,
+			Token:               token,
+		})
+	})
+}
+
+//ListTokens handles GET /v1/user/:id/tokens
+func (a *Account) ListTokens(c *gin.Context) {
+	a.withUserByID(c, func(user *models.User) {
+		tokens, err := a.AuthMiddleware.ListPersonalAccessTokens(c.Request.Context(), user.ID)
+		if err != nil {
+			replyInternalError(c, err)
+			return
+		}
+		replyOK(c, tokens)
+	})
+}
+
+//RevokeToken handles DELETE /v1/user/:id/tokens/:token_id
+func (a *Account) RevokeToken(c *gin.Context) {
+	a.withUserByID(c, func(user *models.User) {
+		if err := a.AuthMiddleware.RevokePersonalAccessToken(c.Request.Context(),
+			user.ID, c.Param("token_id")); err != nil {
+			if err == db.ErrNotFound {
+				replyNotFound(c, "The token does not exist", nil)
+				return
+			}
+			replyInternalError(c, err)
+			return
+		}
+		replyOK(c, nil)
+	})
+}
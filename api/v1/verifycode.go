@@ -0,0 +1,37 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	apierr "github.com/ngs24313/gopu/api/error"
+	"github.com/ngs24313/gopu/utils/verifycode"
+)
+
+func (a *Account) registerCodePurpose() verifycode.Purpose {
+	return verifycode.Purpose{
+		Prefix:     a.Config.Services.Account.RegisterCodePrefix,
+		Expiration: a.Config.Services.Account.RegisterCodeExpiration,
+	}
+}
+
+func (a *Account) passwordResetCodePurpose() verifycode.Purpose {
+	return verifycode.Purpose{
+		Prefix:     a.Config.Services.Account.PasswordResetCodePrefix,
+		Expiration: a.Config.Services.Account.PasswordResetCodeExpiration,
+	}
+}
+
+//createVerifyCode create the code of purpose for the recipient, reply too many requests if it is throttled
+func (a *Account) createVerifyCode(c *gin.Context, purpose verifycode.Purpose, recipient string) (string, bool) {
+	code, err := a.VerifyCodes.Create(purpose, recipient, c.ClientIP())
+	if err != nil {
+		if err == verifycode.ErrThrottled {
+			replyError(c, apierr.NewAppError(http.StatusTooManyRequests, err.Error()))
+			return "", false
+		}
+		replyInternalError(c, err)
+		return "", false
+	}
+	return code, true
+}
@@ -0,0 +1,25 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ngs24313/gopu/middleware"
+)
+
+//WellKnown is the public metadata api under /.well-known
+type WellKnown struct {
+	AuthMiddleware *middleware.Auth
+}
+
+//Register register handles
+func (w *WellKnown) Register(router *gin.RouterGroup) {
+	jwtMiddleware, err := w.AuthMiddleware.Middleware()
+	if err != nil {
+		panic(err)
+	}
+
+	wellKnown := router.Group("/.well-known")
+	{
+		wellKnown.GET("/jwks.json", jwtMiddleware.JWKSHandler)
+		wellKnown.GET("/openid-configuration", jwtMiddleware.DiscoveryHandler)
+	}
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	apidao "github.com/ngs24313/gopu/api/database"
+	v1 "github.com/ngs24313/gopu/api/v1"
+	"github.com/ngs24313/gopu/config"
+	"github.com/ngs24313/gopu/utils/log"
+	"github.com/ngs24313/gopu/utils/rolemanager"
+	"go.uber.org/zap"
+)
+
+const (
+	purgeDefaultInterval = time.Hour
+	purgeBatchSize       = 100
+)
+
+//startUserPurge purges the users soft-deleted longer than the retention and the expired export archives
+//at every interval, no user is purged if the retention is 0
+func startUserPurge(adb apidao.AccountDatabase, roleMgr rolemanager.RoleManager, cfg *config.Config) {
+	conf := cfg.Services.Account.DeletedUsers
+
+	interval := conf.PurgeInterval
+	if interval <= 0 {
+		interval = purgeDefaultInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if conf.Retention > 0 {
+				purgeDeletedUsers(adb, roleMgr, cfg, time.Now().Add(-conf.Retention))
+			}
+			if err := v1.RemoveExpiredExports(*cfg, time.Now()); err != nil {
+				log.Logger(context.Background()).Warn("Failed to remove expired export archives", zap.Error(err))
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+//purgeDeletedUsers purges a batch of the users deleted before the time, failures are only logged
+func purgeDeletedUsers(adb apidao.AccountDatabase, roleMgr rolemanager.RoleManager, cfg *config.Config,
+	deletedBefore time.Time) {
+	ctx := context.Background()
+
+	ids, err := adb.ListPurgeableUsers(ctx, deletedBefore, purgeBatchSize)
+	if err != nil {
+		log.Logger(ctx).Error("Failed to list deleted users to purge", zap.Error(err))
+		return
+	}
+
+	for _, id := range ids {
+		user, err := adb.PurgeUser(ctx, id)
+		if err != nil {
+			log.Logger(ctx).Error("Failed to purge deleted user", zap.String("user", id), zap.Error(err))
+			continue
+		}
+
+		if err := rolemanager.DeleteUserRoles(id, roleMgr); err != nil {
+			log.Logger(ctx).Warn("Failed to delete roles of purged user", zap.String("user", id), zap.Error(err))
+		}
+		if avatar := user.Profile.Avatar; avatar != "" {
+			if err := cfg.RemoveAvatar(avatar); err != nil {
+				log.Logger(ctx).Warn("Failed to remove avatar of purged user", zap.String("user", id), zap.Error(err))
+			}
+		}
+		log.Logger(ctx).Info("Deleted user is purged", zap.String("user", id))
+	}
+}
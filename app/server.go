@@ -2,6 +2,7 @@ package app
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"net/http"
 	"strconv"
@@ -13,11 +14,16 @@ import (
 	"github.com/ngs24313/gopu/utils/cache/cache"
 	"github.com/ngs24313/gopu/utils/casbin"
 	"github.com/ngs24313/gopu/utils/database"
+	"github.com/ngs24313/gopu/utils/keyset"
 	"github.com/ngs24313/gopu/utils/log"
 	"github.com/ngs24313/gopu/utils/mailer"
 	"github.com/ngs24313/gopu/utils/mailer/template"
+	"github.com/ngs24313/gopu/utils/oidc"
+	"github.com/ngs24313/gopu/utils/password"
+	"github.com/ngs24313/gopu/utils/password/policy"
 	"github.com/ngs24313/gopu/utils/rolemanager"
 	casbinMgr "github.com/ngs24313/gopu/utils/rolemanager/casbin"
+	"github.com/ngs24313/gopu/utils/verifycode"
 	"go.uber.org/zap"
 
 	"github.com/gin-contrib/cors"
@@ -34,7 +40,21 @@ func initializeBaseComp(conf *config.Config) error {
 		return err
 	}
 
-	if err := database.Database().Migrate(&models.User{}, &models.Profile{}); err != nil {
+	if err := database.Database().Migrate(
+		&models.User{},
+		&models.Profile{},
+		&models.RefreshToken{},
+		&models.PersonalAccessToken{},
+		&models.TOTP{},
+		&models.RecoveryCode{},
+		&models.OAuthClient{},
+		&models.OAuthConsent{},
+		&models.FederatedIdentity{},
+		&models.Session{},
+		&models.AuditEvent{},
+		&models.PasswordHistory{},
+		&models.Invitation{},
+	); err != nil {
 		return err
 	}
 
@@ -56,6 +76,10 @@ func initializeBaseComp(conf *config.Config) error {
 		return err
 	}
 
+	if err := password.Init(conf); err != nil {
+		return err
+	}
+
 	if err := template.Init(conf); err != nil {
 		return err
 	}
@@ -66,11 +90,21 @@ func initializeBaseComp(conf *config.Config) error {
 //CreateAuthMiddlewareFromConfig ...
 func CreateAuthMiddlewareFromConfig(
 	adb apidao.AccountDatabase,
+	tdb apidao.TokenDatabase,
+	odb apidao.OAuthDatabase,
+	audb apidao.AuditDatabase,
 	roleMgr rolemanager.RoleManager,
 	conf *config.Config,
-) *middleware.Auth {
+) (*middleware.Auth, error) {
 	authConf := conf.Services.Account.Auth
-	options := make([]middleware.AuthOption, 0)
+	options := []middleware.AuthOption{
+		middleware.WithCache(cache.Cache()),
+		middleware.WithLockout(authConf.Lockout),
+		middleware.WithRateLimit(authConf.RateLimit),
+		middleware.WithOAuth(authConf.OAuth),
+		middleware.WithImpersonation(authConf.Impersonation),
+		middleware.WithRequireVerifiedEmail(authConf.RequireVerifiedEmail),
+	}
 
 	if authConf.IdentityKey != "" {
 		options = append(options, middleware.WithIdentityKey(authConf.IdentityKey))
@@ -84,6 +118,22 @@ func CreateAuthMiddlewareFromConfig(
 		options = append(options, middleware.WithKey([]byte(authConf.SecretKey)))
 	}
 
+	if len(authConf.SigningKeys) > 0 {
+		keys, err := keyset.Load(authConf.SigningKeys, authConf.SigningKeyID)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, middleware.WithKeySet(keys))
+	} else {
+		//the id tokens signed by the secret key cannot be verified by relying parties
+		for _, scope := range authConf.OAuth.Scopes {
+			if scope.Name == middleware.ScopeOpenID {
+				return nil, fmt.Errorf("scope [%s] requires signing_keys", scope.Name)
+			}
+		}
+		log.Warn("OpenID Connect is disabled, configure signing_keys to issue ID tokens")
+	}
+
 	if authConf.TokenExpiration != time.Duration(0) {
 		options = append(options, middleware.WithTimeout(authConf.TokenExpiration))
 	}
@@ -92,17 +142,86 @@ func CreateAuthMiddlewareFromConfig(
 		options = append(options, middleware.WithMaxRefersh(authConf.TokenRefreshExpiration))
 	}
 
-	return middleware.NewAuth(adb, roleMgr, options...)
+	authenticators, err := CreateAuthenticatorsFromConfig(adb, roleMgr, conf)
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, middleware.WithAuthenticators(authenticators...))
+
+	mfaRoles := make([]string, 0)
+	for _, role := range conf.RBAC.Roles {
+		if role.RequireMFA {
+			mfaRoles = append(mfaRoles, role.Name)
+		}
+	}
+	options = append(options, middleware.WithMFARoles(mfaRoles))
+
+	return middleware.NewAuth(adb, tdb, odb, audb, roleMgr, options...), nil
+}
+
+//CreateAuthenticatorsFromConfig create the authenticators of login in the configured order
+func CreateAuthenticatorsFromConfig(
+	adb apidao.AccountDatabase,
+	roleMgr rolemanager.RoleManager,
+	conf *config.Config,
+) ([]middleware.Authenticator, error) {
+	authConf := conf.Services.Account.Auth
+
+	names := authConf.Authenticators
+	if len(names) == 0 {
+		names = []string{middleware.AuthenticatorLocal}
+	}
+
+	authenticators := make([]middleware.Authenticator, 0, len(names))
+	for _, name := range names {
+		switch name {
+		case middleware.AuthenticatorLocal:
+			authenticators = append(authenticators,
+				middleware.NewLocalAuthenticator(adb, conf.Services.Account.PasswordPolicy.MaxAge))
+		case middleware.AuthenticatorLDAP:
+			authenticator, err := middleware.NewLDAPAuthenticator(authConf.LDAP, conf.RBAC, adb, roleMgr)
+			if err != nil {
+				return nil, err
+			}
+			authenticators = append(authenticators, authenticator)
+		default:
+			return nil, fmt.Errorf("unknown authenticator [%s]", name)
+		}
+	}
+	return authenticators, nil
+}
+
+//CreateIdentityProvidersFromConfig create the upstream providers of federated login by name
+func CreateIdentityProvidersFromConfig(conf *config.Config) (map[string]*oidc.Provider, error) {
+	providers := make(map[string]*oidc.Provider)
+	for _, providerConf := range conf.Services.Account.Auth.IdentityProviders {
+		if _, ok := providers[providerConf.Name]; ok {
+			return nil, fmt.Errorf("duplicated identity provider [%s]", providerConf.Name)
+		}
+
+		provider, err := oidc.NewProvider(providerConf)
+		if err != nil {
+			return nil, err
+		}
+		providers[providerConf.Name] = provider
+	}
+	return providers, nil
 }
 
 //Initialize server from config
 func Initialize(conf *config.Config) (*gin.Engine, error) {
+	if err := v1.CheckEmailLinks(*conf); err != nil {
+		return nil, err
+	}
+
 	if err := initializeBaseComp(conf); err != nil {
 		return nil, err
 	}
 
 	gin.SetMode(conf.Mode)
 	engine := gin.New()
+	//the client ip keys the login lockout and the rate limits, the headers of clients are not trusted by default
+	engine.ForwardedByClientIP = conf.HTTP.ForwardedByClientIP
 	engine.Use(middleware.Logger())
 	engine.Use(gin.Recovery())
 	engine.Use(cors.New(cors.Config{
@@ -113,22 +232,72 @@ func Initialize(conf *config.Config) (*gin.Engine, error) {
 
 	routerGroup := engine.Group("")
 	accountDatabase := dao.NewAccountDatabase(database.Database())
-	authMiddleware := CreateAuthMiddlewareFromConfig(accountDatabase, rolemanager.GetRoleManager(), conf)
+	tokenDatabase := dao.NewTokenDatabase(database.Database())
+	oauthDatabase := dao.NewOAuthDatabase(database.Database())
+	auditDatabase := dao.NewAuditDatabase(database.Database())
+	authMiddleware, err := CreateAuthMiddlewareFromConfig(accountDatabase,
+		tokenDatabase,
+		oauthDatabase,
+		auditDatabase,
+		rolemanager.GetRoleManager(),
+		conf)
+	if err != nil {
+		return nil, err
+	}
+
+	engine.Use(authMiddleware.RateLimiter().Middleware())
+
+	identityProviders, err := CreateIdentityProvidersFromConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordPolicy, err := policy.New(conf.Services.Account.PasswordPolicy)
+	if err != nil {
+		return nil, err
+	}
 
 	account := v1.Account{
+		ADB:               accountDatabase,
+		AuditDB:           auditDatabase,
+		RoleMgr:           rolemanager.GetRoleManager(),
+		AuthMiddleware:    authMiddleware,
+		Cache:             cache.Cache(),
+		Config:            *conf,
+		PasswordPolicy:    passwordPolicy,
+		VerifyCodes:       verifycode.New(cache.Cache(), conf.Services.Account.VerifyCode, nil),
+		IdentityProviders: identityProviders,
+	}
+
+	rbac := v1.RBAC{
+		RoleMgr:        rolemanager.GetRoleManager(),
+		AuthMiddleware: authMiddleware,
+	}
+
+	admin := v1.Admin{
 		ADB:            accountDatabase,
+		AuditDB:        auditDatabase,
 		RoleMgr:        rolemanager.GetRoleManager(),
 		AuthMiddleware: authMiddleware,
-		Cache:          cache.Cache(),
 		Config:         *conf,
 	}
 
-	rbac := v1.RBAC{
-		RoleMgr: rolemanager.GetRoleManager(),
+	oauth := v1.OAuth{
+		ODB:            oauthDatabase,
+		AuthMiddleware: authMiddleware,
+	}
+
+	wellKnown := v1.WellKnown{
+		AuthMiddleware: authMiddleware,
 	}
 
+	startUserPurge(accountDatabase, rolemanager.GetRoleManager(), conf)
+
 	rbac.Register(routerGroup)
 	account.Register(routerGroup)
+	admin.Register(routerGroup)
+	oauth.Register(routerGroup)
+	wellKnown.Register(routerGroup)
 	return engine, nil
 }
 
@@ -15,6 +15,8 @@ type HTTP struct {
 	TLSPort uint   `json:"tlsport"`
 	TLS     TLS    `mapstructure:"tls" json:"tls"`
 	Acme    ACME   `mapstructure:"acme" json:"acme"`
+	//ForwardedByClientIP trust the X-Forwarded-For and X-Real-IP headers, only behind a proxy which sets them
+	ForwardedByClientIP bool `mapstructure:"forwarded_by_client_ip" json:"forwarded_by_client_ip"`
 }
 
 //TLS is the config of TLS
@@ -62,9 +64,12 @@ type API struct {
 
 //RBACGroup is the rbac group
 type RBACGroup struct {
-	Name   string `mapstructure:"name" json:"name"`
-	APIS   []API  `mapstructure:"apis" json:"apis"`
-	IDAPIS []API  `mapstructure:"idapis" json:"idapis"`
+	Name       string `mapstructure:"name" json:"name"`
+	APIS       []API  `mapstructure:"apis" json:"apis"`
+	IDAPIS     []API  `mapstructure:"idapis" json:"idapis"`
+	RequireMFA bool   `mapstructure:"require_mfa" json:"require_mfa"` //users of the role must login with mfa
+	//Inherits is the parent roles, their permissions are granted to the role
+	Inherits []string `mapstructure:"inherits" json:"inherits"`
 }
 
 //RBAC is the rbac policy
@@ -96,32 +101,238 @@ type EmailTemplates struct {
 	Templates             map[string]EmailTemplate `mapstructure:"templates" json:"templates"`
 	PasswordResetCodeName string                   `mapstructure:"password_reset_code_name" json:"password_reset_code_name"`
 	RegisterCodeName      string                   `mapstructure:"register_code_name" json:"register_code_name"`
+	MagicLinkName         string                   `mapstructure:"magic_link_name" json:"magic_link_name"`
+	EmailChangeCodeName   string                   `mapstructure:"email_change_code_name" json:"email_change_code_name"`
+	EmailChangedName      string                   `mapstructure:"email_changed_name" json:"email_changed_name"`
+	EmailVerifyCodeName   string                   `mapstructure:"email_verify_code_name" json:"email_verify_code_name"`
+	DataExportName        string                   `mapstructure:"data_export_name" json:"data_export_name"`
+	InvitationName        string                   `mapstructure:"invitation_name" json:"invitation_name"`
 }
 
 //Cache is the cache config
 type Cache struct {
-	Type       string        `json:"type"` //support memory、redis、memcache
-	Expiration time.Duration `json:"expiration"`
-	DSN        string        `json:"dsn"` //for redis、memcache etc
-	Prefix     string        `json:"prefix"`
+	Type          string        `json:"type"` //support memory、database, the database cache is shared by the instances
+	Expiration    time.Duration `json:"expiration"`
+	MaxExpiration time.Duration `mapstructure:"max_expiration" json:"max_expiration"`
+	DSN           string        `json:"dsn"` //for redis、memcache etc
+	Prefix        string        `json:"prefix"`
+}
+
+//Lockout is the config of login failure lockout
+type Lockout struct {
+	Prefix             string        `mapstructure:"prefix" json:"prefix"`
+	AccountMaxFailures int           `mapstructure:"account_max_failures" json:"account_max_failures"`
+	IPMaxFailures      int           `mapstructure:"ip_max_failures" json:"ip_max_failures"`
+	FailureWindow      time.Duration `mapstructure:"failure_window" json:"failure_window"`
+	Duration           time.Duration `mapstructure:"duration" json:"duration"`
+	DelayAfter         int           `mapstructure:"delay_after" json:"delay_after"` //failures before progressive delay
+	DelayBase          time.Duration `mapstructure:"delay_base" json:"delay_base"`
+	MaxDelay           time.Duration `mapstructure:"max_delay" json:"max_delay"`
+}
+
+//VerifyCode is the config of the email codes of registering and resetting password
+type VerifyCode struct {
+	Prefix         string        `mapstructure:"prefix" json:"prefix"`                   //prefix of the resend states
+	MaxAttempts    int           `mapstructure:"max_attempts" json:"max_attempts"`       //the code is burnt after failed attempts
+	ResendInterval time.Duration `mapstructure:"resend_interval" json:"resend_interval"` //min interval of codes to a recipient
+	RecipientLimit int           `mapstructure:"recipient_limit" json:"recipient_limit"` //max codes to a recipient in window
+	IPLimit        int           `mapstructure:"ip_limit" json:"ip_limit"`               //max codes requested by a client ip in window
+	LimitWindow    time.Duration `mapstructure:"limit_window" json:"limit_window"`
+}
+
+//SigningKey is the pem key to sign and verify jwt, the key without private key is only used to verify
+type SigningKey struct {
+	ID             string `mapstructure:"id" json:"id"`
+	Algorithm      string `mapstructure:"algorithm" json:"algorithm"` //RS256、ES256、EdDSA etc
+	PrivateKeyPath string `mapstructure:"private_key_path" json:"private_key_path"`
+	PublicKeyPath  string `mapstructure:"public_key_path" json:"public_key_path"`
+}
+
+//OAuthScope is a scope which oauth clients can request, it grants the apis to the client
+type OAuthScope struct {
+	Name        string `mapstructure:"name" json:"name"`
+	Description string `mapstructure:"description" json:"description"`
+	APIS        []API  `mapstructure:"apis" json:"apis"` //%s in path is replaced with the user id
+}
+
+//OAuth is the config of oauth2 authorization server
+type OAuth struct {
+	Issuer         string        `mapstructure:"issuer" json:"issuer"` //base url of the server, derived from request if empty
+	CodeExpiration time.Duration `mapstructure:"code_expiration" json:"code_expiration"`
+	CodePrefix     string        `mapstructure:"code_prefix" json:"code_prefix"`
+	Scopes         []OAuthScope  `mapstructure:"scopes" json:"scopes"`
+}
+
+//IdentityProvider is an upstream openid connect provider of federated login
+type IdentityProvider struct {
+	Name         string   `mapstructure:"name" json:"name"` //used in the url /v1/session/federated/:provider
+	Issuer       string   `mapstructure:"issuer" json:"issuer"`
+	ClientID     string   `mapstructure:"client_id" json:"client_id"`
+	ClientSecret string   `mapstructure:"client_secret" json:"client_secret"`
+	Scopes       []string `mapstructure:"scopes" json:"scopes"`             //openid is always requested
+	RedirectURL  string   `mapstructure:"redirect_url" json:"redirect_url"` //callback url, derived from request if empty
+	TrustEmail   bool     `mapstructure:"trust_email" json:"trust_email"`   //for providers which do not send email_verified
+}
+
+//LDAPAttributes is the attributes of ldap entry mapped to user and profile
+type LDAPAttributes struct {
+	ID       string `mapstructure:"id" json:"id"` //stable id of entry such as entryUUID or objectGUID, dn is used if empty
+	Username string `mapstructure:"username" json:"username"`
+	Email    string `mapstructure:"email" json:"email"`
+	Nickname string `mapstructure:"nickname" json:"nickname"`
+	Company  string `mapstructure:"company" json:"company"`
+	Location string `mapstructure:"location" json:"location"`
+	Groups   string `mapstructure:"groups" json:"groups"` //dns of the groups of entry, such as memberOf
+}
+
+//LDAPGroupRole grants the role to the members of ldap group
+type LDAPGroupRole struct {
+	Group string `mapstructure:"group" json:"group"` //dn of group
+	Role  string `mapstructure:"role" json:"role"`
+}
+
+//LDAP is the config of ldap or active directory authenticator
+type LDAP struct {
+	URL                string          `mapstructure:"url" json:"url"` //ldap://host:389 or ldaps://host:636
+	StartTLS           bool            `mapstructure:"start_tls" json:"start_tls"`
+	InsecureSkipVerify bool            `mapstructure:"insecure_skip_verify" json:"insecure_skip_verify"`
+	BindDN             string          `mapstructure:"bind_dn" json:"bind_dn"` //account to search users, anonymous if empty
+	BindPassword       string          `mapstructure:"bind_password" json:"bind_password"`
+	BaseDN             string          `mapstructure:"base_dn" json:"base_dn"`
+	Filter             string          `mapstructure:"filter" json:"filter"` //%s is replaced with the escaped login name
+	Attributes         LDAPAttributes  `mapstructure:"attributes" json:"attributes"`
+	GroupRoles         []LDAPGroupRole `mapstructure:"group_roles" json:"group_roles"`
+	Timeout            time.Duration   `mapstructure:"timeout" json:"timeout"`
+}
+
+//Impersonation is the config of admins acting as users
+type Impersonation struct {
+	Expiration  time.Duration `mapstructure:"expiration" json:"expiration"`
+	BlockedAPIs []API         `mapstructure:"blocked_apis" json:"blocked_apis"` //path supports :param and *, sensitive apis if empty
+}
+
+//RateLimitRule limits the requests of api by key in a sliding window
+type RateLimitRule struct {
+	Path   string        `mapstructure:"path" json:"path"`     //supports :param and *
+	Method string        `mapstructure:"method" json:"method"` //regexp, all methods if empty or *
+	Key    string        `mapstructure:"key" json:"key"`       //ip, user or field:<name> of request, ip if empty
+	Limit  int           `mapstructure:"limit" json:"limit"`
+	Window time.Duration `mapstructure:"window" json:"window"`
+}
+
+//RateLimit is the config of limiting requests, the states are kept in cache and shared by the instances
+type RateLimit struct {
+	Prefix string          `mapstructure:"prefix" json:"prefix"`
+	Rules  []RateLimitRule `mapstructure:"rules" json:"rules"` //the apis sending emails and login if empty
 }
 
 //Auth for auth config
 type Auth struct {
-	SecretKey              string        `mapstructure:"secret_key" json:"secret_key"`
-	TokenExpiration        time.Duration `mapstructure:"token_expiration" json:"token_expiration"`
-	TokenRefreshExpiration time.Duration `mapstructure:"token_refresh_expiration" json:"token_refresh_expiration"`
-	TokenLookup            string        `mapstructure:"token_lookup" json:"token_lookup"`
-	IdentityKey            string        `mapstructure:"identity_key" json:"identity_key"`
+	SecretKey              string             `mapstructure:"secret_key" json:"secret_key"`
+	SigningKeys            []SigningKey       `mapstructure:"signing_keys" json:"signing_keys"`
+	SigningKeyID           string             `mapstructure:"signing_key_id" json:"signing_key_id"`
+	TokenExpiration        time.Duration      `mapstructure:"token_expiration" json:"token_expiration"`
+	TokenRefreshExpiration time.Duration      `mapstructure:"token_refresh_expiration" json:"token_refresh_expiration"`
+	TokenLookup            string             `mapstructure:"token_lookup" json:"token_lookup"`
+	IdentityKey            string             `mapstructure:"identity_key" json:"identity_key"`
+	Lockout                Lockout            `mapstructure:"lockout" json:"lockout"`
+	RateLimit              RateLimit          `mapstructure:"rate_limit" json:"rate_limit"`
+	OAuth                  OAuth              `mapstructure:"oauth" json:"oauth"`
+	IdentityProviders      []IdentityProvider `mapstructure:"identity_providers" json:"identity_providers"`
+	Authenticators         []string           `mapstructure:"authenticators" json:"authenticators"` //tried in order, local if empty
+	LDAP                   LDAP               `mapstructure:"ldap" json:"ldap"`
+	Impersonation          Impersonation      `mapstructure:"impersonation" json:"impersonation"`
+	RequireVerifiedEmail   bool               `mapstructure:"require_verified_email" json:"require_verified_email"` //unverified users can only verify their email
+}
+
+//MagicLink is the config of passwordless login by the link sent to email
+type MagicLink struct {
+	Enabled    bool          `mapstructure:"enabled" json:"enabled"`
+	Expiration time.Duration `mapstructure:"expiration" json:"expiration"`
+	Prefix     string        `mapstructure:"prefix" json:"prefix"`
+	URL        string        `mapstructure:"url" json:"url"` //the token is appended to url, the api under oauth.issuer is used if empty
+}
+
+//EmailChange is the config of changing and verifying the emails of users, the new email is confirmed by a code
+//and the old email gets a link to undo the change
+type EmailChange struct {
+	CodeExpiration       time.Duration `mapstructure:"code_expiration" json:"code_expiration"`
+	CodePrefix           string        `mapstructure:"code_prefix" json:"code_prefix"`
+	UndoExpiration       time.Duration `mapstructure:"undo_expiration" json:"undo_expiration"`
+	UndoPrefix           string        `mapstructure:"undo_prefix" json:"undo_prefix"`
+	UndoURL              string        `mapstructure:"undo_url" json:"undo_url"` //the token is appended to url, the api under oauth.issuer is used if empty
+	VerifyCodeExpiration time.Duration `mapstructure:"verify_code_expiration" json:"verify_code_expiration"`
+	VerifyCodePrefix     string        `mapstructure:"verify_code_prefix" json:"verify_code_prefix"`
+}
+
+//DeletedUsers is the config of keeping soft-deleted users
+type DeletedUsers struct {
+	Retention     time.Duration `mapstructure:"retention" json:"retention"`           //deleted users are purged after it, never if 0
+	PurgeInterval time.Duration `mapstructure:"purge_interval" json:"purge_interval"` //1h if 0
+}
+
+//DataExport is the config of exporting the data of users, large exports are generated in background and
+//a download link is emailed
+type DataExport struct {
+	AsyncThreshold int           `mapstructure:"async_threshold" json:"async_threshold"` //max records exported in request, 1000 if 0
+	Expiration     time.Duration `mapstructure:"expiration" json:"expiration"`           //download link and archive expiration
+	Prefix         string        `mapstructure:"prefix" json:"prefix"`
+	URL            string        `mapstructure:"url" json:"url"` //the token is appended to url, the api under oauth.issuer is used if empty
+}
+
+//Registration is the config of who can register, the mode is open, approval, invite, domain or closed, open if empty.
+//The users registered in approval mode are pending approval, the invited users can register in all modes except closed
+type Registration struct {
+	Mode                 string        `mapstructure:"mode" json:"mode"`
+	AllowedDomains       []string      `mapstructure:"allowed_domains" json:"allowed_domains"`             //email domains allowed in domain mode
+	InvitationExpiration time.Duration `mapstructure:"invitation_expiration" json:"invitation_expiration"` //7 days if 0
+	InvitationURL        string        `mapstructure:"invitation_url" json:"invitation_url"`               //the token is appended to url, the api under oauth.issuer is used if empty
+}
+
+//PasswordPolicy is the rules of the passwords set by users
+type PasswordPolicy struct {
+	MinLength      int           `mapstructure:"min_length" json:"min_length"`
+	MaxLength      int           `mapstructure:"max_length" json:"max_length"`
+	RequireUpper   bool          `mapstructure:"require_upper" json:"require_upper"`
+	RequireLower   bool          `mapstructure:"require_lower" json:"require_lower"`
+	RequireDigit   bool          `mapstructure:"require_digit" json:"require_digit"`
+	RequireSymbol  bool          `mapstructure:"require_symbol" json:"require_symbol"`
+	MinClasses     int           `mapstructure:"min_classes" json:"min_classes"` //of upper, lower, digit and symbol
+	RejectUserInfo bool          `mapstructure:"reject_user_info" json:"reject_user_info"`
+	Denylist       string        `mapstructure:"denylist" json:"denylist"`         //file of passwords or sha-1 hashes, one per line
+	DenylistDir    string        `mapstructure:"denylist_dir" json:"denylist_dir"` //directory of hibp range files named by sha-1 prefix
+	HistorySize    int           `mapstructure:"history_size" json:"history_size"` //the last passwords which cannot be reused
+	MaxAge         time.Duration `mapstructure:"max_age" json:"max_age"`           //login is rejected after the password expires
+}
+
+//PasswordHasher is the config of hashing new passwords, the hashes of other algorithms or params
+//are upgraded at login
+type PasswordHasher struct {
+	Algorithm     string `mapstructure:"algorithm" json:"algorithm"` //argon2id, scrypt or bcrypt, bcrypt if empty
+	BcryptCost    int    `mapstructure:"bcrypt_cost" json:"bcrypt_cost"`
+	Argon2Time    uint32 `mapstructure:"argon2_time" json:"argon2_time"`
+	Argon2Memory  uint32 `mapstructure:"argon2_memory" json:"argon2_memory"` //KiB
+	Argon2Threads uint8  `mapstructure:"argon2_threads" json:"argon2_threads"`
+	ScryptN       int    `mapstructure:"scrypt_n" json:"scrypt_n"` //power of 2
+	ScryptR       int    `mapstructure:"scrypt_r" json:"scrypt_r"`
+	ScryptP       int    `mapstructure:"scrypt_p" json:"scrypt_p"`
 }
 
 //Account for account http service config
 type Account struct {
-	RegisterCodeExpiration      time.Duration `mapstructure:"register_code_expiration" json:"register_code_expiration"`
-	RegisterCodePrefix          string        `mapstructure:"register_code_prefix" json:"register_code_prefix"`
-	PasswordResetCodeExpiration time.Duration `mapstructure:"password_reset_code_expiration" json:"password_reset_code_expiration"`
-	PasswordResetCodePrefix     string        `mapstructure:"password_reset_code_prefix" json:"password_reset_code_prefix"`
-	Auth                        Auth          `mapstructure:"auth" json:"auth"`
+	RegisterCodeExpiration      time.Duration  `mapstructure:"register_code_expiration" json:"register_code_expiration"`
+	RegisterCodePrefix          string         `mapstructure:"register_code_prefix" json:"register_code_prefix"`
+	Registration                Registration   `mapstructure:"registration" json:"registration"`
+	VerifyCode                  VerifyCode     `mapstructure:"verify_code" json:"verify_code"`
+	PasswordResetCodeExpiration time.Duration  `mapstructure:"password_reset_code_expiration" json:"password_reset_code_expiration"`
+	PasswordResetCodePrefix     string         `mapstructure:"password_reset_code_prefix" json:"password_reset_code_prefix"`
+	Auth                        Auth           `mapstructure:"auth" json:"auth"`
+	MagicLink                   MagicLink      `mapstructure:"magic_link" json:"magic_link"`
+	PasswordPolicy              PasswordPolicy `mapstructure:"password_policy" json:"password_policy"`
+	PasswordHasher              PasswordHasher `mapstructure:"password_hasher" json:"password_hasher"`
+	EmailChange                 EmailChange    `mapstructure:"email_change" json:"email_change"`
+	DeletedUsers                DeletedUsers   `mapstructure:"deleted_users" json:"deleted_users"`
+	DataExport                  DataExport     `mapstructure:"data_export" json:"data_export"`
 }
 
 //Services for services config
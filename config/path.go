@@ -13,6 +13,9 @@ func (c *Config) InitBaseDir() error {
 	if err := os.MkdirAll(c.EmailTemplBasePath(), 0666); err != nil {
 		return err
 	}
+	if err := os.MkdirAll(c.ExportBasePath(), 0666); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -22,8 +25,22 @@ func (c *Config) AvatarBasePath() string {
 	return filepath.Join(dir, "/images/avatar")
 }
 
+//RemoveAvatar remove the avatar file, it is not an error if the file does not exist
+func (c *Config) RemoveAvatar(avatar string) error {
+	if err := os.Remove(filepath.Join(c.AvatarBasePath(), filepath.Base(avatar))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 //EmailTemplBasePath for email template file path
 func (c *Config) EmailTemplBasePath() string {
 	dir, _ := os.Getwd()
 	return filepath.Join(dir, "/templates/email")
 }
+
+//ExportBasePath for user data export archive path
+func (c *Config) ExportBasePath() string {
+	dir, _ := os.Getwd()
+	return filepath.Join(dir, "/exports")
+}
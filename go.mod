@@ -10,8 +10,11 @@ require (
 	github.com/casbin/casbin/v2 v2.1.2
 	github.com/casbin/gorm-adapter v1.0.0
 	github.com/casbin/gorm-adapter/v2 v2.0.3
+	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/gin-contrib/cors v1.3.0
 	github.com/gin-gonic/gin v1.5.0
+	github.com/go-asn1-ber/asn1-ber v1.3.1
+	github.com/go-ldap/ldap/v3 v3.1.10
 	github.com/go-ole/go-ole v1.2.4 // indirect
 	github.com/infobloxopen/protoc-gen-gorm v0.18.0
 	github.com/jinzhu/gorm v1.9.12
@@ -3,18 +3,44 @@ package middleware
 import (
 	"context"
 	"crypto/rand"
-	"io"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/casbin/casbin/v2/util"
 	"github.com/gin-gonic/gin"
 	db "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/config"
 	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils/cache"
+	"github.com/ngs24313/gopu/utils/keyset"
 	"github.com/ngs24313/gopu/utils/log"
+	"github.com/ngs24313/gopu/utils/password"
 	"github.com/ngs24313/gopu/utils/rolemanager"
+	"github.com/rs/xid"
 	"go.uber.org/zap"
 )
 
+//dummyHashPassword is compared when the user does not exist, it is hashed by the configured hasher
+//on first use so that both cases take the same time
+var (
+	dummyHashPassword     string
+	dummyHashPasswordOnce sync.Once
+)
+
+func dummyHash() string {
+	dummyHashPasswordOnce.Do(func() {
+		hashedPwd, err := password.GenHashPassword("ngs24313/gopu")
+		if err != nil {
+			log.Logger(context.Background()).Warn("Failed to hash dummy password", zap.Error(err))
+		}
+		dummyHashPassword = hashedPwd
+	})
+	return dummyHashPassword
+}
+
 type LoginForm struct {
 	Username string `json:"username" form:"username" binding:"required"`
 	Password string `json:"password" form:"password" binding:"required"`
@@ -22,14 +48,24 @@ type LoginForm struct {
 
 //AuthOptions auth options
 type AuthOptions struct {
-	Realm         string
-	Key           []byte
-	Timeout       time.Duration
-	MaxRefersh    time.Duration
-	IdentityKey   string
-	TokenLookup   string
-	TimeFunc      func() time.Time
-	TokenHeadName string
+	Realm          string
+	Key            []byte
+	KeySet         *keyset.KeySet //sign tokens by key set instead of key
+	Timeout        time.Duration
+	MaxRefersh     time.Duration //lifetime of refresh token
+	IdentityKey    string
+	TokenLookup    string
+	TimeFunc       func() time.Time
+	TokenHeadName  string
+	Cache          cache.Cache
+	Lockout        config.Lockout
+	RateLimit      config.RateLimit
+	MFARoles       []string //users of these roles must login with mfa
+	OAuth          config.OAuth
+	Authenticators []Authenticator //tried in order to authenticate login, local authenticator if empty
+	Impersonation  config.Impersonation
+	//users whose email is not verified can only access the routes to verify it
+	RequireVerifiedEmail bool
 }
 
 //AuthOption for set AuthOptions
@@ -39,18 +75,61 @@ type AuthOption func(*AuthOptions)
 type Auth struct {
 	opts    AuthOptions
 	adb     db.AccountDatabase
+	tdb     db.TokenDatabase
+	odb     db.OAuthDatabase
+	audb    db.AuditDatabase
 	roleMgr rolemanager.RoleManager
+	keys    *keyset.KeySet
+	limiter *LoginLimiter
+	revoker *TokenRevoker
+	rates   *RateLimiter
+
+	mfaMu sync.Mutex //serialize the invalid mfa codes and the challenge attempts
 }
 
 //NewAuth create auth
 func NewAuth(adb db.AccountDatabase,
+	tdb db.TokenDatabase,
+	odb db.OAuthDatabase,
+	audb db.AuditDatabase,
 	roleMgr rolemanager.RoleManager,
 	opts ...AuthOption) *Auth {
 	options := loadOpts(opts...)
+
+	if options.OAuth.CodeExpiration == 0 {
+		options.OAuth.CodeExpiration = time.Minute
+	}
+	if options.OAuth.CodePrefix == "" {
+		options.OAuth.CodePrefix = "oauth_code."
+	}
+
+	if options.Impersonation.Expiration == 0 {
+		options.Impersonation.Expiration = impersonationDefaultExpiration
+	}
+	if len(options.Impersonation.BlockedAPIs) == 0 {
+		options.Impersonation.BlockedAPIs = impersonationDefaultBlockedAPIs
+	}
+
+	if len(options.Authenticators) == 0 {
+		options.Authenticators = []Authenticator{NewLocalAuthenticator(adb, 0)}
+	}
+
+	keys := options.KeySet
+	if keys == nil && len(options.Key) > 0 {
+		keys = keyset.NewHMAC("", options.Key)
+	}
+
 	return &Auth{
 		opts:    options,
 		adb:     adb,
+		tdb:     tdb,
+		odb:     odb,
+		audb:    audb,
 		roleMgr: roleMgr,
+		keys:    keys,
+		limiter: NewLoginLimiter(options.Cache, options.Lockout, options.TimeFunc),
+		revoker: NewTokenRevoker(options.Cache, options.TimeFunc),
+		rates:   NewRateLimiter(options.Cache, options.RateLimit, options.TimeFunc),
 	}
 }
 
@@ -59,13 +138,54 @@ func (a *Auth) Options() AuthOptions {
 	return a.opts
 }
 
+//LoginLimiter get the login failure limiter
+func (a *Auth) LoginLimiter() *LoginLimiter {
+	return a.limiter
+}
+
+//RateLimiter get the rate limiter of requests
+func (a *Auth) RateLimiter() *RateLimiter {
+	return a.rates
+}
+
+//RevokeUserTokens revoke all access tokens, refresh tokens and sessions of user issued before now
+func (a *Auth) RevokeUserTokens(ctx context.Context, userID string) error {
+	now := a.opts.TimeFunc()
+	if err := a.tdb.RevokeUserRefreshTokens(ctx, userID, now); err != nil {
+		return err
+	}
+	if err := a.tdb.RevokeUserSessions(ctx, userID, now); err != nil {
+		return err
+	}
+	return a.revoker.RevokeUser(userID, a.opts.Timeout)
+}
+
+//RevokeClientTokens revoke all access tokens and refresh tokens issued to the oauth client
+func (a *Auth) RevokeClientTokens(ctx context.Context, clientID string) error {
+	if err := a.tdb.RevokeClientRefreshTokens(ctx, clientID, a.opts.TimeFunc()); err != nil {
+		return err
+	}
+	return a.revoker.RevokeClient(clientID, a.opts.Timeout)
+}
+
+//revokeFamily revoke all access tokens and refresh tokens of the refresh token family
+func (a *Auth) revokeFamily(ctx context.Context, family string) error {
+	if err := a.tdb.RevokeRefreshTokenFamily(ctx, family, a.opts.TimeFunc()); err != nil {
+		return err
+	}
+	return a.revoker.RevokeFamily(family, a.opts.Timeout)
+}
+
 //Middleware middleware auth for gin
-func (a *Auth) Middleware() (*jwt.GinJWTMiddleware, error) {
-	return jwt.New(&jwt.GinJWTMiddleware{
+func (a *Auth) Middleware() (*JWTMiddleware, error) {
+	if a.keys == nil {
+		return nil, jwt.ErrMissingSecretKey
+	}
+
+	mw, err := jwt.New(&jwt.GinJWTMiddleware{
 		Realm:           a.opts.Realm,
 		Key:             a.opts.Key,
 		Timeout:         a.opts.Timeout,
-		MaxRefresh:      a.opts.MaxRefersh,
 		IdentityKey:     a.opts.IdentityKey,
 		PayloadFunc:     a.payloadFunc,
 		IdentityHandler: a.identityHandler,
@@ -76,21 +196,60 @@ func (a *Auth) Middleware() (*jwt.GinJWTMiddleware, error) {
 		TimeFunc:        a.opts.TimeFunc,
 		TokenHeadName:   a.opts.TokenHeadName,
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &JWTMiddleware{
+		GinJWTMiddleware: mw,
+		auth:             a,
+	}, nil
 }
 
 func (a *Auth) payloadFunc(data interface{}) jwt.MapClaims {
-	if v, ok := data.(*models.User); ok {
-		return jwt.MapClaims{
-			a.opts.IdentityKey: v.ID,
+	var user *models.User
+	claims := jwt.MapClaims{}
+
+	switch v := data.(type) {
+	case *models.User:
+		user = v
+	case *tokenSubject:
+		user = v.user
+		claims["fam"] = v.family
+		claims["amr"] = v.amr
+		if !v.authTime.IsZero() {
+			claims["auth_time"] = v.authTime.Unix()
+		}
+		if v.sessionID != "" {
+			claims["sid"] = v.sessionID
 		}
+		if v.actor != "" {
+			claims["act"] = map[string]interface{}{"sub": v.actor}
+		}
+		if v.clientID != "" {
+			claims["client_id"] = v.clientID
+			claims["scope"] = v.scope
+		}
+	default:
+		return claims
 	}
-	return jwt.MapClaims{}
+
+	claims[a.opts.IdentityKey] = user.ID
+	claims["email_verified"] = user.EmailVerifiedAt != nil
+	claims["jti"] = xid.New().String()
+	//sub-second precision, so tokens issued right after a revocation are still valid
+	claims["iat"] = float64(a.opts.TimeFunc().UnixNano()) / float64(time.Second)
+	return claims
 }
 
 func (a *Auth) identityHandler(c *gin.Context) interface{} {
 	claims := jwt.ExtractClaims(c)
 
-	id := claims[a.opts.IdentityKey].(string)
+	id, ok := claims[a.opts.IdentityKey].(string)
+	if !ok || id == "" {
+		return nil
+	}
+
 	user, err := a.adb.GetUserByID(c.Request.Context(), id)
 	if err != nil {
 		return nil
@@ -105,22 +264,43 @@ func (a *Auth) authenticator(c *gin.Context) (interface{}, error) {
 		return nil, jwt.ErrMissingLoginValues
 	}
 
-	user, err := a.adb.GetUserByUsername(c.Request.Context(), form.Username)
-	if err != nil {
-		if err != db.ErrNotFound {
-			log.Logger(c.Request.Context()).Error("Failed to authenticate when get user by username", zap.Error(err))
+	ctx := c.Request.Context()
+	clientIP := c.ClientIP()
+
+	//the attempt is recorded as a failure before the password is checked and released if it does not fail
+	account := a.lockoutAccount(ctx, form.Username)
+	if err := a.limiter.Attempt(account, clientIP); err != nil {
+		if err != ErrLoginLocked {
+			log.Logger(ctx).Error("Failed to record login attempt", zap.Error(err))
 			return nil, jwt.ErrFailedAuthentication
 		}
+		return nil, err
+	}
 
-		user, err = a.adb.GetUserByEmail(c.Request.Context(), form.Username)
-		if err != nil {
-			if err != db.ErrNotFound {
-				log.Logger(c.Request.Context()).Error("Failed to authenticate when get user by email", zap.Error(err))
+	user, err := a.authenticate(ctx, form.Username, form.Password)
+	if err != nil {
+		if err != ErrInvalidCredentials {
+			for kind, key := range map[string]string{LockoutAccount: account, LockoutIP: clientIP} {
+				if err := a.limiter.Release(kind, key); err != nil {
+					log.Logger(ctx).Warn("Failed to release login attempt", zap.Error(err))
+				}
 			}
-			return nil, jwt.ErrFailedAuthentication
 		}
+		if err == ErrPasswordExpired {
+			return nil, err
+		}
+		return nil, jwt.ErrFailedAuthentication
 	}
 
+	if err := a.limiter.Release(LockoutIP, clientIP); err != nil {
+		log.Logger(ctx).Warn("Failed to release login attempt", zap.Error(err))
+	}
+	//the failures recorded before the user exists locally are keyed by the login
+	for _, key := range []string{user.ID, account} {
+		if err := a.limiter.Succeed(key); err != nil {
+			log.Logger(ctx).Warn("Failed to clear login failures", zap.Error(err))
+		}
+	}
 	return user, nil
 }
 
@@ -134,6 +314,11 @@ func (a *Auth) authorizator(data interface{}, c *gin.Context) bool {
 		return false
 	}
 
+	if !a.mfaSatisfied(user, c) || !a.emailVerified(user, c) || !a.scopeSatisfied(user, c) ||
+		!a.personalAccessTokenPermitted(c) || !a.impersonationPermitted(c) {
+		return false
+	}
+
 	log.Logger(context.Background()).Debug("Starting validate user permission",
 		zap.String("subject", user.ID),
 		zap.String("url", c.Request.URL.Path),
@@ -151,6 +336,46 @@ func (a *Auth) authorizator(data interface{}, c *gin.Context) bool {
 	return true
 }
 
+//mfaSatisfied return false if the user must login with mfa but the token does not,
+//such tokens can only access the mfa routes of the user for enrollment.
+//personal access tokens are created by satisfied sessions, so they are trusted
+func (a *Auth) mfaSatisfied(user *models.User, c *gin.Context) bool {
+	if IsPersonalAccessToken(c) {
+		return true
+	}
+
+	required, err := a.mfaRequired(user.ID)
+	if err != nil {
+		log.Logger(c.Request.Context()).Warn("Failed to check mfa requirement", zap.Error(err))
+		return false
+	}
+
+	if !required || hasAMR(jwt.ExtractClaims(c)["amr"], AMRMFA) {
+		return true
+	}
+
+	return strings.HasPrefix(c.Request.URL.Path, fmt.Sprintf("/v1/user/%s/mfa/", user.ID))
+}
+
+//emailVerified return false if verified emails are required but the email of user is not verified,
+//such users can only access the email routes of the user to verify or correct their email
+func (a *Auth) emailVerified(user *models.User, c *gin.Context) bool {
+	if !a.opts.RequireVerifiedEmail || user.EmailVerifiedAt != nil {
+		return true
+	}
+
+	prefix := fmt.Sprintf("/v1/user/%s/email", user.ID)
+	return c.Request.URL.Path == prefix || strings.HasPrefix(c.Request.URL.Path, prefix+"/")
+}
+
+//matchAPI return true if the request matches the api and the method, the api can be a casbin keyMatch pattern
+//or a route with parameters like /v1/user/:id, the method is a regex matching the whole method
+func matchAPI(c *gin.Context, api string, method string) bool {
+	path := c.Request.URL.Path
+	return (api == "*" || util.KeyMatch(path, api) || util.KeyMatch2(path, api)) &&
+		(method == "*" || util.RegexMatch(c.Request.Method, "^("+method+")$"))
+}
+
 func (a *Auth) unauthorized(c *gin.Context, code int, message string) {
 	c.JSON(code, gin.H{
 		"code":    code,
@@ -170,6 +395,12 @@ func WithKey(key []byte) AuthOption {
 	}
 }
 
+func WithKeySet(keys *keyset.KeySet) AuthOption {
+	return func(o *AuthOptions) {
+		o.KeySet = keys
+	}
+}
+
 func WithTimeout(timeout time.Duration) AuthOption {
 	return func(ao *AuthOptions) {
 		ao.Timeout = timeout
@@ -206,12 +437,59 @@ func WithTimeFunc(f func() time.Time) AuthOption {
 	}
 }
 
-func loadOpts(opts ...AuthOption) AuthOptions {
+func WithCache(c cache.Cache) AuthOption {
+	return func(ao *AuthOptions) {
+		ao.Cache = c
+	}
+}
 
-	key := make([]byte, 16)
-	_, err := rand.Read(key)
-	if err == io.ErrUnexpectedEOF {
-		key = []byte("ngs24313/gopu")
+func WithMFARoles(roles []string) AuthOption {
+	return func(ao *AuthOptions) {
+		ao.MFARoles = roles
+	}
+}
+
+func WithRequireVerifiedEmail(required bool) AuthOption {
+	return func(ao *AuthOptions) {
+		ao.RequireVerifiedEmail = required
+	}
+}
+
+func WithOAuth(oauth config.OAuth) AuthOption {
+	return func(ao *AuthOptions) {
+		ao.OAuth = oauth
+	}
+}
+
+func WithLockout(lockout config.Lockout) AuthOption {
+	return func(ao *AuthOptions) {
+		ao.Lockout = lockout
+	}
+}
+
+func WithRateLimit(rateLimit config.RateLimit) AuthOption {
+	return func(ao *AuthOptions) {
+		ao.RateLimit = rateLimit
+	}
+}
+
+func WithAuthenticators(authenticators ...Authenticator) AuthOption {
+	return func(ao *AuthOptions) {
+		ao.Authenticators = authenticators
+	}
+}
+
+func WithImpersonation(impersonation config.Impersonation) AuthOption {
+	return func(o *AuthOptions) {
+		o.Impersonation = impersonation
+	}
+}
+
+func loadOpts(opts ...AuthOption) AuthOptions {
+	//tokens cannot be verified after restart unless a key is configured
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		key = nil
 	}
 
 	options := AuthOptions{
@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	dao "github.com/ngs24313/gopu/api/database/database"
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils/cache"
+	"github.com/ngs24313/gopu/utils/cache/bigcache"
+	gormdb "github.com/ngs24313/gopu/utils/database/gorm"
+	"github.com/ngs24313/gopu/utils/password"
+	"github.com/ngs24313/gopu/utils/rolemanager"
+)
+
+const (
+	testUsername = "alice"
+	testEmail    = "alice@example.com"
+	testPassword = "correct horse battery"
+)
+
+//testClock is the time source of tests, it only moves by Advance
+type testClock struct {
+	now time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Now()}
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+//testRoleManager grants every permission, the roles of users and the roles they inherit are set by the tests
+type testRoleManager struct {
+	rolemanager.RoleManager
+	roles    map[string][]string
+	inherits map[string][]string
+}
+
+func (m *testRoleManager) Validate(string, *models.Permission) (bool, error) {
+	return true, nil
+}
+
+func (m *testRoleManager) GetRoleForUser(user string) ([]string, error) {
+	return m.roles[user], nil
+}
+
+func (m *testRoleManager) GetAncestorRoles(role string) ([]string, error) {
+	parents, ok := m.inherits[role]
+	if !ok {
+		return nil, rolemanager.ErrRoleNotExists
+	}
+
+	ancestors := []string{}
+	for _, parent := range parents {
+		ancestors = append(ancestors, parent)
+		grandparents, _ := m.GetAncestorRoles(parent)
+		ancestors = append(ancestors, grandparents...)
+	}
+	return ancestors, nil
+}
+
+func (m *testRoleManager) HasRoleForUser(user string, role string) (bool, error) {
+	for _, r := range m.roles[user] {
+		if r == role {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *testRoleManager) AddRoleForUser(user string, role string) (bool, error) {
+	if has, _ := m.HasRoleForUser(user, role); has {
+		return false, rolemanager.ErrUserHasRole
+	}
+	if m.roles == nil {
+		m.roles = map[string][]string{}
+	}
+	m.roles[user] = append(m.roles[user], role)
+	return true, nil
+}
+
+func (m *testRoleManager) DelRoleForUser(user string, role string) (bool, error) {
+	roles := m.roles[user][:0]
+	for _, r := range m.roles[user] {
+		if r != role {
+			roles = append(roles, r)
+		}
+	}
+	m.roles[user] = roles
+	return true, nil
+}
+
+func newTestCache(t *testing.T) cache.Cache {
+	c := bigcache.NewCache()
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+//newTestAuth create the auth of an in-memory database holding the test user
+func newTestAuth(t *testing.T, roleMgr rolemanager.RoleManager, opts ...AuthOption) (*Auth, *models.User) {
+	h, err := gormdb.NewHandler("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//every connection of sqlite memory database is a new database
+	h.(gormdb.Database).Instance().DB().SetMaxOpenConns(1)
+
+	if err := h.Migrate(
+		&models.User{},
+		&models.Profile{},
+		&models.RefreshToken{},
+		&models.PersonalAccessToken{},
+		&models.TOTP{},
+		&models.RecoveryCode{},
+		&models.OAuthClient{},
+		&models.OAuthConsent{},
+		&models.FederatedIdentity{},
+		&models.Session{},
+		&models.AuditEvent{},
+		&models.PasswordHistory{},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	hashedPwd, err := password.GenHashPassword(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adb := dao.NewAccountDatabase(h)
+	user, err := adb.CreateUser(context.Background(), &models.User{
+		Username: testUsername,
+		Email:    testEmail,
+		Password: hashedPwd,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if roleMgr == nil {
+		roleMgr = &testRoleManager{}
+	}
+	opts = append([]AuthOption{WithKey([]byte("secret"))}, opts...)
+	auth := NewAuth(adb, dao.NewTokenDatabase(h), dao.NewOAuthDatabase(h), dao.NewAuditDatabase(h), roleMgr, opts...)
+	return auth, user
+}
+
+//testLogin authenticate the login form from the client ip
+func testLogin(a *Auth, username string, pwd string, ip string) (*models.User, error) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	form := url.Values{"username": {username}, "password": {pwd}}
+	c.Request = httptest.NewRequest("POST", "/v1/session", strings.NewReader(form.Encode()))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	c.Request.RemoteAddr = ip + ":40000"
+
+	data, err := a.authenticator(c)
+	if err != nil {
+		return nil, err
+	}
+	return data.(*models.User), nil
+}
@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	db "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils/log"
+	"github.com/ngs24313/gopu/utils/password"
+	"go.uber.org/zap"
+)
+
+//names of authenticators in config
+const (
+	AuthenticatorLocal = "local"
+	AuthenticatorLDAP  = "ldap"
+)
+
+var (
+	//ErrInvalidCredentials the user is not found or the password is wrong
+	ErrInvalidCredentials = errors.New("username or password is invalid")
+	//ErrPasswordExpired the password is right but older than the max age, it must be reset
+	ErrPasswordExpired = errors.New("password has expired")
+)
+
+//Authenticator authenticates the username and password of login
+type Authenticator interface {
+	Name() string
+	//Authenticate return the user of credentials, ErrInvalidCredentials lets the next authenticator try
+	Authenticate(ctx context.Context, username string, password string) (*models.User, error)
+}
+
+//LocalAuthenticator authenticates users by the passwords in account database
+type LocalAuthenticator struct {
+	adb            db.AccountDatabase
+	passwordMaxAge time.Duration
+}
+
+//NewLocalAuthenticator create local authenticator, passwords never expire if max age is 0
+func NewLocalAuthenticator(adb db.AccountDatabase, passwordMaxAge time.Duration) *LocalAuthenticator {
+	return &LocalAuthenticator{
+		adb:            adb,
+		passwordMaxAge: passwordMaxAge,
+	}
+}
+
+//Name get the name of authenticator
+func (l *LocalAuthenticator) Name() string {
+	return AuthenticatorLocal
+}
+
+//Authenticate find user by username or email and compare the password
+func (l *LocalAuthenticator) Authenticate(ctx context.Context, username string, pwd string) (*models.User, error) {
+	user, err := lookupUser(ctx, l.adb, username)
+	if err != nil {
+		return nil, err
+	}
+
+	hashedPwd := dummyHash()
+	if user != nil {
+		hashedPwd = user.Password
+	}
+
+	//compare with a dummy hash for unknown user, so that both cases take the same time
+	if !password.CompareHashPassword(hashedPwd, pwd) || user == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	//the passwords set before their change time is recorded never expire
+	if l.passwordMaxAge > 0 && user.PasswordChangedAt != nil &&
+		time.Since(*user.PasswordChangedAt) > l.passwordMaxAge {
+		return nil, ErrPasswordExpired
+	}
+
+	l.rehash(ctx, user, pwd)
+	return user, nil
+}
+
+//rehash upgrades the hash of user to the default hasher, failures are only logged
+func (l *LocalAuthenticator) rehash(ctx context.Context, user *models.User, pwd string) {
+	if !password.NeedsRehash(user.Password) {
+		return
+	}
+
+	hashedPwd, err := password.GenHashPassword(pwd)
+	if err != nil {
+		log.Logger(ctx).Warn("Failed to rehash password", zap.String("user", user.ID), zap.Error(err))
+		return
+	}
+
+	user.Password = hashedPwd
+	if err := l.adb.UpdateUser(ctx, user); err != nil {
+		log.Logger(ctx).Warn("Failed to save rehashed password", zap.String("user", user.ID), zap.Error(err))
+	}
+}
+
+//lookupUser find user by username or email, user is nil if not found
+func lookupUser(ctx context.Context, adb db.AccountDatabase, username string) (*models.User, error) {
+	user, err := adb.GetUserByUsername(ctx, username)
+	if err == nil {
+		return user, nil
+	}
+
+	if err != db.ErrNotFound {
+		return nil, err
+	}
+
+	user, err = adb.GetUserByEmail(ctx, username)
+	if err != nil {
+		if err != db.ErrNotFound {
+			return nil, err
+		}
+		return nil, nil
+	}
+	return user, nil
+}
+
+//lockoutAccount get the account key of login lockout, it is the id of the local user so that the logins
+//by username and by email share the failures, or the login itself for unknown accounts
+func (a *Auth) lockoutAccount(ctx context.Context, login string) string {
+	user, err := lookupUser(ctx, a.adb, login)
+	if err != nil {
+		log.Logger(ctx).Warn("Failed to find user of login", zap.Error(err))
+		return login
+	}
+	if user == nil {
+		return login
+	}
+	return user.ID
+}
+
+//authenticate tries the authenticators in order, ErrInvalidCredentials is returned if any of them rejects
+//the credentials, so that failures of backends are not counted as login failures.
+//an expired password stops trying, the credentials are right
+func (a *Auth) authenticate(ctx context.Context, username string, password string) (*models.User, error) {
+	var lastErr error = ErrInvalidCredentials
+	rejected := false
+	for _, authenticator := range a.opts.Authenticators {
+		user, err := authenticator.Authenticate(ctx, username, password)
+		if err == nil {
+			return user, nil
+		}
+
+		if err == ErrInvalidCredentials {
+			rejected = true
+			continue
+		}
+
+		if err == ErrPasswordExpired {
+			return nil, err
+		}
+
+		log.Logger(ctx).Error("Failed to authenticate",
+			zap.String("authenticator", authenticator.Name()),
+			zap.Error(err))
+		lastErr = err
+	}
+
+	if rejected {
+		return nil, ErrInvalidCredentials
+	}
+	return nil, lastErr
+}
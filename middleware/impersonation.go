@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/ngs24313/gopu/config"
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils/log"
+	"go.uber.org/zap"
+)
+
+const impersonationDefaultExpiration = 15 * time.Minute
+
+//impersonationDefaultBlockedAPIs can change the credentials, the account or the permissions
+var impersonationDefaultBlockedAPIs = []config.API{
+	{Path: "/v1/user/:id", Method: "DELETE"},
+	{Path: "/v1/user/:id/password", Method: "*"},
+	{Path: "/v1/user/:id/email", Method: "*"},
+	{Path: "/v1/user/:id/email/*", Method: "*"},
+	{Path: "/v1/user/:id/mfa/*", Method: "*"},
+	{Path: "/v1/user/:id/tokens", Method: "*"},
+	{Path: "/v1/user/:id/tokens/*", Method: "*"},
+	{Path: "/v1/user/:id/sessions", Method: "DELETE"},
+	{Path: "/v1/user/:id/sessions/*", Method: "DELETE"},
+	{Path: "/v1/user/:id/export", Method: "*"},
+	{Path: "/v1/admin/*", Method: "*"},
+	{Path: "/v1/role", Method: "POST"},
+	{Path: "/v1/role/*", Method: "(POST)|(PUT)|(PATCH)|(DELETE)"},
+	{Path: "/v1/oauth/*", Method: "*"},
+	//the consent and the codes of the consented clients are granted by the user
+	{Path: "/oauth/authorize", Method: "*"},
+}
+
+//ErrImpersonationNotAllowed impersonation cannot be started by the request
+var ErrImpersonationNotAllowed = errors.New("impersonation is not allowed")
+
+//ImpersonationToken issue a short-lived access token of target for the user of request, the token carries
+//the impersonator in the act claim and has no refresh token
+func (m *JWTMiddleware) ImpersonationToken(c *gin.Context, target *models.User) (string, time.Time, error) {
+	claims := jwt.ExtractClaims(c)
+	actor, _ := claims[m.IdentityKey].(string)
+	if actor == "" || actor == target.ID || IsPersonalAccessToken(c) || impersonatorOf(claims) != "" {
+		return "", time.Time{}, ErrImpersonationNotAllowed
+	}
+
+	subject := &tokenSubject{
+		user:     target,
+		amr:      claimStrings(claims["amr"]),
+		authTime: m.TimeFunc(),
+		actor:    actor,
+	}
+	//revoking the session of impersonator revokes the token
+	subject.sessionID, _ = claims["sid"].(string)
+
+	token, expire, err := m.generateToken(subject, m.auth.opts.Impersonation.Expiration)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	m.auth.audit(c, &models.AuditEvent{
+		Action:  models.AuditImpersonationStart,
+		ActorID: actor,
+		UserID:  target.ID,
+	})
+	return token, expire, nil
+}
+
+//RejectImpersonation rejects the impersonated requests of the routes which do not require a token,
+//such as the password change by old password
+func (m *JWTMiddleware) RejectImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := m.GetClaimsFromJWT(c)
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		actor := impersonatorOf(claims)
+		if actor == "" {
+			c.Next()
+			return
+		}
+
+		userID, _ := claims[m.IdentityKey].(string)
+		defer m.auth.auditImpersonation(c, actor, userID)
+		m.unauthorized(c, http.StatusForbidden, m.HTTPStatusMessageFunc(jwt.ErrForbidden, c))
+	}
+}
+
+//Impersonator get the id of the admin impersonating the user of request, empty if not impersonated
+func Impersonator(c *gin.Context) string {
+	return impersonatorOf(jwt.ExtractClaims(c))
+}
+
+func impersonatorOf(claims jwt.MapClaims) string {
+	act, ok := claims["act"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	sub, _ := act["sub"].(string)
+	return sub
+}
+
+//impersonationPermitted return false if the request is impersonated and matches a blocked api
+func (a *Auth) impersonationPermitted(c *gin.Context) bool {
+	if Impersonator(c) == "" {
+		return true
+	}
+
+	for _, api := range a.opts.Impersonation.BlockedAPIs {
+		if matchAPI(c, api.Path, api.Method) {
+			return false
+		}
+	}
+	return true
+}
+
+//auditImpersonation records the request made by actor as user
+func (a *Auth) auditImpersonation(c *gin.Context, actor string, userID string) {
+	a.audit(c, &models.AuditEvent{
+		Action:  models.AuditImpersonationRequest,
+		ActorID: actor,
+		UserID:  userID,
+		Method:  c.Request.Method,
+		Path:    c.Request.URL.Path,
+		Status:  c.Writer.Status(),
+	})
+}
+
+//audit writes the event with the client of request, failures are only logged
+func (a *Auth) audit(c *gin.Context, event *models.AuditEvent) {
+	if a.audb == nil {
+		return
+	}
+
+	event.ClientIP = c.ClientIP()
+	event.UserAgent = c.Request.UserAgent()
+	if err := a.audb.CreateAuditEvent(c.Request.Context(), event); err != nil {
+		log.Logger(c.Request.Context()).Error("Failed to write audit event",
+			zap.String("action", event.Action),
+			zap.String("actor", event.ActorID),
+			zap.String("user", event.UserID),
+			zap.Error(err))
+	}
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	apidao "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/models"
+)
+
+func TestImpersonation(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	admin, err := s.auth.adb.CreateUser(ctx, &models.User{Username: "bob", Email: "bob@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := s.auth.Middleware()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//impersonate issue the token of target for the claims of request
+	impersonate := func(claims jwt.MapClaims, target *models.User) (string, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("POST", "/v1/admin/impersonate/"+target.ID, nil)
+		c.Set("JWT_PAYLOAD", claims)
+		token, _, err := m.ImpersonationToken(c, target)
+		return token, err
+	}
+
+	token, err := impersonate(jwt.MapClaims{m.IdentityKey: admin.ID}, s.user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := s.createClient("", "profile")
+	authorize := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"profile"},
+		"code_challenge":        {pkce("verifier")},
+		"code_challenge_method": {"S256"},
+		"approve":               {"true"},
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		form   url.Values
+		status int
+	}{
+		{"allowed api", "GET", "/v1/current_user", nil, http.StatusOK},
+		{"password change", "PUT", "/v1/user/" + s.user.ID + "/password", nil, http.StatusForbidden},
+		{"oauth authorize", "GET", "/oauth/authorize", authorize, http.StatusForbidden},
+		{"oauth consent", "POST", "/oauth/authorize", authorize, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if resp, reply := s.do(tt.method, tt.path, token, tt.form); resp.StatusCode != tt.status {
+			t.Errorf("%s: %s %s = %d %v, want %d", tt.name, tt.method, tt.path, resp.StatusCode, reply, tt.status)
+		}
+	}
+
+	//the user can still change the password
+	if resp, _ := s.do("PUT", "/v1/user/"+s.user.ID+"/password", s.login(), nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("password change of user = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	result, err := s.auth.audb.ListAuditEvents(ctx, apidao.AuditListQuery{ActorID: admin.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	for _, event := range result.Events {
+		if event.UserID != s.user.ID {
+			t.Errorf("event %s of user %s, want %s", event.Action, event.UserID, s.user.ID)
+		}
+		counts[event.Action]++
+	}
+	if counts[models.AuditImpersonationStart] != 1 || counts[models.AuditImpersonationRequest] != len(tests) {
+		t.Errorf("audit events = %v, want 1 start and %d requests", counts, len(tests))
+	}
+
+	//the impersonation cannot be nested or started for the actor itself
+	if _, err := impersonate(jwt.MapClaims{
+		m.IdentityKey: s.user.ID,
+		"act":         map[string]interface{}{"sub": admin.ID},
+	}, admin); err != ErrImpersonationNotAllowed {
+		t.Errorf("nested impersonation = %v, want %v", err, ErrImpersonationNotAllowed)
+	}
+	if _, err := impersonate(jwt.MapClaims{m.IdentityKey: admin.ID}, admin); err != ErrImpersonationNotAllowed {
+		t.Errorf("impersonation of actor = %v, want %v", err, ErrImpersonationNotAllowed)
+	}
+}
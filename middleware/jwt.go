@@ -0,0 +1,283 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	gojwt "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	db "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils/log"
+	"go.uber.org/zap"
+)
+
+//ErrRevokedToken token has been revoked
+var ErrRevokedToken = errors.New("token has been revoked")
+
+//JWTMiddleware is the gin jwt middleware which signs and verifies tokens by the key set of auth
+//and rejects revoked tokens
+type JWTMiddleware struct {
+	*jwt.GinJWTMiddleware
+	auth *Auth
+}
+
+//MiddlewareFunc verifies the token of request and authorizes the user
+func (m *JWTMiddleware) MiddlewareFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		m.middlewareImpl(c)
+	}
+}
+
+func (m *JWTMiddleware) middlewareImpl(c *gin.Context) {
+	//personal access tokens are only accepted in the authorization header
+	if token, err := m.tokenFromHeader(c, "Authorization"); err == nil &&
+		strings.HasPrefix(token, PersonalAccessTokenPrefix) {
+		m.personalAccessTokenImpl(c, token)
+		return
+	}
+
+	claims, err := m.GetClaimsFromJWT(c)
+	if err != nil {
+		m.unauthorized(c, http.StatusUnauthorized, m.HTTPStatusMessageFunc(err, c))
+		return
+	}
+
+	if _, ok := claims["exp"].(float64); !ok {
+		m.unauthorized(c, http.StatusBadRequest, m.HTTPStatusMessageFunc(jwt.ErrMissingExpField, c))
+		return
+	}
+
+	if isIDToken(claims) {
+		m.unauthorized(c, http.StatusUnauthorized, m.HTTPStatusMessageFunc(ErrIDToken, c))
+		return
+	}
+
+	if m.rejectRevoked(c, claims) {
+		return
+	}
+
+	m.touchSession(c, claims)
+	m.authorize(c, claims)
+}
+
+func (m *JWTMiddleware) personalAccessTokenImpl(c *gin.Context, token string) {
+	pat, err := m.auth.authenticatePersonalAccessToken(c.Request.Context(), token)
+	if err != nil {
+		if err == ErrInvalidPersonalAccessToken {
+			m.unauthorized(c, http.StatusUnauthorized, m.HTTPStatusMessageFunc(err, c))
+			return
+		}
+		log.Logger(c.Request.Context()).Error("Failed to authenticate personal access token", zap.Error(err))
+		m.unauthorized(c, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	c.Set(personalAccessTokenKey, pat)
+	m.authorize(c, jwt.MapClaims{
+		m.IdentityKey: pat.UserID,
+		"amr":         []interface{}{AMRPersonalAccessToken},
+	})
+}
+
+//authorize sets the claims and the identity of request, then authorizes the identity,
+//requests under impersonation are audited after they are handled
+func (m *JWTMiddleware) authorize(c *gin.Context, claims jwt.MapClaims) {
+	if actor := impersonatorOf(claims); actor != "" {
+		userID, _ := claims[m.IdentityKey].(string)
+		defer m.auth.auditImpersonation(c, actor, userID)
+	}
+
+	c.Set("JWT_PAYLOAD", claims)
+	identity := m.IdentityHandler(c)
+
+	if identity != nil {
+		c.Set(m.IdentityKey, identity)
+	}
+
+	//the state is checked on every request, so that disabled users are stopped before their tokens expire
+	if user, ok := identity.(*models.User); ok {
+		if err := UserStateError(user); err != nil {
+			m.unauthorized(c, http.StatusForbidden, m.HTTPStatusMessageFunc(err, c))
+			return
+		}
+
+		if !m.auth.rates.Allow(c, user.ID) {
+			return
+		}
+	}
+
+	if !m.Authorizator(identity, c) {
+		m.unauthorized(c, http.StatusForbidden, m.HTTPStatusMessageFunc(jwt.ErrForbidden, c))
+		return
+	}
+
+	c.Next()
+}
+
+//GetClaimsFromJWT get claims from the token of request
+func (m *JWTMiddleware) GetClaimsFromJWT(c *gin.Context) (jwt.MapClaims, error) {
+	token, err := m.ParseToken(c)
+	if err != nil {
+		return nil, err
+	}
+	return jwt.ExtractClaimsFromToken(token), nil
+}
+
+//ParseToken parse the token of request by the key set of auth
+func (m *JWTMiddleware) ParseToken(c *gin.Context) (*gojwt.Token, error) {
+	tokenString, err := m.lookupToken(c)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := m.ParseTokenString(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Set("JWT_TOKEN", tokenString)
+	return token, nil
+}
+
+//ParseTokenString parse the token by the key set of auth
+func (m *JWTMiddleware) ParseTokenString(token string) (*gojwt.Token, error) {
+	return m.auth.keys.Parse(token)
+}
+
+//TokenGenerator generate a token of data signed by the signing key of auth
+func (m *JWTMiddleware) TokenGenerator(data interface{}) (string, time.Time, error) {
+	return m.generateToken(data, m.Timeout)
+}
+
+func (m *JWTMiddleware) generateToken(data interface{}, timeout time.Duration) (string, time.Time, error) {
+	claims := gojwt.MapClaims{}
+	for key, value := range m.PayloadFunc(data) {
+		claims[key] = value
+	}
+
+	now := m.TimeFunc()
+	expire := now.UTC().Add(timeout)
+	claims["exp"] = expire.Unix()
+	claims["orig_iat"] = now.Unix()
+
+	token, err := m.auth.keys.Sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expire, nil
+}
+
+//LogoutHandler revokes the token of request with its refresh token family and session, and clears the cookie
+func (m *JWTMiddleware) LogoutHandler(c *gin.Context) {
+	if token, err := m.ParseToken(c); err == nil {
+		claims := jwt.ExtractClaimsFromToken(token)
+		err := m.auth.revoker.RevokeToken(claims)
+		if family, ok := claims["fam"].(string); ok && err == nil {
+			err = m.auth.revokeFamily(c.Request.Context(), family)
+		}
+		if sid, ok := claims["sid"].(string); ok && err == nil {
+			userID, _ := claims[m.IdentityKey].(string)
+			err = m.auth.RevokeSession(c.Request.Context(), userID, sid)
+			if err == db.ErrNotFound {
+				err = nil
+			}
+		}
+		if err != nil {
+			log.Logger(c.Request.Context()).Error("Failed to revoke token", zap.Error(err))
+			m.unauthorized(c, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+	}
+	m.GinJWTMiddleware.LogoutHandler(c)
+}
+
+//JWKSHandler replies the public keys to verify tokens
+func (m *JWTMiddleware) JWKSHandler(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, m.auth.keys.JWKS())
+}
+
+//rejectRevoked replies unauthorized and return true if the token of claims is revoked,
+//the tokens of impersonation are also revoked with the tokens of the impersonator
+func (m *JWTMiddleware) rejectRevoked(c *gin.Context, claims jwt.MapClaims) bool {
+	userID, _ := claims[m.IdentityKey].(string)
+	revoked, err := m.auth.revoker.IsRevoked(claims, userID)
+	if actor := impersonatorOf(claims); actor != "" && err == nil && !revoked {
+		revoked, err = m.auth.revoker.IsRevoked(claims, actor)
+	}
+	if err != nil {
+		log.Logger(c.Request.Context()).Error("Failed to check token revocation", zap.Error(err))
+		m.unauthorized(c, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return true
+	}
+
+	if revoked {
+		m.unauthorized(c, http.StatusUnauthorized, ErrRevokedToken.Error())
+		return true
+	}
+	return false
+}
+
+//lookupToken get the token string of request by token lookup
+func (m *JWTMiddleware) lookupToken(c *gin.Context) (string, error) {
+	var token string
+	var err error
+
+	for _, method := range strings.Split(m.TokenLookup, ",") {
+		if len(token) > 0 {
+			break
+		}
+
+		parts := strings.SplitN(strings.TrimSpace(method), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		k := strings.TrimSpace(parts[0])
+		v := strings.TrimSpace(parts[1])
+		switch k {
+		case "header":
+			token, err = m.tokenFromHeader(c, v)
+		case "query":
+			token = c.Query(v)
+			err = jwt.ErrEmptyQueryToken
+		case "cookie":
+			token, _ = c.Cookie(v)
+			err = jwt.ErrEmptyCookieToken
+		case "param":
+			token = c.Param(v)
+			err = jwt.ErrEmptyParamToken
+		}
+	}
+
+	if token == "" {
+		if err == nil {
+			err = jwt.ErrEmptyAuthHeader
+		}
+		return "", err
+	}
+	return token, nil
+}
+
+func (m *JWTMiddleware) tokenFromHeader(c *gin.Context, key string) (string, error) {
+	authHeader := c.Request.Header.Get(key)
+	if authHeader == "" {
+		return "", jwt.ErrEmptyAuthHeader
+	}
+
+	parts := strings.SplitN(authHeader, " ", 2)
+	if !(len(parts) == 2 && parts[0] == m.TokenHeadName) {
+		return "", jwt.ErrInvalidAuthHeader
+	}
+	return parts[1], nil
+}
+
+func (m *JWTMiddleware) unauthorized(c *gin.Context, code int, message string) {
+	c.Header("WWW-Authenticate", "JWT realm="+m.Realm)
+	c.Abort()
+	m.Unauthorized(c, code, message)
+}
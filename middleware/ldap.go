@@ -0,0 +1,376 @@
+package middleware
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	db "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/config"
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils"
+	"github.com/ngs24313/gopu/utils/log"
+	"github.com/ngs24313/gopu/utils/password"
+	"github.com/ngs24313/gopu/utils/rolemanager"
+	"go.uber.org/zap"
+)
+
+const (
+	//LDAPProvider is the provider of the federated identities of ldap users
+	LDAPProvider = "ldap"
+
+	ldapDefaultTimeout = 10 * time.Second
+	ldapPasswordSize   = 32
+)
+
+//LDAPAuthenticator authenticates users by binding to ldap or active directory, users are created or
+//updated from the attributes of their entries, and the roles in group roles follow their groups
+type LDAPAuthenticator struct {
+	conf    config.LDAP
+	rbac    config.RBAC
+	adb     db.AccountDatabase
+	roleMgr rolemanager.RoleManager
+}
+
+//NewLDAPAuthenticator create ldap authenticator, new users get the user role of rbac
+func NewLDAPAuthenticator(conf config.LDAP,
+	rbac config.RBAC,
+	adb db.AccountDatabase,
+	roleMgr rolemanager.RoleManager) (*LDAPAuthenticator, error) {
+	if conf.URL == "" || conf.BaseDN == "" {
+		return nil, errors.New("ldap: url and base dn are required")
+	}
+
+	if strings.Count(conf.Filter, "%s") != 1 {
+		return nil, errors.New("ldap: filter must contain one %s for the login name")
+	}
+
+	if conf.Attributes.Username == "" {
+		conf.Attributes.Username = "uid"
+	}
+	if conf.Attributes.Email == "" {
+		conf.Attributes.Email = "mail"
+	}
+	if conf.Attributes.Groups == "" {
+		conf.Attributes.Groups = "memberOf"
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = ldapDefaultTimeout
+	}
+
+	return &LDAPAuthenticator{
+		conf:    conf,
+		rbac:    rbac,
+		adb:     adb,
+		roleMgr: roleMgr,
+	}, nil
+}
+
+//Name get the name of authenticator
+func (l *LDAPAuthenticator) Name() string {
+	return AuthenticatorLDAP
+}
+
+//Authenticate search the entry of username and bind as it, then sync the user and roles of entry
+func (l *LDAPAuthenticator) Authenticate(ctx context.Context, username string, pwd string) (*models.User, error) {
+	//empty password is an unauthenticated bind which always succeeds
+	if username == "" || pwd == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	entry, err := l.bind(ctx, username, pwd)
+	if err != nil {
+		return nil, err
+	}
+
+	user, created, err := l.syncUser(ctx, entry, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if created {
+		if _, err := l.roleMgr.AddRoleForUser(user.ID, l.rbac.UserName); err != nil {
+			if err != rolemanager.ErrUserHasRole {
+				log.Logger(ctx).Warn("Failed to set user role", zap.Error(err))
+			}
+		}
+
+		if err := rolemanager.AddUserIDPrimaryAPI(user.ID, l.rbac.UserName, l.roleMgr, l.rbac); err != nil {
+			log.Logger(ctx).Warn("Failed to set user primary api", zap.Error(err))
+		}
+	}
+
+	if err := l.syncRoles(user, entry); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+//bind find the only entry of username and bind as it
+func (l *LDAPAuthenticator) bind(ctx context.Context, username string, pwd string) (*ldap.Entry, error) {
+	conn, err := l.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if l.conf.BindDN != "" {
+		err = conn.Bind(l.conf.BindDN, l.conf.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ldap: bind to search: %v", err)
+	}
+
+	attrs := []string{
+		l.conf.Attributes.Username,
+		l.conf.Attributes.Email,
+		l.conf.Attributes.Groups,
+	}
+	for _, attr := range []string{
+		l.conf.Attributes.ID,
+		l.conf.Attributes.Nickname,
+		l.conf.Attributes.Company,
+		l.conf.Attributes.Location,
+	} {
+		if attr != "" {
+			attrs = append(attrs, attr)
+		}
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		l.conf.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2,
+		int(l.conf.Timeout/time.Second),
+		false,
+		fmt.Sprintf(l.conf.Filter, ldap.EscapeFilter(username)),
+		attrs,
+		nil,
+	))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			log.Logger(ctx).Warn("More than one ldap entry matches the login name",
+				zap.String("username", username))
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap: search: %v", err)
+	}
+
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+
+	entry := result.Entries[0]
+	if err := conn.Bind(entry.DN, pwd); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap: bind: %v", err)
+	}
+	return entry, nil
+}
+
+func (l *LDAPAuthenticator) dial() (*ldap.Conn, error) {
+	u, err := url.Parse(l.conf.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConf := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: l.conf.InsecureSkipVerify,
+	}
+
+	conn, err := ldap.DialURL(l.conf.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: l.conf.Timeout}),
+		ldap.DialWithTLSConfig(tlsConf))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(l.conf.Timeout)
+
+	if l.conf.StartTLS {
+		if err := conn.StartTLS(tlsConf); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+//syncUser get the user linked to entry and update it by the attributes of entry, the entry is linked to
+//the user of the same email, or a user is created for it
+func (l *LDAPAuthenticator) syncUser(ctx context.Context,
+	entry *ldap.Entry,
+	loginName string) (*models.User, bool, error) {
+	subject := entry.DN
+	if l.conf.Attributes.ID != "" {
+		id := entry.GetRawAttributeValue(l.conf.Attributes.ID)
+		if len(id) == 0 {
+			return nil, false, fmt.Errorf("ldap: entry [%s] has no id attribute", entry.DN)
+		}
+		subject = hex.EncodeToString(id)
+	}
+
+	email := entry.GetAttributeValue(l.conf.Attributes.Email)
+
+	identity, err := l.adb.GetFederatedIdentity(ctx, LDAPProvider, subject)
+	if err == nil {
+		user, err := l.adb.GetUserByID(ctx, identity.UserID)
+		if err != nil {
+			return nil, false, err
+		}
+		return user, false, l.updateUser(ctx, user, entry)
+	}
+	if err != db.ErrNotFound {
+		return nil, false, err
+	}
+
+	identity = &models.FederatedIdentity{
+		Provider: LDAPProvider,
+		Subject:  subject,
+		Email:    email,
+	}
+
+	//the directory is trusted to have verified the emails of its users
+	if email != "" {
+		user, err := l.adb.GetUserByEmail(ctx, email)
+		if err == nil {
+			identity.UserID = user.ID
+			if err := l.adb.CreateFederatedIdentity(ctx, identity); err != nil {
+				return nil, false, err
+			}
+			return user, false, l.updateUser(ctx, user, entry)
+		}
+		if err != db.ErrNotFound {
+			return nil, false, err
+		}
+	}
+
+	//registration.mode is not applied, the directory managed by admin decides who can login
+	username := entry.GetAttributeValue(l.conf.Attributes.Username)
+	if username == "" {
+		username = loginName
+	}
+
+	//ldap users have no local password
+	secret, err := utils.RandomToken(ldapPasswordSize)
+	if err != nil {
+		return nil, false, err
+	}
+
+	hashedPwd, err := password.GenHashPassword(secret)
+	if err != nil {
+		return nil, false, err
+	}
+
+	user := &models.User{
+		Username: username,
+		Password: hashedPwd,
+		Email:    email,
+		Profile: models.Profile{
+			Nickname: username,
+		},
+	}
+	if email != "" {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+	l.applyAttributes(user, entry)
+
+	//the email is not used by other users here
+	if _, err := l.adb.GetUserByUsername(ctx, username); err != db.ErrNotFound {
+		if err == nil {
+			err = db.ErrUsernameAlreadyExists
+		}
+		return nil, false, err
+	}
+
+	user, err = l.adb.CreateFederatedUser(ctx, user, identity)
+	if err != nil {
+		return nil, false, err
+	}
+	return user, true, nil
+}
+
+//updateUser saves the user if the attributes of entry change it
+func (l *LDAPAuthenticator) updateUser(ctx context.Context, user *models.User, entry *ldap.Entry) error {
+	before := *user
+
+	l.applyAttributes(user, entry)
+
+	if email := entry.GetAttributeValue(l.conf.Attributes.Email); email != "" && email != user.Email {
+		if _, err := l.adb.GetUserByEmail(ctx, email); err == db.ErrNotFound {
+			now := time.Now()
+			user.Email = email
+			user.EmailVerifiedAt = &now
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if user.Email == before.Email && user.Profile == before.Profile {
+		return nil
+	}
+	return l.adb.UpdateUser(ctx, user)
+}
+
+func (l *LDAPAuthenticator) applyAttributes(user *models.User, entry *ldap.Entry) {
+	for attr, field := range map[string]*string{
+		l.conf.Attributes.Nickname: &user.Profile.Nickname,
+		l.conf.Attributes.Company:  &user.Profile.Company,
+		l.conf.Attributes.Location: &user.Profile.Location,
+	} {
+		if attr == "" {
+			continue
+		}
+		if value := entry.GetAttributeValue(attr); value != "" {
+			*field = value
+		}
+	}
+}
+
+//syncRoles grants the roles of the groups of entry and removes the roles of other groups in group roles,
+//roles which are not in group roles are not changed
+func (l *LDAPAuthenticator) syncRoles(user *models.User, entry *ldap.Entry) error {
+	groups := entry.GetAttributeValues(l.conf.Attributes.Groups)
+
+	roles := make(map[string]bool)
+	for _, groupRole := range l.conf.GroupRoles {
+		member := false
+		for _, group := range groups {
+			if strings.EqualFold(group, groupRole.Group) {
+				member = true
+				break
+			}
+		}
+		roles[groupRole.Role] = roles[groupRole.Role] || member
+	}
+
+	for role, member := range roles {
+		has, err := l.roleMgr.HasRoleForUser(user.ID, role)
+		if err != nil {
+			return err
+		}
+
+		if member && !has {
+			_, err = l.roleMgr.AddRoleForUser(user.ID, role)
+		} else if !member && has {
+			_, err = l.roleMgr.DelRoleForUser(user.ID, role)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	db "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/config"
+)
+
+const (
+	testServiceDN  = "cn=svc,dc=example,dc=com"
+	testServicePwd = "service password"
+	testAdminGroup = "cn=Admins,ou=groups,dc=example,dc=com"
+)
+
+type ldapEntry struct {
+	dn    string
+	pwd   string
+	attrs map[string][]string
+}
+
+//fakeLDAP serves the simple binds and the searches of uid filters, only the service account can search
+type fakeLDAP struct {
+	net.Listener
+
+	mu      sync.Mutex
+	entries []*ldapEntry
+}
+
+func newFakeLDAP(t *testing.T, entries ...*ldapEntry) *fakeLDAP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeLDAP{Listener: ln, entries: entries}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeLDAP) URL() string {
+	return "ldap://" + f.Addr().String()
+}
+
+func (f *fakeLDAP) setAttr(dn string, attr string, values ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, e := range f.entries {
+		if e.dn == dn {
+			e.attrs[attr] = values
+		}
+	}
+}
+
+func (f *fakeLDAP) serve(conn net.Conn) {
+	defer conn.Close()
+
+	bound := ""
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		f.mu.Lock()
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			pwd := op.Children[2].Data.String()
+			code := int64(ldap.LDAPResultInvalidCredentials)
+			if dn == testServiceDN && pwd == testServicePwd {
+				code = ldap.LDAPResultSuccess
+			}
+			for _, e := range f.entries {
+				if e.dn == dn && e.pwd == pwd {
+					code = ldap.LDAPResultSuccess
+				}
+			}
+			if code == ldap.LDAPResultSuccess {
+				bound = dn
+			}
+			conn.Write(ldapResult(id, ldap.ApplicationBindResponse, code).Bytes())
+		case ldap.ApplicationSearchRequest:
+			if bound != testServiceDN {
+				conn.Write(ldapResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights).Bytes())
+				break
+			}
+			filter, _ := ldap.DecompileFilter(op.Children[6])
+			for _, e := range f.entries {
+				if strings.Contains(filter, "(uid="+e.attrs["uid"][0]+")") {
+					conn.Write(ldapSearchEntry(id, e).Bytes())
+				}
+			}
+			conn.Write(ldapResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+		case ldap.ApplicationUnbindRequest:
+			f.mu.Unlock()
+			return
+		}
+		f.mu.Unlock()
+	}
+}
+
+func ldapMessage(id int64, op *ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	packet.AppendChild(op)
+	return packet
+}
+
+func ldapResult(id int64, tag ber.Tag, code int64) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return ldapMessage(id, op)
+}
+
+func ldapSearchEntry(id int64, e *ldapEntry) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, ""))
+
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	for name, values := range e.attrs {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+		}
+		attr.AppendChild(set)
+		attrs.AppendChild(attr)
+	}
+	op.AppendChild(attrs)
+	return ldapMessage(id, op)
+}
+
+const testCarolDN = "uid=carol,ou=people,dc=example,dc=com"
+
+func newTestLDAP(t *testing.T) (*LDAPAuthenticator, *fakeLDAP, *Auth, *testRoleManager) {
+	server := newFakeLDAP(t,
+		&ldapEntry{
+			dn:  testCarolDN,
+			pwd: "carol password",
+			attrs: map[string][]string{
+				"uid":         {"carol"},
+				"mail":        {"carol@example.com"},
+				"displayName": {"Carol"},
+				"l":           {"Paris"},
+				"memberOf":    {testAdminGroup},
+			},
+		},
+		&ldapEntry{
+			dn:  "uid=alice,ou=people,dc=example,dc=com",
+			pwd: "directory password",
+			attrs: map[string][]string{
+				"uid":  {testUsername},
+				"mail": {"alice@directory.example.com"},
+			},
+		},
+	)
+
+	roleMgr := &testRoleManager{}
+	a, _ := newTestAuth(t, roleMgr)
+
+	l, err := NewLDAPAuthenticator(config.LDAP{
+		URL:          server.URL(),
+		BindDN:       testServiceDN,
+		BindPassword: testServicePwd,
+		BaseDN:       "dc=example,dc=com",
+		Filter:       "(&(objectClass=person)(uid=%s))",
+		Attributes: config.LDAPAttributes{
+			Nickname: "displayName",
+			Location: "l",
+		},
+		GroupRoles: []config.LDAPGroupRole{{Group: strings.ToLower(testAdminGroup), Role: "admin"}},
+	}, config.RBAC{UserName: "user"}, a.adb, roleMgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, server, a, roleMgr
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	l, server, _, roleMgr := newTestLDAP(t)
+	defer server.Close()
+	ctx := context.Background()
+
+	user, err := l.Authenticate(ctx, "carol", "carol password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "carol" || user.Email != "carol@example.com" || user.EmailVerifiedAt == nil ||
+		user.Profile.Nickname != "Carol" || user.Profile.Location != "Paris" {
+		t.Errorf("user of entry = %+v", user)
+	}
+	for _, role := range []string{"user", "admin"} {
+		if has, _ := roleMgr.HasRoleForUser(user.ID, role); !has {
+			t.Errorf("user has no role %s", role)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		pwd      string
+	}{
+		{"wrong password", "carol", "wrong"},
+		{"empty password", "carol", ""},
+		{"unknown user", "dave", "carol password"},
+		{"filter injection", "*", "carol password"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := l.Authenticate(ctx, tt.username, tt.pwd); err != ErrInvalidCredentials {
+				t.Errorf("Authenticate() = %v, want %v", err, ErrInvalidCredentials)
+			}
+		})
+	}
+}
+
+func TestLDAPSync(t *testing.T) {
+	l, server, _, roleMgr := newTestLDAP(t)
+	defer server.Close()
+	ctx := context.Background()
+
+	user, err := l.Authenticate(ctx, "carol", "carol password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the role follows the group and the profile follows the attributes
+	server.setAttr(testCarolDN, "memberOf")
+	server.setAttr(testCarolDN, "l", "Berlin")
+
+	again, err := l.Authenticate(ctx, "carol", "carol password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != user.ID {
+		t.Fatalf("user of second login = %s, want %s", again.ID, user.ID)
+	}
+	if again.Profile.Location != "Berlin" {
+		t.Errorf("location = %s, want Berlin", again.Profile.Location)
+	}
+	if has, _ := roleMgr.HasRoleForUser(user.ID, "admin"); has {
+		t.Error("role of left group is kept")
+	}
+	if has, _ := roleMgr.HasRoleForUser(user.ID, "user"); !has {
+		t.Error("role not in group roles is removed")
+	}
+}
+
+func TestLDAPUsernameTaken(t *testing.T) {
+	l, server, a, _ := newTestLDAP(t)
+	defer server.Close()
+	ctx := context.Background()
+
+	//the entry of other email is not linked to the local user of its username
+	if _, err := l.Authenticate(ctx, testUsername, "directory password"); err != db.ErrUsernameAlreadyExists {
+		t.Errorf("Authenticate() = %v, want %v", err, db.ErrUsernameAlreadyExists)
+	}
+
+	if _, err := testLogin(a, testUsername, testPassword, "127.0.0.1"); err != nil {
+		t.Errorf("local login = %v", err)
+	}
+}
+
+func TestLDAPServiceBindFailure(t *testing.T) {
+	l, server, _, _ := newTestLDAP(t)
+	defer server.Close()
+
+	l.conf.BindPassword = "wrong"
+	if _, err := l.Authenticate(context.Background(), "carol", "carol password"); err == nil ||
+		err == ErrInvalidCredentials {
+		t.Errorf("Authenticate() of failed service bind = %v, want a server error", err)
+	}
+}
@@ -0,0 +1,334 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ngs24313/gopu/config"
+	"github.com/ngs24313/gopu/utils/cache"
+)
+
+const (
+	//LockoutAccount is the lockout kind of account
+	LockoutAccount = "account"
+	//LockoutIP is the lockout kind of client ip
+	LockoutIP = "ip"
+)
+
+var (
+	//ErrLoginLocked too many failed login attempts
+	ErrLoginLocked = errors.New("too many failed login attempts, try again later")
+	//ErrInvalidLockoutKind lockout kind is not account or ip
+	ErrInvalidLockoutKind = errors.New("lockout kind must be account or ip")
+)
+
+//LoginFailure is the login failure state of an account or a client ip
+type LoginFailure struct {
+	Kind          string    `json:"kind"`
+	Key           string    `json:"key"`
+	Failures      int       `json:"failures"`
+	FirstFailedAt time.Time `json:"first_failed_at"`
+	LastFailedAt  time.Time `json:"last_failed_at"`
+	LockedUntil   time.Time `json:"locked_until"`
+}
+
+//Locked return true if the state is locked at now
+func (f *LoginFailure) Locked(now time.Time) bool {
+	return now.Before(f.LockedUntil)
+}
+
+//LoginLimiter records login failures and locks accounts and client ips, the states are read and written under
+//the lock so the concurrent attempts are counted
+type LoginLimiter struct {
+	cache cache.Cache
+	conf  config.Lockout
+	now   func() time.Time
+
+	mu sync.Mutex
+}
+
+//NewLoginLimiter create login limiter, the limiter is disabled if c is nil
+func NewLoginLimiter(c cache.Cache, conf config.Lockout, now func() time.Time) *LoginLimiter {
+	if conf.Prefix == "" {
+		conf.Prefix = "login_failure."
+	}
+	if conf.AccountMaxFailures <= 0 {
+		conf.AccountMaxFailures = 5
+	}
+	if conf.IPMaxFailures <= 0 {
+		conf.IPMaxFailures = 20
+	}
+	if conf.FailureWindow <= 0 {
+		conf.FailureWindow = 15 * time.Minute
+	}
+	if conf.Duration <= 0 {
+		conf.Duration = 15 * time.Minute
+	}
+	if conf.DelayAfter <= 0 {
+		conf.DelayAfter = 3
+	}
+	if conf.DelayBase <= 0 {
+		conf.DelayBase = time.Second
+	}
+	if conf.MaxDelay <= 0 {
+		conf.MaxDelay = 30 * time.Second
+	}
+	if now == nil {
+		now = time.Now
+	}
+
+	return &LoginLimiter{
+		cache: c,
+		conf:  conf,
+		now:   now,
+	}
+}
+
+//Attempt record a login attempt of the account and the client ip before the password is checked, so the
+//concurrent guesses cannot pass the limit. It return ErrLoginLocked if the account or the client ip is locked
+//or delayed, otherwise the attempt counts as a failure until it is released
+func (l *LoginLimiter) Attempt(account, ip string) error {
+	if l.cache == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.allow(account, ip); err != nil {
+		return err
+	}
+	return l.fail(account, ip)
+}
+
+//Allow return ErrLoginLocked if the account or the client ip is locked or delayed
+func (l *LoginLimiter) Allow(account, ip string) error {
+	if l.cache == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.allow(account, ip)
+}
+
+func (l *LoginLimiter) allow(account, ip string) error {
+	now := l.now()
+	for _, kind := range []string{LockoutAccount, LockoutIP} {
+		state, err := l.State(kind, l.keyOf(kind, account, ip))
+		if err != nil {
+			if err == cache.ErrNotFound {
+				continue
+			}
+			return err
+		}
+
+		if state.Locked(now) {
+			return ErrLoginLocked
+		}
+
+		if now.Before(state.LastFailedAt.Add(l.delay(state.Failures))) {
+			return ErrLoginLocked
+		}
+	}
+	return nil
+}
+
+//Fail record a login failure for the account and the client ip
+func (l *LoginLimiter) Fail(account, ip string) error {
+	if l.cache == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fail(account, ip)
+}
+
+func (l *LoginLimiter) fail(account, ip string) error {
+	now := l.now()
+	for _, kind := range []string{LockoutAccount, LockoutIP} {
+		key := l.keyOf(kind, account, ip)
+		state, err := l.State(kind, key)
+		if err != nil {
+			if err != cache.ErrNotFound {
+				return err
+			}
+			state = &LoginFailure{
+				Kind: kind,
+				Key:  key,
+			}
+		}
+
+		if state.Failures == 0 || now.Sub(state.FirstFailedAt) > l.conf.FailureWindow {
+			state.Failures = 0
+			state.FirstFailedAt = now
+		}
+		state.Failures++
+		state.LastFailedAt = now
+
+		if state.Failures >= l.maxFailures(kind) {
+			state.LockedUntil = now.Add(l.conf.Duration)
+		}
+
+		if err := cache.SetJSON(l.cache, l.cacheKey(kind, key), state, l.expiration(state, now)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Succeed clear the failures of account after a successful login
+func (l *LoginLimiter) Succeed(account string) error {
+	return l.Clear(LockoutAccount, account)
+}
+
+//Release revert an attempt of kind and key which did not fail, the lock set by the attempt is removed
+func (l *LoginLimiter) Release(kind, key string) error {
+	if l.cache == nil {
+		return nil
+	}
+
+	if kind != LockoutAccount && kind != LockoutIP {
+		return ErrInvalidLockoutKind
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state, err := l.State(kind, key)
+	if err != nil {
+		if err == cache.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+
+	state.Failures--
+	if state.Failures <= 0 {
+		if err := l.cache.Del(l.cacheKey(kind, key)); err != nil && err != cache.ErrNotFound {
+			return err
+		}
+		return nil
+	}
+	if state.Failures < l.maxFailures(kind) {
+		state.LockedUntil = time.Time{}
+	}
+	return cache.SetJSON(l.cache, l.cacheKey(kind, key), state, l.expiration(state, l.now()))
+}
+
+//State get the failure state of kind and key
+func (l *LoginLimiter) State(kind, key string) (*LoginFailure, error) {
+	if l.cache == nil {
+		return nil, cache.ErrNotFound
+	}
+
+	if kind != LockoutAccount && kind != LockoutIP {
+		return nil, ErrInvalidLockoutKind
+	}
+
+	var state LoginFailure
+	if err := cache.GetJSON(l.cache, l.cacheKey(kind, key), &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+//Clear remove the failure state of kind and key
+func (l *LoginLimiter) Clear(kind, key string) error {
+	if l.cache == nil {
+		return nil
+	}
+
+	if kind != LockoutAccount && kind != LockoutIP {
+		return ErrInvalidLockoutKind
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.cache.Del(l.cacheKey(kind, key)); err != nil && err != cache.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+//List list all failure states, locked only if lockedOnly is true
+func (l *LoginLimiter) List(lockedOnly bool) ([]*LoginFailure, error) {
+	states := make([]*LoginFailure, 0)
+	if l.cache == nil {
+		return states, nil
+	}
+
+	entities, err := l.cache.List()
+	if err != nil {
+		return nil, err
+	}
+
+	now := l.now()
+	for _, entity := range entities {
+		if !strings.Contains(entity.Key, l.conf.Prefix) {
+			continue
+		}
+
+		var state LoginFailure
+		if err := json.Unmarshal(entity.Value, &state); err != nil {
+			continue
+		}
+
+		if lockedOnly && !state.Locked(now) {
+			continue
+		}
+		states = append(states, &state)
+	}
+	return states, nil
+}
+
+func (l *LoginLimiter) keyOf(kind, account, ip string) string {
+	if kind == LockoutIP {
+		return ip
+	}
+	return strings.ToLower(account)
+}
+
+func (l *LoginLimiter) cacheKey(kind, key string) string {
+	return fmt.Sprintf("%s%s.%s", l.conf.Prefix, kind, key)
+}
+
+func (l *LoginLimiter) maxFailures(kind string) int {
+	if kind == LockoutIP {
+		return l.conf.IPMaxFailures
+	}
+	return l.conf.AccountMaxFailures
+}
+
+//delay is the progressive delay after failures, doubled on every failure
+func (l *LoginLimiter) delay(failures int) time.Duration {
+	if failures < l.conf.DelayAfter {
+		return 0
+	}
+
+	delay := l.conf.DelayBase
+	for i := l.conf.DelayAfter; i < failures; i++ {
+		delay *= 2
+		if delay >= l.conf.MaxDelay {
+			return l.conf.MaxDelay
+		}
+	}
+	return delay
+}
+
+func (l *LoginLimiter) expiration(state *LoginFailure, now time.Time) time.Duration {
+	expiration := state.FirstFailedAt.Add(l.conf.FailureWindow).Sub(now)
+	if locked := state.LockedUntil.Sub(now); locked > expiration {
+		expiration = locked
+	}
+	if expiration <= 0 {
+		expiration = l.conf.FailureWindow
+	}
+	return expiration
+}
@@ -0,0 +1,227 @@
+package middleware
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ngs24313/gopu/config"
+)
+
+func TestLoginLimiterLockout(t *testing.T) {
+	conf := config.Lockout{
+		AccountMaxFailures: 3,
+		IPMaxFailures:      5,
+		FailureWindow:      10 * time.Minute,
+		Duration:           15 * time.Minute,
+		DelayAfter:         100,
+	}
+
+	type failure struct {
+		account string
+		ip      string
+		after   time.Duration //wait before the failure
+	}
+
+	tests := []struct {
+		name     string
+		failures []failure
+		wait     time.Duration
+		account  string
+		ip       string
+		want     error
+	}{
+		{
+			name:     "below max failures",
+			failures: []failure{{"bob", "10.0.0.1", 0}, {"bob", "10.0.0.1", 0}},
+			account:  "bob",
+			ip:       "10.0.0.1",
+		},
+		{
+			name:     "account locked",
+			failures: []failure{{"bob", "10.0.0.1", 0}, {"bob", "10.0.0.2", 0}, {"bob", "10.0.0.3", 0}},
+			account:  "bob",
+			ip:       "10.0.0.4",
+			want:     ErrLoginLocked,
+		},
+		{
+			name:     "account key is case insensitive",
+			failures: []failure{{"Bob", "10.0.0.1", 0}, {"BOB", "10.0.0.2", 0}, {"bob", "10.0.0.3", 0}},
+			account:  "bOb",
+			ip:       "10.0.0.4",
+			want:     ErrLoginLocked,
+		},
+		{
+			name:     "account lock expires",
+			failures: []failure{{"bob", "10.0.0.1", 0}, {"bob", "10.0.0.1", 0}, {"bob", "10.0.0.1", 0}},
+			wait:     16 * time.Minute,
+			account:  "bob",
+			ip:       "10.0.0.1",
+		},
+		{
+			name:     "failures out of window are reset",
+			failures: []failure{{"bob", "10.0.0.1", 0}, {"bob", "10.0.0.1", 0}, {"bob", "10.0.0.1", 11 * time.Minute}},
+			account:  "bob",
+			ip:       "10.0.0.1",
+		},
+		{
+			name: "ip locked across accounts",
+			failures: []failure{
+				{"a", "10.0.0.1", 0}, {"b", "10.0.0.1", 0}, {"c", "10.0.0.1", 0}, {"d", "10.0.0.1", 0}, {"e", "10.0.0.1", 0},
+			},
+			account: "f",
+			ip:      "10.0.0.1",
+			want:    ErrLoginLocked,
+		},
+		{
+			name:     "other accounts and ips are not locked",
+			failures: []failure{{"bob", "10.0.0.1", 0}, {"bob", "10.0.0.1", 0}, {"bob", "10.0.0.1", 0}},
+			account:  "carol",
+			ip:       "10.0.0.2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newTestClock()
+			l := NewLoginLimiter(newTestCache(t), conf, clock.Now)
+
+			for _, f := range tt.failures {
+				clock.Advance(f.after)
+				if err := l.Fail(f.account, f.ip); err != nil {
+					t.Fatal(err)
+				}
+			}
+			clock.Advance(tt.wait)
+
+			if err := l.Allow(tt.account, tt.ip); err != tt.want {
+				t.Errorf("Allow() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginLimiterDelay(t *testing.T) {
+	l := NewLoginLimiter(nil, config.Lockout{
+		DelayAfter: 3,
+		DelayBase:  time.Second,
+		MaxDelay:   10 * time.Second,
+	}, nil)
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{6, 8 * time.Second},
+		{7, 10 * time.Second},
+		{20, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := l.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginLimiterSucceed(t *testing.T) {
+	clock := newTestClock()
+	l := NewLoginLimiter(newTestCache(t), config.Lockout{AccountMaxFailures: 2, DelayAfter: 100}, clock.Now)
+
+	if err := l.Fail("bob", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Succeed("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Fail("bob", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Allow("bob", "10.0.0.1"); err != nil {
+		t.Errorf("Allow() after success = %v, want nil", err)
+	}
+}
+
+func TestLoginLimiterAttempt(t *testing.T) {
+	clock := newTestClock()
+	l := NewLoginLimiter(newTestCache(t), config.Lockout{AccountMaxFailures: 3, IPMaxFailures: 100, DelayAfter: 100},
+		clock.Now)
+
+	//the concurrent attempts are recorded before they are checked, so no more than max pass
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.Attempt("bob", "10.0.0.1"); err == nil {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 3 {
+		t.Fatalf("concurrent attempts allowed = %d, want 3", allowed)
+	}
+
+	//releasing an attempt which did not fail removes its lock
+	if err := l.Release(LockoutAccount, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Allow("bob", "10.0.0.2"); err != nil {
+		t.Errorf("Allow() after release = %v, want nil", err)
+	}
+	state, err := l.State(LockoutAccount, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Failures != 2 {
+		t.Errorf("failures after release = %d, want 2", state.Failures)
+	}
+
+	if err := l.Release(LockoutIP, "10.0.0.9"); err != nil {
+		t.Errorf("Release() of unknown key = %v, want nil", err)
+	}
+}
+
+func TestLoginLockoutKeyedByUser(t *testing.T) {
+	a, user := newTestAuth(t, nil,
+		WithCache(newTestCache(t)),
+		WithLockout(config.Lockout{AccountMaxFailures: 3, DelayAfter: 100}),
+	)
+
+	//the failures by username and email count for the same user
+	for _, login := range []string{testUsername, testEmail, testUsername} {
+		if _, err := testLogin(a, login, "wrong", "10.0.0.1"); err == nil {
+			t.Fatalf("login %s with wrong password succeeded", login)
+		}
+	}
+
+	if _, err := testLogin(a, testEmail, testPassword, "10.0.0.2"); err != ErrLoginLocked {
+		t.Fatalf("login by email of locked user = %v, want %v", err, ErrLoginLocked)
+	}
+
+	state, err := a.LoginLimiter().State(LockoutAccount, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Failures != 3 {
+		t.Errorf("failures of user = %d, want 3", state.Failures)
+	}
+
+	//the unknown logins are keyed by themselves
+	for i := 0; i < 3; i++ {
+		testLogin(a, "nobody", "wrong", "10.0.0.3")
+	}
+	if _, err := a.LoginLimiter().State(LockoutAccount, "nobody"); err != nil {
+		t.Errorf("failures of unknown login are not recorded: %v", err)
+	}
+}
@@ -0,0 +1,352 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	db "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/utils"
+	"github.com/ngs24313/gopu/utils/cache"
+	"github.com/ngs24313/gopu/utils/log"
+	"github.com/ngs24313/gopu/utils/rolemanager"
+	"github.com/ngs24313/gopu/utils/totp"
+	"go.uber.org/zap"
+)
+
+const (
+	mfaChallengePrefix     = "mfa_challenge."
+	mfaChallengeExpiration = 5 * time.Minute
+	mfaChallengeAttempts   = 5
+	mfaChallengeTokenSize  = 32
+
+	//the invalid mfa codes of a user are limited across the challenges and the account routes
+	mfaAttemptsPrefix = "mfa_attempts."
+	mfaMaxAttempts    = 5
+	mfaAttemptsWindow = 15 * time.Minute
+
+	//totpSkew is the accepted time steps before and after now
+	totpSkew = 1
+	//RecoveryCodeCount is the number of recovery codes generated for user
+	RecoveryCodeCount = 10
+	recoveryCodeSize  = 10
+
+	//AMRPassword authentication method of password
+	AMRPassword = "pwd"
+	//AMRMFA authentication method of second factor
+	AMRMFA = "mfa"
+	//AMRFederated authentication method of upstream identity providers
+	AMRFederated = "fed"
+	//AMRMagicLink authentication method of the link sent to email
+	AMRMagicLink = "email"
+)
+
+var (
+	//ErrInvalidMFAToken mfa challenge token is not found or expired
+	ErrInvalidMFAToken = errors.New("mfa token is invalid or expired")
+	//ErrInvalidMFACode totp code or recovery code is not valid
+	ErrInvalidMFACode = errors.New("mfa code is invalid")
+	//ErrMFALocked too many invalid mfa codes of user
+	ErrMFALocked = errors.New("too many invalid mfa codes, try again later")
+)
+
+//MFAForm for the second step of login
+type MFAForm struct {
+	MFAToken string `json:"mfa_token" form:"mfa_token" binding:"required"`
+	Code     string `json:"code" form:"code" binding:"required"`
+}
+
+//MFAChallenge is the reply of login when the user has mfa enabled
+type MFAChallenge struct {
+	Code        int       `json:"code"`
+	MFARequired bool      `json:"mfa_required"`
+	MFAToken    string    `json:"mfa_token"`
+	Expire      time.Time `json:"expire"`
+}
+
+type mfaChallenge struct {
+	UserID   string   `json:"user_id"`
+	AMR      []string `json:"amr"` //methods of the first factor
+	Attempts int      `json:"attempts"`
+}
+
+//mfaAttempts is the invalid mfa codes of a user in the window
+type mfaAttempts struct {
+	Failures      int       `json:"failures"`
+	FirstFailedAt time.Time `json:"first_failed_at"`
+}
+
+//MFAEnabled return true if the user has confirmed totp
+func (a *Auth) MFAEnabled(ctx context.Context, userID string) (bool, error) {
+	t, err := a.adb.GetTOTP(ctx, userID)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return t.Enabled, nil
+}
+
+//VerifyMFA verify a totp code or a recovery code of user, each code can only be used once.
+//The invalid codes are counted per user, ErrMFALocked is returned after the max attempts until the window expires
+func (a *Auth) VerifyMFA(ctx context.Context, userID string, code string) (bool, error) {
+	if a.opts.Cache == nil {
+		return a.verifyMFA(ctx, userID, code)
+	}
+
+	a.mfaMu.Lock()
+	defer a.mfaMu.Unlock()
+	return a.verifyMFALimited(ctx, userID, code)
+}
+
+//verifyMFALimited verify the code and count the invalid codes of user, the caller must hold mfaMu
+func (a *Auth) verifyMFALimited(ctx context.Context, userID string, code string) (bool, error) {
+	now := a.opts.TimeFunc()
+	key := mfaAttemptsPrefix + userID
+
+	var attempts mfaAttempts
+	if err := cache.GetJSON(a.opts.Cache, key, &attempts); err != nil && err != cache.ErrNotFound {
+		return false, err
+	}
+	if attempts.Failures > 0 && !now.Before(attempts.FirstFailedAt.Add(mfaAttemptsWindow)) {
+		attempts = mfaAttempts{}
+	}
+	if attempts.Failures >= mfaMaxAttempts {
+		return false, ErrMFALocked
+	}
+
+	ok, err := a.verifyMFA(ctx, userID, code)
+	if err != nil {
+		return false, err
+	}
+
+	if ok {
+		if err := a.opts.Cache.Del(key); err != nil && err != cache.ErrNotFound {
+			log.Logger(ctx).Warn("Failed to clear mfa attempts", zap.Error(err))
+		}
+		return true, nil
+	}
+
+	if attempts.Failures == 0 {
+		attempts.FirstFailedAt = now
+	}
+	attempts.Failures++
+	if err := cache.SetJSON(a.opts.Cache, key, &attempts, attempts.FirstFailedAt.Add(mfaAttemptsWindow).Sub(now)); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+func (a *Auth) verifyMFA(ctx context.Context, userID string, code string) (bool, error) {
+	t, err := a.adb.GetTOTP(ctx, userID)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if !t.Enabled {
+		return false, nil
+	}
+
+	code = strings.TrimSpace(code)
+	if step, ok := totp.Validate(t.Secret, code, a.opts.TimeFunc(), totpSkew); ok {
+		return a.adb.UseTOTPStep(ctx, userID, step)
+	}
+
+	return a.adb.UseRecoveryCode(ctx, userID, hashRecoveryCode(code), a.opts.TimeFunc())
+}
+
+//GenerateRecoveryCodes replace the recovery codes of user, the plain codes are only returned here
+func (a *Auth) GenerateRecoveryCodes(ctx context.Context, userID string) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+
+	for i := range codes {
+		byts := make([]byte, recoveryCodeSize)
+		if _, err := rand.Read(byts); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(byts))[:recoveryCodeSize]
+		codes[i] = code[:recoveryCodeSize/2] + "-" + code[recoveryCodeSize/2:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	if err := a.adb.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+//mfaRequired return true if any role of user or the roles they inherit requires mfa
+func (a *Auth) mfaRequired(userID string) (bool, error) {
+	if len(a.opts.MFARoles) == 0 {
+		return false, nil
+	}
+
+	roles, err := a.roleMgr.GetRoleForUser(userID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, role := range roles {
+		ancestors, err := a.roleMgr.GetAncestorRoles(role)
+		if err != nil && err != rolemanager.ErrRoleNotExists {
+			return false, err
+		}
+
+		for _, name := range append([]string{role}, ancestors...) {
+			for _, mfaRole := range a.opts.MFARoles {
+				if name == mfaRole {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+func (a *Auth) createMFAChallenge(userID string, amr []string) (string, time.Time, error) {
+	token, err := utils.RandomToken(mfaChallengeTokenSize)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	if err := cache.SetJSON(a.opts.Cache, mfaChallengePrefix+utils.HashToken(token), &mfaChallenge{
+		UserID: userID,
+		AMR:    amr,
+	}, mfaChallengeExpiration); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, a.opts.TimeFunc().Add(mfaChallengeExpiration), nil
+}
+
+//verifyMFAChallenge verify the code of the challenge of token and return the challenge, the challenge is removed
+//after it is passed or after the max attempts
+func (a *Auth) verifyMFAChallenge(ctx context.Context, token string, code string) (*mfaChallenge, error) {
+	a.mfaMu.Lock()
+	defer a.mfaMu.Unlock()
+
+	key := mfaChallengePrefix + utils.HashToken(token)
+	var challenge mfaChallenge
+	if err := cache.GetJSON(a.opts.Cache, key, &challenge); err != nil {
+		if err == cache.ErrNotFound {
+			return nil, ErrInvalidMFAToken
+		}
+		return nil, err
+	}
+
+	ok, err := a.verifyMFALimited(ctx, challenge.UserID, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		challenge.Attempts++
+		if challenge.Attempts >= mfaChallengeAttempts {
+			err = a.opts.Cache.Del(key)
+		} else {
+			err = cache.SetJSON(a.opts.Cache, key, &challenge, mfaChallengeExpiration)
+		}
+		if err != nil && err != cache.ErrNotFound {
+			log.Logger(ctx).Warn("Failed to update mfa challenge", zap.Error(err))
+		}
+		return nil, ErrInvalidMFACode
+	}
+
+	if err := a.opts.Cache.Del(key); err != nil && err != cache.ErrNotFound {
+		log.Logger(ctx).Warn("Failed to delete mfa challenge", zap.Error(err))
+	}
+	return &challenge, nil
+}
+
+//MFAHandler verify the code of mfa challenge and replies an access token and a refresh token
+func (m *JWTMiddleware) MFAHandler(c *gin.Context) {
+	var form MFAForm
+	if err := c.ShouldBind(&form); err != nil {
+		m.unauthorized(c, http.StatusBadRequest, m.HTTPStatusMessageFunc(err, c))
+		return
+	}
+
+	ctx := c.Request.Context()
+	challenge, err := m.auth.verifyMFAChallenge(ctx, form.MFAToken, form.Code)
+	switch err {
+	case nil:
+	case ErrInvalidMFAToken, ErrInvalidMFACode:
+		m.unauthorized(c, http.StatusUnauthorized, m.HTTPStatusMessageFunc(err, c))
+		return
+	case ErrMFALocked:
+		m.unauthorized(c, http.StatusTooManyRequests, m.HTTPStatusMessageFunc(err, c))
+		return
+	default:
+		log.Logger(ctx).Error("Failed to verify mfa code", zap.Error(err))
+		m.unauthorized(c, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	user, err := m.auth.adb.GetUserByID(ctx, challenge.UserID)
+	if err != nil {
+		m.unauthorized(c, http.StatusUnauthorized, m.HTTPStatusMessageFunc(ErrInvalidMFAToken, c))
+		return
+	}
+
+	if err := UserStateError(user); err != nil {
+		m.unauthorized(c, http.StatusForbidden, m.HTTPStatusMessageFunc(err, c))
+		return
+	}
+
+	amr := challenge.AMR
+	if len(amr) == 0 {
+		amr = []string{AMRPassword}
+	}
+
+	m.replyTokenPair(c, &tokenSubject{
+		user:     user,
+		amr:      append(amr, AMRMFA),
+		authTime: m.TimeFunc(),
+	})
+}
+
+func (m *JWTMiddleware) replyMFAChallenge(c *gin.Context, userID string, amr []string) {
+	token, expire, err := m.auth.createMFAChallenge(userID, amr)
+	if err != nil {
+		log.Logger(c.Request.Context()).Error("Failed to create mfa challenge", zap.Error(err))
+		m.unauthorized(c, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	c.JSON(http.StatusOK, &MFAChallenge{
+		Code:        http.StatusOK,
+		MFARequired: true,
+		MFAToken:    token,
+		Expire:      expire,
+	})
+}
+
+//hashRecoveryCode normalizes the recovery code before hashing
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	return utils.HashToken(code)
+}
+
+//hasAMR return true if the claims of token contains the authentication method
+func hasAMR(amr interface{}, method string) bool {
+	methods, ok := amr.([]interface{})
+	if !ok {
+		return false
+	}
+
+	for _, m := range methods {
+		if s, ok := m.(string); ok && s == method {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,203 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils/totp"
+)
+
+//newTestMFA create the auth of test user having totp enabled
+func newTestMFA(t *testing.T, clock *testClock) (*Auth, *models.User, string) {
+	a, user := newTestAuth(t, nil, WithCache(newTestCache(t)), WithTimeFunc(clock.Now))
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.adb.SaveTOTP(context.Background(), &models.TOTP{
+		UserID:  user.ID,
+		Secret:  secret,
+		Enabled: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return a, user, secret
+}
+
+func testTOTPCode(t *testing.T, secret string, at time.Time) string {
+	code, err := totp.Code(secret, totp.Step(at))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestVerifyMFATOTP(t *testing.T) {
+	tests := []struct {
+		name string
+		at   time.Duration //time of code relative to now
+		want bool
+	}{
+		{"current code", 0, true},
+		{"previous code in skew", -totp.Period, true},
+		{"next code in skew", totp.Period, true},
+		{"code out of skew", -2 * totp.Period, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newTestClock()
+			a, user, secret := newTestMFA(t, clock)
+
+			ok, err := a.VerifyMFA(context.Background(), user.ID, testTOTPCode(t, secret, clock.Now().Add(tt.at)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.want {
+				t.Errorf("VerifyMFA() = %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyMFAReplay(t *testing.T) {
+	clock := newTestClock()
+	a, user, secret := newTestMFA(t, clock)
+	ctx := context.Background()
+
+	code := testTOTPCode(t, secret, clock.Now())
+	if ok, err := a.VerifyMFA(ctx, user.ID, code); err != nil || !ok {
+		t.Fatalf("VerifyMFA() = %v, %v, want true", ok, err)
+	}
+	if ok, err := a.VerifyMFA(ctx, user.ID, code); err != nil || ok {
+		t.Fatalf("VerifyMFA() of replayed code = %v, %v, want false", ok, err)
+	}
+
+	//the codes of the steps before the used one are rejected too
+	previous := testTOTPCode(t, secret, clock.Now().Add(-totp.Period))
+	if ok, err := a.VerifyMFA(ctx, user.ID, previous); err != nil || ok {
+		t.Fatalf("VerifyMFA() of previous code = %v, %v, want false", ok, err)
+	}
+
+	clock.Advance(totp.Period)
+	if ok, err := a.VerifyMFA(ctx, user.ID, testTOTPCode(t, secret, clock.Now())); err != nil || !ok {
+		t.Fatalf("VerifyMFA() of next code = %v, %v, want true", ok, err)
+	}
+}
+
+func TestVerifyMFARecoveryCode(t *testing.T) {
+	clock := newTestClock()
+	a, user, _ := newTestMFA(t, clock)
+	ctx := context.Background()
+
+	codes, err := a.GenerateRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("recovery codes = %d, want %d", len(codes), RecoveryCodeCount)
+	}
+
+	if ok, err := a.VerifyMFA(ctx, user.ID, codes[0]); err != nil || !ok {
+		t.Fatalf("VerifyMFA() of recovery code = %v, %v, want true", ok, err)
+	}
+	if ok, err := a.VerifyMFA(ctx, user.ID, codes[0]); err != nil || ok {
+		t.Fatalf("VerifyMFA() of used recovery code = %v, %v, want false", ok, err)
+	}
+}
+
+func TestVerifyMFAAttemptLimit(t *testing.T) {
+	clock := newTestClock()
+	a, user, secret := newTestMFA(t, clock)
+	ctx := context.Background()
+
+	for i := 0; i < mfaMaxAttempts; i++ {
+		if ok, err := a.VerifyMFA(ctx, user.ID, "000000"); err != nil || ok {
+			t.Fatalf("VerifyMFA() of wrong code = %v, %v, want false", ok, err)
+		}
+	}
+
+	//the right code is refused until the window expires
+	if _, err := a.VerifyMFA(ctx, user.ID, testTOTPCode(t, secret, clock.Now())); err != ErrMFALocked {
+		t.Fatalf("VerifyMFA() after max attempts = %v, want %v", err, ErrMFALocked)
+	}
+
+	clock.Advance(mfaAttemptsWindow)
+	if ok, err := a.VerifyMFA(ctx, user.ID, testTOTPCode(t, secret, clock.Now())); err != nil || !ok {
+		t.Fatalf("VerifyMFA() after window = %v, %v, want true", ok, err)
+	}
+}
+
+func TestVerifyMFAConcurrentAttempts(t *testing.T) {
+	clock := newTestClock()
+	a, user, _ := newTestMFA(t, clock)
+	ctx := context.Background()
+
+	//the concurrent invalid codes are all counted, so no more than max are verified
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		verified int
+	)
+	for i := 0; i < 3*mfaMaxAttempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := a.VerifyMFA(ctx, user.ID, "000000"); err != ErrMFALocked {
+				mu.Lock()
+				verified++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if verified != mfaMaxAttempts {
+		t.Errorf("concurrent codes verified = %d, want %d", verified, mfaMaxAttempts)
+	}
+}
+
+func TestMFARequired(t *testing.T) {
+	roleMgr := &testRoleManager{
+		roles: map[string][]string{
+			"admin":    {"admin"},
+			"operator": {"operator"},
+			"intern":   {"intern"},
+			"user":     {"user"},
+			"orphan":   {"deleted"},
+		},
+		inherits: map[string][]string{
+			"admin":    {},
+			"operator": {"admin"},
+			"intern":   {"operator"},
+			"user":     {},
+		},
+	}
+	a, _ := newTestAuth(t, roleMgr, WithMFARoles([]string{"admin"}))
+
+	tests := []struct {
+		userID string
+		want   bool
+	}{
+		{"admin", true},
+		{"operator", true},
+		{"intern", true},
+		{"user", false},
+		{"orphan", false},
+		{"nobody", false},
+	}
+
+	for _, tt := range tests {
+		got, err := a.mfaRequired(tt.userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("mfaRequired(%s) = %v, want %v", tt.userID, got, tt.want)
+		}
+	}
+}
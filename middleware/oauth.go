@@ -0,0 +1,661 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	db "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/config"
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils"
+	"github.com/ngs24313/gopu/utils/cache"
+	"github.com/ngs24313/gopu/utils/log"
+	"go.uber.org/zap"
+)
+
+const (
+	oauthCodeSize = 32
+
+	//CodeChallengeMethodS256 is the only supported pkce method, plain is not allowed
+	CodeChallengeMethodS256 = "S256"
+
+	//GrantTypeAuthorizationCode exchanges an authorization code for tokens
+	GrantTypeAuthorizationCode = "authorization_code"
+	//GrantTypeRefreshToken exchanges a refresh token for tokens
+	GrantTypeRefreshToken = "refresh_token"
+
+	codeVerifierMinLength = 43
+	codeVerifierMaxLength = 128
+)
+
+//oauth2 error codes, see RFC 6749
+const (
+	OAuthInvalidRequest          = "invalid_request"
+	OAuthInvalidClient           = "invalid_client"
+	OAuthInvalidGrant            = "invalid_grant"
+	OAuthInvalidScope            = "invalid_scope"
+	OAuthUnauthorizedClient      = "unauthorized_client"
+	OAuthUnsupportedGrantType    = "unsupported_grant_type"
+	OAuthUnsupportedResponseType = "unsupported_response_type"
+	OAuthAccessDenied            = "access_denied"
+	OAuthServerError             = "server_error"
+)
+
+//ErrInvalidScope the scope is unknown or not allowed
+var ErrInvalidScope = errors.New("scope is invalid")
+
+//OAuthError is the error reply of oauth2 endpoints
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+//AuthorizeForm is the authorization request of oauth2,
+//approve is only used by the consent request
+type AuthorizeForm struct {
+	ResponseType        string `json:"response_type" form:"response_type"`
+	ClientID            string `json:"client_id" form:"client_id"`
+	RedirectURI         string `json:"redirect_uri" form:"redirect_uri"`
+	Scope               string `json:"scope" form:"scope"`
+	State               string `json:"state" form:"state"`
+	CodeChallenge       string `json:"code_challenge" form:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method" form:"code_challenge_method"`
+	Prompt              string `json:"prompt" form:"prompt"`
+	Nonce               string `json:"nonce" form:"nonce"`
+	Approve             bool   `json:"approve" form:"approve"`
+}
+
+//TokenForm is the token request of oauth2
+type TokenForm struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	Scope        string `form:"scope"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+}
+
+//OAuthToken is the token reply of oauth2
+type OAuthToken struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+//ScopeInfo is a scope shown to the user for consent
+type ScopeInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+//ConsentRequest is the reply of authorization when the user has not granted the scopes to the client,
+//the request should be posted again with approve after the user decides
+type ConsentRequest struct {
+	Code            int         `json:"code"`
+	ConsentRequired bool        `json:"consent_required"`
+	ClientID        string      `json:"client_id"`
+	ClientName      string      `json:"client_name"`
+	Scopes          []ScopeInfo `json:"scopes"`
+}
+
+type oauthCode struct {
+	ClientID      string   `json:"client_id"`
+	UserID        string   `json:"user_id"`
+	RedirectURI   string   `json:"redirect_uri"` //redirect_uri parameter of the authorization request
+	Scope         string   `json:"scope"`
+	CodeChallenge string   `json:"code_challenge"`
+	AMR           []string `json:"amr"`
+	AuthTime      int64    `json:"auth_time"`
+	Nonce         string   `json:"nonce"`
+}
+
+type authorizeRequest struct {
+	form        *AuthorizeForm
+	client      *models.OAuthClient
+	user        *models.User
+	redirectURI string
+	scopes      []string
+}
+
+//OAuthScope get the configured scope by name, the openid scope is unknown unless openid connect is enabled
+func (a *Auth) OAuthScope(name string) (*config.OAuthScope, bool) {
+	if name == ScopeOpenID && !a.OpenIDEnabled() {
+		return nil, false
+	}
+
+	for i, scope := range a.opts.OAuth.Scopes {
+		if scope.Name == name {
+			return &a.opts.OAuth.Scopes[i], true
+		}
+	}
+
+	for i, scope := range standardScopes {
+		if scope.Name == name {
+			return &standardScopes[i], true
+		}
+	}
+	return nil, false
+}
+
+//scopeSatisfied return false if the token is issued to an oauth client and no scope of it grants the request
+func (a *Auth) scopeSatisfied(user *models.User, c *gin.Context) bool {
+	claims := jwt.ExtractClaims(c)
+	if _, ok := claims["client_id"]; !ok {
+		return true
+	}
+
+	scope, _ := claims["scope"].(string)
+	for _, name := range strings.Fields(scope) {
+		s, ok := a.OAuthScope(name)
+		if !ok {
+			continue
+		}
+
+		for _, api := range s.APIS {
+			path := api.Path
+			if strings.Contains(path, "%s") {
+				path = fmt.Sprintf(path, user.ID)
+			}
+
+			if matchAPI(c, path, api.Method) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//AuthorizeHandler handles the authorization request of the login user, it redirects to the client with
+//an authorization code if the user has granted the scopes, otherwise replies a consent request
+func (m *JWTMiddleware) AuthorizeHandler(c *gin.Context) {
+	var form AuthorizeForm
+	if err := c.ShouldBindQuery(&form); err != nil {
+		m.replyOAuthError(c, http.StatusBadRequest, OAuthInvalidRequest, err.Error())
+		return
+	}
+
+	req, ok := m.authorizeRequest(c, &form)
+	if !ok {
+		return
+	}
+
+	consent, err := m.auth.odb.GetConsent(c.Request.Context(), req.user.ID, req.client.ID)
+	if err != nil && err != db.ErrNotFound {
+		log.Logger(c.Request.Context()).Error("Failed to get oauth consent", zap.Error(err))
+		m.redirectError(c, req, OAuthServerError, "")
+		return
+	}
+
+	if err == nil && form.Prompt != "consent" && containsAll(strings.Fields(consent.Scopes), req.scopes) {
+		m.redirectCode(c, req)
+		return
+	}
+
+	scopes := make([]ScopeInfo, 0, len(req.scopes))
+	for _, name := range req.scopes {
+		s, _ := m.auth.OAuthScope(name)
+		scopes = append(scopes, ScopeInfo{
+			Name:        s.Name,
+			Description: s.Description,
+		})
+	}
+
+	c.JSON(http.StatusOK, &ConsentRequest{
+		Code:            http.StatusOK,
+		ConsentRequired: true,
+		ClientID:        req.client.ID,
+		ClientName:      req.client.Name,
+		Scopes:          scopes,
+	})
+}
+
+//ConsentHandler records the decision of the login user and redirects to the client
+func (m *JWTMiddleware) ConsentHandler(c *gin.Context) {
+	var form AuthorizeForm
+	if err := c.ShouldBind(&form); err != nil {
+		m.replyOAuthError(c, http.StatusBadRequest, OAuthInvalidRequest, err.Error())
+		return
+	}
+
+	req, ok := m.authorizeRequest(c, &form)
+	if !ok {
+		return
+	}
+
+	if !form.Approve {
+		m.redirectError(c, req, OAuthAccessDenied, "The user denied the request")
+		return
+	}
+
+	ctx := c.Request.Context()
+	consent, err := m.auth.odb.GetConsent(ctx, req.user.ID, req.client.ID)
+	if err != nil {
+		if err != db.ErrNotFound {
+			log.Logger(ctx).Error("Failed to get oauth consent", zap.Error(err))
+			m.redirectError(c, req, OAuthServerError, "")
+			return
+		}
+		consent = &models.OAuthConsent{
+			UserID:   req.user.ID,
+			ClientID: req.client.ID,
+		}
+	}
+
+	granted := strings.Fields(consent.Scopes)
+	for _, scope := range req.scopes {
+		if !containsAll(granted, []string{scope}) {
+			granted = append(granted, scope)
+		}
+	}
+	consent.Scopes = strings.Join(granted, " ")
+
+	if err := m.auth.odb.SaveConsent(ctx, consent); err != nil {
+		log.Logger(ctx).Error("Failed to save oauth consent", zap.Error(err))
+		m.redirectError(c, req, OAuthServerError, "")
+		return
+	}
+
+	m.redirectCode(c, req)
+}
+
+//TokenHandler handles the token request of oauth clients
+func (m *JWTMiddleware) TokenHandler(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	var form TokenForm
+	if err := c.ShouldBind(&form); err != nil {
+		m.replyOAuthError(c, http.StatusBadRequest, OAuthInvalidRequest, err.Error())
+		return
+	}
+
+	client, ok := m.authenticateClient(c, &form)
+	if !ok {
+		return
+	}
+
+	var subject *tokenSubject
+	var oauthErr *OAuthError
+	switch form.GrantType {
+	case GrantTypeAuthorizationCode:
+		subject, oauthErr = m.exchangeCode(c.Request.Context(), client, &form)
+	case GrantTypeRefreshToken:
+		subject, oauthErr = m.exchangeRefreshToken(c.Request.Context(), client, &form)
+	default:
+		oauthErr = &OAuthError{Code: OAuthUnsupportedGrantType}
+	}
+
+	if oauthErr != nil {
+		status := http.StatusBadRequest
+		if oauthErr.Code == OAuthServerError {
+			status = http.StatusInternalServerError
+		}
+		m.replyOAuthError(c, status, oauthErr.Code, oauthErr.Description)
+		return
+	}
+
+	pair, err := m.issueTokenPair(c.Request.Context(), subject)
+	if err != nil {
+		log.Logger(c.Request.Context()).Error("Failed to issue oauth tokens", zap.Error(err))
+		m.replyOAuthError(c, http.StatusInternalServerError, OAuthServerError, "")
+		return
+	}
+
+	var idToken string
+	if m.auth.OpenIDEnabled() && containsAll(strings.Fields(subject.scope), []string{ScopeOpenID}) {
+		if idToken, err = m.idToken(c, subject); err != nil {
+			log.Logger(c.Request.Context()).Error("Failed to issue id token", zap.Error(err))
+			m.replyOAuthError(c, http.StatusInternalServerError, OAuthServerError, "")
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, &OAuthToken{
+		AccessToken:  pair.Token,
+		TokenType:    m.TokenHeadName,
+		ExpiresIn:    int64(m.Timeout / time.Second),
+		RefreshToken: pair.RefreshToken,
+		Scope:        subject.scope,
+		IDToken:      idToken,
+	})
+}
+
+//authorizeRequest validates the authorization request, errors are replied to the user agent
+//until the redirect uri is trusted, then they are redirected to the client
+func (m *JWTMiddleware) authorizeRequest(c *gin.Context, form *AuthorizeForm) (*authorizeRequest, bool) {
+	ctx := c.Request.Context()
+
+	claims := jwt.ExtractClaims(c)
+	if _, ok := claims["client_id"]; ok {
+		m.replyOAuthError(c, http.StatusForbidden, OAuthAccessDenied, "Tokens of oauth clients cannot authorize clients")
+		return nil, false
+	}
+
+	data, _ := c.Get(m.IdentityKey)
+	user, ok := data.(*models.User)
+	if !ok {
+		m.replyOAuthError(c, http.StatusUnauthorized, OAuthAccessDenied, "")
+		return nil, false
+	}
+
+	client, err := m.auth.odb.GetClient(ctx, form.ClientID)
+	if err != nil {
+		if err != db.ErrNotFound {
+			log.Logger(ctx).Error("Failed to get oauth client", zap.Error(err))
+			m.replyOAuthError(c, http.StatusInternalServerError, OAuthServerError, "")
+			return nil, false
+		}
+		m.replyOAuthError(c, http.StatusBadRequest, OAuthInvalidRequest, "client_id is invalid")
+		return nil, false
+	}
+
+	redirectURIs := client.RedirectURIList()
+	redirectURI := form.RedirectURI
+	if redirectURI == "" && len(redirectURIs) == 1 {
+		redirectURI = redirectURIs[0]
+	}
+	if !containsAll(redirectURIs, []string{redirectURI}) {
+		m.replyOAuthError(c, http.StatusBadRequest, OAuthInvalidRequest, "redirect_uri is not registered")
+		return nil, false
+	}
+
+	req := &authorizeRequest{
+		form:        form,
+		client:      client,
+		user:        user,
+		redirectURI: redirectURI,
+	}
+
+	if form.ResponseType != "code" {
+		m.redirectError(c, req, OAuthUnsupportedResponseType, "Only code is supported")
+		return nil, false
+	}
+
+	if form.CodeChallenge == "" && client.Public {
+		m.redirectError(c, req, OAuthInvalidRequest, "code_challenge is required for public clients")
+		return nil, false
+	}
+
+	if form.CodeChallenge != "" && form.CodeChallengeMethod != CodeChallengeMethodS256 {
+		m.redirectError(c, req, OAuthInvalidRequest, "code_challenge_method must be S256")
+		return nil, false
+	}
+
+	scopes, err := m.clientScopes(client, form.Scope)
+	if err != nil {
+		m.redirectError(c, req, OAuthInvalidScope, "")
+		return nil, false
+	}
+	req.scopes = scopes
+
+	return req, true
+}
+
+//clientScopes get the requested scopes which must be allowed for client, all allowed scopes if scope is empty
+func (m *JWTMiddleware) clientScopes(client *models.OAuthClient, scope string) ([]string, error) {
+	allowed := client.ScopeList()
+	requested := strings.Fields(scope)
+	if len(requested) == 0 {
+		requested = allowed
+	}
+
+	scopes := make([]string, 0, len(requested))
+	for _, name := range requested {
+		if _, ok := m.auth.OAuthScope(name); !ok || !containsAll(allowed, []string{name}) {
+			return nil, ErrInvalidScope
+		}
+		if !containsAll(scopes, []string{name}) {
+			scopes = append(scopes, name)
+		}
+	}
+
+	if len(scopes) == 0 {
+		return nil, ErrInvalidScope
+	}
+	return scopes, nil
+}
+
+//authenticateClient authenticates the client by http basic auth or form, public clients have no secret
+func (m *JWTMiddleware) authenticateClient(c *gin.Context, form *TokenForm) (*models.OAuthClient, bool) {
+	clientID, secret, basic := c.Request.BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = form.ClientID, form.ClientSecret
+	}
+
+	invalid := func() {
+		if basic {
+			c.Header("WWW-Authenticate", "Basic realm="+m.Realm)
+		}
+		m.replyOAuthError(c, http.StatusUnauthorized, OAuthInvalidClient, "")
+	}
+
+	if clientID == "" {
+		invalid()
+		return nil, false
+	}
+
+	client, err := m.auth.odb.GetClient(c.Request.Context(), clientID)
+	if err != nil {
+		if err != db.ErrNotFound {
+			log.Logger(c.Request.Context()).Error("Failed to get oauth client", zap.Error(err))
+			m.replyOAuthError(c, http.StatusInternalServerError, OAuthServerError, "")
+			return nil, false
+		}
+		invalid()
+		return nil, false
+	}
+
+	if !client.Public &&
+		subtle.ConstantTimeCompare([]byte(utils.HashToken(secret)), []byte(client.SecretHash)) != 1 {
+		invalid()
+		return nil, false
+	}
+	return client, true
+}
+
+//exchangeCode redeems the authorization code, each code can only be used once
+func (m *JWTMiddleware) exchangeCode(ctx context.Context,
+	client *models.OAuthClient,
+	form *TokenForm) (*tokenSubject, *OAuthError) {
+	invalidGrant := &OAuthError{Code: OAuthInvalidGrant}
+
+	key := m.auth.opts.OAuth.CodePrefix + utils.HashToken(form.Code)
+
+	var code oauthCode
+	if err := cache.GetJSON(m.auth.opts.Cache, key, &code); err != nil {
+		if err != cache.ErrNotFound {
+			log.Logger(ctx).Error("Failed to get oauth code", zap.Error(err))
+			return nil, &OAuthError{Code: OAuthServerError}
+		}
+		return nil, invalidGrant
+	}
+
+	//only the one deleting the code can redeem it
+	if err := m.auth.opts.Cache.Del(key); err != nil {
+		if err != cache.ErrNotFound {
+			log.Logger(ctx).Error("Failed to delete oauth code", zap.Error(err))
+			return nil, &OAuthError{Code: OAuthServerError}
+		}
+		return nil, invalidGrant
+	}
+
+	if code.ClientID != client.ID || code.RedirectURI != form.RedirectURI {
+		return nil, invalidGrant
+	}
+
+	if code.CodeChallenge != "" {
+		if !verifyCodeChallenge(code.CodeChallenge, form.CodeVerifier) {
+			return nil, invalidGrant
+		}
+	} else if form.CodeVerifier != "" {
+		return nil, invalidGrant
+	}
+
+	user, err := m.auth.adb.GetUserByID(ctx, code.UserID)
+	if err != nil {
+		if err != db.ErrNotFound {
+			log.Logger(ctx).Error("Failed to get user of oauth code", zap.Error(err))
+			return nil, &OAuthError{Code: OAuthServerError}
+		}
+		return nil, invalidGrant
+	}
+	if UserStateError(user) != nil {
+		return nil, invalidGrant
+	}
+
+	subject := &tokenSubject{
+		user:     user,
+		amr:      code.AMR,
+		clientID: client.ID,
+		scope:    code.Scope,
+		nonce:    code.Nonce,
+	}
+	if code.AuthTime != 0 {
+		subject.authTime = time.Unix(code.AuthTime, 0)
+	}
+	return subject, nil
+}
+
+func (m *JWTMiddleware) exchangeRefreshToken(ctx context.Context,
+	client *models.OAuthClient,
+	form *TokenForm) (*tokenSubject, *OAuthError) {
+	subject, err := m.rotateRefreshToken(ctx, form.RefreshToken, client.ID, form.Scope)
+	if err != nil {
+		switch err {
+		case ErrInvalidScope:
+			return nil, &OAuthError{Code: OAuthInvalidScope}
+		case ErrInvalidRefreshToken, ErrRefreshTokenReused:
+			return nil, &OAuthError{Code: OAuthInvalidGrant, Description: err.Error()}
+		}
+		log.Logger(ctx).Error("Failed to rotate oauth refresh token", zap.Error(err))
+		return nil, &OAuthError{Code: OAuthServerError}
+	}
+	return subject, nil
+}
+
+//redirectCode redirects to the client with a new authorization code
+func (m *JWTMiddleware) redirectCode(c *gin.Context, req *authorizeRequest) {
+	claims := jwt.ExtractClaims(c)
+	code, err := utils.RandomToken(oauthCodeSize)
+	if err == nil {
+		err = cache.SetJSON(m.auth.opts.Cache, m.auth.opts.OAuth.CodePrefix+utils.HashToken(code), &oauthCode{
+			ClientID:      req.client.ID,
+			UserID:        req.user.ID,
+			RedirectURI:   req.form.RedirectURI,
+			Scope:         strings.Join(req.scopes, " "),
+			CodeChallenge: req.form.CodeChallenge,
+			AMR:           claimStrings(claims["amr"]),
+			AuthTime:      int64(claimFloat(claims, "auth_time")),
+			Nonce:         req.form.Nonce,
+		}, m.auth.opts.OAuth.CodeExpiration)
+	}
+
+	if err != nil {
+		log.Logger(c.Request.Context()).Error("Failed to create oauth code", zap.Error(err))
+		m.redirectError(c, req, OAuthServerError, "")
+		return
+	}
+
+	m.redirect(c, req, url.Values{
+		"code": []string{code},
+	})
+}
+
+func (m *JWTMiddleware) redirectError(c *gin.Context, req *authorizeRequest, code string, description string) {
+	values := url.Values{
+		"error": []string{code},
+	}
+	if description != "" {
+		values.Set("error_description", description)
+	}
+	m.redirect(c, req, values)
+}
+
+func (m *JWTMiddleware) redirect(c *gin.Context, req *authorizeRequest, values url.Values) {
+	u, err := url.Parse(req.redirectURI)
+	if err != nil {
+		m.replyOAuthError(c, http.StatusBadRequest, OAuthInvalidRequest, "redirect_uri is invalid")
+		return
+	}
+
+	query := u.Query()
+	for key, value := range values {
+		query[key] = value
+	}
+	if req.form.State != "" {
+		query.Set("state", req.form.State)
+	}
+	u.RawQuery = query.Encode()
+
+	c.Redirect(http.StatusFound, u.String())
+}
+
+func (m *JWTMiddleware) replyOAuthError(c *gin.Context, status int, code string, description string) {
+	c.Abort()
+	c.JSON(status, &OAuthError{
+		Code:        code,
+		Description: description,
+	})
+}
+
+//verifyCodeChallenge verify the code verifier with the S256 code challenge, see RFC 7636
+func verifyCodeChallenge(challenge string, verifier string) bool {
+	if len(verifier) < codeVerifierMinLength || len(verifier) > codeVerifierMaxLength {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+//containsAll return true if all of sub are in set
+func containsAll(set []string, sub []string) bool {
+	for _, s := range sub {
+		found := false
+		for _, v := range set {
+			if v == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+//claimStrings convert a string array claim
+func claimStrings(claim interface{}) []string {
+	values, _ := claim.([]interface{})
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
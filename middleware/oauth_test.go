@@ -0,0 +1,455 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ngs24313/gopu/config"
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils"
+	"github.com/ngs24313/gopu/utils/keyset"
+)
+
+const testRedirectURI = "https://app.example.com/callback"
+
+//testServer is the login, oauth, two protected routes and the password route rejecting impersonation of auth over http
+type testServer struct {
+	*httptest.Server
+	t      *testing.T
+	auth   *Auth
+	user   *models.User
+	client *http.Client
+}
+
+func newTestServer(t *testing.T, opts ...AuthOption) *testServer {
+	opts = append([]AuthOption{
+		WithCache(newTestCache(t)),
+		WithOAuth(config.OAuth{
+			Scopes: []config.OAuthScope{
+				{Name: "profile", APIS: []config.API{{Path: "/v1/current_user", Method: "GET"}}},
+				{Name: "write", APIS: []config.API{{Path: "/v1/user/%s/profile", Method: "PUT"}}},
+			},
+		}),
+	}, opts...)
+	a, user := newTestAuth(t, nil, opts...)
+
+	m, err := a.Middleware()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST("/v1/session", m.LoginHandler)
+	engine.POST("/v1/session/refresh_token", m.RefreshHandler)
+	engine.POST("/oauth/token", m.TokenHandler)
+	engine.GET("/.well-known/openid-configuration", m.DiscoveryHandler)
+
+	authorize := engine.Group("/oauth/authorize")
+	authorize.Use(m.MiddlewareFunc())
+	{
+		authorize.GET("", m.AuthorizeHandler)
+		authorize.POST("", m.ConsentHandler)
+	}
+
+	ok := func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{})
+	}
+	engine.PUT("/v1/user/:id/password", m.RejectImpersonation(), ok)
+
+	v1 := engine.Group("/v1")
+	v1.Use(m.MiddlewareFunc())
+	{
+		v1.GET("/current_user", ok)
+		v1.PUT("/user/:id/profile", ok)
+	}
+
+	return &testServer{
+		Server: httptest.NewServer(engine),
+		t:      t,
+		auth:   a,
+		user:   user,
+		client: &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+//do send the form by method, the form is in query for GET
+func (s *testServer) do(method string, path string, token string, form url.Values) (*http.Response, map[string]interface{}) {
+	var body *strings.Reader
+	if method == "GET" {
+		if len(form) > 0 {
+			path += "?" + form.Encode()
+		}
+		body = strings.NewReader("")
+	} else {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequest(method, s.URL+path, body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if method != "GET" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	byts, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	reply := map[string]interface{}{}
+	json.Unmarshal(byts, &reply)
+	return resp, reply
+}
+
+func (s *testServer) login() string {
+	resp, reply := s.do("POST", "/v1/session", "", url.Values{"username": {testUsername}, "password": {testPassword}})
+	token, _ := reply["token"].(string)
+	if resp.StatusCode != http.StatusOK || token == "" {
+		s.t.Fatalf("login = %d %v", resp.StatusCode, reply)
+	}
+	return token
+}
+
+//createClient create a client of the scopes, the client is public if secret is empty
+func (s *testServer) createClient(secret string, scopes ...string) *models.OAuthClient {
+	client := &models.OAuthClient{
+		Name:         "app",
+		Public:       secret == "",
+		RedirectURIs: testRedirectURI,
+		Scopes:       strings.Join(scopes, " "),
+	}
+	if secret != "" {
+		client.SecretHash = utils.HashToken(secret)
+	}
+
+	client, err := s.auth.odb.CreateClient(context.Background(), client)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return client
+}
+
+//authorize request a code by the authorize form, the consent is given if approve is true
+func (s *testServer) authorize(token string, form url.Values, approve bool) (*http.Response, url.Values) {
+	var resp *http.Response
+	if approve {
+		form.Set("approve", "true")
+		resp, _ = s.do("POST", "/oauth/authorize", token, form)
+		form.Del("approve")
+	} else {
+		resp, _ = s.do("GET", "/oauth/authorize", token, form)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return resp, location.Query()
+}
+
+//testKeySet load a key set of a new ES256 key
+func testKeySet(t *testing.T) *keyset.KeySet {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "keyset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := keyset.Load([]config.SigningKey{{ID: "test", Algorithm: "ES256", PrivateKeyPath: path}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func pkce(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestOAuthAuthorizationCodeFlow(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	session := s.login()
+	client := s.createClient("", "profile", "write")
+
+	verifier := strings.Repeat("verifier", 6)
+	form := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"profile"},
+		"state":                 {"xyz"},
+		"code_challenge":        {pkce(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	//the first authorization asks for consent
+	resp, reply := s.do("GET", "/oauth/authorize", session, form)
+	if resp.StatusCode != http.StatusOK || reply["consent_required"] != true {
+		t.Fatalf("authorize without consent = %d %v", resp.StatusCode, reply)
+	}
+
+	resp, query := s.authorize(session, form, true)
+	if resp.StatusCode != http.StatusFound || query.Get("code") == "" || query.Get("state") != "xyz" {
+		t.Fatalf("consent = %d %v", resp.StatusCode, query)
+	}
+
+	//a wrong verifier burns the code
+	exchange := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {query.Get("code")},
+		"redirect_uri":  {testRedirectURI},
+		"client_id":     {client.ID},
+		"code_verifier": {strings.Repeat("wrong", 10)},
+	}
+	if _, reply := s.do("POST", "/oauth/token", "", exchange); reply["error"] != OAuthInvalidGrant {
+		t.Fatalf("token of wrong verifier = %v", reply)
+	}
+
+	//the consent is remembered
+	resp, query = s.authorize(session, form, false)
+	if resp.StatusCode != http.StatusFound || query.Get("code") == "" {
+		t.Fatalf("authorize after consent = %d %v", resp.StatusCode, query)
+	}
+
+	exchange.Set("code", query.Get("code"))
+	exchange.Set("code_verifier", verifier)
+	resp, reply = s.do("POST", "/oauth/token", "", exchange)
+	accessToken, _ := reply["access_token"].(string)
+	refreshToken, _ := reply["refresh_token"].(string)
+	if resp.StatusCode != http.StatusOK || accessToken == "" || refreshToken == "" {
+		t.Fatalf("token = %d %v", resp.StatusCode, reply)
+	}
+
+	if resp, _ := s.do("POST", "/oauth/token", "", exchange); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("reused code = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	//the token only reaches the apis of its scopes
+	if resp, _ := s.do("GET", "/v1/current_user", accessToken, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("api in scope = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if resp, _ := s.do("PUT", "/v1/user/"+s.user.ID+"/profile", accessToken, nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("api out of scope = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+
+	//the oauth refresh tokens are not session refresh tokens
+	if resp, _ := s.do("POST", "/v1/session/refresh_token", "", url.Values{"refresh_token": {refreshToken}}); resp.StatusCode == http.StatusOK {
+		t.Fatal("oauth refresh token refreshed the session")
+	}
+
+	refresh := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"client_id":     {client.ID},
+	}
+	refresh.Set("scope", "profile write")
+	if _, reply := s.do("POST", "/oauth/token", "", refresh); reply["error"] != OAuthInvalidScope {
+		t.Fatalf("refresh widening scope = %v", reply)
+	}
+	refresh.Del("scope")
+
+	resp, reply = s.do("POST", "/oauth/token", "", refresh)
+	rotatedAccess, _ := reply["access_token"].(string)
+	rotatedRefresh, _ := reply["refresh_token"].(string)
+	if resp.StatusCode != http.StatusOK || rotatedRefresh == "" || rotatedRefresh == refreshToken {
+		t.Fatalf("refresh = %d %v", resp.StatusCode, reply)
+	}
+
+	//reusing the rotated token revokes the whole family
+	if _, reply := s.do("POST", "/oauth/token", "", refresh); reply["error"] != OAuthInvalidGrant {
+		t.Fatalf("reused refresh token = %v", reply)
+	}
+
+	refresh.Set("refresh_token", rotatedRefresh)
+	if _, reply := s.do("POST", "/oauth/token", "", refresh); reply["error"] != OAuthInvalidGrant {
+		t.Fatalf("refresh token of revoked family = %v", reply)
+	}
+	for _, token := range []string{accessToken, rotatedAccess} {
+		if resp, _ := s.do("GET", "/v1/current_user", token, nil); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("access token of revoked family = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestOAuthAuthorizeRequest(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	session := s.login()
+	public := s.createClient("", "profile")
+
+	tests := []struct {
+		name      string
+		form      url.Values
+		status    int
+		wantError string //the error redirected to the client
+	}{
+		{
+			name:   "unknown client",
+			form:   url.Values{"response_type": {"code"}, "client_id": {"nobody"}, "code_challenge": {pkce("v")}},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "unregistered redirect uri",
+			form: url.Values{"response_type": {"code"}, "client_id": {public.ID}, "redirect_uri": {"https://evil.example.com/cb"},
+				"code_challenge": {pkce("v")}},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:      "public client without pkce",
+			form:      url.Values{"response_type": {"code"}, "client_id": {public.ID}},
+			status:    http.StatusFound,
+			wantError: OAuthInvalidRequest,
+		},
+		{
+			name: "scope not granted to client",
+			form: url.Values{"response_type": {"code"}, "client_id": {public.ID}, "scope": {"write"},
+				"code_challenge": {pkce("v")}, "code_challenge_method": {"S256"}},
+			status:    http.StatusFound,
+			wantError: OAuthInvalidScope,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, query := s.authorize(session, tt.form, false)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("authorize = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.wantError != "" && query.Get("error") != tt.wantError {
+				t.Errorf("error = %s, want %s", query.Get("error"), tt.wantError)
+			}
+		})
+	}
+}
+
+func TestOAuthConfidentialClient(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	session := s.login()
+	secret := "client secret"
+	client := s.createClient(secret, "profile")
+
+	form := url.Values{"response_type": {"code"}, "client_id": {client.ID}, "state": {"s"}}
+	_, query := s.authorize(session, form, true)
+	if query.Get("code") == "" {
+		t.Fatalf("consent = %v", query)
+	}
+
+	exchange := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {query.Get("code")},
+		"client_id":     {client.ID},
+		"client_secret": {"wrong"},
+	}
+	if resp, reply := s.do("POST", "/oauth/token", "", exchange); resp.StatusCode != http.StatusUnauthorized ||
+		reply["error"] != OAuthInvalidClient {
+		t.Fatalf("token of wrong secret = %d %v", resp.StatusCode, reply)
+	}
+
+	_, query = s.authorize(session, form, false)
+	exchange.Set("code", query.Get("code"))
+	exchange.Set("client_secret", secret)
+	if _, reply := s.do("POST", "/oauth/token", "", exchange); reply["access_token"] == nil {
+		t.Fatalf("token of confidential client = %v", reply)
+	}
+}
+
+func TestOpenIDConnect(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []AuthOption
+		enabled bool
+	}{
+		{"secret key", nil, false},
+		{"asymmetric key", []AuthOption{WithKeySet(testKeySet(t))}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.opts...)
+			defer s.Close()
+
+			session := s.login()
+			secret := "client secret"
+			client := s.createClient(secret, ScopeOpenID, "profile")
+
+			if resp, _ := s.do("GET", "/.well-known/openid-configuration", "", nil); (resp.StatusCode == http.StatusOK) != tt.enabled {
+				t.Errorf("discovery = %d, want enabled %v", resp.StatusCode, tt.enabled)
+			}
+
+			//the openid scope is unknown unless the id tokens can be verified by the relying parties
+			form := url.Values{"response_type": {"code"}, "client_id": {client.ID}, "scope": {"openid profile"}}
+			_, query := s.authorize(session, form, true)
+			if !tt.enabled {
+				if query.Get("error") != OAuthInvalidScope {
+					t.Fatalf("authorize openid = %v, want %s", query, OAuthInvalidScope)
+				}
+				return
+			}
+
+			_, reply := s.do("POST", "/oauth/token", "", url.Values{
+				"grant_type":    {"authorization_code"},
+				"code":          {query.Get("code")},
+				"client_id":     {client.ID},
+				"client_secret": {secret},
+			})
+			idToken, _ := reply["id_token"].(string)
+			if idToken == "" {
+				t.Fatalf("token of openid scope = %v", reply)
+			}
+			token, err := s.auth.keys.Parse(idToken)
+			if err != nil || token.Method.Alg() != "ES256" {
+				t.Errorf("id token is not signed by the key: %v", err)
+			}
+		})
+	}
+}
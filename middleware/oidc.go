@@ -0,0 +1,216 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	gojwt "github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/ngs24313/gopu/config"
+	"github.com/ngs24313/gopu/models"
+)
+
+//standard scopes of openid connect
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+//idTokenType is the typ claim of id tokens, they are signed by the same keys as the access tokens
+const idTokenType = "ID"
+
+//ErrIDToken an id token is presented as an access token
+var ErrIDToken = errors.New("id token cannot be used as access token")
+
+//standardScopes are used unless the scopes with the same name are configured,
+//only openid grants the userinfo api, profile and email filter the claims of user
+var standardScopes = []config.OAuthScope{
+	{
+		Name:        ScopeOpenID,
+		Description: "Sign in with your account",
+		APIS: []config.API{
+			{Path: "/oauth/userinfo", Method: "(GET)|(POST)"},
+		},
+	},
+	{
+		Name:        ScopeProfile,
+		Description: "Read your username, nickname, avatar and location",
+	},
+	{
+		Name:        ScopeEmail,
+		Description: "Read your email address",
+	},
+}
+
+//OpenIDConfiguration is the discovery document of openid connect
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+//OpenIDEnabled return true if the id tokens are signed by an asymmetric key, the tokens signed by the secret key
+//cannot be verified by relying parties so openid connect is disabled
+func (a *Auth) OpenIDEnabled() bool {
+	return a.keys != nil && a.keys.SigningKey() != nil && !a.keys.SigningKey().Symmetric()
+}
+
+//DiscoveryHandler replies the openid connect discovery document, not found if openid connect is disabled
+func (m *JWTMiddleware) DiscoveryHandler(c *gin.Context) {
+	if !m.auth.OpenIDEnabled() {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	issuer := m.Issuer(c)
+
+	scopes := make([]string, 0, len(standardScopes)+len(m.auth.opts.OAuth.Scopes))
+	for _, scope := range standardScopes {
+		scopes = append(scopes, scope.Name)
+	}
+	for _, scope := range m.auth.opts.OAuth.Scopes {
+		if !containsAll(scopes, []string{scope.Name}) {
+			scopes = append(scopes, scope.Name)
+		}
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, &OpenIDConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserInfoEndpoint:                  issuer + "/oauth/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		ScopesSupported:                   scopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{m.auth.keys.SigningKey().Algorithm},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{CodeChallengeMethodS256},
+		ClaimsSupported: []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "amr",
+			"preferred_username", "nickname", "picture", "location", "updated_at",
+			"email", "email_verified",
+		},
+	})
+}
+
+//UserInfoHandler replies the claims of the token user filtered by the scopes of token,
+//tokens of oauth clients must have the openid scope
+func (m *JWTMiddleware) UserInfoHandler(c *gin.Context) {
+	data, _ := c.Get(m.IdentityKey)
+	user, ok := data.(*models.User)
+	if !ok {
+		m.replyOAuthError(c, http.StatusUnauthorized, "invalid_token", "")
+		return
+	}
+
+	scopes := []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+	claims := jwt.ExtractClaims(c)
+	if _, ok := claims["client_id"]; ok {
+		scope, _ := claims["scope"].(string)
+		scopes = strings.Fields(scope)
+	}
+
+	if !containsAll(scopes, []string{ScopeOpenID}) {
+		c.Header("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+		m.replyOAuthError(c, http.StatusForbidden, "insufficient_scope", "The openid scope is required")
+		return
+	}
+
+	c.JSON(http.StatusOK, m.userClaims(c, user, scopes))
+}
+
+//idToken issue the id token of subject for its client
+func (m *JWTMiddleware) idToken(c *gin.Context, subject *tokenSubject) (string, error) {
+	now := m.TimeFunc()
+
+	claims := gojwt.MapClaims{}
+	for key, value := range m.userClaims(c, subject.user, strings.Fields(subject.scope)) {
+		claims[key] = value
+	}
+
+	claims["iss"] = m.Issuer(c)
+	claims["aud"] = subject.clientID
+	claims["typ"] = idTokenType
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(m.Timeout).Unix()
+	if len(subject.amr) > 0 {
+		claims["amr"] = subject.amr
+	}
+	if !subject.authTime.IsZero() {
+		claims["auth_time"] = subject.authTime.Unix()
+	}
+	if subject.nonce != "" {
+		claims["nonce"] = subject.nonce
+	}
+
+	return m.auth.keys.Sign(claims)
+}
+
+//isIDToken return true if the claims are of an id token, the access tokens have no audience
+func isIDToken(claims map[string]interface{}) bool {
+	if typ, _ := claims["typ"].(string); typ == idTokenType {
+		return true
+	}
+	_, ok := claims["aud"]
+	return ok
+}
+
+//userClaims get the standard claims of user for the scopes
+func (m *JWTMiddleware) userClaims(c *gin.Context, user *models.User, scopes []string) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub": user.ID,
+	}
+
+	if containsAll(scopes, []string{ScopeProfile}) {
+		claims["preferred_username"] = user.Username
+		claims["nickname"] = user.Profile.Nickname
+		claims["location"] = user.Profile.Location
+		claims["updated_at"] = user.UpdatedAt.Unix()
+		if user.Profile.Avatar != "" {
+			claims["picture"] = m.Issuer(c) + "/images/avatar/" + user.Profile.Avatar
+		}
+	}
+
+	if containsAll(scopes, []string{ScopeEmail}) {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.EmailVerifiedAt != nil
+	}
+	return claims
+}
+
+//Issuer get the configured issuer, or the base url of request
+func (m *JWTMiddleware) Issuer(c *gin.Context) string {
+	return m.auth.Issuer(c)
+}
+
+//Issuer get the configured issuer, or the base url of request
+func (a *Auth) Issuer(c *gin.Context) string {
+	if issuer := a.opts.OAuth.Issuer; issuer != "" {
+		return strings.TrimSuffix(issuer, "/")
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	db "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils"
+	"github.com/ngs24313/gopu/utils/log"
+	"go.uber.org/zap"
+)
+
+const (
+	//PersonalAccessTokenPrefix distinguishes personal access tokens from jwt
+	PersonalAccessTokenPrefix = "gopu_pat_"
+	personalAccessTokenSize   = 32
+	personalAccessTokenKey    = "PERSONAL_ACCESS_TOKEN"
+	//personalAccessTokenTouch limits the writes of last used time
+	personalAccessTokenTouch = time.Minute
+
+	//AMRPersonalAccessToken authentication method of personal access token
+	AMRPersonalAccessToken = "pat"
+)
+
+//ErrInvalidPersonalAccessToken personal access token is not found, expired or revoked
+var ErrInvalidPersonalAccessToken = errors.New("personal access token is invalid")
+
+//CreatePersonalAccessToken create a token of user, the token has all permissions of user if permissions is empty,
+//the plain token is only returned here
+func (a *Auth) CreatePersonalAccessToken(ctx context.Context,
+	userID string,
+	name string,
+	expiresAt *time.Time,
+	permissions []models.Permission) (string, *models.PersonalAccessToken, error) {
+	random, err := utils.RandomToken(personalAccessTokenSize)
+	if err != nil {
+		return "", nil, err
+	}
+	token := PersonalAccessTokenPrefix + random
+
+	pat, err := a.tdb.CreatePersonalAccessToken(ctx, &models.PersonalAccessToken{
+		UserID:         userID,
+		Name:           name,
+		TokenHash:      utils.HashToken(token),
+		ExpiresAt:      expiresAt,
+		PermissionList: permissions,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return token, pat, nil
+}
+
+//ListPersonalAccessTokens list the tokens of user
+func (a *Auth) ListPersonalAccessTokens(ctx context.Context, userID string) ([]*models.PersonalAccessToken, error) {
+	return a.tdb.ListPersonalAccessTokens(ctx, userID)
+}
+
+//RevokePersonalAccessToken revoke the token of user
+func (a *Auth) RevokePersonalAccessToken(ctx context.Context, userID string, id string) error {
+	return a.tdb.RevokePersonalAccessToken(ctx, userID, id, a.opts.TimeFunc())
+}
+
+//IsPersonalAccessToken return true if the request is authenticated by a personal access token
+func IsPersonalAccessToken(c *gin.Context) bool {
+	_, ok := c.Get(personalAccessTokenKey)
+	return ok
+}
+
+//authenticatePersonalAccessToken get the valid personal access token and records its last used time
+func (a *Auth) authenticatePersonalAccessToken(ctx context.Context, token string) (*models.PersonalAccessToken, error) {
+	pat, err := a.tdb.GetPersonalAccessTokenByHash(ctx, utils.HashToken(token))
+	if err != nil {
+		if err == db.ErrNotFound {
+			return nil, ErrInvalidPersonalAccessToken
+		}
+		return nil, err
+	}
+
+	now := a.opts.TimeFunc()
+	if pat.RevokedAt != nil || (pat.ExpiresAt != nil && !now.Before(*pat.ExpiresAt)) {
+		return nil, ErrInvalidPersonalAccessToken
+	}
+
+	if pat.LastUsedAt == nil || now.Sub(*pat.LastUsedAt) >= personalAccessTokenTouch {
+		if err := a.tdb.TouchPersonalAccessToken(ctx, pat.ID, now); err != nil {
+			log.Logger(ctx).Warn("Failed to update last used time of personal access token", zap.Error(err))
+		}
+	}
+	return pat, nil
+}
+
+//personalAccessTokenPermitted return false if the request is authenticated by a personal access token
+//and none of its permissions matches the request
+func (a *Auth) personalAccessTokenPermitted(c *gin.Context) bool {
+	value, ok := c.Get(personalAccessTokenKey)
+	if !ok {
+		return true
+	}
+
+	pat := value.(*models.PersonalAccessToken)
+	if len(pat.PermissionList) == 0 {
+		return true
+	}
+
+	for _, permission := range pat.PermissionList {
+		if matchAPI(c, permission.API, permission.Method) {
+			return true
+		}
+	}
+	return false
+}
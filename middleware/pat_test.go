@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ngs24313/gopu/models"
+)
+
+func TestPersonalAccessToken(t *testing.T) {
+	clock := newTestClock()
+	s := newTestServer(t, WithTimeFunc(clock.Now))
+	defer s.Close()
+	ctx := context.Background()
+
+	create := func(expiresAt *time.Time, permissions ...models.Permission) (string, *models.PersonalAccessToken) {
+		token, pat, err := s.auth.CreatePersonalAccessToken(ctx, s.user.ID, "ci", expiresAt, permissions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token, pat
+	}
+
+	expiresAt := clock.Now().Add(time.Hour)
+	full, _ := create(nil)
+	readOnly, _ := create(nil, models.Permission{API: "*", Method: "GET"})
+	profileOnly, profileOnlyPAT := create(nil, models.Permission{API: "/v1/user/:id/profile", Method: "*"})
+	expiring, _ := create(&expiresAt)
+
+	profile := "/v1/user/" + s.user.ID + "/profile"
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{"all permissions of user", "PUT", profile, full, http.StatusOK},
+		{"method in subset", "GET", "/v1/current_user", readOnly, http.StatusOK},
+		{"method out of subset", "PUT", profile, readOnly, http.StatusForbidden},
+		{"path in subset", "PUT", profile, profileOnly, http.StatusOK},
+		{"path out of subset", "GET", "/v1/current_user", profileOnly, http.StatusForbidden},
+		{"before expiration", "GET", "/v1/current_user", expiring, http.StatusOK},
+		{"unknown token", "GET", "/v1/current_user", PersonalAccessTokenPrefix + "unknown", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if resp, reply := s.do(tt.method, tt.path, tt.token, nil); resp.StatusCode != tt.status {
+			t.Errorf("%s: %s %s = %d %v, want %d", tt.name, tt.method, tt.path, resp.StatusCode, reply, tt.status)
+		}
+	}
+
+	if err := s.auth.RevokePersonalAccessToken(ctx, s.user.ID, profileOnlyPAT.ID); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := s.do("PUT", profile, profileOnly, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("revoked token = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	clock.Advance(time.Hour)
+	if resp, _ := s.do("GET", "/v1/current_user", expiring, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expired token = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+	if resp, _ := s.do("GET", "/v1/current_user", full, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("token without expiration = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
@@ -0,0 +1,279 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/casbin/casbin/v2/util"
+	"github.com/gin-gonic/gin"
+	apierr "github.com/ngs24313/gopu/api/error"
+	"github.com/ngs24313/gopu/config"
+	"github.com/ngs24313/gopu/utils/cache"
+	"github.com/ngs24313/gopu/utils/log"
+	"go.uber.org/zap"
+)
+
+const (
+	//RateLimitIP is the rate limit key of client ip
+	RateLimitIP = "ip"
+	//RateLimitUser is the rate limit key of authenticated user, only applied to the authenticated routes
+	RateLimitUser = "user"
+	//RateLimitFieldPrefix is the prefix of the rate limit key of request field
+	RateLimitFieldPrefix = "field:"
+)
+
+//rateLimitDefaultRules limit the apis sending emails and the login
+var rateLimitDefaultRules = []config.RateLimitRule{
+	{Path: "/v1/user/register_code", Method: "POST", Key: RateLimitIP, Limit: 10, Window: time.Hour},
+	{Path: "/v1/user/register_code", Method: "POST", Key: "field:email", Limit: 5, Window: time.Hour},
+	{Path: "/v1/user/password/reset_code", Method: "POST", Key: RateLimitIP, Limit: 10, Window: time.Hour},
+	{Path: "/v1/user/password/reset_code", Method: "POST", Key: "field:email", Limit: 5, Window: time.Hour},
+	{Path: "/v1/session/*", Method: "POST", Key: RateLimitIP, Limit: 60, Window: time.Minute},
+}
+
+//rateLimitResult is the state of a rule after the request
+type rateLimitResult struct {
+	rule       *config.RateLimitRule
+	key        string
+	allowed    bool
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+//RateLimiter limits the requests matching the rules by sliding windows, the limiter is disabled if cache is nil.
+//The requests are counted in fixed windows by the atomic increment of cache, the sliding window is estimated by
+//the current and the previous window, so the limits are shared by the instances sharing the cache
+type RateLimiter struct {
+	cache cache.Cache
+	conf  config.RateLimit
+	now   func() time.Time
+}
+
+//NewRateLimiter create rate limiter, the default rules are used if conf has none
+func NewRateLimiter(c cache.Cache, conf config.RateLimit, now func() time.Time) *RateLimiter {
+	if conf.Prefix == "" {
+		conf.Prefix = "rate_limit."
+	}
+	if len(conf.Rules) == 0 {
+		conf.Rules = rateLimitDefaultRules
+	}
+	if now == nil {
+		now = time.Now
+	}
+
+	return &RateLimiter{
+		cache: c,
+		conf:  conf,
+		now:   now,
+	}
+}
+
+//Middleware limits the requests by the rules of ip and request field keys, the user keys are limited after
+//the user is authenticated
+func (l *RateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l.Allow(c, "") {
+			c.Next()
+		}
+	}
+}
+
+//Allow apply the rules matching the request, the rules of user keys are applied if userID is not empty.
+//It replies too many requests and return false if any rule is exceeded, the requests denied are not counted
+func (l *RateLimiter) Allow(c *gin.Context, userID string) bool {
+	if l.cache == nil {
+		return true
+	}
+
+	now := l.now()
+	results := make([]*rateLimitResult, 0)
+	for i := range l.conf.Rules {
+		rule := &l.conf.Rules[i]
+		if !l.match(c, rule) || (rule.Key == RateLimitUser) != (userID != "") {
+			continue
+		}
+		key := l.keyOf(c, rule, userID)
+		if key == "" {
+			continue
+		}
+
+		result, err := l.count(i, rule, key, now)
+		if err != nil {
+			log.Logger(c.Request.Context()).Warn("Failed to check rate limit",
+				zap.String("path", rule.Path),
+				zap.String("key", rule.Key),
+				zap.Error(err))
+			continue
+		}
+		results = append(results, result)
+	}
+
+	if len(results) == 0 {
+		return true
+	}
+
+	var (
+		allowed    = true
+		strictest  = results[0]
+		retryAfter time.Duration
+	)
+	for _, result := range results {
+		if !result.allowed {
+			allowed = false
+			if result.retryAfter > retryAfter {
+				retryAfter = result.retryAfter
+			}
+		}
+		if result.remaining < strictest.remaining {
+			strictest = result
+		}
+	}
+
+	c.Header("RateLimit-Limit", strconv.Itoa(strictest.rule.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(strictest.remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(seconds(strictest.reset)))
+
+	if allowed {
+		return true
+	}
+
+	//the denied request is taken back from all windows
+	for _, result := range results {
+		if _, err := l.cache.Incr(result.key, -1, 2*result.rule.Window); err != nil {
+			log.Logger(c.Request.Context()).Warn("Failed to save rate limit",
+				zap.String("key", result.key),
+				zap.Error(err))
+		}
+	}
+
+	c.Header("Retry-After", strconv.Itoa(seconds(retryAfter)))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, apierr.NewAppError(
+		http.StatusTooManyRequests,
+		"Too many requests, try again later",
+	))
+	return false
+}
+
+//count counts the request in the window of rule and key at now and check whether it is allowed
+func (l *RateLimiter) count(index int, rule *config.RateLimitRule, key string, now time.Time) (*rateLimitResult, error) {
+	window := now.UnixNano() / int64(rule.Window)
+	start := time.Unix(0, window*int64(rule.Window))
+	prefix := fmt.Sprintf("%s%d.%s.", l.conf.Prefix, index, key)
+	cacheKey := prefix + strconv.FormatInt(window, 10)
+
+	var previous int64
+	entity, err := l.cache.Get(prefix + strconv.FormatInt(window-1, 10))
+	if err == nil {
+		previous, err = strconv.ParseInt(string(entity.Value), 10, 64)
+	}
+	if err != nil && err != cache.ErrNotFound {
+		return nil, err
+	}
+
+	count, err := l.cache.Incr(cacheKey, 1, 2*rule.Window)
+	if err != nil {
+		return nil, err
+	}
+
+	//the previous window is weighted by its part still in the sliding window
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(rule.Window)
+	before := count - 1
+	estimated := float64(previous)*weight + float64(before)
+
+	result := &rateLimitResult{
+		rule:    rule,
+		key:     cacheKey,
+		allowed: estimated+1 <= float64(rule.Limit),
+		reset:   start.Add(rule.Window).Sub(now),
+	}
+
+	result.remaining = rule.Limit - int(math.Ceil(estimated))
+	if result.allowed {
+		result.remaining--
+	}
+	if result.remaining < 0 {
+		result.remaining = 0
+	}
+
+	if !result.allowed {
+		result.retryAfter = result.reset
+		if previous > 0 && before+1 <= int64(rule.Limit) {
+			//the weight of previous window decreases until one more request fits
+			fits := 1 - float64(int64(rule.Limit)-before-1)/float64(previous)
+			result.retryAfter = time.Duration(fits*float64(rule.Window)) - elapsed
+		}
+	}
+	return result, nil
+}
+
+func (l *RateLimiter) match(c *gin.Context, rule *config.RateLimitRule) bool {
+	if rule.Limit <= 0 || rule.Window <= 0 || !util.KeyMatch2(c.Request.URL.Path, rule.Path) {
+		return false
+	}
+	return rule.Method == "" || rule.Method == "*" || util.RegexMatch(c.Request.Method, "^("+rule.Method+")$")
+}
+
+//keyOf get the key of request by the rule, the rule is skipped if it is empty
+func (l *RateLimiter) keyOf(c *gin.Context, rule *config.RateLimitRule, userID string) string {
+	switch {
+	case rule.Key == "" || rule.Key == RateLimitIP:
+		return RateLimitIP + "." + c.ClientIP()
+	case rule.Key == RateLimitUser:
+		return RateLimitUser + "." + userID
+	case strings.HasPrefix(rule.Key, RateLimitFieldPrefix):
+		name := strings.TrimPrefix(rule.Key, RateLimitFieldPrefix)
+		if value := requestField(c, name); value != "" {
+			return rule.Key + "." + strings.ToLower(value)
+		}
+	}
+	return ""
+}
+
+//requestField get the field from the json body, the form or the query of request,
+//the body is kept for the handlers
+func requestField(c *gin.Context, name string) string {
+	if c.ContentType() != gin.MIMEJSON {
+		if value := c.PostForm(name); value != "" {
+			return value
+		}
+		return c.Query(name)
+	}
+
+	if c.Request.Body == nil {
+		return c.Query(name)
+	}
+
+	body, err := ioutil.ReadAll(c.Request.Body)
+	c.Request.Body.Close()
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return c.Query(name)
+	}
+	if value, ok := fields[name].(string); ok {
+		return value
+	}
+	return c.Query(name)
+}
+
+//seconds round up the duration to seconds, at least 1
+func seconds(d time.Duration) int {
+	s := int(math.Ceil(d.Seconds()))
+	if s < 1 {
+		return 1
+	}
+	return s
+}
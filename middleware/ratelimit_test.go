@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ngs24313/gopu/config"
+)
+
+//newTestLimiter serve every path by an echo handler behind the limiter of rules
+func newTestLimiter(t *testing.T, rules ...config.RateLimitRule) (*RateLimiter, *testClock, *gin.Engine) {
+	//the windows are counted from the start of the hour
+	clock := newTestClock()
+	clock.now = clock.now.Truncate(time.Hour)
+	l := NewRateLimiter(newTestCache(t), config.RateLimit{Rules: rules}, clock.Now)
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(l.Middleware())
+	engine.Any("/*path", func(c *gin.Context) {
+		body, _ := ioutil.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	})
+	return l, clock, engine
+}
+
+type testRequest struct {
+	method      string
+	path        string
+	ip          string
+	contentType string
+	body        string
+}
+
+func (r testRequest) serve(engine *gin.Engine) *httptest.ResponseRecorder {
+	if r.method == "" {
+		r.method = "POST"
+	}
+	if r.ip == "" {
+		r.ip = "10.0.0.1"
+	}
+
+	req := httptest.NewRequest(r.method, r.path, strings.NewReader(r.body))
+	req.RemoteAddr = r.ip + ":40000"
+	if r.contentType != "" {
+		req.Header.Set("Content-Type", r.contentType)
+	}
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+//serveN serve the request n times and return the number of allowed requests
+func serveN(engine *gin.Engine, r testRequest, n int) int {
+	allowed := 0
+	for i := 0; i < n; i++ {
+		if r.serve(engine).Code == http.StatusOK {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestRateLimitSlidingWindow(t *testing.T) {
+	_, clock, engine := newTestLimiter(t, config.RateLimitRule{Path: "/login", Method: "POST", Limit: 4, Window: time.Minute})
+	login := testRequest{path: "/login"}
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		want    int //allowed of 10 requests
+	}{
+		{"first window", 0, 4},
+		{"denied requests are not counted", 30 * time.Second, 0},
+		{"previous window fully weighted", 30 * time.Second, 0},
+		{"previous window half weighted", 30 * time.Second, 2},
+		{"previous window quarter weighted", 15 * time.Second, 1},
+		{"windows expired", 2 * time.Minute, 4},
+	}
+
+	for _, tt := range tests {
+		clock.Advance(tt.advance)
+		if got := serveN(engine, login, 10); got != tt.want {
+			t.Fatalf("%s: allowed %d requests, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	_, clock, engine := newTestLimiter(t, config.RateLimitRule{Path: "/login", Method: "POST", Limit: 4, Window: time.Minute})
+	login := testRequest{path: "/login"}
+
+	w := login.serve(engine)
+	if w.Header().Get("RateLimit-Limit") != "4" || w.Header().Get("RateLimit-Remaining") != "3" ||
+		w.Header().Get("RateLimit-Reset") != "60" {
+		t.Errorf("headers of first request = %v", w.Header())
+	}
+
+	serveN(engine, login, 3)
+
+	//one more request fits when the weight of the previous window drops to 3/4
+	clock.Advance(time.Minute)
+	w = login.serve(engine)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if got := w.Header().Get("Retry-After"); got != "15" {
+		t.Errorf("Retry-After = %s, want 15", got)
+	}
+
+	clock.Advance(15 * time.Second)
+	if w := login.serve(engine); w.Code != http.StatusOK {
+		t.Errorf("status after Retry-After = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	_, _, engine := newTestLimiter(t,
+		config.RateLimitRule{Path: "/v1/session/*", Method: "POST", Key: RateLimitIP, Limit: 2, Window: time.Minute},
+		config.RateLimitRule{Path: "/v1/user/register_code", Method: "POST", Key: "field:email", Limit: 1, Window: time.Hour},
+	)
+
+	tests := []struct {
+		name string
+		req  testRequest
+		want int //allowed of 3 requests
+	}{
+		{"ip", testRequest{path: "/v1/session/"}, 2},
+		{"ip shared by paths", testRequest{path: "/v1/session/refresh_token"}, 0},
+		{"other ip", testRequest{path: "/v1/session/", ip: "10.0.0.2"}, 2},
+		{"method not matched", testRequest{method: "GET", path: "/v1/session/"}, 3},
+		{"path not matched", testRequest{path: "/v1/current_user"}, 3},
+		{"form field", testRequest{path: "/v1/user/register_code", contentType: "application/x-www-form-urlencoded",
+			body: "email=alice%40example.com"}, 1},
+		{"json field of other case", testRequest{path: "/v1/user/register_code", contentType: "application/json",
+			body: `{"email":"Alice@Example.com"}`}, 0},
+		{"other field", testRequest{path: "/v1/user/register_code", contentType: "application/json",
+			body: `{"email":"bob@example.com"}`}, 1},
+		{"no field", testRequest{path: "/v1/user/register_code"}, 3},
+	}
+
+	for _, tt := range tests {
+		if got := serveN(engine, tt.req, 3); got != tt.want {
+			t.Errorf("%s: allowed %d requests, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	//the handlers still read the body
+	body := `{"email":"carol@example.com"}`
+	w := testRequest{path: "/v1/user/register_code", contentType: "application/json", body: body}.serve(engine)
+	if w.Code != http.StatusOK || w.Body.String() != body {
+		t.Errorf("reply = %d %s, want the body", w.Code, w.Body.String())
+	}
+}
+
+func TestRateLimitUser(t *testing.T) {
+	l, _, _ := newTestLimiter(t, config.RateLimitRule{Path: "/v1/user/*", Key: RateLimitUser, Limit: 1, Window: time.Minute})
+
+	allow := func(userID string, ip string) bool {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("PUT", "/v1/user/profile", nil)
+		c.Request.RemoteAddr = ip + ":40000"
+		return l.Allow(c, userID)
+	}
+
+	//the user rules are only applied to the authenticated requests
+	for i := 0; i < 3; i++ {
+		if !allow("", "10.0.0.1") {
+			t.Fatal("anonymous request is limited by user rule")
+		}
+	}
+
+	if !allow("user1", "10.0.0.1") {
+		t.Fatal("first request of user is denied")
+	}
+	if allow("user1", "10.0.0.2") {
+		t.Error("user is not limited across ips")
+	}
+	if !allow("user2", "10.0.0.1") {
+		t.Error("other user is limited")
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	l := NewRateLimiter(nil, config.RateLimit{}, nil)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/session/", nil)
+	for i := 0; i < 100; i++ {
+		if !l.Allow(c, "") {
+			t.Fatal("limiter without cache denied a request")
+		}
+	}
+}
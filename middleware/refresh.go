@@ -0,0 +1,260 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	db "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils"
+	"github.com/ngs24313/gopu/utils/log"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+)
+
+//refreshTokenSize is the random bytes of refresh token
+const refreshTokenSize = 32
+
+var (
+	//ErrInvalidRefreshToken refresh token is not found, expired or revoked
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid")
+	//ErrRefreshTokenReused refresh token was used before, its family is revoked
+	ErrRefreshTokenReused = errors.New("refresh token has been used")
+)
+
+//RefreshForm for refresh token
+type RefreshForm struct {
+	RefreshToken string `json:"refresh_token" form:"refresh_token" binding:"required"`
+}
+
+//TokenPair is the reply of login and refresh
+type TokenPair struct {
+	Code          int       `json:"code"`
+	Token         string    `json:"token"`
+	Expire        time.Time `json:"expire"`
+	RefreshToken  string    `json:"refresh_token"`
+	RefreshExpire time.Time `json:"refresh_expire"`
+}
+
+//tokenSubject is the data of payload func
+type tokenSubject struct {
+	user      *models.User
+	family    string
+	amr       []string
+	clientID  string //oauth client, empty for first party login
+	scope     string
+	authTime  time.Time
+	nonce     string //nonce of the oidc authorization request
+	sessionID string //session of first party login
+	userAgent string
+	clientIP  string
+	actor     string //admin impersonating the user
+}
+
+//issueTokenPair issue an access token and a refresh token of subject, a new family is created if family is empty,
+//and the new family of first party login is recorded as a session
+func (m *JWTMiddleware) issueTokenPair(ctx context.Context, subject *tokenSubject) (*TokenPair, error) {
+	now := m.TimeFunc()
+	refreshExpire := now.Add(m.auth.opts.MaxRefersh)
+
+	if subject.family == "" {
+		subject.family = xid.New().String()
+		if subject.clientID == "" {
+			if err := m.auth.createSession(ctx, subject, refreshExpire); err != nil {
+				return nil, err
+			}
+		}
+	} else if subject.sessionID != "" {
+		if err := m.auth.tdb.RefreshSession(ctx, subject.sessionID, now, refreshExpire); err != nil {
+			return nil, err
+		}
+	}
+
+	token, expire, err := m.TokenGenerator(subject)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := utils.RandomToken(refreshTokenSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := m.auth.tdb.CreateRefreshToken(ctx, &models.RefreshToken{
+		UserID:    subject.user.ID,
+		FamilyID:  subject.family,
+		AMR:       strings.Join(subject.amr, ","),
+		ClientID:  subject.clientID,
+		Scope:     subject.scope,
+		AuthTime:  subject.authTime,
+		TokenHash: utils.HashToken(refreshToken),
+		ExpiresAt: refreshExpire,
+	}); err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		Code:          http.StatusOK,
+		Token:         token,
+		Expire:        expire,
+		RefreshToken:  refreshToken,
+		RefreshExpire: refreshExpire,
+	}, nil
+}
+
+//rotateRefreshToken marks the refresh token of client used and returns the subject of its family,
+//the whole family is revoked if the token is reused, the scope of subject is narrowed if scope is not empty
+func (m *JWTMiddleware) rotateRefreshToken(ctx context.Context,
+	refreshToken string,
+	clientID string,
+	scope string) (*tokenSubject, error) {
+	tdb := m.auth.tdb
+	now := m.TimeFunc()
+
+	token, err := tdb.GetRefreshTokenByHash(ctx, utils.HashToken(refreshToken))
+	if err != nil {
+		if err == db.ErrNotFound {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	if token.RevokedAt != nil || !now.Before(token.ExpiresAt) || token.ClientID != clientID {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if scope == "" {
+		scope = token.Scope
+	} else if !containsAll(strings.Fields(token.Scope), strings.Fields(scope)) {
+		return nil, ErrInvalidScope
+	}
+
+	ok, err := tdb.UseRefreshToken(ctx, token.ID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok || token.UsedAt != nil {
+		log.Logger(ctx).Warn("Refresh token is reused, revoke its family",
+			zap.String("user", token.UserID),
+			zap.String("family", token.FamilyID))
+		if err := m.auth.revokeFamily(ctx, token.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	user, err := m.auth.adb.GetUserByID(ctx, token.UserID)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+	if UserStateError(user) != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	subject := &tokenSubject{
+		user:     user,
+		family:   token.FamilyID,
+		clientID: token.ClientID,
+		scope:    scope,
+		authTime: token.AuthTime,
+	}
+	if token.AMR != "" {
+		subject.amr = strings.Split(token.AMR, ",")
+	}
+
+	if token.ClientID == "" {
+		subject.sessionID, err = m.auth.sessionOfFamily(ctx, token.FamilyID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return subject, nil
+}
+
+//LoginHandler authenticates the user and replies an access token and a refresh token
+func (m *JWTMiddleware) LoginHandler(c *gin.Context) {
+	data, err := m.Authenticator(c)
+	if err != nil {
+		m.unauthorized(c, http.StatusUnauthorized, m.HTTPStatusMessageFunc(err, c))
+		return
+	}
+
+	user, ok := data.(*models.User)
+	if !ok {
+		m.unauthorized(c, http.StatusUnauthorized, m.HTTPStatusMessageFunc(jwt.ErrFailedAuthentication, c))
+		return
+	}
+
+	m.LoginUser(c, user, AMRPassword)
+}
+
+//LoginUser replies an access token and a refresh token of the authenticated user,
+//or a mfa challenge if the user has mfa enabled
+func (m *JWTMiddleware) LoginUser(c *gin.Context, user *models.User, amr ...string) {
+	if err := UserStateError(user); err != nil {
+		m.unauthorized(c, http.StatusForbidden, m.HTTPStatusMessageFunc(err, c))
+		return
+	}
+
+	mfaEnabled, err := m.auth.MFAEnabled(c.Request.Context(), user.ID)
+	if err != nil {
+		log.Logger(c.Request.Context()).Error("Failed to get mfa of user", zap.Error(err))
+		m.unauthorized(c, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	if mfaEnabled {
+		m.replyMFAChallenge(c, user.ID, amr)
+		return
+	}
+
+	m.replyTokenPair(c, &tokenSubject{
+		user:     user,
+		amr:      amr,
+		authTime: m.TimeFunc(),
+	})
+}
+
+//RefreshHandler exchanges a refresh token for a new access token and a new refresh token
+func (m *JWTMiddleware) RefreshHandler(c *gin.Context) {
+	var form RefreshForm
+	if err := c.ShouldBind(&form); err != nil {
+		m.unauthorized(c, http.StatusBadRequest, m.HTTPStatusMessageFunc(err, c))
+		return
+	}
+
+	subject, err := m.rotateRefreshToken(c.Request.Context(), form.RefreshToken, "", "")
+	if err != nil {
+		if err == ErrInvalidRefreshToken || err == ErrRefreshTokenReused {
+			m.unauthorized(c, http.StatusUnauthorized, m.HTTPStatusMessageFunc(err, c))
+			return
+		}
+		log.Logger(c.Request.Context()).Error("Failed to rotate refresh token", zap.Error(err))
+		m.unauthorized(c, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	m.replyTokenPair(c, subject)
+}
+
+func (m *JWTMiddleware) replyTokenPair(c *gin.Context, subject *tokenSubject) {
+	subject.userAgent = c.Request.UserAgent()
+	subject.clientIP = c.ClientIP()
+
+	pair, err := m.issueTokenPair(c.Request.Context(), subject)
+	if err != nil {
+		log.Logger(c.Request.Context()).Error("Failed to issue tokens", zap.Error(err))
+		m.unauthorized(c, http.StatusInternalServerError, m.HTTPStatusMessageFunc(jwt.ErrFailedTokenCreation, c))
+		return
+	}
+	c.JSON(http.StatusOK, pair)
+}
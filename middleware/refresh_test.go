@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestRefreshTokenReuse(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	refresh := func(refreshToken string) (int, string, string) {
+		resp, reply := s.do("POST", "/v1/session/refresh_token", "", url.Values{"refresh_token": {refreshToken}})
+		token, _ := reply["token"].(string)
+		next, _ := reply["refresh_token"].(string)
+		return resp.StatusCode, token, next
+	}
+
+	resp, reply := s.do("POST", "/v1/session", "", url.Values{"username": {testUsername}, "password": {testPassword}})
+	first, _ := reply["refresh_token"].(string)
+	if resp.StatusCode != http.StatusOK || first == "" {
+		t.Fatalf("login = %d %v", resp.StatusCode, reply)
+	}
+
+	status, token, second := refresh(first)
+	if status != http.StatusOK || token == "" || second == "" || second == first {
+		t.Fatalf("refresh = %d, want a new token pair", status)
+	}
+
+	//a rotated token is reused by someone else, the whole family is revoked
+	if status, _, _ := refresh(first); status != http.StatusUnauthorized {
+		t.Errorf("reused refresh token = %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status, _, _ := refresh(second); status != http.StatusUnauthorized {
+		t.Errorf("refresh token of revoked family = %d, want %d", status, http.StatusUnauthorized)
+	}
+	if resp, _ := s.do("GET", "/v1/current_user", token, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("access token of revoked family = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	//other logins are not affected
+	if resp, _ := s.do("GET", "/v1/current_user", s.login(), nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("access token of other login = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/ngs24313/gopu/utils/cache"
+)
+
+const (
+	revokedTokenPrefix   = "revoked_token."
+	revokedUserPrefix    = "revoked_user."
+	revokedFamilyPrefix  = "revoked_family."
+	revokedClientPrefix  = "revoked_client."
+	revokedSessionPrefix = "revoked_session."
+)
+
+//TokenRevoker stores revoked tokens in cache until they expire
+type TokenRevoker struct {
+	cache cache.Cache
+	now   func() time.Time
+}
+
+//NewTokenRevoker create token revoker, the revoker is disabled if c is nil
+func NewTokenRevoker(c cache.Cache, now func() time.Time) *TokenRevoker {
+	if now == nil {
+		now = time.Now
+	}
+	return &TokenRevoker{
+		cache: c,
+		now:   now,
+	}
+}
+
+//RevokeToken revoke the token of claims until it expires
+func (r *TokenRevoker) RevokeToken(claims jwt.MapClaims) error {
+	if r.cache == nil {
+		return nil
+	}
+
+	jti, ok := claims["jti"].(string)
+	if !ok || jti == "" {
+		return nil
+	}
+
+	ttl := time.Unix(int64(claimFloat(claims, "exp")), 0).Sub(r.now())
+	if ttl <= 0 {
+		return nil
+	}
+
+	return r.cache.Set(&cache.Entity{
+		Key:        revokedTokenPrefix + jti,
+		Value:      []byte(""),
+		Expiration: ttl,
+	})
+}
+
+//RevokeFamily revoke all tokens of the refresh token family, ttl must cover the token timeout
+func (r *TokenRevoker) RevokeFamily(family string, ttl time.Duration) error {
+	if r.cache == nil || family == "" {
+		return nil
+	}
+
+	return r.cache.Set(&cache.Entity{
+		Key:        revokedFamilyPrefix + family,
+		Value:      []byte(""),
+		Expiration: ttl,
+	})
+}
+
+//RevokeSession revoke all tokens of the session, ttl must cover the token timeout
+func (r *TokenRevoker) RevokeSession(sessionID string, ttl time.Duration) error {
+	if r.cache == nil || sessionID == "" {
+		return nil
+	}
+
+	return r.cache.Set(&cache.Entity{
+		Key:        revokedSessionPrefix + sessionID,
+		Value:      []byte(""),
+		Expiration: ttl,
+	})
+}
+
+//RevokeClient revoke all tokens issued to the oauth client, ttl must cover the token timeout
+func (r *TokenRevoker) RevokeClient(clientID string, ttl time.Duration) error {
+	if r.cache == nil || clientID == "" {
+		return nil
+	}
+
+	return r.cache.Set(&cache.Entity{
+		Key:        revokedClientPrefix + clientID,
+		Value:      []byte(""),
+		Expiration: ttl,
+	})
+}
+
+//RevokeUser revoke all tokens of user issued before now, ttl must cover the token timeout
+func (r *TokenRevoker) RevokeUser(userID string, ttl time.Duration) error {
+	if r.cache == nil {
+		return nil
+	}
+
+	return r.cache.Set(&cache.Entity{
+		Key:        revokedUserPrefix + userID,
+		Value:      []byte(strconv.FormatInt(r.now().UnixNano(), 10)),
+		Expiration: ttl,
+	})
+}
+
+//IsRevoked return true if the token of claims is revoked
+func (r *TokenRevoker) IsRevoked(claims jwt.MapClaims, userID string) (bool, error) {
+	if r.cache == nil {
+		return false, nil
+	}
+
+	for prefix, claim := range map[string]string{
+		revokedTokenPrefix:   "jti",
+		revokedFamilyPrefix:  "fam",
+		revokedClientPrefix:  "client_id",
+		revokedSessionPrefix: "sid",
+	} {
+		id, ok := claims[claim].(string)
+		if !ok || id == "" {
+			continue
+		}
+
+		if _, err := r.cache.Get(prefix + id); err == nil {
+			return true, nil
+		} else if err != cache.ErrNotFound {
+			return false, err
+		}
+	}
+
+	entity, err := r.cache.Get(revokedUserPrefix + userID)
+	if err != nil {
+		if err == cache.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	revokedAt, err := strconv.ParseInt(string(entity.Value), 10, 64)
+	if err != nil {
+		return false, err
+	}
+
+	issuedAt := claimFloat(claims, "iat")
+	return issuedAt*float64(time.Second) < float64(revokedAt), nil
+}
+
+func claimFloat(claims jwt.MapClaims, name string) float64 {
+	switch v := claims[name].(type) {
+	case float64:
+		return v
+	case int64:
+		return float64(v)
+	case int:
+		return float64(v)
+	}
+	return 0
+}
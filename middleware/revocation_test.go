@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+)
+
+func TestTokenRevoker(t *testing.T) {
+	const userID = "user1"
+
+	tests := []struct {
+		name   string
+		revoke func(r *TokenRevoker, claims jwt.MapClaims) error
+		claims jwt.MapClaims //claims of the checked token, jti is t1 if missing
+		want   bool
+	}{
+		{
+			name:   "not revoked",
+			revoke: func(r *TokenRevoker, claims jwt.MapClaims) error { return nil },
+			want:   false,
+		},
+		{
+			name:   "token revoked",
+			revoke: func(r *TokenRevoker, claims jwt.MapClaims) error { return r.RevokeToken(claims) },
+			want:   true,
+		},
+		{
+			name: "other token revoked",
+			revoke: func(r *TokenRevoker, claims jwt.MapClaims) error {
+				return r.RevokeToken(jwt.MapClaims{"jti": "t2", "exp": claims["exp"]})
+			},
+			want: false,
+		},
+		{
+			name: "expired token is not recorded",
+			revoke: func(r *TokenRevoker, claims jwt.MapClaims) error {
+				return r.RevokeToken(jwt.MapClaims{"jti": "t1", "exp": float64(r.now().Add(-time.Minute).Unix())})
+			},
+			want: false,
+		},
+		{
+			name:   "family revoked",
+			revoke: func(r *TokenRevoker, claims jwt.MapClaims) error { return r.RevokeFamily("f1", time.Hour) },
+			claims: jwt.MapClaims{"fam": "f1"},
+			want:   true,
+		},
+		{
+			name:   "session revoked",
+			revoke: func(r *TokenRevoker, claims jwt.MapClaims) error { return r.RevokeSession("s1", time.Hour) },
+			claims: jwt.MapClaims{"sid": "s1"},
+			want:   true,
+		},
+		{
+			name:   "client revoked",
+			revoke: func(r *TokenRevoker, claims jwt.MapClaims) error { return r.RevokeClient("c1", time.Hour) },
+			claims: jwt.MapClaims{"client_id": "c1"},
+			want:   true,
+		},
+		{
+			name:   "user revoked after issued",
+			revoke: func(r *TokenRevoker, claims jwt.MapClaims) error { return r.RevokeUser(userID, time.Hour) },
+			want:   true,
+		},
+		{
+			name:   "other user revoked",
+			revoke: func(r *TokenRevoker, claims jwt.MapClaims) error { return r.RevokeUser("user2", time.Hour) },
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newTestClock()
+			r := NewTokenRevoker(newTestCache(t), clock.Now)
+
+			claims := jwt.MapClaims{
+				"jti": "t1",
+				"iat": float64(clock.Now().Unix()),
+				"exp": float64(clock.Now().Add(time.Hour).Unix()),
+			}
+			for k, v := range tt.claims {
+				claims[k] = v
+			}
+
+			clock.Advance(time.Second)
+			if err := tt.revoke(r, claims); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := r.IsRevoked(claims, userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("IsRevoked() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenRevokerUserKeepsNewTokens(t *testing.T) {
+	clock := newTestClock()
+	r := NewTokenRevoker(newTestCache(t), clock.Now)
+
+	if err := r.RevokeUser("user1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	//the tokens issued after revoking are valid
+	clock.Advance(time.Second)
+	claims := jwt.MapClaims{"jti": "t1", "iat": float64(clock.Now().Unix())}
+	revoked, err := r.IsRevoked(claims, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked {
+		t.Error("token issued after revoking user is revoked")
+	}
+}
+
+func TestTokenRevokerDisabled(t *testing.T) {
+	r := NewTokenRevoker(nil, nil)
+	if err := r.RevokeUser("user1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	revoked, err := r.IsRevoked(jwt.MapClaims{"jti": "t1"}, "user1")
+	if err != nil || revoked {
+		t.Errorf("IsRevoked() of disabled revoker = %v, %v, want false, nil", revoked, err)
+	}
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	db "github.com/ngs24313/gopu/api/database"
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils/cache"
+	"github.com/ngs24313/gopu/utils/log"
+	"go.uber.org/zap"
+)
+
+const (
+	//sessionTouch limits the writes of last seen time
+	sessionTouch       = time.Minute
+	sessionTouchPrefix = "session_seen."
+)
+
+//ListSessions list the active sessions of user
+func (a *Auth) ListSessions(ctx context.Context, userID string) ([]*models.Session, error) {
+	return a.tdb.ListSessions(ctx, userID, a.opts.TimeFunc())
+}
+
+//RevokeSession revoke the session of user with its tokens
+func (a *Auth) RevokeSession(ctx context.Context, userID string, id string) error {
+	session, err := a.tdb.RevokeSession(ctx, userID, id, a.opts.TimeFunc())
+	if err != nil {
+		return err
+	}
+
+	if err := a.revokeFamily(ctx, session.FamilyID); err != nil {
+		return err
+	}
+	return a.revoker.RevokeSession(session.ID, a.opts.Timeout)
+}
+
+//SessionID get the session id of the token of request, empty if the token has no session
+func SessionID(c *gin.Context) string {
+	sid, _ := jwt.ExtractClaims(c)["sid"].(string)
+	return sid
+}
+
+//createSession records the first party login of subject as a session of its family
+func (a *Auth) createSession(ctx context.Context, subject *tokenSubject, expiresAt time.Time) error {
+	now := a.opts.TimeFunc()
+	session, err := a.tdb.CreateSession(ctx, &models.Session{
+		UserID:     subject.user.ID,
+		FamilyID:   subject.family,
+		AMR:        strings.Join(subject.amr, ","),
+		UserAgent:  subject.userAgent,
+		ClientIP:   subject.clientIP,
+		LastSeenAt: now,
+		ExpiresAt:  expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	subject.sessionID = session.ID
+	return nil
+}
+
+//sessionOfFamily get the session id of the refresh token family, empty for the families without session
+func (a *Auth) sessionOfFamily(ctx context.Context, family string) (string, error) {
+	session, err := a.tdb.GetSessionByFamily(ctx, family)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+
+	if session.RevokedAt != nil {
+		return "", ErrInvalidRefreshToken
+	}
+	return session.ID, nil
+}
+
+//touchSession records the last seen time and ip of the session of claims, at most once a minute
+func (m *JWTMiddleware) touchSession(c *gin.Context, claims jwt.MapClaims) {
+	sid, ok := claims["sid"].(string)
+	if !ok || sid == "" || m.auth.opts.Cache == nil {
+		return
+	}
+
+	ctx := c.Request.Context()
+	key := sessionTouchPrefix + sid
+	if _, err := m.auth.opts.Cache.Get(key); err != cache.ErrNotFound {
+		if err != nil {
+			log.Logger(ctx).Warn("Failed to get last seen of session", zap.Error(err))
+		}
+		return
+	}
+
+	if err := m.auth.opts.Cache.Set(&cache.Entity{
+		Key:        key,
+		Value:      []byte(""),
+		Expiration: sessionTouch,
+	}); err != nil {
+		log.Logger(ctx).Warn("Failed to set last seen of session", zap.Error(err))
+		return
+	}
+
+	if err := m.auth.tdb.TouchSession(ctx, sid, c.ClientIP(), m.TimeFunc()); err != nil {
+		log.Logger(ctx).Warn("Failed to update last seen of session", zap.Error(err))
+	}
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestRevokeSession(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	ctx := context.Background()
+
+	resp, reply := s.do("POST", "/v1/session", "", url.Values{"username": {testUsername}, "password": {testPassword}})
+	token, _ := reply["token"].(string)
+	refreshToken, _ := reply["refresh_token"].(string)
+	if resp.StatusCode != http.StatusOK || token == "" {
+		t.Fatalf("login = %d %v", resp.StatusCode, reply)
+	}
+
+	sessions, err := s.auth.ListSessions(ctx, s.user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("sessions = %d, want 1", len(sessions))
+	}
+	revoked := sessions[0]
+	other := s.login()
+
+	if resp, _ := s.do("GET", "/v1/current_user", token, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("access token before revoking = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	if err := s.auth.RevokeSession(ctx, "other", revoked.ID); err == nil {
+		t.Error("session is revoked by other user")
+	}
+	if err := s.auth.RevokeSession(ctx, s.user.ID, revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp, _ := s.do("GET", "/v1/current_user", token, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("access token of revoked session = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+	resp, _ = s.do("POST", "/v1/session/refresh_token", "", url.Values{"refresh_token": {refreshToken}})
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("refresh token of revoked session = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+	if resp, _ := s.do("GET", "/v1/current_user", other, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("access token of other session = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	if sessions, err := s.auth.ListSessions(ctx, s.user.ID); err != nil || len(sessions) != 1 || sessions[0].ID == revoked.ID {
+		t.Errorf("sessions after revoking = %v %v, want the other session", sessions, err)
+	}
+}
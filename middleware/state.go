@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ngs24313/gopu/models"
+)
+
+var (
+	//ErrAccountDisabled the user is disabled by admin
+	ErrAccountDisabled = errors.New("account is disabled")
+	//ErrAccountLocked the user is locked by admin
+	ErrAccountLocked = errors.New("account is locked")
+	//ErrAccountPendingApproval the user is not approved yet
+	ErrAccountPendingApproval = errors.New("account is pending approval")
+	//ErrAccountPendingDeletion the user is going to be deleted
+	ErrAccountPendingDeletion = errors.New("account is pending deletion")
+	//ErrInvalidStateTransition the user cannot be moved to the state from its state
+	ErrInvalidStateTransition = errors.New("invalid state transition")
+)
+
+//UserStateError get the error of the state of user, nil if the user is active
+func UserStateError(user *models.User) error {
+	switch user.State {
+	case models.UserStateActive:
+		return nil
+	case models.UserStateDisabled:
+		return ErrAccountDisabled
+	case models.UserStateLocked:
+		return ErrAccountLocked
+	case models.UserStatePendingApproval:
+		return ErrAccountPendingApproval
+	case models.UserStatePendingDeletion:
+		return ErrAccountPendingDeletion
+	}
+	return ErrAccountDisabled
+}
+
+//SetUserState move the user to state by actor, the tokens and sessions of user are revoked
+//if the user is no longer active
+func (a *Auth) SetUserState(ctx context.Context,
+	user *models.User,
+	state string,
+	reason string,
+	actorID string) error {
+	if !user.CanTransitionTo(state) {
+		return ErrInvalidStateTransition
+	}
+
+	from := user.State
+	now := a.opts.TimeFunc()
+	user.State = state
+	user.StateReason = reason
+	user.StateActorID = actorID
+	user.StateChangedAt = &now
+	if err := a.adb.UpdateUserState(ctx, user, from); err != nil {
+		return err
+	}
+
+	if state == models.UserStateActive {
+		return nil
+	}
+	return a.RevokeUserTokens(ctx, user.ID)
+}
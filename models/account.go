@@ -7,6 +7,24 @@ import (
 	"github.com/rs/xid"
 )
 
+//states of the lifecycle of user, only active users can login and access
+const (
+	UserStateActive          = "active"
+	UserStateDisabled        = "disabled"
+	UserStateLocked          = "locked"
+	UserStatePendingApproval = "pending_approval"
+	UserStatePendingDeletion = "pending_deletion"
+)
+
+//userStateTransitions is the states which the users of a state can be moved to
+var userStateTransitions = map[string][]string{
+	UserStateActive:          {UserStateDisabled, UserStateLocked, UserStatePendingDeletion},
+	UserStateDisabled:        {UserStateActive, UserStatePendingDeletion},
+	UserStateLocked:          {UserStateActive, UserStateDisabled, UserStatePendingDeletion},
+	UserStatePendingApproval: {UserStateActive, UserStateDisabled, UserStatePendingDeletion},
+	UserStatePendingDeletion: {UserStateActive, UserStateDisabled},
+}
+
 //User user models
 type User struct {
 	ID        string     `gorm:"primary_key" json:"id"`
@@ -14,9 +32,17 @@ type User struct {
 	UpdatedAt time.Time  `json:"updated_at"`
 	DeletedAt *time.Time `sql:"index" json:"deleted_at,omitempty"`
 
-	Username string `gorm:"column:username;unique_key;index" json:"username"`
-	Password string `gorm:"column:password" json:"-"`
-	Email    string `gorm:"column:email;unique_key;index" json:"email"`
+	Username          string     `gorm:"column:username;unique_key;index" json:"username"`
+	Password          string     `gorm:"column:password" json:"-"`
+	PasswordChangedAt *time.Time `gorm:"column:password_changed_at" json:"password_changed_at,omitempty"`
+	Email             string     `gorm:"column:email;unique_key;index" json:"email"`
+	EmailVerifiedAt   *time.Time `gorm:"column:email_verified_at" json:"email_verified_at,omitempty"`
+	EmailVerified     bool       `gorm:"-" json:"email_verified"`
+
+	State          string     `gorm:"column:state;index;default:'active'" json:"state"`
+	StateReason    string     `gorm:"column:state_reason" json:"state_reason,omitempty"`
+	StateActorID   string     `gorm:"column:state_actor_id" json:"state_actor_id,omitempty"` //the admin changing the state
+	StateChangedAt *time.Time `gorm:"column:state_changed_at" json:"state_changed_at,omitempty"`
 
 	Profile   Profile
 	ProfileID uint
@@ -29,6 +55,34 @@ func (u *User) BeforeCreate(s *gorm.Scope) error {
 	return s.SetColumn("id", xid.New().String())
 }
 
+//CanTransitionTo return true if the user can be moved to state
+func (u *User) CanTransitionTo(state string) bool {
+	for _, s := range userStateTransitions[u.State] {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+//IsValidUserState return true if state is a state of the lifecycle
+func IsValidUserState(state string) bool {
+	_, ok := userStateTransitions[state]
+	return ok
+}
+
+//AfterSave for gorm set email verified
+func (u *User) AfterSave() error {
+	u.EmailVerified = u.EmailVerifiedAt != nil
+	return nil
+}
+
+//AfterFind for gorm set email verified
+func (u *User) AfterFind() error {
+	u.EmailVerified = u.EmailVerifiedAt != nil
+	return nil
+}
+
 //Profile profile of user
 type Profile struct {
 	ID        uint       `gorm:"primary_key" json:"id"`
@@ -40,3 +94,12 @@ type Profile struct {
 	Company   string     `gorm:"column:company" json:"company"`
 	Location  string     `gorm:"column:location" json:"location"`
 }
+
+//PasswordHistory is a previous password of user
+type PasswordHistory struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	UserID   string `gorm:"column:user_id;index" json:"user_id"`
+	Password string `gorm:"column:password" json:"-"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/rs/xid"
+)
+
+//actions of audit events
+const (
+	AuditImpersonationStart   = "impersonation.start"
+	AuditImpersonationRequest = "impersonation.request"
+)
+
+//AuditEvent records an action of actor on user
+type AuditEvent struct {
+	ID        string    `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+
+	Action    string `gorm:"column:action;index" json:"action"`
+	ActorID   string `gorm:"column:actor_id;index" json:"actor_id"`
+	UserID    string `gorm:"column:user_id;index" json:"user_id"` //the user acted on
+	Method    string `gorm:"column:method" json:"method,omitempty"`
+	Path      string `gorm:"column:path" json:"path,omitempty"`
+	Status    int    `gorm:"column:status" json:"status,omitempty"`
+	ClientIP  string `gorm:"column:client_ip" json:"client_ip"`
+	UserAgent string `gorm:"column:user_agent" json:"user_agent"`
+}
+
+//BeforeCreate for gorm set id
+func (e *AuditEvent) BeforeCreate(scope *gorm.Scope) error {
+	return scope.SetColumn("id", xid.New().String())
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+//FederatedIdentity links the subject of an upstream identity provider or ldap to user
+type FederatedIdentity struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Provider string `gorm:"column:provider;unique_index:idx_federated_identity_subject" json:"provider"`
+	Subject  string `gorm:"column:subject;unique_index:idx_federated_identity_subject" json:"subject"`
+	UserID   string `gorm:"column:user_id;index" json:"user_id"`
+	Email    string `gorm:"column:email" json:"email"` //email of the upstream identity when it was linked
+}
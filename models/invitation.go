@@ -0,0 +1,70 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/rs/xid"
+)
+
+//invitation status
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationRevoked  = "revoked"
+	InvitationExpired  = "expired"
+)
+
+//Invitation is a registration invite created by admin, the roles are added to the user at signup
+type Invitation struct {
+	ID        string    `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Email      string     `gorm:"column:email;index" json:"email"`
+	Roles      string     `gorm:"column:roles" json:"roles"` //pre-assigned roles, space separated
+	TokenHash  string     `gorm:"column:token_hash;unique_index" json:"-"`
+	InviterID  string     `gorm:"column:inviter_id" json:"inviter_id"`
+	ExpiresAt  time.Time  `gorm:"column:expires_at" json:"expires_at"`
+	AcceptedAt *time.Time `gorm:"column:accepted_at" json:"accepted_at,omitempty"`
+	UserID     string     `gorm:"column:user_id" json:"user_id,omitempty"` //the user registered by it
+	RevokedAt  *time.Time `gorm:"column:revoked_at" json:"revoked_at,omitempty"`
+
+	Status string `gorm:"-" json:"status"`
+}
+
+//BeforeCreate for gorm set id
+func (i *Invitation) BeforeCreate(s *gorm.Scope) error {
+	return s.SetColumn("id", xid.New().String())
+}
+
+//AfterSave for gorm set status
+func (i *Invitation) AfterSave() error {
+	i.Status = i.StatusAt(time.Now())
+	return nil
+}
+
+//AfterFind for gorm set status
+func (i *Invitation) AfterFind() error {
+	i.Status = i.StatusAt(time.Now())
+	return nil
+}
+
+//StatusAt get the status of invitation at the time
+func (i *Invitation) StatusAt(now time.Time) string {
+	switch {
+	case i.AcceptedAt != nil:
+		return InvitationAccepted
+	case i.RevokedAt != nil:
+		return InvitationRevoked
+	case !now.Before(i.ExpiresAt):
+		return InvitationExpired
+	}
+	return InvitationPending
+}
+
+//RoleList get the pre-assigned roles
+func (i *Invitation) RoleList() []string {
+	return strings.Fields(i.Roles)
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+//TOTP is the totp authenticator of user
+type TOTP struct {
+	UserID    string    `gorm:"primary_key" json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Secret   string `gorm:"column:secret" json:"-"`
+	Enabled  bool   `gorm:"column:enabled" json:"enabled"`
+	LastStep int64  `gorm:"column:last_step" json:"-"` //last accepted time step, a code cannot be used twice
+}
+
+//RecoveryCode one-time recovery code of user, only the hash of code is stored
+type RecoveryCode struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	UserID   string     `gorm:"column:user_id;index" json:"user_id"`
+	CodeHash string     `gorm:"column:code_hash;index" json:"-"`
+	UsedAt   *time.Time `gorm:"column:used_at" json:"used_at,omitempty"`
+}
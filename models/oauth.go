@@ -0,0 +1,49 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/rs/xid"
+)
+
+//OAuthClient is an application which logs in users by oauth2, the id is the client_id
+type OAuthClient struct {
+	ID        string    `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Name         string `gorm:"column:name" json:"name"`
+	OwnerID      string `gorm:"column:owner_id;index" json:"owner_id"`
+	Public       bool   `gorm:"column:public" json:"public"` //public clients have no secret and must use pkce
+	SecretHash   string `gorm:"column:secret_hash" json:"-"`
+	RedirectURIs string `gorm:"column:redirect_uris" json:"redirect_uris"` //space separated
+	Scopes       string `gorm:"column:scopes" json:"scopes"`               //allowed scopes, space separated
+}
+
+//BeforeCreate for gorm set id
+func (c *OAuthClient) BeforeCreate(s *gorm.Scope) error {
+	return s.SetColumn("id", xid.New().String())
+}
+
+//RedirectURIList get the registered redirect uris
+func (c *OAuthClient) RedirectURIList() []string {
+	return strings.Fields(c.RedirectURIs)
+}
+
+//ScopeList get the allowed scopes
+func (c *OAuthClient) ScopeList() []string {
+	return strings.Fields(c.Scopes)
+}
+
+//OAuthConsent is the scopes which the user has granted to the client
+type OAuthConsent struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID   string `gorm:"column:user_id;unique_index:idx_oauth_consent_user_client" json:"user_id"`
+	ClientID string `gorm:"column:client_id;unique_index:idx_oauth_consent_user_client" json:"client_id"`
+	Scopes   string `gorm:"column:scopes" json:"scopes"` //space separated
+}
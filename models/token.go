@@ -0,0 +1,101 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/rs/xid"
+)
+
+//RefreshToken refresh token of user, only the hash of token is stored
+type RefreshToken struct {
+	ID        string    `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID    string     `gorm:"column:user_id;index" json:"user_id"`
+	FamilyID  string     `gorm:"column:family_id;index" json:"family_id"`
+	AMR       string     `gorm:"column:amr" json:"amr"`                             //authentication methods of the family, comma separated
+	ClientID  string     `gorm:"column:client_id;index" json:"client_id,omitempty"` //oauth client, empty for first party login
+	Scope     string     `gorm:"column:scope" json:"scope,omitempty"`               //oauth scopes, space separated
+	AuthTime  time.Time  `gorm:"column:auth_time" json:"auth_time"`                 //when the user authenticated
+	TokenHash string     `gorm:"column:token_hash;unique_index" json:"-"`
+	ExpiresAt time.Time  `gorm:"column:expires_at" json:"expires_at"`
+	UsedAt    *time.Time `gorm:"column:used_at" json:"used_at,omitempty"`
+	RevokedAt *time.Time `gorm:"column:revoked_at" json:"revoked_at,omitempty"`
+}
+
+//BeforeCreate for gorm set id
+func (t *RefreshToken) BeforeCreate(s *gorm.Scope) error {
+	return s.SetColumn("id", xid.New().String())
+}
+
+//Session is a login of user on a device, the tokens of session are the refresh token family of it
+type Session struct {
+	ID        string    `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID     string     `gorm:"column:user_id;index" json:"user_id"`
+	FamilyID   string     `gorm:"column:family_id;unique_index" json:"family_id"`
+	AMR        string     `gorm:"column:amr" json:"amr"` //authentication methods of login, comma separated
+	UserAgent  string     `gorm:"column:user_agent" json:"user_agent"`
+	ClientIP   string     `gorm:"column:client_ip" json:"client_ip"` //ip of the last request
+	LastSeenAt time.Time  `gorm:"column:last_seen_at" json:"last_seen_at"`
+	ExpiresAt  time.Time  `gorm:"column:expires_at" json:"expires_at"` //expiration of the latest refresh token
+	RevokedAt  *time.Time `gorm:"column:revoked_at" json:"revoked_at,omitempty"`
+
+	Current bool `gorm:"-" json:"current"` //the session of request
+}
+
+//BeforeCreate for gorm set id
+func (s *Session) BeforeCreate(scope *gorm.Scope) error {
+	return scope.SetColumn("id", xid.New().String())
+}
+
+//PersonalAccessToken long-lived token of user for scripts, only the hash of token is stored
+type PersonalAccessToken struct {
+	ID        string    `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID      string     `gorm:"column:user_id;index" json:"user_id"`
+	Name        string     `gorm:"column:name" json:"name"`
+	TokenHash   string     `gorm:"column:token_hash;unique_index" json:"-"`
+	Permissions string     `gorm:"column:permissions;type:text" json:"-"` //json of []Permission, empty for all permissions of user
+	ExpiresAt   *time.Time `gorm:"column:expires_at" json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time `gorm:"column:last_used_at" json:"last_used_at,omitempty"`
+	RevokedAt   *time.Time `gorm:"column:revoked_at" json:"revoked_at,omitempty"`
+
+	PermissionList []Permission `gorm:"-" json:"permissions"`
+}
+
+//BeforeCreate for gorm set id
+func (t *PersonalAccessToken) BeforeCreate(s *gorm.Scope) error {
+	return s.SetColumn("id", xid.New().String())
+}
+
+//BeforeSave for gorm encode permissions
+func (t *PersonalAccessToken) BeforeSave() error {
+	if len(t.PermissionList) == 0 {
+		t.Permissions = ""
+		return nil
+	}
+
+	byts, err := json.Marshal(t.PermissionList)
+	if err != nil {
+		return err
+	}
+	t.Permissions = string(byts)
+	return nil
+}
+
+//AfterFind for gorm decode permissions
+func (t *PersonalAccessToken) AfterFind() error {
+	if t.Permissions == "" {
+		t.PermissionList = nil
+		return nil
+	}
+	return json.Unmarshal([]byte(t.Permissions), &t.PermissionList)
+}
@@ -1,22 +1,32 @@
 package bigcache
 
 import (
+	"encoding/binary"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/allegro/bigcache"
 	"github.com/ngs24313/gopu/utils/cache"
 )
 
+//deadlineSize is the size of the expiration deadline stored before each value
+const deadlineSize = 8
+
 type bigCache struct {
 	cache  *bigcache.BigCache
 	option cache.Options
+
+	//mu serializes the counters, the other entities are set by bigcache directly
+	mu sync.Mutex
 }
 
-//NewCache create a bigcache 
+//NewCache create a bigcache
 func NewCache() cache.Cache {
 	return &bigCache{
 		option: cache.Options{
-			Expiration: time.Minute * 10,
+			Expiration:    time.Minute * 10,
+			MaxExpiration: time.Hour * 24 * 7,
 		},
 	}
 }
@@ -26,7 +36,13 @@ func (c *bigCache) Init(opts ...cache.Option) error {
 		o(&c.option)
 	}
 
-	conf := bigcache.DefaultConfig(c.option.Expiration)
+	//bigcache evicts entries by a global life window, so the window must cover
+	//the longest expiration, shorter expiration is checked by the deadline of entry
+	lifeWindow := c.option.Expiration
+	if c.option.MaxExpiration > lifeWindow {
+		lifeWindow = c.option.MaxExpiration
+	}
+	conf := bigcache.DefaultConfig(lifeWindow)
 
 	cache , err := bigcache.NewBigCache(conf)
 	if  err != nil {
@@ -45,14 +61,20 @@ func (c *bigCache) Get(key string) (*cache.Entity, error) {
 		}
 		return nil, err
 	}
+
+	value, ok := c.unwrap(byt)
+	if !ok {
+		_ = c.cache.Delete(c.withPrefix(key))
+		return nil, cache.ErrNotFound
+	}
 	return &cache.Entity{
 		Key: key,
-		Value: byt,
+		Value: value,
 	}, nil
 }
 
 func (c *bigCache) Set(e *cache.Entity) error {
-	return c.cache.Set(c.withPrefix(e.Key), e.Value)
+	return c.cache.Set(c.withPrefix(e.Key), c.wrap(e))
 }
 
 func (c *bigCache) Del(key string) error {
@@ -65,6 +87,37 @@ func (c *bigCache) Del(key string) error {
 	return nil
 }
 
+func (c *bigCache) Incr(key string, delta int64, expiration time.Duration) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var count int64
+	byt, err := c.cache.Get(c.withPrefix(key))
+	if err != nil && err != bigcache.ErrEntryNotFound {
+		return 0, err
+	}
+
+	//the deadline of counter is kept while it is alive
+	if value, ok := c.unwrap(byt); ok {
+		if count, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+			return 0, err
+		}
+		count += delta
+		byt = append(byt[:deadlineSize:deadlineSize], strconv.FormatInt(count, 10)...)
+	} else {
+		count = delta
+		byt = c.wrap(&cache.Entity{
+			Value:      []byte(strconv.FormatInt(count, 10)),
+			Expiration: expiration,
+		})
+	}
+
+	if err := c.cache.Set(c.withPrefix(key), byt); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
 func (c *bigCache) List() ([]*cache.Entity, error) {
 	entitys := make([]*cache.Entity, 0)
 
@@ -74,9 +127,13 @@ func (c *bigCache) List() ([]*cache.Entity, error) {
 		if err != nil {
 			break
 		}
+		value, ok := c.unwrap(entity.Value())
+		if !ok {
+			continue
+		}
 		entitys = append(entitys, &cache.Entity{
 			Key: entity.Key(),
-			Value: entity.Value(),
+			Value: value,
 		})
 	}
 	return entitys, nil
@@ -84,4 +141,30 @@ func (c *bigCache) List() ([]*cache.Entity, error) {
 
 func (c *bigCache) withPrefix(key string) string {
 	return c.option.Prefix + key
-}
\ No newline at end of file
+}
+
+//wrap prepends the expiration deadline of entity to its value
+func (c *bigCache) wrap(e *cache.Entity) []byte {
+	expiration := e.Expiration
+	if expiration <= 0 {
+		expiration = c.option.Expiration
+	}
+
+	byt := make([]byte, deadlineSize+len(e.Value))
+	binary.BigEndian.PutUint64(byt, uint64(time.Now().Add(expiration).UnixNano()))
+	copy(byt[deadlineSize:], e.Value)
+	return byt
+}
+
+//unwrap returns the value of entity, ok is false if the entity is expired
+func (c *bigCache) unwrap(byt []byte) ([]byte, bool) {
+	if len(byt) < deadlineSize {
+		return nil, false
+	}
+
+	deadline := int64(binary.BigEndian.Uint64(byt))
+	if time.Now().UnixNano() >= deadline {
+		return nil, false
+	}
+	return byt[deadlineSize:], true
+}
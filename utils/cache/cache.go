@@ -12,9 +12,10 @@ var (
 
 //Options  options
 type Options struct {
-	Prefix     string        `json:"prefix"`
-	Expiration time.Duration `json:"expiration"`
-	DSN        string        `json:"dsn"`
+	Prefix        string        `json:"prefix"`
+	Expiration    time.Duration `json:"expiration"`
+	MaxExpiration time.Duration `json:"max_expiration"` //the longest expiration of entity
+	DSN           string        `json:"dsn"`
 }
 
 //Option option for Options
@@ -34,6 +35,9 @@ type Cache interface {
 	Set(e *Entity) error
 	Del(key string) error
 	List() ([]*Entity, error)
+	//Incr atomically adds delta to the counter of key and return the new count, the counter is created with
+	//expiration if it does not exist or is expired. The value of counter is its decimal count
+	Incr(key string, delta int64, expiration time.Duration) (int64, error)
 }
 
 //WithPrefix with prefix
@@ -50,6 +54,13 @@ func WithExpiration(expiration time.Duration) Option {
 	}
 }
 
+//WithMaxExpiration with the longest expiration of entity
+func WithMaxExpiration(expiration time.Duration) Option {
+	return func(o *Options) {
+		o.MaxExpiration = expiration
+	}
+}
+
 //WithDSN for network connect
 func WithDSN(dsn string) Option {
 	return func(o *Options) {
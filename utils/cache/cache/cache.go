@@ -6,6 +6,9 @@ import (
 	"github.com/ngs24313/gopu/config"
 	"github.com/ngs24313/gopu/utils/cache"
 	"github.com/ngs24313/gopu/utils/cache/bigcache"
+	dbcache "github.com/ngs24313/gopu/utils/cache/database"
+	"github.com/ngs24313/gopu/utils/database"
+	"github.com/ngs24313/gopu/utils/database/gorm"
 )
 
 var (
@@ -18,6 +21,12 @@ func Init(c *config.Config) error {
 	switch cacheConf.Type {
 	case "memory":
 		defaultCache = bigcache.NewCache()
+	case "database":
+		d, ok := database.Database().(gorm.Database)
+		if !ok {
+			panic(fmt.Sprintf("cache type [%s] is not supported by the database", cacheConf.Type))
+		}
+		defaultCache = dbcache.NewCache(d.Instance())
 	default:
 		panic(fmt.Sprintf("cache type [%s] is not support", cacheConf.Type))
 	}
@@ -25,6 +34,7 @@ func Init(c *config.Config) error {
 	if err := defaultCache.Init(
 		cache.WithDSN(cacheConf.DSN),
 		cache.WithExpiration(cacheConf.Expiration),
+		cache.WithMaxExpiration(cacheConf.MaxExpiration),
 		cache.WithPrefix(cacheConf.Prefix)); err != nil {
 		return err
 	}
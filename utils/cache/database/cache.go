@@ -0,0 +1,188 @@
+package database
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/ngs24313/gopu/utils/cache"
+	"github.com/ngs24313/gopu/utils/log"
+	"go.uber.org/zap"
+)
+
+const (
+	//incrRetries is the times to increase a counter which is created by others meanwhile
+	incrRetries = 2
+	//cleanInterval is the interval of deleting the expired entities
+	cleanInterval = 5 * time.Minute
+)
+
+//entry is the row of a cache entity, the count of counter is kept in its own column to be increased atomically
+type entry struct {
+	Key       string    `gorm:"column:cache_key;primary_key;size:255"`
+	Value     []byte    `gorm:"column:value"`
+	Counter   *int64    `gorm:"column:counter"`
+	ExpiresAt time.Time `gorm:"column:expires_at;index"`
+}
+
+//TableName for gorm
+func (entry) TableName() string {
+	return "cache_entries"
+}
+
+type databaseCache struct {
+	db     *gorm.DB
+	option cache.Options
+}
+
+//NewCache create a cache stored in the database, it is shared by the instances of the same database
+func NewCache(db *gorm.DB) cache.Cache {
+	return &databaseCache{
+		db: db,
+		option: cache.Options{
+			Expiration: time.Minute * 10,
+		},
+	}
+}
+
+func (c *databaseCache) Init(opts ...cache.Option) error {
+	for _, o := range opts {
+		o(&c.option)
+	}
+	if err := c.db.AutoMigrate(&entry{}).Error; err != nil {
+		return err
+	}
+
+	go c.clean()
+	return nil
+}
+
+func (c *databaseCache) Get(key string) (*cache.Entity, error) {
+	var e entry
+	if err := c.db.Where("cache_key = ? AND expires_at > ?", c.withPrefix(key), time.Now()).
+		First(&e).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, cache.ErrNotFound
+		}
+		return nil, err
+	}
+
+	value := e.Value
+	if e.Counter != nil {
+		value = []byte(strconv.FormatInt(*e.Counter, 10))
+	}
+	return &cache.Entity{
+		Key:   key,
+		Value: value,
+	}, nil
+}
+
+func (c *databaseCache) Set(e *cache.Entity) error {
+	return c.db.Save(&entry{
+		Key:       c.withPrefix(e.Key),
+		Value:     e.Value,
+		ExpiresAt: c.deadline(e.Expiration),
+	}).Error
+}
+
+func (c *databaseCache) Del(key string) error {
+	db := c.db.Where("cache_key = ?", c.withPrefix(key)).Delete(&entry{})
+	if err := db.Error; err != nil {
+		return err
+	}
+	if db.RowsAffected == 0 {
+		return cache.ErrNotFound
+	}
+	return nil
+}
+
+func (c *databaseCache) List() ([]*cache.Entity, error) {
+	var entries []entry
+	if err := c.db.Where("cache_key LIKE ? AND expires_at > ?", c.option.Prefix+"%", time.Now()).
+		Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	entitys := make([]*cache.Entity, 0, len(entries))
+	for _, e := range entries {
+		value := e.Value
+		if e.Counter != nil {
+			value = []byte(strconv.FormatInt(*e.Counter, 10))
+		}
+		entitys = append(entitys, &cache.Entity{
+			Key:   e.Key,
+			Value: value,
+		})
+	}
+	return entitys, nil
+}
+
+func (c *databaseCache) Incr(key string, delta int64, expiration time.Duration) (int64, error) {
+	key = c.withPrefix(key)
+
+	var (
+		count int64
+		err   error
+	)
+	for i := 0; i < incrRetries; i++ {
+		err = c.db.Transaction(func(tx *gorm.DB) error {
+			now := time.Now()
+
+			//the row is locked by the update until the count is read
+			result := tx.Model(&entry{}).
+				Where("cache_key = ? AND expires_at > ? AND counter IS NOT NULL", key, now).
+				UpdateColumn("counter", gorm.Expr("counter + ?", delta))
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				var e entry
+				if err := tx.Where("cache_key = ?", key).First(&e).Error; err != nil {
+					return err
+				}
+				count = *e.Counter
+				return nil
+			}
+
+			//the expired entity or the entity set by Set is replaced
+			if err := tx.Where("cache_key = ?", key).Delete(&entry{}).Error; err != nil {
+				return err
+			}
+			count = delta
+			return tx.Create(&entry{
+				Key:       key,
+				Counter:   &count,
+				ExpiresAt: c.deadline(expiration),
+			}).Error
+		})
+		if err == nil {
+			return count, nil
+		}
+	}
+	//the counter is created by others at the same time as the last retry
+	return 0, err
+}
+
+//clean deletes the expired entities periodically, they are never returned but kept until then
+func (c *databaseCache) clean() {
+	ticker := time.NewTicker(cleanInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := c.db.Where("expires_at <= ?", time.Now()).Delete(&entry{}).Error; err != nil {
+			log.Logger(context.Background()).Warn("Failed to delete expired cache", zap.Error(err))
+		}
+	}
+}
+
+func (c *databaseCache) withPrefix(key string) string {
+	return c.option.Prefix + key
+}
+
+func (c *databaseCache) deadline(expiration time.Duration) time.Time {
+	if expiration <= 0 {
+		expiration = c.option.Expiration
+	}
+	return time.Now().Add(expiration)
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/ngs24313/gopu/utils/cache"
+)
+
+func newTestCache(t *testing.T) (cache.Cache, *gorm.DB) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//every connection has its own in-memory database
+	db.DB().SetMaxOpenConns(1)
+
+	c := NewCache(db)
+	if err := c.Init(cache.WithPrefix("test.")); err != nil {
+		t.Fatal(err)
+	}
+	return c, db
+}
+
+func TestCache(t *testing.T) {
+	c, db := newTestCache(t)
+	defer db.Close()
+
+	if err := c.Set(&cache.Entity{Key: "a", Value: []byte("1"), Expiration: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(&cache.Entity{Key: "a", Value: []byte("2"), Expiration: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	if e, err := c.Get("a"); err != nil || string(e.Value) != "2" {
+		t.Errorf("Get() = %v, %v, want 2", e, err)
+	}
+
+	entities, err := c.List()
+	if err != nil || len(entities) != 1 || entities[0].Key != "test.a" {
+		t.Errorf("List() = %v, %v, want test.a", entities, err)
+	}
+
+	if err := c.Del("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get("a"); err != cache.ErrNotFound {
+		t.Errorf("Get() of deleted = %v, want %v", err, cache.ErrNotFound)
+	}
+	if err := c.Del("a"); err != cache.ErrNotFound {
+		t.Errorf("Del() of deleted = %v, want %v", err, cache.ErrNotFound)
+	}
+
+	//the expired entity is not returned
+	if err := db.Create(&entry{Key: "test.b", Value: []byte("1"), ExpiresAt: time.Now().Add(-time.Second)}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get("b"); err != cache.ErrNotFound {
+		t.Errorf("Get() of expired = %v, want %v", err, cache.ErrNotFound)
+	}
+}
+
+func TestCacheIncr(t *testing.T) {
+	c, db := newTestCache(t)
+	defer db.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Incr("n", 1, time.Minute); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n, err := c.Incr("n", -1, time.Minute); err != nil || n != 9 {
+		t.Errorf("Incr() = %d, %v, want 9", n, err)
+	}
+	if e, err := c.Get("n"); err != nil || string(e.Value) != "9" {
+		t.Errorf("Get() of counter = %v, %v, want 9", e, err)
+	}
+
+	//the expired counter and the entity set by Set start from delta
+	if err := db.Model(&entry{}).Where("cache_key = ?", "test.n").
+		Update("expires_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.Incr("n", 2, time.Minute); err != nil || n != 2 {
+		t.Errorf("Incr() of expired = %d, %v, want 2", n, err)
+	}
+	if err := c.Set(&cache.Entity{Key: "s", Value: []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.Incr("s", 1, time.Minute); err != nil || n != 1 {
+		t.Errorf("Incr() of entity = %d, %v, want 1", n, err)
+	}
+}
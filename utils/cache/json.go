@@ -0,0 +1,28 @@
+package cache
+
+import (
+	"encoding/json"
+	"time"
+)
+
+//GetJSON get the entity by key and decode its value into v
+func GetJSON(c Cache, key string, v interface{}) error {
+	entity, err := c.Get(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(entity.Value, v)
+}
+
+//SetJSON encode v and set it to cache with expiration
+func SetJSON(c Cache, key string, v interface{}, expiration time.Duration) error {
+	byt, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Set(&Entity{
+		Key:        key,
+		Value:      byt,
+		Expiration: expiration,
+	})
+}
@@ -0,0 +1,177 @@
+package keyset
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+//JWK is a public key in JSON Web Key format, see RFC 7517
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+
+	//RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	//EC and OKP
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+//JWKS is the JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+//JWKS get the public keys of key set, symmetric keys are never published
+func (s *KeySet) JWKS() *JWKS {
+	jwks := &JWKS{
+		Keys: make([]JWK, 0, len(s.keys)),
+	}
+
+	for _, key := range s.keys {
+		jwk := JWK{
+			KeyID:     key.ID,
+			Use:       "sig",
+			Algorithm: key.Algorithm,
+		}
+
+		switch k := key.publicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = encodeBigInt(k.N, 0)
+			jwk.E = encodeBigInt(big.NewInt(int64(k.E)), 0)
+		case *ecdsa.PublicKey:
+			size := (k.Curve.Params().BitSize + 7) / 8
+			jwk.KeyType = "EC"
+			jwk.Curve = k.Curve.Params().Name
+			jwk.X = encodeBigInt(k.X, size)
+			jwk.Y = encodeBigInt(k.Y, size)
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(k)
+		default:
+			continue
+		}
+
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
+
+//NewVerifier create a verification only key set of jwks, keys which are not used to sign or cannot be
+//parsed are skipped, the key set cannot sign tokens
+func NewVerifier(jwks *JWKS) *KeySet {
+	set := &KeySet{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		publicKey, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+
+		if jwk.Algorithm != "" && checkAlgorithm(jwk.Algorithm, publicKey) != nil {
+			continue
+		}
+
+		set.keys = append(set.keys, &Key{
+			ID:        jwk.KeyID,
+			Algorithm: jwk.Algorithm,
+			publicKey: publicKey,
+		})
+	}
+	return set
+}
+
+//PublicKey parse the public key of jwk
+func (k *JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 || e.Int64() < 3 {
+			return nil, errors.New("keyset: invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("keyset: unsupported curve [%s]", k.Curve)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("keyset: point is not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("keyset: unsupported curve [%s]", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("keyset: invalid ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("keyset: unsupported key type [%s]", k.KeyType)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	byts, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(byts) == 0 {
+		return nil, errors.New("keyset: empty integer")
+	}
+	return new(big.Int).SetBytes(byts), nil
+}
+
+//encodeBigInt encode n as base64url, left padded with zero to size bytes
+func encodeBigInt(n *big.Int, size int) string {
+	byts := n.Bytes()
+	if len(byts) < size {
+		padded := make([]byte, size)
+		copy(padded[size-len(byts):], byts)
+		byts = padded
+	}
+	return base64.RawURLEncoding.EncodeToString(byts)
+}
@@ -0,0 +1,262 @@
+package keyset
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/ngs24313/gopu/config"
+)
+
+var (
+	//ErrNoSigningKey no key can be used to sign tokens
+	ErrNoSigningKey = errors.New("keyset: no signing key")
+	//ErrUnknownKey the kid of token is not in the key set
+	ErrUnknownKey = errors.New("keyset: unknown key id")
+	//ErrAlgorithmMismatch the alg of token does not match its key
+	ErrAlgorithmMismatch = errors.New("keyset: signing algorithm does not match the key")
+)
+
+//Key is a key to sign or verify tokens
+type Key struct {
+	ID        string
+	Algorithm string
+
+	privateKey interface{} //nil for verification only keys
+	publicKey  interface{}
+}
+
+//CanSign return true if the key has private key
+func (k *Key) CanSign() bool {
+	return k.privateKey != nil
+}
+
+//Symmetric return true if the key is a shared secret, the tokens signed by it cannot be verified by others
+func (k *Key) Symmetric() bool {
+	_, ok := k.publicKey.([]byte)
+	return ok
+}
+
+//KeySet is the signing key and the verification keys of tokens
+type KeySet struct {
+	signing *Key
+	keys    []*Key
+}
+
+//NewHMAC create a key set of a HS256 secret
+func NewHMAC(id string, secret []byte) *KeySet {
+	key := &Key{
+		ID:         id,
+		Algorithm:  jwt.SigningMethodHS256.Alg(),
+		privateKey: secret,
+		publicKey:  secret,
+	}
+	return &KeySet{
+		signing: key,
+		keys:    []*Key{key},
+	}
+}
+
+//Load load keys from pem files, tokens are signed by the key of signingID or the first key with private key,
+//all keys are used to verify tokens so that keys can be rotated
+func Load(keys []config.SigningKey, signingID string) (*KeySet, error) {
+	set := &KeySet{}
+
+	for _, conf := range keys {
+		key, err := loadKey(conf)
+		if err != nil {
+			return nil, fmt.Errorf("keyset: load key [%s]: %v", conf.ID, err)
+		}
+
+		for _, k := range set.keys {
+			if k.ID == key.ID {
+				return nil, fmt.Errorf("keyset: duplicated key id [%s]", key.ID)
+			}
+		}
+		set.keys = append(set.keys, key)
+
+		if set.signing == nil && key.CanSign() && (signingID == "" || signingID == key.ID) {
+			set.signing = key
+		}
+	}
+
+	if set.signing == nil {
+		return nil, ErrNoSigningKey
+	}
+	return set, nil
+}
+
+//SigningKey get the key to sign tokens
+func (s *KeySet) SigningKey() *Key {
+	return s.signing
+}
+
+//Sign sign the claims by signing key, the key id is set to kid header. ErrNoSigningKey is returned
+//for the verification only key sets
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if s.signing == nil {
+		return "", ErrNoSigningKey
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(s.signing.Algorithm), claims)
+	if s.signing.ID != "" {
+		token.Header["kid"] = s.signing.ID
+	}
+	return token.SignedString(s.signing.privateKey)
+}
+
+//Parse parse and verify the token by the key of its kid header
+func (s *KeySet) Parse(token string) (*jwt.Token, error) {
+	return jwt.Parse(token, s.keyFunc)
+}
+
+func (s *KeySet) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	var key *Key
+	if kid == "" && len(s.keys) == 1 {
+		key = s.keys[0]
+	}
+	for _, k := range s.keys {
+		if kid != "" && k.ID == kid {
+			key = k
+			break
+		}
+	}
+
+	if key == nil {
+		return nil, ErrUnknownKey
+	}
+
+	//keys of jwks may omit alg, the alg of token must match the key type then
+	if key.Algorithm == "" {
+		if err := checkAlgorithm(t.Method.Alg(), key.publicKey); err != nil {
+			return nil, ErrAlgorithmMismatch
+		}
+	} else if t.Method.Alg() != key.Algorithm {
+		return nil, ErrAlgorithmMismatch
+	}
+	return key.publicKey, nil
+}
+
+func loadKey(conf config.SigningKey) (*Key, error) {
+	if conf.ID == "" {
+		return nil, errors.New("key id is empty")
+	}
+
+	key := &Key{
+		ID:        conf.ID,
+		Algorithm: conf.Algorithm,
+	}
+
+	if conf.PrivateKeyPath != "" {
+		privateKey, err := readPrivateKey(conf.PrivateKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		key.privateKey = privateKey
+		key.publicKey = privateKey.Public()
+	}
+
+	if conf.PublicKeyPath != "" {
+		publicKey, err := readPublicKey(conf.PublicKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		key.publicKey = publicKey
+	}
+
+	if key.publicKey == nil {
+		return nil, errors.New("private key path and public key path are both empty")
+	}
+
+	if err := checkAlgorithm(key.Algorithm, key.publicKey); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+//checkAlgorithm return an error if the algorithm cannot be used with public key
+func checkAlgorithm(alg string, publicKey crypto.PublicKey) error {
+	var ok bool
+	switch {
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		_, ok = publicKey.(*rsa.PublicKey)
+	case strings.HasPrefix(alg, "ES"):
+		_, ok = publicKey.(*ecdsa.PublicKey)
+	case alg == SigningMethodEdDSA.Alg():
+		_, ok = publicKey.(ed25519.PublicKey)
+	default:
+		return fmt.Errorf("unsupported algorithm [%s]", alg)
+	}
+
+	if !ok || jwt.GetSigningMethod(alg) == nil {
+		return fmt.Errorf("algorithm [%s] does not match the key type %T", alg, publicKey)
+	}
+	return nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	byts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(byts)
+	if block == nil {
+		return nil, fmt.Errorf("no pem data in %s", path)
+	}
+	return block, nil
+}
+
+func readPrivateKey(path string) (crypto.Signer, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
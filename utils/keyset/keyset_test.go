@@ -0,0 +1,296 @@
+package keyset
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/ngs24313/gopu/config"
+)
+
+//testKeys generate the keys of tests once, rsa keys are slow to generate
+var testKeys = map[string]crypto.Signer{}
+
+func testKey(t *testing.T, kind string) crypto.Signer {
+	if key, ok := testKeys[kind]; ok {
+		return key
+	}
+
+	var (
+		key crypto.Signer
+		err error
+	)
+	switch kind {
+	case "rsa":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ec":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	testKeys[kind] = key
+	return key
+}
+
+//writeKey write the private key and the public key of kind to dir, return their paths
+func writeKey(t *testing.T, dir string, id string, kind string) (string, string) {
+	key := testKey(t, kind)
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privatePath := filepath.Join(dir, id+".key")
+	publicPath := filepath.Join(dir, id+".pub")
+	for path, block := range map[string]*pem.Block{
+		privatePath: {Type: "PRIVATE KEY", Bytes: privateDER},
+		publicPath:  {Type: "PUBLIC KEY", Bytes: publicDER},
+	} {
+		if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return privatePath, publicPath
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "keyset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{"sub": "user1"}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		kind string
+		alg  string
+		kty  string
+	}{
+		{"rsa", "RS256", "RSA"},
+		{"rsa", "PS256", "RSA"},
+		{"ec", "ES256", "EC"},
+		{"ed25519", "EdDSA", "OKP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			privatePath, _ := writeKey(t, dir, tt.alg, tt.kind)
+			set, err := Load([]config.SigningKey{{ID: tt.alg, Algorithm: tt.alg, PrivateKeyPath: privatePath}}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token, err := set.Sign(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parsed, err := set.Parse(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.Header["kid"] != tt.alg || parsed.Claims.(jwt.MapClaims)["sub"] != "user1" {
+				t.Errorf("Parse() = %v %v", parsed.Header, parsed.Claims)
+			}
+
+			//the published keys verify the tokens without the private keys
+			jwks := set.JWKS()
+			if len(jwks.Keys) != 1 || jwks.Keys[0].KeyType != tt.kty || jwks.Keys[0].Algorithm != tt.alg {
+				t.Fatalf("JWKS() = %+v", jwks)
+			}
+
+			byts, err := json.Marshal(jwks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var published JWKS
+			if err := json.Unmarshal(byts, &published); err != nil {
+				t.Fatal(err)
+			}
+
+			verifier := NewVerifier(&published)
+			if _, err := verifier.Parse(token); err != nil {
+				t.Errorf("Parse() by jwks = %v", err)
+			}
+			if _, err := verifier.Sign(testClaims()); err == nil {
+				t.Error("Sign() by verifier succeeded")
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	oldPrivate, oldPublic := writeKey(t, dir, "old", "ec")
+	newPrivate, _ := writeKey(t, dir, "new", "ed25519")
+
+	before, err := Load([]config.SigningKey{{ID: "old", Algorithm: "ES256", PrivateKeyPath: oldPrivate}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := before.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the old key is kept to verify the tokens issued before rotating
+	after, err := Load([]config.SigningKey{
+		{ID: "old", Algorithm: "ES256", PublicKeyPath: oldPublic},
+		{ID: "new", Algorithm: "EdDSA", PrivateKeyPath: newPrivate},
+	}, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.SigningKey().ID != "new" {
+		t.Fatalf("signing key = %s, want new", after.SigningKey().ID)
+	}
+
+	newToken, err := after.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		if _, err := after.Parse(token); err != nil {
+			t.Errorf("Parse() of %s token = %v", name, err)
+		}
+	}
+
+	if _, err := before.Parse(newToken); !isInner(err, ErrUnknownKey) {
+		t.Errorf("Parse() of unknown kid = %v, want %v", err, ErrUnknownKey)
+	}
+	if keys := after.JWKS().Keys; len(keys) != 2 {
+		t.Errorf("JWKS() after rotating = %d keys, want 2", len(keys))
+	}
+}
+
+func TestRejectAlgorithmConfusion(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	privatePath, publicPath := writeKey(t, dir, "rsa", "rsa")
+	set, err := Load([]config.SigningKey{{ID: "rsa", Algorithm: "RS256", PrivateKeyPath: privatePath}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//a HS256 token keyed by the public key must not pass as the rsa key
+	publicPEM, err := ioutil.ReadFile(publicPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	forged.Header["kid"] = "rsa"
+	token, err := forged.SignedString(publicPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := set.Parse(token); !isInner(err, ErrAlgorithmMismatch) {
+		t.Errorf("Parse() of HS256 token = %v, want %v", err, ErrAlgorithmMismatch)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	rsaPrivate, rsaPublic := writeKey(t, dir, "rsa", "rsa")
+
+	tests := []struct {
+		name string
+		keys []config.SigningKey
+		id   string
+	}{
+		{"empty key id", []config.SigningKey{{Algorithm: "RS256", PrivateKeyPath: rsaPrivate}}, ""},
+		{"algorithm of other key type", []config.SigningKey{{ID: "a", Algorithm: "ES256", PrivateKeyPath: rsaPrivate}}, ""},
+		{"unsupported algorithm", []config.SigningKey{{ID: "a", Algorithm: "HS256", PrivateKeyPath: rsaPrivate}}, ""},
+		{"no key path", []config.SigningKey{{ID: "a", Algorithm: "RS256"}}, ""},
+		{"missing file", []config.SigningKey{{ID: "a", Algorithm: "RS256", PrivateKeyPath: filepath.Join(dir, "none")}}, ""},
+		{"duplicated key id", []config.SigningKey{
+			{ID: "a", Algorithm: "RS256", PrivateKeyPath: rsaPrivate},
+			{ID: "a", Algorithm: "RS256", PublicKeyPath: rsaPublic},
+		}, ""},
+		{"public key only", []config.SigningKey{{ID: "a", Algorithm: "RS256", PublicKeyPath: rsaPublic}}, ""},
+		{"unknown signing id", []config.SigningKey{{ID: "a", Algorithm: "RS256", PrivateKeyPath: rsaPrivate}}, "b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Load(tt.keys, tt.id); err == nil {
+				t.Error("Load() succeeded")
+			}
+		})
+	}
+}
+
+func TestHMACIsNotPublished(t *testing.T) {
+	set := NewHMAC("", []byte("secret"))
+
+	token, err := set.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := set.Parse(token); err != nil {
+		t.Fatal(err)
+	}
+	if keys := set.JWKS().Keys; len(keys) != 0 {
+		t.Errorf("JWKS() of hmac = %+v, want no keys", keys)
+	}
+}
+
+func TestJWKPublicKeyErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		jwk  JWK
+	}{
+		{"unknown key type", JWK{KeyType: "oct"}},
+		{"rsa without modulus", JWK{KeyType: "RSA", E: "AQAB"}},
+		{"rsa small exponent", JWK{KeyType: "RSA", N: "AQAB", E: "AQ"}},
+		{"unsupported curve", JWK{KeyType: "EC", Curve: "P-192", X: "AQ", Y: "AQ"}},
+		{"point not on curve", JWK{KeyType: "EC", Curve: "P-256", X: "AQ", Y: "AQ"}},
+		{"short ed25519 key", JWK{KeyType: "OKP", Curve: "Ed25519", X: "AQ"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.jwk.PublicKey(); err == nil {
+				t.Error("PublicKey() succeeded")
+			}
+		})
+	}
+}
+
+//isInner return true if err is the validation error of the key func error target
+func isInner(err error, target error) bool {
+	validationErr, ok := err.(*jwt.ValidationError)
+	return ok && validationErr.Inner == target
+}
@@ -21,3 +21,8 @@ func Init(conf *config.Config) error {
 func Send(msg *Message) error {
 	return defaultMailer.Send(msg)
 }
+
+//SetMailer replace the default mailer, the tests use it to catch the sent messages
+func SetMailer(m Mailer) {
+	defaultMailer = m
+}
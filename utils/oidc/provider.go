@@ -0,0 +1,390 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/ngs24313/gopu/config"
+	"github.com/ngs24313/gopu/utils/keyset"
+)
+
+const (
+	//ScopeOpenID is always requested from the provider
+	ScopeOpenID = "openid"
+
+	//keysRefreshInterval limits the refresh of jwks when the kid of id token is unknown
+	keysRefreshInterval = time.Minute
+	maxResponseSize     = 1 << 20
+)
+
+var (
+	//ErrInvalidIDToken id token of provider is not valid
+	ErrInvalidIDToken = errors.New("oidc: id token is invalid")
+)
+
+//Metadata is the discovery document of provider
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+//Claims is the identity of user in the id token of provider
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+}
+
+//Error is the error response of token endpoint
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	if e.Description != "" {
+		return "oidc: " + e.Code + ": " + e.Description
+	}
+	return "oidc: " + e.Code
+}
+
+//Provider is an upstream openid connect provider, users are logged in by authorization code flow with pkce
+type Provider struct {
+	conf     config.IdentityProvider
+	client   *http.Client
+	timeFunc func() time.Time
+
+	mu          sync.Mutex
+	metadata    *Metadata
+	keys        *keyset.KeySet
+	keysFetched time.Time
+}
+
+//ProviderOption is the option of provider
+type ProviderOption func(p *Provider)
+
+//WithHTTPClient set the http client to request the provider
+func WithHTTPClient(client *http.Client) ProviderOption {
+	return func(p *Provider) {
+		p.client = client
+	}
+}
+
+//WithTimeFunc set the time func to verify id tokens
+func WithTimeFunc(f func() time.Time) ProviderOption {
+	return func(p *Provider) {
+		p.timeFunc = f
+	}
+}
+
+//NewProvider create a provider, the discovery document is fetched on first use
+func NewProvider(conf config.IdentityProvider, opts ...ProviderOption) (*Provider, error) {
+	if conf.Name == "" || conf.Issuer == "" || conf.ClientID == "" {
+		return nil, fmt.Errorf("oidc: name, issuer and client id of provider [%s] are required", conf.Name)
+	}
+	conf.Issuer = strings.TrimSuffix(conf.Issuer, "/")
+
+	p := &Provider{
+		conf:     conf,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		timeFunc: time.Now,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p, nil
+}
+
+//Name get the name of provider
+func (p *Provider) Name() string {
+	return p.conf.Name
+}
+
+//Config get the config of provider
+func (p *Provider) Config() config.IdentityProvider {
+	return p.conf
+}
+
+//CodeChallenge get the S256 pkce challenge of verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+//AuthCodeURL get the authorization url which the user is redirected to
+func (p *Provider) AuthCodeURL(ctx context.Context, redirectURI, state, nonce, codeVerifier string) (string, error) {
+	metadata, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	scopes := []string{ScopeOpenID}
+	for _, scope := range p.conf.Scopes {
+		if scope != ScopeOpenID {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.conf.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", strings.Join(scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", CodeChallenge(codeVerifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+//Exchange exchanges the code for the id token and verifies it
+func (p *Provider) Exchange(ctx context.Context, redirectURI, code, codeVerifier, nonce string) (*Claims, error) {
+	metadata, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.conf.ClientID), url.QueryEscape(p.conf.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		Error
+		IDToken string `json:"id_token"`
+	}
+	if err := decodeJSON(resp.Body, &token); err != nil {
+		return nil, fmt.Errorf("oidc: token response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if token.Code == "" {
+			return nil, fmt.Errorf("oidc: token endpoint replies %s", resp.Status)
+		}
+		return nil, &token.Error
+	}
+
+	if token.IDToken == "" {
+		return nil, errors.New("oidc: token response has no id token")
+	}
+	return p.Verify(ctx, token.IDToken, nonce)
+}
+
+//Verify verifies the signature, issuer, audience, expiration and nonce of id token
+func (p *Provider) Verify(ctx context.Context, idToken string, nonce string) (*Claims, error) {
+	metadata, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := p.parse(ctx, idToken)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidIDToken
+	}
+
+	if !claims.VerifyIssuer(metadata.Issuer, true) ||
+		!claims.VerifyExpiresAt(p.timeFunc().Unix(), true) {
+		return nil, ErrInvalidIDToken
+	}
+
+	aud := claimStrings(claims["aud"])
+	if !contains(aud, p.conf.ClientID) {
+		return nil, ErrInvalidIDToken
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != p.conf.ClientID {
+		return nil, ErrInvalidIDToken
+	}
+
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, ErrInvalidIDToken
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, ErrInvalidIDToken
+	}
+
+	result := &Claims{
+		Subject: subject,
+	}
+	result.Email, _ = claims["email"].(string)
+	result.PreferredUsername, _ = claims["preferred_username"].(string)
+	result.Name, _ = claims["name"].(string)
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		result.EmailVerified = verified
+	case string:
+		result.EmailVerified = verified == "true"
+	}
+	if p.conf.TrustEmail && result.Email != "" {
+		result.EmailVerified = true
+	}
+	return result, nil
+}
+
+//Metadata get the discovery document of provider, the issuer of document must be the configured issuer
+func (p *Provider) Metadata(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var metadata Metadata
+	if err := p.get(ctx, p.conf.Issuer+"/.well-known/openid-configuration", &metadata); err != nil {
+		return nil, fmt.Errorf("oidc: discovery of [%s]: %v", p.conf.Name, err)
+	}
+
+	if strings.TrimSuffix(metadata.Issuer, "/") != p.conf.Issuer {
+		return nil, fmt.Errorf("oidc: issuer [%s] of discovery does not match [%s]", metadata.Issuer, p.conf.Issuer)
+	}
+
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: discovery of [%s] has no endpoints", p.conf.Name)
+	}
+
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+//parse parse the id token by the keys of provider, the keys are refreshed if the kid is unknown
+func (p *Provider) parse(ctx context.Context, idToken string) (*jwt.Token, error) {
+	keys, err := p.keySet(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := keys.Parse(idToken)
+	if ve, ok := err.(*jwt.ValidationError); ok && ve.Inner == keyset.ErrUnknownKey {
+		if keys, err = p.keySet(ctx, true); err != nil {
+			return nil, err
+		}
+		token, err = keys.Parse(idToken)
+	}
+
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	return token, nil
+}
+
+func (p *Provider) keySet(ctx context.Context, refresh bool) (*keyset.KeySet, error) {
+	metadata, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys != nil && (!refresh || p.timeFunc().Sub(p.keysFetched) < keysRefreshInterval) {
+		return p.keys, nil
+	}
+
+	var jwks keyset.JWKS
+	if err := p.get(ctx, metadata.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("oidc: jwks of [%s]: %v", p.conf.Name, err)
+	}
+
+	p.keys = keyset.NewVerifier(&jwks)
+	p.keysFetched = p.timeFunc()
+	return p.keys, nil
+}
+
+func (p *Provider) get(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s replies %s", u, resp.Status)
+	}
+	return decodeJSON(resp.Body, v)
+}
+
+func decodeJSON(r io.Reader, v interface{}) error {
+	byts, err := ioutil.ReadAll(io.LimitReader(r, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(byts, v)
+}
+
+//claimStrings get the strings of claim which is a string or an array of strings
+func claimStrings(v interface{}) []string {
+	switch value := v.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
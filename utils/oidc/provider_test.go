@@ -0,0 +1,320 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/ngs24313/gopu/config"
+	"github.com/ngs24313/gopu/utils/keyset"
+)
+
+const (
+	testClientID     = "gopu"
+	testClientSecret = "client secret"
+	testNonce        = "n-0S6_WzA2Mj"
+	testCode         = "code1"
+	testVerifier     = "verifier-of-at-least-forty-three-characters-long"
+)
+
+//standin is an openid connect provider of tests, it issues the id token of claims for the test code
+type standin struct {
+	*httptest.Server
+	t      *testing.T
+	key    *rsa.PrivateKey
+	kid    string
+	claims jwt.MapClaims
+
+	issuer     string //issuer of discovery, the url of server if empty
+	challenge  string //code challenge of the authorization request
+	jwksServed int
+}
+
+func newStandin(t *testing.T) *standin {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &standin{t: t, key: key, kid: "key1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := s.issuer
+		if issuer == "" {
+			issuer = s.URL
+		}
+		json.NewEncoder(w).Encode(&Metadata{
+			Issuer:                issuer,
+			AuthorizationEndpoint: s.URL + "/authorize",
+			TokenEndpoint:         s.URL + "/token",
+			JWKSURI:               s.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		s.jwksServed++
+		json.NewEncoder(w).Encode(&keyset.JWKS{Keys: []keyset.JWK{{
+			KeyType: "RSA",
+			KeyID:   s.kid,
+			N:       jwt.EncodeSegment(s.key.N.Bytes()),
+			E:       "AQAB",
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		//the credentials of basic auth are form encoded, see RFC 6749 2.3.1
+		id, secret, _ := r.BasicAuth()
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+		r.ParseForm()
+		if id != testClientID || secret != testClientSecret || r.PostForm.Get("code") != testCode ||
+			CodeChallenge(r.PostForm.Get("code_verifier")) != s.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(&Error{Code: "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": s.idToken(s.claims), "token_type": "Bearer"})
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+//validClaims get the claims of a valid id token at now
+func (s *standin) validClaims(now time.Time) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":   s.URL,
+		"sub":   "upstream-user",
+		"aud":   testClientID,
+		"exp":   now.Add(time.Minute).Unix(),
+		"iat":   now.Unix(),
+		"nonce": testNonce,
+		"email": "alice@example.com",
+	}
+}
+
+func (s *standin) idToken(claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.kid
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return signed
+}
+
+func (s *standin) provider(t *testing.T, now time.Time, trustEmail bool) *Provider {
+	return s.providerAt(t, func() time.Time { return now }, trustEmail)
+}
+
+func (s *standin) providerAt(t *testing.T, timeFunc func() time.Time, trustEmail bool) *Provider {
+	p, err := NewProvider(config.IdentityProvider{
+		Name:         "corp",
+		Issuer:       s.URL + "/",
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		TrustEmail:   trustEmail,
+	}, WithTimeFunc(timeFunc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestVerify(t *testing.T) {
+	s := newStandin(t)
+	defer s.Close()
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		change func(claims jwt.MapClaims)
+		nonce  string
+		ok     bool
+	}{
+		{name: "valid", nonce: testNonce, ok: true},
+		{name: "audience in array", nonce: testNonce, ok: true, change: func(c jwt.MapClaims) {
+			c["aud"] = []string{"other", testClientID}
+			c["azp"] = testClientID
+		}},
+		{name: "wrong nonce", nonce: "other", ok: false},
+		{name: "missing nonce", nonce: testNonce, ok: false, change: func(c jwt.MapClaims) {
+			delete(c, "nonce")
+		}},
+		{name: "other audience", nonce: testNonce, ok: false, change: func(c jwt.MapClaims) {
+			c["aud"] = "other"
+		}},
+		{name: "authorized party of other client", nonce: testNonce, ok: false, change: func(c jwt.MapClaims) {
+			c["aud"] = []string{"other", testClientID}
+			c["azp"] = "other"
+		}},
+		{name: "other issuer", nonce: testNonce, ok: false, change: func(c jwt.MapClaims) {
+			c["iss"] = "https://evil.example.com"
+		}},
+		{name: "expired", nonce: testNonce, ok: false, change: func(c jwt.MapClaims) {
+			c["exp"] = now.Add(-time.Minute).Unix()
+		}},
+		{name: "no subject", nonce: testNonce, ok: false, change: func(c jwt.MapClaims) {
+			delete(c, "sub")
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := s.validClaims(now)
+			if tt.change != nil {
+				tt.change(claims)
+			}
+
+			result, err := s.provider(t, now, false).Verify(context.Background(), s.idToken(claims), tt.nonce)
+			if !tt.ok {
+				if err != ErrInvalidIDToken {
+					t.Errorf("Verify() = %v, want %v", err, ErrInvalidIDToken)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Subject != "upstream-user" || result.Email != "alice@example.com" {
+				t.Errorf("Verify() = %+v", result)
+			}
+		})
+	}
+}
+
+func TestVerifyEmailVerified(t *testing.T) {
+	s := newStandin(t)
+	defer s.Close()
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		verified   interface{}
+		trustEmail bool
+		want       bool
+	}{
+		{"not claimed", nil, false, false},
+		{"bool claim", true, false, true},
+		{"string claim", "true", false, true},
+		{"false claim", false, false, false},
+		{"trusted provider", nil, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := s.validClaims(now)
+			if tt.verified != nil {
+				claims["email_verified"] = tt.verified
+			}
+
+			result, err := s.provider(t, now, tt.trustEmail).Verify(context.Background(), s.idToken(claims), testNonce)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.EmailVerified != tt.want {
+				t.Errorf("EmailVerified = %v, want %v", result.EmailVerified, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyKeyRotation(t *testing.T) {
+	s := newStandin(t)
+	defer s.Close()
+	now := time.Now()
+	ctx := context.Background()
+	p := s.providerAt(t, func() time.Time { return now }, false)
+
+	//the claims outlive the clock moving past the refresh interval
+	claims := s.validClaims(now)
+	claims["exp"] = now.Add(time.Hour).Unix()
+
+	if _, err := p.Verify(ctx, s.idToken(claims), testNonce); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.key, s.kid = key, "key2"
+
+	//the jwks is not fetched again within the refresh interval
+	if _, err := p.Verify(ctx, s.idToken(claims), testNonce); err != ErrInvalidIDToken {
+		t.Errorf("Verify() of unknown kid = %v, want %v", err, ErrInvalidIDToken)
+	}
+	if s.jwksServed != 1 {
+		t.Errorf("jwks fetched %d times, want 1", s.jwksServed)
+	}
+
+	//but is fetched for the unknown kid after it
+	now = now.Add(keysRefreshInterval)
+	if _, err := p.Verify(ctx, s.idToken(claims), testNonce); err != nil {
+		t.Fatalf("Verify() after rotating = %v", err)
+	}
+	if s.jwksServed != 2 {
+		t.Errorf("jwks fetched %d times, want 2", s.jwksServed)
+	}
+}
+
+func TestVerifyRejectsUnsignedToken(t *testing.T) {
+	s := newStandin(t)
+	defer s.Close()
+	now := time.Now()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodNone, s.validClaims(now))
+	token.Header["kid"] = s.kid
+	unsigned, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.provider(t, now, false).Verify(context.Background(), unsigned, testNonce); err != ErrInvalidIDToken {
+		t.Errorf("Verify() of unsigned token = %v, want %v", err, ErrInvalidIDToken)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	s := newStandin(t)
+	defer s.Close()
+	now := time.Now()
+	ctx := context.Background()
+	p := s.provider(t, now, false)
+
+	s.challenge = CodeChallenge(testVerifier)
+	s.claims = s.validClaims(now)
+
+	result, err := p.Exchange(ctx, "https://gopu.example.com/callback", testCode, testVerifier, testNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Subject != "upstream-user" {
+		t.Errorf("Exchange() = %+v", result)
+	}
+
+	_, err = p.Exchange(ctx, "https://gopu.example.com/callback", testCode, "wrong verifier", testNonce)
+	if e, ok := err.(*Error); !ok || e.Code != "invalid_grant" {
+		t.Errorf("Exchange() of wrong verifier = %v, want invalid_grant", err)
+	}
+
+	if _, err := p.Exchange(ctx, "https://gopu.example.com/callback", testCode, testVerifier, "other"); err != ErrInvalidIDToken {
+		t.Errorf("Exchange() of other nonce = %v, want %v", err, ErrInvalidIDToken)
+	}
+}
+
+func TestMetadataIssuerMismatch(t *testing.T) {
+	s := newStandin(t)
+	defer s.Close()
+
+	s.issuer = "https://evil.example.com"
+	p := s.provider(t, time.Now(), false)
+
+	if _, err := p.Metadata(context.Background()); err == nil {
+		t.Error("Metadata() of other issuer succeeded")
+	}
+}
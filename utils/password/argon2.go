@@ -0,0 +1,101 @@
+package password
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strconv"
+
+	"golang.org/x/crypto/argon2"
+)
+
+//defaults of argon2id, the second recommended option of owasp
+const (
+	argon2DefaultTime    = 2
+	argon2DefaultMemory  = 19 * 1024
+	argon2DefaultThreads = 1
+	argon2KeySize        = 32
+)
+
+//Argon2Hasher hashes passwords by argon2id
+type Argon2Hasher struct {
+	time    uint32
+	memory  uint32 //KiB
+	threads uint8
+}
+
+//NewArgon2Hasher create argon2id hasher, the defaults are used for 0
+func NewArgon2Hasher(time uint32, memory uint32, threads uint8) *Argon2Hasher {
+	if time == 0 {
+		time = argon2DefaultTime
+	}
+	if memory == 0 {
+		memory = argon2DefaultMemory
+	}
+	if threads == 0 {
+		threads = argon2DefaultThreads
+	}
+	return &Argon2Hasher{
+		time:    time,
+		memory:  memory,
+		threads: threads,
+	}
+}
+
+//ID get the ids of argon2id hashes
+func (a *Argon2Hasher) ID() []string {
+	return []string{"argon2id"}
+}
+
+//Hash generate $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>
+func (a *Argon2Hasher) Hash(pwd string) (string, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(pwd), salt, a.time, a.memory, a.threads, argon2KeySize)
+	return formatPHC("argon2id", strconv.Itoa(argon2.Version),
+		fmt.Sprintf("m=%d,t=%d,p=%d", a.memory, a.time, a.threads), salt, key), nil
+}
+
+//Verify compares a hashed password with plaintext
+func (a *Argon2Hasher) Verify(hash string, pwd string) (bool, error) {
+	p, memory, time, threads, err := parseArgon2(hash)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(pwd), p.salt, time, memory, threads, uint32(len(p.hash)))
+	return subtle.ConstantTimeCompare(key, p.hash) == 1, nil
+}
+
+//NeedsRehash return true if the params of hash are not the params of hasher
+func (a *Argon2Hasher) NeedsRehash(hash string) bool {
+	_, memory, time, threads, err := parseArgon2(hash)
+	return err != nil || memory != a.memory || time != a.time || threads != a.threads
+}
+
+func parseArgon2(hash string) (*phc, uint32, uint32, uint8, error) {
+	p, err := parsePHC(hash)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+
+	if p.version != strconv.Itoa(argon2.Version) {
+		return nil, 0, 0, 0, ErrMalformedHash
+	}
+
+	memory, err := p.intParam("m")
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	time, err := p.intParam("t")
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	threads, err := p.intParam("p")
+	if err != nil || threads > 255 {
+		return nil, 0, 0, 0, ErrMalformedHash
+	}
+	return p, uint32(memory), uint32(time), uint8(threads), nil
+}
@@ -1,24 +1,49 @@
 package password
 
 import (
-	"context"
-
-	"github.com/ngs24313/gopu/utils/log"
-	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
 )
 
-//GenHashPassword generate hashed password
-func GenHashPassword(pwd string) string {
-	hashedPwd, err := bcrypt.GenerateFromPassword([]byte(pwd), bcrypt.DefaultCost)
+//BcryptHasher hashes passwords by bcrypt, the hashes keep the standard $2a$<cost>$ format
+type BcryptHasher struct {
+	cost int
+}
+
+//NewBcryptHasher create bcrypt hasher, bcrypt.DefaultCost is used if cost is 0
+func NewBcryptHasher(cost int) *BcryptHasher {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	return &BcryptHasher{
+		cost: cost,
+	}
+}
+
+//ID get the ids of bcrypt hashes
+func (b *BcryptHasher) ID() []string {
+	return []string{"2a", "2b", "2y"}
+}
+
+//Hash generate hashed password
+func (b *BcryptHasher) Hash(pwd string) (string, error) {
+	hashedPwd, err := bcrypt.GenerateFromPassword([]byte(pwd), b.cost)
 	if err != nil {
-		log.Logger(context.Background()).Warn("Failed to generate password by bcrypt", zap.Error(err))
+		return "", err
+	}
+	return string(hashedPwd), nil
+}
+
+//Verify compares a hashed password with plaintext
+func (b *BcryptHasher) Verify(hash string, pwd string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(pwd))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
 	}
-	return string(hashedPwd)
+	return err == nil, err
 }
 
-//CompareHashPassword compares a hashed password with plaintext
-func CompareHashPassword(hashPwd string, pwd string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashPwd), []byte(pwd))
-	return err == nil
+//NeedsRehash return true if the cost of hash is not the cost of hasher
+func (b *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != b.cost
 }
@@ -0,0 +1,133 @@
+package password
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+//digests of the legacy hashes by the suffix of their ids
+var legacyDigests = map[string]func() hash.Hash{
+	"":    sha1.New,
+	"1":   sha1.New,
+	"256": sha256.New,
+	"512": sha512.New,
+}
+
+//PBKDF2Hasher verifies the pbkdf2 hashes of passlib and phc string format such as
+//$pbkdf2-sha256$29000$<salt>$<hash> or $pbkdf2-sha256$i=29000$<salt>$<hash>
+type PBKDF2Hasher struct{}
+
+//NewPBKDF2Hasher create pbkdf2 hasher, it can only verify
+func NewPBKDF2Hasher() *PBKDF2Hasher {
+	return &PBKDF2Hasher{}
+}
+
+//ID get the ids of pbkdf2 hashes
+func (p *PBKDF2Hasher) ID() []string {
+	return []string{"pbkdf2", "pbkdf2-sha1", "pbkdf2-sha256", "pbkdf2-sha512"}
+}
+
+//Hash is not supported, pbkdf2 is only for the users imported from older systems
+func (p *PBKDF2Hasher) Hash(pwd string) (string, error) {
+	return "", ErrVerifyOnly
+}
+
+//Verify compares a hashed password with plaintext
+func (p *PBKDF2Hasher) Verify(hashPwd string, pwd string) (bool, error) {
+	parts := strings.Split(hashPwd, "$")
+	if len(parts) != 5 || parts[0] != "" {
+		return false, ErrMalformedHash
+	}
+
+	digest, ok := legacyDigests[strings.TrimPrefix(strings.TrimPrefix(parts[1], "pbkdf2"), "-sha")]
+	if !ok {
+		return false, ErrMalformedHash
+	}
+
+	rounds, err := strconv.Atoi(strings.TrimPrefix(parts[2], "i="))
+	if err != nil || rounds <= 0 {
+		return false, ErrMalformedHash
+	}
+
+	salt, err := decodeB64(parts[3])
+	if err != nil {
+		return false, ErrMalformedHash
+	}
+	key, err := decodeB64(parts[4])
+	if err != nil || len(key) == 0 {
+		return false, ErrMalformedHash
+	}
+
+	derived := pbkdf2.Key([]byte(pwd), salt, rounds, len(key), digest)
+	return subtle.ConstantTimeCompare(derived, key) == 1, nil
+}
+
+//NeedsRehash always return true, the hashes are upgraded to the default hasher
+func (p *PBKDF2Hasher) NeedsRehash(hash string) bool {
+	return true
+}
+
+//SaltedSHAHasher verifies the ldap style hashes {SSHA}base64(digest+salt), the unsalted {SHA} hashes are
+//verified as well
+type SaltedSHAHasher struct{}
+
+//NewSaltedSHAHasher create salted sha hasher, it can only verify
+func NewSaltedSHAHasher() *SaltedSHAHasher {
+	return &SaltedSHAHasher{}
+}
+
+//ID get the ids of salted sha hashes
+func (s *SaltedSHAHasher) ID() []string {
+	return []string{"ssha", "ssha256", "ssha512", "sha", "sha256", "sha512"}
+}
+
+//Hash is not supported, salted sha is only for the users imported from older systems
+func (s *SaltedSHAHasher) Hash(pwd string) (string, error) {
+	return "", ErrVerifyOnly
+}
+
+//Verify compares a hashed password with plaintext
+func (s *SaltedSHAHasher) Verify(hashPwd string, pwd string) (bool, error) {
+	end := strings.Index(hashPwd, "}")
+	if !strings.HasPrefix(hashPwd, "{") || end < 0 {
+		return false, ErrMalformedHash
+	}
+
+	id := strings.ToLower(hashPwd[1:end])
+	salted := strings.HasPrefix(id, "ssha")
+	digest, ok := legacyDigests[strings.TrimPrefix(strings.TrimPrefix(id, "s"), "sha")]
+	if !salted {
+		digest, ok = legacyDigests[strings.TrimPrefix(id, "sha")]
+	}
+	if !ok {
+		return false, ErrMalformedHash
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(hashPwd[end+1:])
+	if err != nil {
+		return false, ErrMalformedHash
+	}
+
+	h := digest()
+	size := h.Size()
+	if len(raw) < size || (!salted && len(raw) != size) {
+		return false, ErrMalformedHash
+	}
+
+	h.Write([]byte(pwd))
+	h.Write(raw[size:])
+	return subtle.ConstantTimeCompare(h.Sum(nil), raw[:size]) == 1, nil
+}
+
+//NeedsRehash always return true, the hashes are upgraded to the default hasher
+func (s *SaltedSHAHasher) NeedsRehash(hash string) bool {
+	return true
+}
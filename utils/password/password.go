@@ -0,0 +1,139 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ngs24313/gopu/config"
+)
+
+var (
+	//ErrUnknownHasher the algorithm of hash is not registered
+	ErrUnknownHasher = errors.New("password: unknown hasher")
+	//ErrVerifyOnly the hasher only verifies legacy hashes
+	ErrVerifyOnly = errors.New("password: hasher can only verify")
+	//ErrMalformedHash the hash cannot be parsed by its hasher
+	ErrMalformedHash = errors.New("password: malformed hash")
+)
+
+//Hasher hashes and verifies passwords of an algorithm, hashes are self-describing in phc string format
+//such as $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+type Hasher interface {
+	//ID get the ids of the hashes of hasher, such as argon2id
+	ID() []string
+	//Hash hash the password by the params of hasher
+	Hash(pwd string) (string, error)
+	//Verify compare the hash with password
+	Verify(hash string, pwd string) (bool, error)
+	//NeedsRehash return true if the params of hash differ from the params of hasher
+	NeedsRehash(hash string) bool
+}
+
+var (
+	mu            sync.RWMutex
+	hashers       = make(map[string]Hasher)
+	defaultHasher Hasher
+)
+
+func init() {
+	defaultHasher = NewBcryptHasher(0)
+	for _, h := range []Hasher{
+		defaultHasher,
+		NewArgon2Hasher(0, 0, 0),
+		NewScryptHasher(0, 0, 0),
+		NewPBKDF2Hasher(),
+		NewSaltedSHAHasher(),
+	} {
+		Register(h)
+	}
+}
+
+//Init set the default hasher of new passwords by config
+func Init(conf *config.Config) error {
+	hasherConf := conf.Services.Account.PasswordHasher
+
+	var h Hasher
+	switch hasherConf.Algorithm {
+	case "", "bcrypt":
+		h = NewBcryptHasher(hasherConf.BcryptCost)
+	case "argon2id":
+		h = NewArgon2Hasher(hasherConf.Argon2Time, hasherConf.Argon2Memory, hasherConf.Argon2Threads)
+	case "scrypt":
+		if hasherConf.ScryptN&(hasherConf.ScryptN-1) != 0 {
+			return fmt.Errorf("password: scrypt n [%d] is not a power of 2", hasherConf.ScryptN)
+		}
+		h = NewScryptHasher(hasherConf.ScryptN, hasherConf.ScryptR, hasherConf.ScryptP)
+	default:
+		return fmt.Errorf("password: unsupported hash algorithm [%s]", hasherConf.Algorithm)
+	}
+
+	Register(h)
+	mu.Lock()
+	defaultHasher = h
+	mu.Unlock()
+	return nil
+}
+
+//Register register the hasher for its ids, the hasher of the same id is replaced
+func Register(h Hasher) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, id := range h.ID() {
+		hashers[id] = h
+	}
+}
+
+//GenHashPassword hash the password by the default hasher
+func GenHashPassword(pwd string) (string, error) {
+	mu.RLock()
+	h := defaultHasher
+	mu.RUnlock()
+	return h.Hash(pwd)
+}
+
+//CompareHashPassword compares a hashed password of any registered hasher with plaintext
+func CompareHashPassword(hashPwd string, pwd string) bool {
+	h, err := hasherOf(hashPwd)
+	if err != nil {
+		return false
+	}
+
+	ok, err := h.Verify(hashPwd, pwd)
+	return err == nil && ok
+}
+
+//NeedsRehash return true if the hash is not hashed by the default hasher with its current params
+func NeedsRehash(hashPwd string) bool {
+	h, err := hasherOf(hashPwd)
+	if err != nil {
+		return true
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	return h != defaultHasher || h.NeedsRehash(hashPwd)
+}
+
+//hasherOf find the hasher by the id of hash, $id$... for phc strings and {ID} for ldap style hashes
+func hasherOf(hash string) (Hasher, error) {
+	var id string
+	switch {
+	case strings.HasPrefix(hash, "$"):
+		parts := strings.SplitN(hash[1:], "$", 2)
+		id = parts[0]
+	case strings.HasPrefix(hash, "{"):
+		if end := strings.Index(hash, "}"); end > 0 {
+			id = strings.ToLower(hash[1:end])
+		}
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	h, ok := hashers[id]
+	if !ok {
+		return nil, ErrUnknownHasher
+	}
+	return h, nil
+}
@@ -0,0 +1,201 @@
+package password
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/ngs24313/gopu/config"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+//withDefaultHasher set the default hasher by conf and return the func to restore it
+func withDefaultHasher(t *testing.T, conf config.PasswordHasher) func() {
+	mu.RLock()
+	before := defaultHasher
+	mu.RUnlock()
+
+	c := &config.Config{}
+	c.Services.Account.PasswordHasher = conf
+	if err := Init(c); err != nil {
+		t.Fatal(err)
+	}
+
+	return func() {
+		Register(before)
+		mu.Lock()
+		defaultHasher = before
+		mu.Unlock()
+	}
+}
+
+func TestHashers(t *testing.T) {
+	//small params keep the tests fast
+	tests := []struct {
+		name   string
+		hasher Hasher
+		other  Hasher //same algorithm with other params
+		prefix string
+	}{
+		{"bcrypt", NewBcryptHasher(4), NewBcryptHasher(5), "$2a$04$"},
+		{"argon2id", NewArgon2Hasher(1, 64, 1), NewArgon2Hasher(2, 64, 1), "$argon2id$v=19$m=64,t=1,p=1$"},
+		{"scrypt", NewScryptHasher(16, 1, 1), NewScryptHasher(32, 1, 1), "$scrypt$ln=4,r=1,p=1$"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := tt.hasher.Hash("correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(hash, tt.prefix) {
+				t.Fatalf("Hash() = %s, want prefix %s", hash, tt.prefix)
+			}
+
+			other, err := tt.hasher.Hash("correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if other == hash {
+				t.Error("Hash() is not salted")
+			}
+
+			for pwd, want := range map[string]bool{"correct horse": true, "correct horsE": false, "": false} {
+				if ok, err := tt.hasher.Verify(hash, pwd); err != nil || ok != want {
+					t.Errorf("Verify(%q) = %v, %v, want %v", pwd, ok, err, want)
+				}
+			}
+
+			//the params of hash are used to verify it
+			if ok, err := tt.other.Verify(hash, "correct horse"); err != nil || !ok {
+				t.Errorf("Verify() by other params = %v, %v, want true", ok, err)
+			}
+
+			if tt.hasher.NeedsRehash(hash) {
+				t.Error("NeedsRehash() of same params = true")
+			}
+			if !tt.other.NeedsRehash(hash) {
+				t.Error("NeedsRehash() of other params = false")
+			}
+		})
+	}
+}
+
+func TestLegacyHashers(t *testing.T) {
+	salt := []byte("saltsaltsalt")
+	b64 := base64.RawStdEncoding.EncodeToString
+
+	pbkdf2SHA256 := pbkdf2.Key([]byte("password"), salt, 1000, 32, sha256.New)
+	ssha512 := sha512.Sum512(append([]byte("password"), salt...))
+
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"pbkdf2 of passlib", "$pbkdf2-sha256$1000$" + b64(salt) + "$" + strings.Replace(b64(pbkdf2SHA256), "+", ".", -1)},
+		{"pbkdf2 of phc", "$pbkdf2-sha256$i=1000$" + b64(salt) + "$" + b64(pbkdf2SHA256)},
+		{"unsalted sha", "{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g="},
+		{"salted sha512", "{SSHA512}" + base64.StdEncoding.EncodeToString(append(ssha512[:], salt...))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !CompareHashPassword(tt.hash, "password") {
+				t.Error("CompareHashPassword() of right password = false")
+			}
+			if CompareHashPassword(tt.hash, "Password") {
+				t.Error("CompareHashPassword() of wrong password = true")
+			}
+			if !NeedsRehash(tt.hash) {
+				t.Error("NeedsRehash() of legacy hash = false")
+			}
+		})
+	}
+
+	for _, h := range []Hasher{NewPBKDF2Hasher(), NewSaltedSHAHasher()} {
+		if _, err := h.Hash("password"); err != ErrVerifyOnly {
+			t.Errorf("Hash() of %T = %v, want %v", h, err, ErrVerifyOnly)
+		}
+	}
+}
+
+func TestMalformedHashes(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"plaintext",
+		"$unknown$x$y$z",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=64,t=1,p=256$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdA$aGFzaA",
+		"$scrypt$ln=40,r=1,p=1$c2FsdA$aGFzaA",
+		"$scrypt$ln=4,r=1$c2FsdA$aGFzaA",
+		"$scrypt$ln=4,r=1,p=1$!!$aGFzaA",
+		"$pbkdf2-md5$1000$c2FsdA$aGFzaA",
+		"$pbkdf2-sha256$-1$c2FsdA$aGFzaA",
+		"$2a$04$short",
+		"{SSHA}not base64",
+		"{SHA}c2hvcnQ=",
+		"{MD5}c2hvcnQ=",
+	} {
+		if CompareHashPassword(hash, "") {
+			t.Errorf("CompareHashPassword(%q) = true", hash)
+		}
+		if !NeedsRehash(hash) {
+			t.Errorf("NeedsRehash(%q) = false", hash)
+		}
+	}
+}
+
+func TestDefaultHasher(t *testing.T) {
+	bcryptHash, err := GenHashPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if NeedsRehash(bcryptHash) {
+		t.Fatal("NeedsRehash() of default hash = true")
+	}
+
+	restore := withDefaultHasher(t, config.PasswordHasher{Algorithm: "argon2id", Argon2Time: 1, Argon2Memory: 64})
+	defer restore()
+
+	//the hashes of other algorithms still verify but are upgraded
+	if !CompareHashPassword(bcryptHash, "password") {
+		t.Error("CompareHashPassword() of bcrypt hash = false")
+	}
+	if !NeedsRehash(bcryptHash) {
+		t.Error("NeedsRehash() of bcrypt hash = false")
+	}
+
+	argon2Hash, err := GenHashPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(argon2Hash, "$argon2id$") || NeedsRehash(argon2Hash) {
+		t.Errorf("GenHashPassword() = %s, NeedsRehash() = %v", argon2Hash, NeedsRehash(argon2Hash))
+	}
+
+	//the hashes of the old params are upgraded too
+	stale, err := NewArgon2Hasher(2, 64, 1).Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !NeedsRehash(stale) {
+		t.Error("NeedsRehash() of old params = false")
+	}
+}
+
+func TestInitErrors(t *testing.T) {
+	for _, conf := range []config.PasswordHasher{
+		{Algorithm: "md5"},
+		{Algorithm: "scrypt", ScryptN: 1000},
+	} {
+		c := &config.Config{}
+		c.Services.Account.PasswordHasher = conf
+		if err := Init(c); err == nil {
+			t.Errorf("Init(%+v) succeeded", conf)
+		}
+	}
+}
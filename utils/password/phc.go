@@ -0,0 +1,91 @@
+package password
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//saltSize is the random bytes of the salts of new hashes
+const saltSize = 16
+
+//phc is a hash in phc string format $<id>[$v=<version>]$<param>=<value>(,<param>=<value>)*$<salt>$<hash>
+type phc struct {
+	id      string
+	version string
+	params  map[string]string
+	salt    []byte
+	hash    []byte
+}
+
+func parsePHC(s string) (*phc, error) {
+	parts := strings.Split(s, "$")
+	if len(parts) < 5 || parts[0] != "" {
+		return nil, ErrMalformedHash
+	}
+
+	p := &phc{
+		id:     parts[1],
+		params: make(map[string]string),
+	}
+
+	rest := parts[2:]
+	if strings.HasPrefix(rest[0], "v=") {
+		p.version = rest[0][2:]
+		rest = rest[1:]
+	}
+	if len(rest) != 3 {
+		return nil, ErrMalformedHash
+	}
+
+	for _, param := range strings.Split(rest[0], ",") {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			return nil, ErrMalformedHash
+		}
+		p.params[kv[0]] = kv[1]
+	}
+
+	var err error
+	if p.salt, err = decodeB64(rest[1]); err != nil {
+		return nil, ErrMalformedHash
+	}
+	if p.hash, err = decodeB64(rest[2]); err != nil || len(p.hash) == 0 {
+		return nil, ErrMalformedHash
+	}
+	return p, nil
+}
+
+//intParam get the param as int, it must be positive
+func (p *phc) intParam(name string) (int, error) {
+	v, err := strconv.Atoi(p.params[name])
+	if err != nil || v <= 0 {
+		return 0, ErrMalformedHash
+	}
+	return v, nil
+}
+
+func formatPHC(id string, version string, params string, salt []byte, hash []byte) string {
+	if version != "" {
+		id += "$v=" + version
+	}
+	return fmt.Sprintf("$%s$%s$%s$%s", id, params,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash))
+}
+
+//decodeB64 decodes the unpadded base64 of phc strings, the . of passlib is accepted for +
+func decodeB64(s string) ([]byte, error) {
+	s = strings.TrimRight(strings.Replace(s, ".", "+", -1), "=")
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+func newSalt() ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
@@ -0,0 +1,254 @@
+package policy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ngs24313/gopu/config"
+	"github.com/ngs24313/gopu/utils/password"
+)
+
+//rules of policy
+const (
+	RuleMinLength = "min_length"
+	RuleMaxLength = "max_length"
+	RuleUpper     = "upper"
+	RuleLower     = "lower"
+	RuleDigit     = "digit"
+	RuleSymbol    = "symbol"
+	RuleClasses   = "classes"
+	RuleUserInfo  = "user_info"
+	RuleDenylist  = "denylist"
+	RuleHistory   = "history"
+)
+
+//userInfoMinLength is the min length of the user info which passwords cannot contain
+const userInfoMinLength = 3
+
+//Violation is a rule which the password breaks
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+//Policy checks passwords by the rules of config
+type Policy struct {
+	conf     config.PasswordPolicy
+	denylist map[string]bool //lower case passwords
+	hashes   map[string]bool //upper case sha-1 of passwords
+}
+
+//New create policy, the denylist file is loaded here
+func New(conf config.PasswordPolicy) (*Policy, error) {
+	p := &Policy{
+		conf:     conf,
+		denylist: make(map[string]bool),
+		hashes:   make(map[string]bool),
+	}
+
+	if conf.Denylist != "" {
+		if err := p.loadDenylist(conf.Denylist); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+//loadDenylist loads the passwords of file, the lines of 40 hex digits with optional :count are sha-1 hashes
+func (p *Policy) loadDenylist(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if hash := strings.SplitN(line, ":", 2)[0]; isSHA1(hash) {
+			p.hashes[strings.ToUpper(hash)] = true
+			continue
+		}
+		p.denylist[strings.ToLower(line)] = true
+	}
+	return scanner.Err()
+}
+
+//Validate return the violations of password, the password cannot contain the user infos such as username and email
+func (p *Policy) Validate(pwd string, userInfos ...string) ([]Violation, error) {
+	violations := make([]Violation, 0)
+	add := func(rule string, format string, a ...interface{}) {
+		violations = append(violations, Violation{
+			Rule:    rule,
+			Message: fmt.Sprintf(format, a...),
+		})
+	}
+
+	length := utf8.RuneCountInString(pwd)
+	if p.conf.MinLength > 0 && length < p.conf.MinLength {
+		add(RuleMinLength, "Password must be at least %d characters", p.conf.MinLength)
+	}
+	if p.conf.MaxLength > 0 && length > p.conf.MaxLength {
+		add(RuleMaxLength, "Password must be at most %d characters", p.conf.MaxLength)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range pwd {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r):
+			symbol = true
+		}
+	}
+
+	for _, class := range []struct {
+		required bool
+		has      bool
+		rule     string
+		name     string
+	}{
+		{p.conf.RequireUpper, upper, RuleUpper, "an uppercase letter"},
+		{p.conf.RequireLower, lower, RuleLower, "a lowercase letter"},
+		{p.conf.RequireDigit, digit, RuleDigit, "a digit"},
+		{p.conf.RequireSymbol, symbol, RuleSymbol, "a symbol"},
+	} {
+		if class.required && !class.has {
+			add(class.rule, "Password must contain %s", class.name)
+		}
+	}
+
+	if p.conf.MinClasses > 0 {
+		classes := 0
+		for _, has := range []bool{upper, lower, digit, symbol} {
+			if has {
+				classes++
+			}
+		}
+		if classes < p.conf.MinClasses {
+			add(RuleClasses, "Password must contain %d of uppercase letters, lowercase letters, digits and symbols",
+				p.conf.MinClasses)
+		}
+	}
+
+	if p.conf.RejectUserInfo && p.containsUserInfo(pwd, userInfos) {
+		add(RuleUserInfo, "Password cannot contain the username or email")
+	}
+
+	denied, err := p.denied(pwd)
+	if err != nil {
+		return nil, err
+	}
+	if denied {
+		add(RuleDenylist, "Password is too common or has appeared in a data breach")
+	}
+	return violations, nil
+}
+
+//Reused return a violation if password matches any of the hashed passwords in history
+func (p *Policy) Reused(pwd string, history []string) *Violation {
+	for _, hashed := range history {
+		if password.CompareHashPassword(hashed, pwd) {
+			return &Violation{
+				Rule:    RuleHistory,
+				Message: fmt.Sprintf("Password cannot be one of the last %d passwords", p.conf.HistorySize),
+			}
+		}
+	}
+	return nil
+}
+
+//HistorySize get the number of the last passwords which cannot be reused
+func (p *Policy) HistorySize() int {
+	return p.conf.HistorySize
+}
+
+func (p *Policy) containsUserInfo(pwd string, userInfos []string) bool {
+	pwd = strings.ToLower(pwd)
+	for _, info := range userInfos {
+		info = strings.ToLower(info)
+		candidates := []string{info}
+		if at := strings.LastIndex(info, "@"); at > 0 {
+			candidates = append(candidates, info[:at])
+		}
+
+		for _, candidate := range candidates {
+			if utf8.RuneCountInString(candidate) >= userInfoMinLength && strings.Contains(pwd, candidate) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//denied return true if the password is in the denylist file or the hibp range file of its hash prefix
+func (p *Policy) denied(pwd string) (bool, error) {
+	if p.denylist[strings.ToLower(pwd)] {
+		return true, nil
+	}
+
+	sum := sha1.Sum([]byte(pwd))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	if p.hashes[hash] {
+		return true, nil
+	}
+
+	if p.conf.DenylistDir == "" {
+		return false, nil
+	}
+	return inRangeFile(p.conf.DenylistDir, hash)
+}
+
+//inRangeFile find the suffix of hash in the range file of its prefix, the file is named by the first 5 hex digits
+//of hash with or without .txt, its lines are the suffixes with optional :count
+func inRangeFile(dir string, hash string) (bool, error) {
+	prefix, suffix := hash[:5], hash[5:]
+
+	var f *os.File
+	var err error
+	for _, name := range []string{prefix, prefix + ".txt", strings.ToLower(prefix), strings.ToLower(prefix) + ".txt"} {
+		f, err = os.Open(filepath.Join(dir, name))
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	if f == nil {
+		return false, nil
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2)[0]
+		if strings.EqualFold(line, suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+func isSHA1(s string) bool {
+	if len(s) != sha1.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,225 @@
+package policy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ngs24313/gopu/config"
+	"github.com/ngs24313/gopu/utils/password"
+)
+
+func rules(violations []Violation) []string {
+	names := make([]string, 0, len(violations))
+	for _, v := range violations {
+		names = append(names, v.Rule)
+	}
+	return names
+}
+
+func sha1Hex(pwd string) string {
+	sum := sha1.Sum([]byte(pwd))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		conf      config.PasswordPolicy
+		pwd       string
+		userInfos []string
+		want      []string
+	}{
+		{
+			name: "no rules",
+			pwd:  "a",
+			want: []string{},
+		},
+		{
+			name: "too short",
+			conf: config.PasswordPolicy{MinLength: 8},
+			pwd:  "short",
+			want: []string{RuleMinLength},
+		},
+		{
+			name: "length counts characters",
+			conf: config.PasswordPolicy{MinLength: 4, MaxLength: 4},
+			pwd:  "密码密码",
+			want: []string{},
+		},
+		{
+			name: "too long",
+			conf: config.PasswordPolicy{MaxLength: 8},
+			pwd:  "much too long",
+			want: []string{RuleMaxLength},
+		},
+		{
+			name: "missing classes",
+			conf: config.PasswordPolicy{RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true},
+			pwd:  "lowercase",
+			want: []string{RuleUpper, RuleDigit, RuleSymbol},
+		},
+		{
+			name: "all classes",
+			conf: config.PasswordPolicy{RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true},
+			pwd:  "Aa1!",
+			want: []string{},
+		},
+		{
+			name: "space is a symbol",
+			conf: config.PasswordPolicy{RequireSymbol: true},
+			pwd:  "two words",
+			want: []string{},
+		},
+		{
+			name: "too few classes",
+			conf: config.PasswordPolicy{MinClasses: 3},
+			pwd:  "Password",
+			want: []string{RuleClasses},
+		},
+		{
+			name: "enough classes",
+			conf: config.PasswordPolicy{MinClasses: 3},
+			pwd:  "Password1",
+			want: []string{},
+		},
+		{
+			name:      "contains username",
+			conf:      config.PasswordPolicy{RejectUserInfo: true},
+			pwd:       "xxALICExx",
+			userInfos: []string{"alice", "carol@example.com"},
+			want:      []string{RuleUserInfo},
+		},
+		{
+			name:      "contains local part of email",
+			conf:      config.PasswordPolicy{RejectUserInfo: true},
+			pwd:       "carol-2020",
+			userInfos: []string{"alice", "carol@example.com"},
+			want:      []string{RuleUserInfo},
+		},
+		{
+			name:      "short user info is ignored",
+			conf:      config.PasswordPolicy{RejectUserInfo: true},
+			pwd:       "jo-password",
+			userInfos: []string{"jo", "jo@example.com"},
+			want:      []string{},
+		},
+		{
+			name:      "user info is allowed",
+			pwd:       "alice-password",
+			userInfos: []string{"alice"},
+			want:      []string{},
+		},
+		{
+			name: "every violation",
+			conf: config.PasswordPolicy{MinLength: 10, RequireDigit: true, MinClasses: 2},
+			pwd:  "abc",
+			want: []string{RuleMinLength, RuleDigit, RuleClasses},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(tt.conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			violations, err := p.Validate(tt.pwd, tt.userInfos...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rules(violations); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDenylist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//the denylist file has plain passwords and sha-1 hashes with counts
+	denylist := filepath.Join(dir, "denylist.txt")
+	content := "Password1\n\n" + sha1Hex("hashed secret") + ":42\n" + strings.ToLower(sha1Hex("lower hash")) + "\n"
+	if err := ioutil.WriteFile(denylist, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	//the range files are named by the first 5 hex digits of sha-1 and hold the suffixes
+	ranges := filepath.Join(dir, "ranges")
+	if err := os.Mkdir(ranges, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, pwd := range map[string]string{"": "breached", ".txt": "leaked"} {
+		hash := sha1Hex(pwd)
+		if err := ioutil.WriteFile(filepath.Join(ranges, hash[:5]+name), []byte(hash[5:]+":3\r\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := New(config.PasswordPolicy{Denylist: denylist, DenylistDir: ranges})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		pwd    string
+		denied bool
+	}{
+		{"Password1", true},
+		{"PASSWORD1", true},
+		{"hashed secret", true},
+		{"lower hash", true},
+		{"breached", true},
+		{"leaked", true},
+		{"Password2", false},
+		{"hashed Secret", false},
+	}
+
+	for _, tt := range tests {
+		violations, err := p.Validate(tt.pwd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if denied := len(violations) == 1 && violations[0].Rule == RuleDenylist; denied != tt.denied {
+			t.Errorf("Validate(%q) = %v, want denied %v", tt.pwd, rules(violations), tt.denied)
+		}
+	}
+
+	if _, err := New(config.PasswordPolicy{Denylist: filepath.Join(dir, "none")}); err == nil {
+		t.Error("New() of missing denylist succeeded")
+	}
+}
+
+func TestReused(t *testing.T) {
+	p, err := New(config.PasswordPolicy{HistorySize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var history []string
+	for _, pwd := range []string{"first password", "second password"} {
+		hashed, err := password.GenHashPassword(pwd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		history = append(history, hashed)
+	}
+
+	if v := p.Reused("second password", history); v == nil || v.Rule != RuleHistory {
+		t.Errorf("Reused() of last password = %v, want %s", v, RuleHistory)
+	}
+	if v := p.Reused("third password", history); v != nil {
+		t.Errorf("Reused() of new password = %v, want nil", v)
+	}
+}
@@ -0,0 +1,103 @@
+package password
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"math/bits"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+//defaults of scrypt
+const (
+	scryptDefaultN = 1 << 15
+	scryptDefaultR = 8
+	scryptDefaultP = 1
+	scryptKeySize  = 32
+)
+
+//ScryptHasher hashes passwords by scrypt
+type ScryptHasher struct {
+	n int //power of 2
+	r int
+	p int
+}
+
+//NewScryptHasher create scrypt hasher, the defaults are used for 0
+func NewScryptHasher(n int, r int, p int) *ScryptHasher {
+	if n == 0 {
+		n = scryptDefaultN
+	}
+	if r == 0 {
+		r = scryptDefaultR
+	}
+	if p == 0 {
+		p = scryptDefaultP
+	}
+	return &ScryptHasher{
+		n: n,
+		r: r,
+		p: p,
+	}
+}
+
+//ID get the ids of scrypt hashes
+func (s *ScryptHasher) ID() []string {
+	return []string{"scrypt"}
+}
+
+//Hash generate $scrypt$ln=<log2 n>,r=<r>,p=<p>$<salt>$<hash>
+func (s *ScryptHasher) Hash(pwd string) (string, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return "", err
+	}
+
+	key, err := scrypt.Key([]byte(pwd), salt, s.n, s.r, s.p, scryptKeySize)
+	if err != nil {
+		return "", err
+	}
+	return formatPHC("scrypt", "",
+		fmt.Sprintf("ln=%d,r=%d,p=%d", bits.TrailingZeros(uint(s.n)), s.r, s.p), salt, key), nil
+}
+
+//Verify compares a hashed password with plaintext
+func (s *ScryptHasher) Verify(hash string, pwd string) (bool, error) {
+	p, n, r, parallel, err := parseScrypt(hash)
+	if err != nil {
+		return false, err
+	}
+
+	key, err := scrypt.Key([]byte(pwd), p.salt, n, r, parallel, len(p.hash))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, p.hash) == 1, nil
+}
+
+//NeedsRehash return true if the params of hash are not the params of hasher
+func (s *ScryptHasher) NeedsRehash(hash string) bool {
+	_, n, r, p, err := parseScrypt(hash)
+	return err != nil || n != s.n || r != s.r || p != s.p
+}
+
+func parseScrypt(hash string) (*phc, int, int, int, error) {
+	p, err := parsePHC(hash)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+
+	ln, err := p.intParam("ln")
+	if err != nil || ln >= 32 {
+		return nil, 0, 0, 0, ErrMalformedHash
+	}
+	r, err := p.intParam("r")
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	parallel, err := p.intParam("p")
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	return p, 1 << uint(ln), r, parallel, nil
+}
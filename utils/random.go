@@ -1,21 +1,24 @@
 package utils
 
 import (
-	"math/rand"
-	"time"
+	"crypto/rand"
+	"math/big"
 )
 
 var (
-	rander = rand.New(rand.NewSource(time.Now().Unix()))
+	ten = big.NewInt(10)
 )
 
-//RandomDigit random a number string, length is count
-func RandomDigit(count int) string {
+//RandomDigit random a number string from crypto/rand, length is count
+func RandomDigit(count int) (string, error) {
 	var byts []byte = make([]byte, count)
 
 	for i := 0; i < count; i++ {
-		n := rander.Intn(10)
-		byts[i] = byte('0' + n)
+		n, err := rand.Int(rand.Reader, ten)
+		if err != nil {
+			return "", err
+		}
+		byts[i] = byte('0' + n.Int64())
 	}
-	return string(byts)
+	return string(byts), nil
 }
@@ -2,6 +2,7 @@ package casbin
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/casbin/casbin/v2"
 	"github.com/ngs24313/gopu/models"
@@ -11,6 +12,9 @@ import (
 
 type casbinRoleManager struct {
 	enforcer *casbin.SyncedEnforcer
+
+	//mu serializes the role updates
+	mu sync.Mutex
 }
 
 //NewCasbinRoleManager 创建casbin角色管理器
@@ -61,7 +65,7 @@ func (m *casbinRoleManager) ListRole(params *rolemanager.ListRoleParams) (*rolem
 
 	roleNames := make([]string, 0)
 	for _, sub := range subjs {
-		if _, err := xid.FromString(sub); err != nil {
+		if !isUserID(sub) {
 			roleNames = append(roleNames, sub)
 		}
 	}
@@ -107,8 +111,101 @@ func (m *casbinRoleManager) ListRole(params *rolemanager.ListRoleParams) (*rolem
 	return reply, nil
 }
 
+//UpdateRole applies the diff between the current and the updated permissions of role. The casbin version
+//has no batch policy api, so the applied policies are rolled back if any of them fails
+func (m *casbinRoleManager) UpdateRole(name string, update *rolemanager.RoleUpdate) (*rolemanager.RoleDiff, error) {
+	if update == nil {
+		return nil, fmt.Errorf("The argument [update] does not be nil")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	role, err := m.GetRoleByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(role.Permissions) == 0 {
+		return nil, rolemanager.ErrRoleNotExists
+	}
+
+	current := permissionSet(role.Permissions)
+
+	target := update.Permissions
+	if target == nil {
+		removed := permissionSet(update.Remove)
+		target = make([]models.Permission, 0, len(role.Permissions)+len(update.Add))
+		for _, p := range append(role.Permissions, update.Add...) {
+			if !removed[permissionKey(p)] {
+				target = append(target, p)
+			}
+		}
+	}
+	targetSet := permissionSet(target)
+	if len(targetSet) == 0 {
+		return nil, rolemanager.ErrRoleNoPermission
+	}
+
+	diff := &rolemanager.RoleDiff{
+		Added:   make([]models.Permission, 0),
+		Removed: make([]models.Permission, 0),
+	}
+	for _, p := range role.Permissions {
+		if !targetSet[permissionKey(p)] {
+			diff.Removed = append(diff.Removed, models.Permission{API: p.API, Method: p.Method})
+		}
+	}
+	for _, p := range target {
+		key := permissionKey(p)
+		if !current[key] {
+			current[key] = true
+			diff.Added = append(diff.Added, models.Permission{API: p.API, Method: p.Method})
+		}
+	}
+
+	if err := m.applyDiff(name, diff); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+//applyDiff adds the permissions before removing, so the role always exists while applying.
+//The enforcer changes its policies before saving them by the adapter, so the failed one is rolled back too
+func (m *casbinRoleManager) applyDiff(name string, diff *rolemanager.RoleDiff) error {
+	added := make([]models.Permission, 0, len(diff.Added))
+	removed := make([]models.Permission, 0, len(diff.Removed))
+
+	err := func() error {
+		for _, p := range diff.Added {
+			added = append(added, p)
+			if _, err := m.enforcer.AddPermissionForUser(name, p.API, p.Method); err != nil {
+				return err
+			}
+		}
+		for _, p := range diff.Removed {
+			removed = append(removed, p)
+			if _, err := m.enforcer.DeletePermissionForUser(name, p.API, p.Method); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if err == nil {
+		return nil
+	}
+
+	//the rollback is best effort, the error of applying is returned
+	for _, p := range removed {
+		m.enforcer.AddPermissionForUser(name, p.API, p.Method)
+	}
+	for _, p := range added {
+		m.enforcer.DeletePermissionForUser(name, p.API, p.Method)
+	}
+	return err
+}
+
 func (m *casbinRoleManager) AddRoleForUser(user string, role string) (bool, error) {
-	if !m.enforcer.HasPolicy(role) {
+	if !m.roleExists(role) {
 		return false, rolemanager.ErrRoleNotExists
 	}
 	ok, err := m.enforcer.AddRoleForUser(user, role)
@@ -141,10 +238,173 @@ func (m *casbinRoleManager) GetRoleForUser(user string) ([]string, error) {
 	return m.enforcer.GetRolesForUser(user)
 }
 
+//GetUserForRole get the users having the role directly, the child roles are excluded
 func (m *casbinRoleManager) GetUserForRole(role string) ([]string, error) {
-	return m.enforcer.GetUsersForRole(role)
+	subjects, err := m.enforcer.GetUsersForRole(role)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]string, 0, len(subjects))
+	for _, sub := range subjects {
+		if isUserID(sub) {
+			users = append(users, sub)
+		}
+	}
+	return users, nil
+}
+
+//AddParentRole make role inherit the permissions of parent, ErrRoleCycle is returned if parent already
+//inherits role
+func (m *casbinRoleManager) AddParentRole(role string, parent string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.roleExists(role) || !m.roleExists(parent) {
+		return false, rolemanager.ErrRoleNotExists
+	}
+
+	if role == parent {
+		return false, rolemanager.ErrRoleCycle
+	}
+
+	ancestors, err := m.ancestors(parent)
+	if err != nil {
+		return false, err
+	}
+	for _, ancestor := range ancestors {
+		if ancestor == role {
+			return false, rolemanager.ErrRoleCycle
+		}
+	}
+
+	ok, err := m.enforcer.AddRoleForUser(role, parent)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, rolemanager.ErrRoleHasParent
+	}
+	return true, nil
+}
+
+func (m *casbinRoleManager) DelParentRole(role string, parent string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.roleExists(role) {
+		return false, rolemanager.ErrRoleNotExists
+	}
+
+	ok, err := m.enforcer.DeleteRoleForUser(role, parent)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, rolemanager.ErrRoleNotHaveParent
+	}
+	return true, nil
+}
+
+func (m *casbinRoleManager) GetParentRoles(role string) ([]string, error) {
+	if !m.roleExists(role) {
+		return nil, rolemanager.ErrRoleNotExists
+	}
+	return m.enforcer.GetRolesForUser(role)
+}
+
+func (m *casbinRoleManager) GetChildRoles(role string) ([]string, error) {
+	if !m.roleExists(role) {
+		return nil, rolemanager.ErrRoleNotExists
+	}
+
+	subjects, err := m.enforcer.GetUsersForRole(role)
+	if err != nil {
+		return nil, err
+	}
+
+	children := make([]string, 0, len(subjects))
+	for _, sub := range subjects {
+		if !isUserID(sub) {
+			children = append(children, sub)
+		}
+	}
+	return children, nil
+}
+
+func (m *casbinRoleManager) GetAncestorRoles(role string) ([]string, error) {
+	if !m.roleExists(role) {
+		return nil, rolemanager.ErrRoleNotExists
+	}
+	return m.ancestors(role)
+}
+
+func (m *casbinRoleManager) GetEffectivePermissions(role string) ([]models.Permission, error) {
+	if !m.roleExists(role) {
+		return nil, rolemanager.ErrRoleNotExists
+	}
+
+	ancestors, err := m.ancestors(role)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := make([]models.Permission, 0)
+	granted := make(map[string]bool)
+	for _, name := range append([]string{role}, ancestors...) {
+		for _, p := range m.enforcer.GetPermissionsForUser(name) {
+			permission := models.Permission{Role: name, API: p[1], Method: p[2]}
+			if key := permissionKey(permission); !granted[key] {
+				granted[key] = true
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	return permissions, nil
+}
+
+//ancestors get the roles inherited by role directly or indirectly, the nearer roles are in front
+func (m *casbinRoleManager) ancestors(role string) ([]string, error) {
+	ancestors := make([]string, 0)
+	visited := map[string]bool{role: true}
+	for queue := []string{role}; len(queue) > 0; queue = queue[1:] {
+		parents, err := m.enforcer.GetRolesForUser(queue[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, parent := range parents {
+			if !visited[parent] {
+				visited[parent] = true
+				ancestors = append(ancestors, parent)
+				queue = append(queue, parent)
+			}
+		}
+	}
+	return ancestors, nil
+}
+
+//roleExists check if name is a role, the roles have permissions and the user ids are not roles
+func (m *casbinRoleManager) roleExists(name string) bool {
+	return !isUserID(name) && len(m.enforcer.GetPermissionsForUser(name)) > 0
 }
 
 func (m *casbinRoleManager) Validate(user string, permission *models.Permission) (bool, error) {
 	return m.enforcer.Enforce(user, permission.API, permission.Method)
 }
+
+func isUserID(name string) bool {
+	_, err := xid.FromString(name)
+	return err == nil
+}
+
+func permissionKey(p models.Permission) string {
+	return p.API + " " + p.Method
+}
+
+func permissionSet(permissions []models.Permission) map[string]bool {
+	set := make(map[string]bool, len(permissions))
+	for _, p := range permissions {
+		set[permissionKey(p)] = true
+	}
+	return set
+}
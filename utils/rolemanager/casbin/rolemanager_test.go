@@ -0,0 +1,219 @@
+package casbin
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/ngs24313/gopu/models"
+	"github.com/ngs24313/gopu/utils/rolemanager"
+)
+
+var errTestAdapter = errors.New("adapter failed")
+
+//testAdapter keeps no policy and fails to save the policies of api fail
+type testAdapter struct {
+	fail string
+}
+
+func (a *testAdapter) LoadPolicy(model model.Model) error { return nil }
+func (a *testAdapter) SavePolicy(model model.Model) error { return nil }
+
+func (a *testAdapter) AddPolicy(sec string, ptype string, rule []string) error {
+	return a.check(rule)
+}
+
+func (a *testAdapter) RemovePolicy(sec string, ptype string, rule []string) error {
+	return a.check(rule)
+}
+
+func (a *testAdapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) error {
+	return nil
+}
+
+func (a *testAdapter) check(rule []string) error {
+	if len(rule) > 1 && rule[1] == a.fail {
+		return errTestAdapter
+	}
+	return nil
+}
+
+//newTestRoleManager create the role manager of role editor, which can get a and b
+func newTestRoleManager(t *testing.T) (rolemanager.RoleManager, *testAdapter) {
+	adapter := &testAdapter{}
+	e, err := casbin.NewSyncedEnforcer("../../../policy/rbac_model.conf", adapter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewCasbinRoleManager(e)
+	if _, err := m.CreateRole(&models.Role{
+		Name:        "editor",
+		Permissions: []models.Permission{{API: "/a", Method: "GET"}, {API: "/b", Method: "GET"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return m, adapter
+}
+
+//permissionsOf get the sorted permission keys of role
+func permissionsOf(t *testing.T, m rolemanager.RoleManager, name string) []string {
+	role, err := m.GetRoleByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, 0, len(role.Permissions))
+	for _, p := range role.Permissions {
+		keys = append(keys, permissionKey(p))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestUpdateRole(t *testing.T) {
+	tests := []struct {
+		name    string
+		update  rolemanager.RoleUpdate
+		added   []models.Permission
+		removed []models.Permission
+		want    []string
+	}{
+		{
+			name: "replace",
+			update: rolemanager.RoleUpdate{
+				Permissions: []models.Permission{{API: "/b", Method: "GET"}, {API: "/c", Method: "POST"}},
+			},
+			added:   []models.Permission{{API: "/c", Method: "POST"}},
+			removed: []models.Permission{{API: "/a", Method: "GET"}},
+			want:    []string{"/b GET", "/c POST"},
+		},
+		{
+			name: "add and remove",
+			update: rolemanager.RoleUpdate{
+				Add:    []models.Permission{{API: "/b", Method: "GET"}, {API: "/c", Method: "POST"}},
+				Remove: []models.Permission{{API: "/a", Method: "GET"}, {API: "/d", Method: "GET"}},
+			},
+			added:   []models.Permission{{API: "/c", Method: "POST"}},
+			removed: []models.Permission{{API: "/a", Method: "GET"}},
+			want:    []string{"/b GET", "/c POST"},
+		},
+		{
+			name: "removing wins over adding",
+			update: rolemanager.RoleUpdate{
+				Add:    []models.Permission{{API: "/a", Method: "GET"}, {API: "/c", Method: "POST"}},
+				Remove: []models.Permission{{API: "/a", Method: "GET"}, {API: "/c", Method: "POST"}},
+			},
+			added:   []models.Permission{},
+			removed: []models.Permission{{API: "/a", Method: "GET"}},
+			want:    []string{"/b GET"},
+		},
+		{
+			name: "duplicated permissions",
+			update: rolemanager.RoleUpdate{
+				Permissions: []models.Permission{{API: "/c", Method: "GET"}, {API: "/c", Method: "GET"}},
+			},
+			added:   []models.Permission{{API: "/c", Method: "GET"}},
+			removed: []models.Permission{{API: "/a", Method: "GET"}, {API: "/b", Method: "GET"}},
+			want:    []string{"/c GET"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestRoleManager(t)
+
+			diff, err := m.UpdateRole("editor", &tt.update)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(diff.Added, tt.added) || !reflect.DeepEqual(diff.Removed, tt.removed) {
+				t.Errorf("UpdateRole() = %v, want added %v and removed %v", diff, tt.added, tt.removed)
+			}
+			if got := permissionsOf(t, m, "editor"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("permissions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateRoleRejected(t *testing.T) {
+	m, _ := newTestRoleManager(t)
+	want := []string{"/a GET", "/b GET"}
+
+	tests := []struct {
+		name   string
+		role   string
+		update *rolemanager.RoleUpdate
+		err    error
+	}{
+		{"no permission left", "editor", &rolemanager.RoleUpdate{
+			Remove: []models.Permission{{API: "/a", Method: "GET"}, {API: "/b", Method: "GET"}},
+		}, rolemanager.ErrRoleNoPermission},
+		{"empty replacement", "editor", &rolemanager.RoleUpdate{
+			Permissions: []models.Permission{},
+		}, rolemanager.ErrRoleNoPermission},
+		{"unknown role", "viewer", &rolemanager.RoleUpdate{
+			Add: []models.Permission{{API: "/a", Method: "GET"}},
+		}, rolemanager.ErrRoleNotExists},
+	}
+
+	for _, tt := range tests {
+		if _, err := m.UpdateRole(tt.role, tt.update); err != tt.err {
+			t.Errorf("%s: UpdateRole() = %v, want %v", tt.name, err, tt.err)
+		}
+		if got := permissionsOf(t, m, "editor"); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: permissions = %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+func TestUpdateRoleRollback(t *testing.T) {
+	tests := []struct {
+		name   string
+		fail   string
+		update rolemanager.RoleUpdate
+	}{
+		{"adding fails", "/d", rolemanager.RoleUpdate{
+			Add:    []models.Permission{{API: "/c", Method: "GET"}, {API: "/d", Method: "GET"}},
+			Remove: []models.Permission{{API: "/a", Method: "GET"}},
+		}},
+		{"removing fails", "/b", rolemanager.RoleUpdate{
+			Permissions: []models.Permission{{API: "/c", Method: "GET"}},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, adapter := newTestRoleManager(t)
+			adapter.fail = tt.fail
+
+			if _, err := m.UpdateRole("editor", &tt.update); err != errTestAdapter {
+				t.Fatalf("UpdateRole() = %v, want %v", err, errTestAdapter)
+			}
+			want := []string{"/a GET", "/b GET"}
+			if got := permissionsOf(t, m, "editor"); !reflect.DeepEqual(got, want) {
+				t.Errorf("permissions = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestAddRoleForUser(t *testing.T) {
+	m, _ := newTestRoleManager(t)
+
+	if ok, err := m.AddRoleForUser("alice", "editor"); !ok || err != nil {
+		t.Fatalf("AddRoleForUser() = %v %v, want true", ok, err)
+	}
+	if _, err := m.AddRoleForUser("alice", "editor"); err != rolemanager.ErrUserHasRole {
+		t.Errorf("AddRoleForUser() again = %v, want %v", err, rolemanager.ErrUserHasRole)
+	}
+	if _, err := m.AddRoleForUser("alice", "viewer"); err != rolemanager.ErrRoleNotExists {
+		t.Errorf("AddRoleForUser() of unknown role = %v, want %v", err, rolemanager.ErrRoleNotExists)
+	}
+	if ok, err := m.Validate("alice", &models.Permission{API: "/a", Method: "GET"}); !ok || err != nil {
+		t.Errorf("Validate() = %v %v, want true", ok, err)
+	}
+}
@@ -1,6 +1,8 @@
 package rolemanager
 
 import (
+	"fmt"
+
 	"github.com/ngs24313/gopu/config"
 	"github.com/ngs24313/gopu/models"
 )
@@ -40,5 +42,14 @@ func ApplyConfigToRoleManager(mgr RoleManager, conf *config.Config) error {
 			return err
 		}
 	}
+
+	//the parents are added after all roles are created
+	for _, g := range rbac.Roles {
+		for _, parent := range g.Inherits {
+			if _, err := mgr.AddParentRole(g.Name, parent); err != nil && err != ErrRoleHasParent {
+				return fmt.Errorf("role %s inherits %s: %v", g.Name, parent, err)
+			}
+		}
+	}
 	return nil
 }
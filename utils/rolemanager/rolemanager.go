@@ -13,6 +13,14 @@ var (
 	ErrUserHasRole = errors.New("The user already has the role ")
 	//ErrUserNotHaveRole 用户没有拥有该角色权限
 	ErrUserNotHaveRole = errors.New("The user does not have role")
+	//ErrRoleNoPermission 角色至少需要一个权限
+	ErrRoleNoPermission = errors.New("The role must have at least one permission")
+	//ErrRoleHasParent 角色已经继承该父角色
+	ErrRoleHasParent = errors.New("The role already inherits the parent")
+	//ErrRoleNotHaveParent 角色没有继承该父角色
+	ErrRoleNotHaveParent = errors.New("The role does not inherit the parent")
+	//ErrRoleCycle 角色继承不能形成环
+	ErrRoleCycle = errors.New("The role inheritance would create a cycle")
 )
 
 //ListRoleParams 角色列表查询参数
@@ -27,12 +35,36 @@ type ListRoleReply struct {
 	Roles      []*models.Role `json:"roles"`
 }
 
+//RoleUpdate 角色权限修改，Permissions不为nil时替换角色的全部权限，否则添加Add并移除Remove
+type RoleUpdate struct {
+	Permissions []models.Permission
+	Add         []models.Permission
+	Remove      []models.Permission
+}
+
+//RoleDiff 角色权限修改结果
+type RoleDiff struct {
+	Added   []models.Permission `json:"added"`
+	Removed []models.Permission `json:"removed"`
+}
+
 //RoleManager role manager interface
 type RoleManager interface {
 	DeleteRole(name string) (bool, error)
 	CreateRole(role *models.Role) (bool, error)
 	GetRoleByName(name string) (*models.Role, error)
 	ListRole(params *ListRoleParams) (*ListRoleReply, error)
+	UpdateRole(name string, update *RoleUpdate) (*RoleDiff, error)
+
+	AddParentRole(role string, parent string) (bool, error)
+	DelParentRole(role string, parent string) (bool, error)
+	GetParentRoles(role string) ([]string, error)
+	GetChildRoles(role string) ([]string, error)
+	//GetAncestorRoles get the roles inherited by role directly or indirectly
+	GetAncestorRoles(role string) ([]string, error)
+	//GetEffectivePermissions get the permissions of role and its ancestors, the Role of each permission is
+	//the role granting it
+	GetEffectivePermissions(role string) ([]models.Permission, error)
 
 	AddRoleForUser(user string, role string) (bool, error)
 	DelRoleForUser(user string, role string) (bool, error)
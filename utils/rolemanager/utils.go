@@ -40,3 +40,20 @@ func AddUserIDPrimaryAPI(
 	}
 	return nil
 }
+
+//DeleteUserRoles removes the roles and the primary api of user
+func DeleteUserRoles(uid string, mgr RoleManager) error {
+	roles, err := mgr.GetRoleForUser(uid)
+	if err != nil {
+		return err
+	}
+
+	for _, role := range roles {
+		if _, err := mgr.DelRoleForUser(uid, role); err != nil && err != ErrUserNotHaveRole {
+			return err
+		}
+	}
+
+	_, err = mgr.DeleteRole(uid)
+	return err
+}
@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+//RandomToken generate an url safe random token from size bytes of crypto/rand
+func RandomToken(size int) (string, error) {
+	byts := make([]byte, size)
+	if _, err := rand.Read(byts); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(byts), nil
+}
+
+//HashToken return the hex sha256 of token, used to store tokens in database
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
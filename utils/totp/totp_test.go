@@ -0,0 +1,115 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+//rfcSecret is the base32 of the sha1 key "12345678901234567890" in RFC 6238
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	//the test vectors of RFC 6238 truncated to 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCodeSecretFormat(t *testing.T) {
+	want, err := Code(rfcSecret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Code(" "+strings.ToLower(rfcSecret)+" ", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("Code() of lower case secret = %s, want %s", got, want)
+	}
+
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code() of invalid secret succeeded")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name   string
+		offset int64 //step of code relative to now
+		skew   int
+		code   string //the code of offset is used if empty
+		want   bool
+	}{
+		{name: "current step", offset: 0, skew: 0, want: true},
+		{name: "previous step in skew", offset: -1, skew: 1, want: true},
+		{name: "next step in skew", offset: 1, skew: 1, want: true},
+		{name: "previous step without skew", offset: -1, skew: 0, want: false},
+		{name: "step out of skew", offset: -2, skew: 1, want: false},
+		{name: "future step out of skew", offset: 2, skew: 1, want: false},
+		{name: "wrong code", skew: 1, code: "000000", want: false},
+		{name: "short code", skew: 1, code: "12345", want: false},
+		{name: "long code", skew: 1, code: "1234567", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := tt.code
+			if code == "" {
+				var err error
+				if code, err = Code(rfcSecret, current+tt.offset); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			step, ok := Validate(rfcSecret, code, now, tt.skew)
+			if ok != tt.want {
+				t.Fatalf("Validate() = %v, want %v", ok, tt.want)
+			}
+			if ok && step != current+tt.offset {
+				t.Errorf("Validate() step = %d, want %d", step, current+tt.offset)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("length of secret = %d, want 32", len(secret))
+	}
+
+	other, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret == other {
+		t.Error("GenerateSecret() returned the same secret twice")
+	}
+}
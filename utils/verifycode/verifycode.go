@@ -0,0 +1,223 @@
+package verifycode
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ngs24313/gopu/config"
+	"github.com/ngs24313/gopu/utils"
+	"github.com/ngs24313/gopu/utils/cache"
+)
+
+//Digits is the length of code
+const Digits = 6
+
+var (
+	//ErrInvalidCode the code is wrong, expired or burnt
+	ErrInvalidCode = errors.New("verification code is invalid or expired")
+	//ErrThrottled too many codes are requested by the recipient or the client ip
+	ErrThrottled = errors.New("too many verification codes requested, try again later")
+)
+
+//Purpose is what the codes are used for, the codes of a recipient are independent between purposes
+type Purpose struct {
+	Prefix     string //prefix of the cache keys of codes
+	Expiration time.Duration
+}
+
+//code is cached by the purpose and the recipient, only the hash of code is kept
+type code struct {
+	Hash      string    `json:"hash"`
+	Attempts  int       `json:"attempts"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+//sendState is the codes sent to a recipient or requested by a client ip in window
+type sendState struct {
+	Count       int       `json:"count"`
+	WindowStart time.Time `json:"window_start"`
+	LastSentAt  time.Time `json:"last_sent_at"`
+}
+
+//Service creates and verifies the codes sent to emails, the failed attempts of a code and the resends are limited
+type Service struct {
+	cache cache.Cache
+	conf  config.VerifyCode
+	now   func() time.Time
+
+	mu sync.Mutex
+}
+
+//New create verification code service
+func New(c cache.Cache, conf config.VerifyCode, now func() time.Time) *Service {
+	if conf.Prefix == "" {
+		conf.Prefix = "verify_code."
+	}
+	if conf.MaxAttempts <= 0 {
+		conf.MaxAttempts = 5
+	}
+	if conf.ResendInterval <= 0 {
+		conf.ResendInterval = time.Minute
+	}
+	if conf.RecipientLimit <= 0 {
+		conf.RecipientLimit = 5
+	}
+	if conf.IPLimit <= 0 {
+		conf.IPLimit = 20
+	}
+	if conf.LimitWindow <= 0 {
+		conf.LimitWindow = time.Hour
+	}
+	if now == nil {
+		now = time.Now
+	}
+
+	return &Service{
+		cache: c,
+		conf:  conf,
+		now:   now,
+	}
+}
+
+//Create generate a code of purpose for the recipient requested by the client ip, the previous code is replaced.
+//ErrThrottled is returned if the recipient or the client ip requests too many codes
+func (s *Service) Create(purpose Purpose, recipient string, ip string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	recipientKey := s.conf.Prefix + "recipient." + purpose.Prefix + normalize(recipient)
+	ipKey := s.conf.Prefix + "ip." + ip
+
+	recipientState, err := s.sendState(recipientKey, now)
+	if err != nil {
+		return "", err
+	}
+	if recipientState.Count >= s.conf.RecipientLimit || now.Before(recipientState.LastSentAt.Add(s.conf.ResendInterval)) {
+		return "", ErrThrottled
+	}
+
+	ipState, err := s.sendState(ipKey, now)
+	if err != nil {
+		return "", err
+	}
+	if ipState.Count >= s.conf.IPLimit {
+		return "", ErrThrottled
+	}
+
+	value, err := utils.RandomDigit(Digits)
+	if err != nil {
+		return "", err
+	}
+
+	if err := cache.SetJSON(s.cache, purpose.Prefix+normalize(recipient), &code{
+		Hash:      utils.HashToken(value),
+		ExpiresAt: now.Add(purpose.Expiration),
+	}, purpose.Expiration); err != nil {
+		return "", err
+	}
+
+	for key, state := range map[string]*sendState{recipientKey: recipientState, ipKey: ipState} {
+		state.Count++
+		state.LastSentAt = now
+		if err := cache.SetJSON(s.cache, key, state, state.WindowStart.Add(s.conf.LimitWindow).Sub(now)); err != nil {
+			return "", err
+		}
+	}
+	return value, nil
+}
+
+//Verify check the code of purpose for the recipient, the code is kept until Delete. A failed attempt
+//is counted and the code is burnt after the max attempts, ErrInvalidCode is returned if it does not match
+func (s *Service) Verify(purpose Purpose, recipient string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.verify(purpose, recipient, value)
+}
+
+//Consume check the code like Verify and burn it if it matches, so that the concurrent requests
+//cannot use the same code
+func (s *Service) Consume(purpose Purpose, recipient string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.verify(purpose, recipient, value); err != nil {
+		return err
+	}
+
+	//the one deleting the code uses it
+	if err := s.cache.Del(purpose.Prefix + normalize(recipient)); err != nil {
+		if err == cache.ErrNotFound {
+			return ErrInvalidCode
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *Service) verify(purpose Purpose, recipient string, value string) error {
+	key := purpose.Prefix + normalize(recipient)
+
+	var c code
+	if err := cache.GetJSON(s.cache, key, &c); err != nil {
+		if err == cache.ErrNotFound {
+			return ErrInvalidCode
+		}
+		return err
+	}
+
+	now := s.now()
+	if !now.Before(c.ExpiresAt) {
+		return ErrInvalidCode
+	}
+
+	if subtle.ConstantTimeCompare([]byte(c.Hash), []byte(utils.HashToken(value))) == 1 {
+		return nil
+	}
+
+	c.Attempts++
+	if c.Attempts >= s.conf.MaxAttempts {
+		if err := s.cache.Del(key); err != nil && err != cache.ErrNotFound {
+			return err
+		}
+		return ErrInvalidCode
+	}
+
+	if err := cache.SetJSON(s.cache, key, &c, c.ExpiresAt.Sub(now)); err != nil {
+		return err
+	}
+	return ErrInvalidCode
+}
+
+//Delete burn the code of purpose for the recipient after it is used
+func (s *Service) Delete(purpose Purpose, recipient string) error {
+	if err := s.cache.Del(purpose.Prefix + normalize(recipient)); err != nil && err != cache.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+//sendState get the state of key in the current window
+func (s *Service) sendState(key string, now time.Time) (*sendState, error) {
+	var state sendState
+	if err := cache.GetJSON(s.cache, key, &state); err != nil {
+		if err != cache.ErrNotFound {
+			return nil, err
+		}
+	}
+
+	if state.Count == 0 || !now.Before(state.WindowStart.Add(s.conf.LimitWindow)) {
+		state = sendState{
+			WindowStart: now,
+		}
+	}
+	return &state, nil
+}
+
+func normalize(recipient string) string {
+	return strings.ToLower(strings.TrimSpace(recipient))
+}
@@ -0,0 +1,232 @@
+package verifycode
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ngs24313/gopu/config"
+	"github.com/ngs24313/gopu/utils/cache/bigcache"
+)
+
+var (
+	testPurpose  = Purpose{Prefix: "test.", Expiration: 10 * time.Minute}
+	otherPurpose = Purpose{Prefix: "other.", Expiration: 10 * time.Minute}
+)
+
+const testRecipient = "alice@example.com"
+
+//testClock is the time source of tests, it only moves by Advance
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestService(t *testing.T, conf config.VerifyCode) (*Service, *testClock) {
+	c := bigcache.NewCache()
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	clock := &testClock{now: time.Now()}
+	return New(c, conf, clock.Now), clock
+}
+
+func mustCreate(t *testing.T, s *Service, purpose Purpose, recipient string, ip string) string {
+	value, err := s.Create(purpose, recipient, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(value) != Digits {
+		t.Fatalf("Create() = %s, want %d digits", value, Digits)
+	}
+	return value
+}
+
+func TestVerifyAndConsume(t *testing.T) {
+	s, _ := newTestService(t, config.VerifyCode{})
+	value := mustCreate(t, s, testPurpose, testRecipient, "10.0.0.1")
+
+	//the recipient is normalized and verifying keeps the code
+	for i := 0; i < 2; i++ {
+		if err := s.Verify(testPurpose, " Alice@Example.com ", value); err != nil {
+			t.Fatalf("Verify() = %v", err)
+		}
+	}
+
+	if err := s.Verify(otherPurpose, testRecipient, value); err != ErrInvalidCode {
+		t.Errorf("Verify() of other purpose = %v, want %v", err, ErrInvalidCode)
+	}
+
+	if err := s.Consume(testPurpose, testRecipient, value); err != nil {
+		t.Fatalf("Consume() = %v", err)
+	}
+	if err := s.Consume(testPurpose, testRecipient, value); err != ErrInvalidCode {
+		t.Errorf("Consume() of used code = %v, want %v", err, ErrInvalidCode)
+	}
+}
+
+func TestConsumeOnce(t *testing.T) {
+	s, _ := newTestService(t, config.VerifyCode{})
+	value := mustCreate(t, s, testPurpose, testRecipient, "10.0.0.1")
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+		ok int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Consume(testPurpose, testRecipient, value); err == nil {
+				mu.Lock()
+				ok++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if ok != 1 {
+		t.Errorf("concurrent Consume() succeeded %d times, want 1", ok)
+	}
+}
+
+func TestInvalidCodes(t *testing.T) {
+	tests := []struct {
+		name  string
+		spoil func(t *testing.T, s *Service, clock *testClock, value string) string //return the code to verify
+	}{
+		{
+			name: "expired",
+			spoil: func(t *testing.T, s *Service, clock *testClock, value string) string {
+				clock.Advance(testPurpose.Expiration)
+				return value
+			},
+		},
+		{
+			name: "replaced by new code",
+			spoil: func(t *testing.T, s *Service, clock *testClock, value string) string {
+				clock.Advance(time.Minute)
+				if next := mustCreate(t, s, testPurpose, testRecipient, "10.0.0.1"); next == value {
+					t.Skip("the new code equals the old one")
+				}
+				return value
+			},
+		},
+		{
+			name: "burnt after max attempts",
+			spoil: func(t *testing.T, s *Service, clock *testClock, value string) string {
+				for i := 0; i < 3; i++ {
+					if err := s.Verify(testPurpose, testRecipient, wrongCode(value)); err != ErrInvalidCode {
+						t.Fatalf("Verify() of wrong code = %v, want %v", err, ErrInvalidCode)
+					}
+				}
+				return value
+			},
+		},
+		{
+			name: "deleted",
+			spoil: func(t *testing.T, s *Service, clock *testClock, value string) string {
+				if err := s.Delete(testPurpose, testRecipient); err != nil {
+					t.Fatal(err)
+				}
+				return value
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, clock := newTestService(t, config.VerifyCode{MaxAttempts: 3})
+			value := mustCreate(t, s, testPurpose, testRecipient, "10.0.0.1")
+
+			if err := s.Consume(testPurpose, testRecipient, tt.spoil(t, s, clock, value)); err != ErrInvalidCode {
+				t.Errorf("Consume() = %v, want %v", err, ErrInvalidCode)
+			}
+		})
+	}
+}
+
+func TestAttemptsBelowMax(t *testing.T) {
+	s, _ := newTestService(t, config.VerifyCode{MaxAttempts: 3})
+	value := mustCreate(t, s, testPurpose, testRecipient, "10.0.0.1")
+
+	for i := 0; i < 2; i++ {
+		if err := s.Verify(testPurpose, testRecipient, wrongCode(value)); err != ErrInvalidCode {
+			t.Fatalf("Verify() of wrong code = %v, want %v", err, ErrInvalidCode)
+		}
+	}
+	if err := s.Consume(testPurpose, testRecipient, value); err != nil {
+		t.Errorf("Consume() after %d failed attempts = %v", 2, err)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	conf := config.VerifyCode{
+		ResendInterval: time.Minute,
+		RecipientLimit: 3,
+		IPLimit:        4,
+		LimitWindow:    time.Hour,
+	}
+
+	t.Run("resend interval", func(t *testing.T) {
+		s, clock := newTestService(t, conf)
+		mustCreate(t, s, testPurpose, testRecipient, "10.0.0.1")
+
+		if _, err := s.Create(testPurpose, testRecipient, "10.0.0.2"); err != ErrThrottled {
+			t.Errorf("Create() in resend interval = %v, want %v", err, ErrThrottled)
+		}
+		//the throttle is by purpose
+		mustCreate(t, s, otherPurpose, testRecipient, "10.0.0.1")
+
+		clock.Advance(conf.ResendInterval)
+		mustCreate(t, s, testPurpose, testRecipient, "10.0.0.1")
+	})
+
+	t.Run("recipient limit", func(t *testing.T) {
+		s, clock := newTestService(t, conf)
+		for i := 0; i < conf.RecipientLimit; i++ {
+			mustCreate(t, s, testPurpose, testRecipient, "10.0.0.1")
+			clock.Advance(conf.ResendInterval)
+		}
+
+		if _, err := s.Create(testPurpose, testRecipient, "10.0.0.2"); err != ErrThrottled {
+			t.Errorf("Create() over recipient limit = %v, want %v", err, ErrThrottled)
+		}
+
+		clock.Advance(conf.LimitWindow)
+		mustCreate(t, s, testPurpose, testRecipient, "10.0.0.1")
+	})
+
+	t.Run("ip limit", func(t *testing.T) {
+		s, clock := newTestService(t, conf)
+		for _, recipient := range []string{"a@example.com", "b@example.com", "c@example.com", "d@example.com"} {
+			mustCreate(t, s, testPurpose, recipient, "10.0.0.1")
+		}
+
+		if _, err := s.Create(testPurpose, "e@example.com", "10.0.0.1"); err != ErrThrottled {
+			t.Errorf("Create() over ip limit = %v, want %v", err, ErrThrottled)
+		}
+		mustCreate(t, s, testPurpose, "e@example.com", "10.0.0.2")
+
+		clock.Advance(conf.LimitWindow)
+		mustCreate(t, s, testPurpose, "f@example.com", "10.0.0.1")
+	})
+}
+
+//wrongCode get a code of the same length other than value
+func wrongCode(value string) string {
+	if value == "000000" {
+		return "000001"
+	}
+	return "000000"
+}